@@ -0,0 +1,298 @@
+package main
+
+import (
+	"blober.io/config"
+	"blober.io/models"
+	"blober.io/services"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// admin commands open the same stores as the server, badgerDB stores
+// are locked by a running server so it must be stopped first, migrate
+// only needs the database and can run at any time
+
+// runMigrate creates or updates the database tables
+func runMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
+
+	db, err := services.CreateDatabaseConnection(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Println("database migrated")
+	return nil
+}
+
+// runCreateAdmin creates an admin account, or makes an existing one admin
+func runCreateAdmin(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "email of the admin account")
+	password := fs.String("password", "", "password, replaces the password of an existing account when set")
+	first := fs.String("first", "Admin", "first name, used when the account is created")
+	last := fs.String("last", "", "last name, used when the account is created")
+	fs.Parse(args)
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	account, err := s.accountRepo.CreateAdmin(*first, *last, *email, *password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("admin %s (id %d)\npublic key:  %s\nprivate key: %s\n",
+		account.Email, account.ID, account.Cred.PublicAccessKey, account.Cred.PrivateAccessKey)
+	return nil
+}
+
+// runReindexCache rebuilds the blob cache from the database
+func runReindexCache(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reindex-cache", flag.ExitOnError)
+	fs.Parse(args)
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	cached, removed, err := s.appRepo.ReindexCache()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("cached %d blobs, removed %d stale entries\n", cached, removed)
+	return nil
+}
+
+// runVerify cross-checks the database, the blob cache and
+// object storage. It exits with 1 when issues are found
+func runVerify(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the full report as JSON")
+	fs.Parse(args)
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	report, err := s.appRepo.Verify()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		err = writeJSON(os.Stdout, report)
+	} else {
+		printReport(os.Stdout, report)
+	}
+
+	if err == nil && len(report.Issues) > 0 {
+		s.close()
+		os.Exit(1)
+	}
+	return err
+}
+
+// runGC removes orphan objects and stale cache entries
+func runGC(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print what would be removed")
+	fs.Parse(args)
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	report, err := s.appRepo.Verify()
+	if err != nil {
+		return err
+	}
+
+	orphans, stale := report.Count(models.IssueOrphanObject), report.Count(models.IssueStaleCache)
+	if *dryRun {
+		for _, issue := range report.Issues {
+			if issue.Kind == models.IssueOrphanObject || issue.Kind == models.IssueStaleCache {
+				printIssue(os.Stdout, issue)
+			}
+		}
+		fmt.Printf("would remove %d orphan objects and %d stale cache entries\n", orphans, stale)
+		return nil
+	}
+
+	removed := s.appRepo.Repair(report, models.GCPolicy())
+	fmt.Printf("removed %d of %d orphan objects and stale cache entries\n", removed, orphans+stale)
+	return nil
+}
+
+// runReconcile verifies then repairs issues selected by -repair
+func runReconcile(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.String("repair", models.DefaultRepairPolicy().String(), "issue kinds to repair, all or none")
+	asJSON := fs.Bool("json", false, "print the full report as JSON")
+	fs.Parse(args)
+
+	policy, err := models.ParseRepairPolicy(*repair)
+	if err != nil {
+		return err
+	}
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	report, err := s.appRepo.Reconcile(policy)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSON(os.Stdout, report)
+	}
+
+	printReport(os.Stdout, report)
+	fmt.Printf("repaired %d of %d issues\n", report.Repaired, len(report.Issues))
+	return nil
+}
+
+// runExport writes accounts, apps and lifecycle rules as JSON
+func runExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "file to write, defaults to stdout")
+	fs.Parse(args)
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	export, err := s.appRepo.ExportAccounts()
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return writeJSON(os.Stdout, export)
+	}
+
+	// exports hold password hashes
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSON(f, export); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runImport creates the accounts, apps and buckets of an export
+func runImport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	input := fs.String("i", "", "export file to read, defaults to stdin")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	export := &models.Export{}
+	if err := json.NewDecoder(r).Decode(export); err != nil {
+		return fmt.Errorf("invalid export, %v", err)
+	}
+
+	s, err := openStack(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	report, err := s.appRepo.ImportAccounts(export)
+	if report != nil {
+		for _, skipped := range report.Skipped {
+			fmt.Printf("skipped %s, already exists\n", skipped)
+		}
+		fmt.Printf("imported %d accounts and %d apps\n", report.Accounts, report.Apps)
+	}
+	return err
+}
+
+// printReport prints issues of a verification and a count per kind
+func printReport(w io.Writer, report *models.VerifyReport) {
+	kinds := make(map[string]int)
+	for _, issue := range report.Issues {
+		printIssue(w, issue)
+		kinds[issue.Kind]++
+	}
+
+	fmt.Fprintf(w, "checked %d apps, %d blobs, %d objects, %d cache entries in %s\n", report.Apps,
+		report.Blobs, report.Objects, report.CacheEntries, report.FinishedAt.Sub(report.StartedAt))
+
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	for _, kind := range names {
+		fmt.Fprintf(w, "%-16s %d\n", kind, kinds[kind])
+	}
+
+	if len(names) == 0 {
+		fmt.Fprintln(w, "no issues found")
+	}
+}
+
+// printIssue prints an issue on a line
+func printIssue(w io.Writer, issue *models.Issue) {
+	fmt.Fprintf(w, "%-16s %s", issue.Kind, issue.App)
+	if issue.Hash != "" {
+		fmt.Fprintf(w, "/%s", issue.Hash)
+	}
+	if issue.Detail != "" {
+		fmt.Fprintf(w, " (%s)", issue.Detail)
+	}
+	if issue.Repaired {
+		fmt.Fprint(w, " repaired")
+	} else if issue.Error != "" {
+		fmt.Fprintf(w, " not repaired, %s", issue.Error)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"blober.io/models"
+	"context"
+)
+
+// CreateAccount creates an account. Its keys are
+// returned once it is authenticated
+func (c *Client) CreateAccount(ctx context.Context, firstName, lastName, email, password string) (*models.Account, error) {
+	in := &models.Account{FirstName: firstName, LastName: lastName, Email: email, Password: password}
+	account := &models.Account{}
+	if err := c.call(ctx, "POST", "/account/new", nil, in, account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// Authenticate authenticates an account and makes the
+// client use its private key for the following calls
+func (c *Client) Authenticate(ctx context.Context, email, password string) (*models.Account, error) {
+	account := &models.Account{}
+	in := &models.Account{Email: email, Password: password}
+	if err := c.call(ctx, "POST", "/account/authenticate", nil, in, account); err != nil {
+		return nil, err
+	}
+
+	if account.Cred != nil {
+		c.Key = account.Cred.PrivateAccessKey
+	}
+
+	return account, nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"blober.io/models"
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// CreateApp creates an app, app names are unique across accounts
+func (c *Client) CreateApp(ctx context.Context, name string) (*models.App, error) {
+	app := &models.App{}
+	if err := c.call(ctx, "POST", "/app/new", nil, &models.App{Name: name}, app); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// Apps lists apps of the account
+func (c *Client) Apps(ctx context.Context) ([]*models.App, error) {
+	apps := make([]*models.App, 0)
+	if err := c.call(ctx, "GET", "/me/apps", nil, nil, &apps); err != nil {
+		return nil, err
+	}
+
+	return apps, nil
+}
+
+// DeleteApp deletes an app and its bucket. Apps holding blobs
+// are refused unless force is set, their blobs are then purged
+func (c *Client) DeleteApp(ctx context.Context, app string, force bool) (*models.App, error) {
+	deleted := &models.App{}
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	if err := c.call(ctx, "DELETE", appPath(app), query, nil, deleted); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// updateApp sends an app setting and returns the updated app
+func (c *Client) updateApp(ctx context.Context, path string, in interface{}) (*models.App, error) {
+	updated := &models.App{}
+	if err := c.call(ctx, "PUT", path, nil, in, updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// SetVersioning sets whether uploads of an existing key keep older versions
+func (c *Client) SetVersioning(ctx context.Context, app string, enabled bool) (*models.App, error) {
+	return c.updateApp(ctx, appPath(app, "versioning"), map[string]bool{"enabled": enabled})
+}
+
+// SetThumbnailSizes sets thumbnails generated for uploaded images, e.g 200x200
+func (c *Client) SetThumbnailSizes(ctx context.Context, app string, sizes []string) (*models.App, error) {
+	return c.updateApp(ctx, appPath(app, "thumbnails"), map[string][]string{"sizes": sizes})
+}
+
+// SetScanPolicy sets whether downloads are blocked until blobs are scanned clean
+func (c *Client) SetScanPolicy(ctx context.Context, app string, blockUntilScanned bool) (*models.App, error) {
+	return c.updateApp(ctx, appPath(app, "scanning"), map[string]bool{"block_until_scanned": blockUntilScanned})
+}
+
+// SetStripGPS sets whether GPS position is removed from uploaded images
+func (c *Client) SetStripGPS(ctx context.Context, app string, strip bool) (*models.App, error) {
+	return c.updateApp(ctx, appPath(app, "gps"), map[string]bool{"strip_gps": strip})
+}
+
+// SetTrashRetention sets how many days deleted blobs are kept in trash
+func (c *Client) SetTrashRetention(ctx context.Context, app string, days int) (*models.App, error) {
+	return c.updateApp(ctx, appPath(app, "trash", "retention"), map[string]int{"days": days})
+}
+
+// UploadPolicy returns the upload policy of an app
+func (c *Client) UploadPolicy(ctx context.Context, app string) (*models.UploadPolicy, error) {
+	policy := &models.UploadPolicy{}
+	if err := c.call(ctx, "GET", appPath(app, "policy"), nil, nil, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// SetUploadPolicy replaces the upload policy of an app
+func (c *Client) SetUploadPolicy(ctx context.Context, app string, policy *models.UploadPolicy) (*models.UploadPolicy, error) {
+	updated := &models.UploadPolicy{}
+	if err := c.call(ctx, "PUT", appPath(app, "policy"), nil, policy, updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// LifecycleRules returns the lifecycle rules of an app
+func (c *Client) LifecycleRules(ctx context.Context, app string) ([]*models.LifecycleRule, error) {
+	rules := make([]*models.LifecycleRule, 0)
+	if err := c.call(ctx, "GET", appPath(app, "lifecycle"), nil, nil, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SetLifecycleRules replaces the lifecycle rules of an app
+func (c *Client) SetLifecycleRules(ctx context.Context, app string, rules []*models.LifecycleRule) ([]*models.LifecycleRule, error) {
+	updated := make([]*models.LifecycleRule, 0)
+	if err := c.call(ctx, "PUT", appPath(app, "lifecycle"), nil, rules, &updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// RunLifecycle applies lifecycle rules of an app now,
+// a dry run reports the actions without applying them
+func (c *Client) RunLifecycle(ctx context.Context, app string, dryRun bool) (*models.LifecycleReport, error) {
+	report := &models.LifecycleReport{}
+	query := url.Values{"dry_run": {strconv.FormatBool(dryRun)}}
+	if err := c.call(ctx, "POST", appPath(app, "lifecycle", "run"), query, nil, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// Jobs lists processing jobs of an app, newest first. status
+// filters jobs, "dead" lists jobs that exhausted their attempts
+func (c *Client) Jobs(ctx context.Context, app, status string, page int) ([]*models.Job, error) {
+	jobs := make([]*models.Job, 0)
+	var query url.Values
+	if status != "" {
+		query = url.Values{"status": {status}}
+	}
+
+	if err := c.call(ctx, "GET", appPath(app, "jobs", strconv.Itoa(page)), query, nil, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// BlobJobs lists processing jobs of a blob
+func (c *Client) BlobJobs(ctx context.Context, app, hash string) ([]*models.Job, error) {
+	jobs := make([]*models.Job, 0)
+	if err := c.call(ctx, "GET", appPath(app, "blobs", hash, "jobs"), nil, nil, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// RetryJob queues a dead job again
+func (c *Client) RetryJob(ctx context.Context, app string, id uint) (*models.Job, error) {
+	job := &models.Job{}
+	if err := c.call(ctx, "POST", appPath(app, "jobs", strconv.Itoa(int(id)), "retry"), nil, nil, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,596 @@
+package client
+
+import (
+	"blober.io/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a file to upload
+type File struct {
+	Name   string
+	Reader io.Reader
+	Size   int64 // -1 when unknown, only used to report progress
+}
+
+// ProgressFunc is called as files are uploaded with the bytes
+// sent so far and the total size, -1 when a file size is unknown
+type ProgressFunc func(sent, total int64)
+
+// UploadOptions holds optional attributes of uploaded files
+type UploadOptions struct {
+	Key      string            // logical name, defaults to the filename
+	Private  bool              // only downloadable with the private key
+	Metadata map[string]string // immutable user metadata
+	Tags     map[string]string // editable tags
+	UploadId string            // matches upload progress events of the activity stream
+	Progress ProgressFunc
+}
+
+// UploadResult is the result of uploads of many files
+type UploadResult struct {
+	SuccessCount int64                 `json:"success_count"`
+	FailureCount int64                 `json:"failure_count"`
+	Blobs        []*models.Blob        `json:"blobs"`
+	Errors       []*models.UploadError `json:"errors"`
+}
+
+// Upload uploads a file to app. The file is streamed,
+// uploads are not retried as the reader can not be replayed
+func (c *Client) Upload(ctx context.Context, app string, file *File, opt *UploadOptions) (*models.Blob, error) {
+	if opt == nil {
+		opt = &UploadOptions{}
+	}
+
+	blob := &models.Blob{}
+	fields := map[string]string{"key": opt.Key}
+	if err := c.upload(ctx, appPath(app, "upload"), "file_data", []*File{file}, fields, opt, blob); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// UploadFile uploads the file at path to app
+func (c *Client) UploadFile(ctx context.Context, app, path string, opt *UploadOptions) (*models.Blob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Upload(ctx, app, &File{Name: filepath.Base(path), Reader: f, Size: info.Size()}, opt)
+}
+
+// UploadMultiple uploads many files to app in a single request. Files
+// refused by the server are listed in the errors of the result
+func (c *Client) UploadMultiple(ctx context.Context, app string, files []*File, opt *UploadOptions) (*UploadResult, error) {
+	if opt == nil {
+		opt = &UploadOptions{}
+	}
+
+	result := &UploadResult{}
+	if err := c.upload(ctx, appPath(app, "uploads"), "files[]", files, nil, opt, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UploadArchive uploads a zip, tar or tar.gz archive extracted into one
+// blob per entry, keyed by their path under prefix. When extraction stops
+// early, the report of the entries extracted so far is returned with the error
+func (c *Client) UploadArchive(ctx context.Context, app string, archive *File, prefix string, opt *UploadOptions) (*UploadResult, error) {
+	if opt == nil {
+		opt = &UploadOptions{}
+	}
+
+	result := &UploadResult{}
+	fields := map[string]string{"prefix": prefix}
+	err := c.upload(ctx, appPath(app, "uploads", "archive"), "archive", []*File{archive}, fields, opt, result)
+	if e, ok := err.(*Error); ok && len(e.Data) > 0 && json.Unmarshal(e.Data, result) == nil {
+		return result, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// upload streams files as a multipart form to path
+func (c *Client) upload(ctx context.Context, path, field string, files []*File, fields map[string]string,
+	opt *UploadOptions, out interface{}) error {
+	header := make(http.Header)
+	for k, v := range opt.Metadata {
+		header.Set("X-Blober-Meta-"+k, v)
+	}
+	if opt.UploadId != "" {
+		header.Set("X-Blober-Upload-Id", opt.UploadId)
+	}
+
+	form := map[string]string{"private": strconv.FormatBool(opt.Private)}
+	for k, v := range fields {
+		if v != "" {
+			form[k] = v
+		}
+	}
+	if len(opt.Tags) > 0 {
+		tags := make(url.Values)
+		for k, v := range opt.Tags {
+			tags.Set(k, v)
+		}
+		form["tags"] = tags.Encode()
+	}
+
+	var total int64
+	for _, f := range files {
+		if f.Size < 0 || total < 0 {
+			total = -1
+			continue
+		}
+		total += f.Size
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	header.Set("Content-Type", writer.FormDataContentType())
+
+	// the form is written while the request is sent, writing
+	// fails and stops when the transport closes the body
+	go func() {
+		progress := &progressReader{fn: opt.Progress, total: total}
+		err := func() error {
+			for k, v := range form {
+				if err := writer.WriteField(k, v); err != nil {
+					return err
+				}
+			}
+
+			for _, f := range files {
+				part, err := writer.CreateFormFile(field, f.Name)
+				if err != nil {
+					return err
+				}
+
+				progress.r = f.Reader
+				if _, err := io.Copy(part, progress); err != nil {
+					return err
+				}
+			}
+
+			return writer.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := c.send(ctx, "POST", path, nil, header, func() (io.Reader, error) { return pr, nil })
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decode(resp, out)
+}
+
+// progressReader reports bytes read through it
+type progressReader struct {
+	r     io.Reader
+	fn    ProgressFunc
+	sent  int64
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	if p.fn != nil && n > 0 {
+		p.fn(p.sent, p.total)
+	}
+
+	return n, err
+}
+
+// DownloadOptions selects a part or a transformation of a downloaded blob
+type DownloadOptions struct {
+	Offset    int64      // first byte downloaded
+	Length    int64      // bytes downloaded from Offset, 0 downloads to the end
+	Transform url.Values // image transformation, e.g w=200&h=200&fit=cover
+	VersionId string     // version downloaded by DownloadByKey, defaults to the current one
+}
+
+// Object is a downloaded blob, its content is read from the embedded reader
+type Object struct {
+	io.ReadCloser
+	ContentType string
+	Filename    string
+	ETag        string
+	Size        int64 // bytes of the downloaded part, -1 when unknown
+	TotalSize   int64 // size of the whole blob, -1 when unknown
+	Metadata    map[string]string
+	Tags        map[string]string
+}
+
+// Download downloads a blob of app. Private blobs require the private key
+func (c *Client) Download(ctx context.Context, app, hash string, opt *DownloadOptions) (*Object, error) {
+	return c.download(ctx, "/res"+appPath(app, hash), opt)
+}
+
+// DownloadByKey downloads the current version of key, or the version opt.VersionId
+func (c *Client) DownloadByKey(ctx context.Context, app, key string, opt *DownloadOptions) (*Object, error) {
+	return c.download(ctx, "/res"+appPath(app)+"/key/"+escapeKey(key), opt)
+}
+
+// download downloads a blob at path
+func (c *Client) download(ctx context.Context, path string, opt *DownloadOptions) (*Object, error) {
+	if opt == nil {
+		opt = &DownloadOptions{}
+	}
+
+	query := make(url.Values)
+	for k, v := range opt.Transform {
+		query[k] = v
+	}
+	if opt.VersionId != "" {
+		query.Set("versionId", opt.VersionId)
+	}
+
+	header := make(http.Header)
+	if opt.Offset > 0 || opt.Length > 0 {
+		end := ""
+		if opt.Length > 0 {
+			end = strconv.FormatInt(opt.Offset+opt.Length-1, 10)
+		}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%s", opt.Offset, end))
+	}
+
+	resp, err := c.send(ctx, "GET", path, query, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, decode(resp, nil)
+	}
+
+	obj := &Object{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type"),
+		ETag: strings.Trim(resp.Header.Get("ETag"), `"`), Size: resp.ContentLength, TotalSize: resp.ContentLength,
+		Metadata: make(map[string]string)}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		obj.Filename = params["filename"]
+	}
+
+	for k, v := range resp.Header {
+		if strings.HasPrefix(k, "X-Blober-Meta-") && len(v) > 0 {
+			obj.Metadata[strings.ToLower(k[len("X-Blober-Meta-"):])] = v[0]
+		}
+	}
+
+	if tags, err := models.ParseTagging(resp.Header.Get("X-Blober-Tagging")); err == nil {
+		obj.Tags = tags
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-9/100
+		if i := strings.LastIndex(resp.Header.Get("Content-Range"), "/"); i >= 0 {
+			if total, err := strconv.ParseInt(resp.Header.Get("Content-Range")[i+1:], 10, 64); err == nil {
+				obj.TotalSize = total
+			}
+		}
+		return obj, nil
+	}
+
+	// the whole blob was sent, e.g for a transformed image
+	if header.Get("Range") != "" {
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, opt.Offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		obj.Size = -1
+		if opt.Length > 0 {
+			obj.ReadCloser = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, opt.Length), resp.Body}
+			obj.Size = opt.Length
+		}
+	}
+
+	return obj, nil
+}
+
+// escapeKey escapes segments of a key, keeping slashes
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// ArchiveOptions selects blobs downloaded as an archive
+type ArchiveOptions struct {
+	Hashes []string `json:"hashes,omitempty"`
+	Prefix string   `json:"prefix,omitempty"` // key prefix, requires the private key
+	Format string   `json:"format,omitempty"` // zip(default) or tar.gz
+	Name   string   `json:"name,omitempty"`   // archive filename, without extension
+}
+
+// DownloadArchive downloads blobs of app as a single archive
+func (c *Client) DownloadArchive(ctx context.Context, app string, opt *ArchiveOptions) (io.ReadCloser, error) {
+	data, err := json.Marshal(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.send(ctx, "POST", appPath(app, "archive"), nil, nil, func() (io.Reader, error) {
+		return strings.NewReader(string(data)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decode(resp, nil)
+	}
+
+	return resp.Body, nil
+}
+
+// BlobFilter narrows down blob listings. Zero values are ignored
+type BlobFilter struct {
+	Tags        map[string]string // blobs must carry every tag
+	ContentType string            // content type prefix, e.g image/
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	MinDuration float64
+	MaxDuration float64
+	MinPages    int
+	MaxPages    int
+	Exif        map[string]string // EXIF tags, e.g Make=Canon
+}
+
+// query returns the query parameters of filter
+func (filter *BlobFilter) query() url.Values {
+	query := make(url.Values)
+	if filter == nil {
+		return query
+	}
+
+	for k, v := range filter.Tags {
+		query.Add("tag", k+":"+v)
+	}
+	for k, v := range filter.Exif {
+		query.Add("exif", k+":"+v)
+	}
+	if filter.ContentType != "" {
+		query.Set("content_type", filter.ContentType)
+	}
+
+	ints := map[string]int{"min_width": filter.MinWidth, "max_width": filter.MaxWidth,
+		"min_height": filter.MinHeight, "max_height": filter.MaxHeight,
+		"min_pages": filter.MinPages, "max_pages": filter.MaxPages}
+	for name, v := range ints {
+		if v > 0 {
+			query.Set(name, strconv.Itoa(v))
+		}
+	}
+
+	floats := map[string]float64{"min_duration": filter.MinDuration, "max_duration": filter.MaxDuration}
+	for name, v := range floats {
+		if v > 0 {
+			query.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	return query
+}
+
+// BlobIterator iterates over paginated blob listings,
+// pages are fetched as the iteration goes
+//
+//	it := c.Blobs(ctx, app.ID, nil)
+//	for it.Next() {
+//		fmt.Println(it.Blob().Key)
+//	}
+//	if err := it.Err(); err != nil {
+type BlobIterator struct {
+	fetch func(page int) ([]*models.Blob, error)
+	page  int
+	blobs []*models.Blob
+	blob  *models.Blob
+	done  bool
+	err   error
+}
+
+// Next advances to the next blob, it returns false
+// at the end of the listing or when a page fails
+func (it *BlobIterator) Next() bool {
+	for len(it.blobs) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		blobs, err := it.fetch(it.page)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page++
+		if len(blobs) == 0 {
+			it.done = true
+			return false
+		}
+		it.blobs = blobs
+	}
+
+	it.blob, it.blobs = it.blobs[0], it.blobs[1:]
+	return true
+}
+
+// Blob returns the current blob
+func (it *BlobIterator) Blob() *models.Blob {
+	return it.blob
+}
+
+// Err returns the error that stopped the iteration
+func (it *BlobIterator) Err() error {
+	return it.err
+}
+
+// Blobs iterates over current blobs of the app identified by appId
+func (c *Client) Blobs(ctx context.Context, appId uint, filter *BlobFilter) *BlobIterator {
+	query := filter.query()
+	return &BlobIterator{fetch: func(page int) ([]*models.Blob, error) {
+		blobs := make([]*models.Blob, 0)
+		path := fmt.Sprintf("/apps/%d/blobs/%d", appId, page)
+		return blobs, c.call(ctx, "GET", path, query, nil, &blobs)
+	}}
+}
+
+// Trash iterates over trashed blobs of app, most recently deleted first
+func (c *Client) Trash(ctx context.Context, app string) *BlobIterator {
+	return &BlobIterator{fetch: func(page int) ([]*models.Blob, error) {
+		blobs := make([]*models.Blob, 0)
+		return blobs, c.call(ctx, "GET", appPath(app, "trash", strconv.Itoa(page)), nil, nil, &blobs)
+	}}
+}
+
+// Tags returns the tags of a blob
+func (c *Client) Tags(ctx context.Context, app, hash string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if err := c.call(ctx, "GET", appPath(app, "blobs", hash, "tags"), nil, nil, &tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// SetTags replaces the tags of a blob
+func (c *Client) SetTags(ctx context.Context, app, hash string, tags map[string]string) (map[string]string, error) {
+	updated := make(map[string]string)
+	if err := c.call(ctx, "PUT", appPath(app, "blobs", hash, "tags"), nil, tags, &updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// CopyOptions holds attributes of a copied or moved blob
+type CopyOptions struct {
+	TargetApp string            `json:"target_app"`
+	Key       string            `json:"key,omitempty"`      // defaults to the source key
+	Private   *bool             `json:"private,omitempty"`  // defaults to the source private flag
+	Metadata  map[string]string `json:"metadata,omitempty"` // replaces source metadata when set
+	Tags      map[string]string `json:"tags,omitempty"`     // replaces source tags when set
+}
+
+// Copy copies a blob into another app of the account
+func (c *Client) Copy(ctx context.Context, app, hash string, opt *CopyOptions) (*models.Blob, error) {
+	return c.blobCall(ctx, "POST", appPath(app, "blobs", hash, "copy"), nil, opt)
+}
+
+// Move moves a blob into another app of the account
+func (c *Client) Move(ctx context.Context, app, hash string, opt *CopyOptions) (*models.Blob, error) {
+	return c.blobCall(ctx, "POST", appPath(app, "blobs", hash, "move"), nil, opt)
+}
+
+// Versions lists versions of key, or of every key when key is
+// empty. Delete markers are included, newest versions come first
+func (c *Client) Versions(ctx context.Context, app, key string) ([]*models.Blob, error) {
+	var query url.Values
+	if key != "" {
+		query = url.Values{"key": {key}}
+	}
+
+	return c.blobsCall(ctx, "GET", appPath(app, "versions"), query, nil)
+}
+
+// RestoreVersion makes an older version of a key current
+func (c *Client) RestoreVersion(ctx context.Context, app, versionId string) (*models.Blob, error) {
+	return c.blobCall(ctx, "POST", appPath(app, "versions", versionId, "restore"), nil, nil)
+}
+
+// DeleteObject deletes key, or a single version of it. Versioned
+// apps get a delete marker, which is returned, when versionId is empty
+func (c *Client) DeleteObject(ctx context.Context, app, key, versionId string) (*models.Blob, error) {
+	query := url.Values{"key": {key}}
+	if versionId != "" {
+		query.Set("versionId", versionId)
+	}
+
+	return c.blobCall(ctx, "DELETE", appPath(app, "objects"), query, nil)
+}
+
+// Delete moves a blob to trash
+func (c *Client) Delete(ctx context.Context, app, hash string) (*models.Blob, error) {
+	return c.blobCall(ctx, "DELETE", appPath(app, "blobs", hash), nil, nil)
+}
+
+// DeleteBlobs moves blobs to trash
+func (c *Client) DeleteBlobs(ctx context.Context, app string, hashes []string) ([]*models.Blob, error) {
+	return c.blobsCall(ctx, "POST", appPath(app, "blobs", "delete"), nil, map[string][]string{"hashes": hashes})
+}
+
+// RestoreBlobs restores trashed blobs by hash, or
+// every blob trashed after since when it is not nil
+func (c *Client) RestoreBlobs(ctx context.Context, app string, hashes []string, since *time.Time) ([]*models.Blob, error) {
+	in := &struct {
+		Hashes []string   `json:"hashes"`
+		Since  *time.Time `json:"since,omitempty"`
+	}{hashes, since}
+
+	return c.blobsCall(ctx, "POST", appPath(app, "trash", "restore"), nil, in)
+}
+
+// PurgeBlobs permanently removes trashed blobs, or the whole trash when hashes is empty
+func (c *Client) PurgeBlobs(ctx context.Context, app string, hashes []string) ([]*models.Blob, error) {
+	return c.blobsCall(ctx, "POST", appPath(app, "trash", "purge"), nil, map[string][]string{"hashes": hashes})
+}
+
+// blobCall calls an endpoint returning a blob
+func (c *Client) blobCall(ctx context.Context, method, path string, query url.Values, in interface{}) (*models.Blob, error) {
+	blob := &models.Blob{}
+	if err := c.call(ctx, method, path, query, in, blob); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// blobsCall calls an endpoint returning blobs
+func (c *Client) blobsCall(ctx context.Context, method, path string, query url.Values, in interface{}) ([]*models.Blob, error) {
+	blobs := make([]*models.Blob, 0)
+	if err := c.call(ctx, method, path, query, in, &blobs); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
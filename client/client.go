@@ -0,0 +1,207 @@
+// Package client is the Go client of the blober.io API.
+//
+//	c := client.New("http://localhost:9008", privateKey)
+//	blob, err := c.UploadFile(ctx, "FileShareApp", "report.pdf", &client.UploadOptions{Private: true})
+//
+// Failed calls return an *Error, or a *PolicyError for uploads refused by
+// the upload policy of an app. Idempotent calls(GET, PUT and DELETE) are
+// retried on network errors and 5xx, 423 and 429 responses
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryAfter bounds how long a Retry-After header can delay a retry
+var maxRetryAfter = 30 * time.Second
+
+// Client calls the blober.io API with the key of an account.
+// Calls requiring a private key fail with an unauthorized
+// error when Key is a public key
+type Client struct {
+	BaseURL    string // e.g http://localhost:9008
+	S3URL      string // S3 API endpoint signed urls point to, e.g http://localhost:9009
+	Region     string // BUCKET_REGION of the server, DefaultRegion when empty
+	Key        string // private or public key
+	HTTPClient *http.Client
+	MaxRetries int           // retries of idempotent calls
+	RetryWait  time.Duration // delay before the first retry, doubled for the next ones
+}
+
+// New creates a client of the API at baseURL
+func New(baseURL, key string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Key: key, HTTPClient: http.DefaultClient,
+		MaxRetries: 3, RetryWait: 200 * time.Millisecond}
+}
+
+// response is the JSON envelope of API responses, handlers.Response
+type response struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Ping checks the API is up
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "GET", "/echo", nil, nil, nil)
+}
+
+// call sends a request with in as JSON body and decodes the data of the response into out
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body func() (io.Reader, error)
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = func() (io.Reader, error) { return bytes.NewReader(data), nil }
+	}
+
+	resp, err := c.send(ctx, method, path, query, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decode(resp, out)
+}
+
+// send sends a request, retrying idempotent methods. body is called for
+// every attempt, it returns nil when the request has no body
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, header http.Header,
+	body func() (io.Reader, error)) (*http.Response, error) {
+	retries := 0
+	if idempotent(method) {
+		retries = c.MaxRetries
+	}
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		req, err := c.newRequest(ctx, method, path, query, header, body)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if attempt == retries || (err == nil && !retryable(resp.StatusCode)) {
+			return resp, err
+		}
+
+		delay := wait
+		if err == nil {
+			if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(after) * time.Second
+				if delay > maxRetryAfter {
+					delay = maxRetryAfter
+				}
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		// jitter spreads retries of concurrent clients
+		delay += time.Duration(rand.Int63n(int64(delay)/4 + 1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// newRequest creates an authenticated request
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, header http.Header,
+	body func() (io.Reader, error)) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := body()
+		if err != nil {
+			return nil, err
+		}
+		r = b
+	}
+
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Key != "" {
+		req.Header.Set("X-Blober-ID", c.Key)
+	}
+	if req.Header.Get("Content-Type") == "" && r != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// decode decodes the data of resp into out, or the error it carries
+func decode(resp *http.Response, out interface{}) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	r := &response{}
+	if err := json.Unmarshal(data, r); err != nil {
+		if resp.StatusCode >= 400 {
+			return &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return err
+	}
+
+	if r.Error || resp.StatusCode >= 400 {
+		return newError(resp.StatusCode, r)
+	}
+
+	if out == nil || len(r.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(r.Data, out)
+}
+
+// idempotent reports whether requests with method can be retried
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// retryable reports whether a response with code can succeed on retry
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusLocked || code >= 500
+}
+
+// appPath returns the escaped path of an app resource
+func appPath(app string, elems ...string) string {
+	path := "/" + url.PathEscape(app)
+	for _, e := range elems {
+		path += "/" + url.PathEscape(e)
+	}
+
+	return path
+}
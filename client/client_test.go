@@ -0,0 +1,480 @@
+package client
+
+import (
+	"blober.io/config"
+	"blober.io/handlers"
+	"blober.io/models"
+	"blober.io/repos"
+	"blober.io/services"
+	"blober.io/store"
+	"blober.io/store/redistest"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/joho/godotenv"
+)
+
+var ctx = context.Background()
+
+// newSessionStore opens a session store in a temporary directory
+func newSessionStore(t *testing.T) (store.SessionStore, func()) {
+	dir, err := ioutil.TempDir("", "blober-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := store.NewBadgerSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sessions, func() {
+		sessions.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newAuthServer serves the real handlers without database and object
+// storage, only requests refused before reaching repositories are served.
+// wrap, when not nil, wraps the router
+func newAuthServer(t *testing.T, wrap func(http.Handler) http.Handler) (*Client, func()) {
+	sessions, closeStore := newSessionStore(t)
+
+	var handler http.Handler = handlers.NewRouter(handlers.NewAccountHandler(nil), handlers.NewAppHandler(sessions, nil, nil, config.Default().Limits))
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+
+	server := httptest.NewServer(handler)
+	c := New(server.URL, "an-unknown-private-key-of-the-account")
+	c.RetryWait = time.Millisecond
+
+	return c, func() {
+		server.Close()
+		closeStore()
+	}
+}
+
+// openDatabase loads the server configuration from ../.env and opens
+// its database, tests are skipped without Postgres and MinIO
+func openDatabase(t *testing.T) (*config.Config, *gorm.DB) {
+	godotenv.Load("../.env")
+	if os.Getenv("DATABASE_URL") == "" || os.Getenv("MINIO_HOST") == "" {
+		t.Skip("DATABASE_URL and MINIO_HOST are not set")
+	}
+
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := services.CreateDatabaseConnection(cfg.DatabaseURL)
+	if err != nil {
+		t.Skipf("database is not available, %v", err)
+	}
+
+	return cfg, db
+}
+
+// newRepositories returns repositories over db and the MinIO server of
+// cfg, with sessions and blobs
+func newRepositories(t *testing.T, cfg *config.Config, db *gorm.DB, sessions store.SessionStore,
+	blobs store.BlobStore) (*repos.AccountRepository, *repos.AppRepository) {
+	storage, err := services.NewStorageService(&services.StorageServiceOption{
+		AccessKey: cfg.Storage.AccessKey,
+		SecretKey: cfg.Storage.SecretKey,
+		Host:      cfg.Storage.Host,
+		UseTLS:    cfg.Storage.UseTLS,
+		Region:    cfg.Storage.Region,
+		PublicURL: cfg.Server.PublicURL,
+		Store:     blobs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := services.NewJobQueue(db, &services.JobQueueOption{
+		PollInterval:      cfg.Jobs.PollInterval,
+		ClaimTimeout:      cfg.Jobs.ClaimTimeout,
+		HeartbeatInterval: cfg.Jobs.HeartbeatInterval,
+	})
+	accountRepo := repos.NewAccountRepository(sessions, db)
+	return accountRepo, repos.NewAppRepository(db, accountRepo, storage, queue, cfg)
+}
+
+// newServer serves the real handlers backed by Postgres and MinIO.
+// It returns a client authenticated with a new account and an app
+// of that account
+func newServer(t *testing.T) (*Client, *models.App, func()) {
+	cfg, db := openDatabase(t)
+	sessions, closeStore := newSessionStore(t)
+	dir, err := ioutil.TempDir("", "blober-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobStore, err := store.NewBadgerBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountRepo, appRepo := newRepositories(t, cfg, db, sessions, blobStore)
+	router := handlers.NewRouter(handlers.NewAccountHandler(accountRepo),
+		handlers.NewAppHandler(sessions, blobStore, appRepo, cfg.Limits))
+	server := httptest.NewServer(router)
+	closeAll := func() {
+		server.Close()
+		blobStore.Close()
+		os.RemoveAll(dir)
+		closeStore()
+		db.Close()
+	}
+
+	c := New(server.URL, "")
+	id := time.Now().UnixNano()
+	email := fmt.Sprintf("sdk%d@blober.io", id)
+	if _, err := c.CreateAccount(ctx, "Sdk", "Client", email, "a-password"); err != nil {
+		closeAll()
+		t.Fatal(err)
+	}
+
+	if _, err := c.Authenticate(ctx, email, "a-password"); err != nil {
+		closeAll()
+		t.Fatal(err)
+	}
+
+	app, err := c.CreateApp(ctx, fmt.Sprintf("sdk%d", id))
+	if err != nil {
+		closeAll()
+		t.Fatal(err)
+	}
+
+	return c, app, closeAll
+}
+
+func TestErrors(t *testing.T) {
+	c, closeServer := newAuthServer(t, nil)
+	defer closeServer()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Apps(ctx)
+	if e, ok := err.(*Error); !ok || e.StatusCode != 403 || !IsUnauthorized(err) {
+		t.Errorf("expected an unauthorized error, got %v", err)
+	}
+
+	if _, err := c.Upload(ctx, "photos", &File{Name: "a.txt", Reader: strings.NewReader("a"), Size: 1}, nil); !IsUnauthorized(err) {
+		t.Errorf("expected an unauthorized upload, got %v", err)
+	}
+
+	if err := c.call(ctx, "GET", "/no/such/address/here", nil, nil, nil); !IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	err = c.call(ctx, "DELETE", "/me/apps", nil, nil, nil)
+	if e, ok := err.(*Error); !ok || e.StatusCode != 405 || IsNotFound(err) || IsUnauthorized(err) {
+		t.Errorf("expected a method not allowed error, got %v", err)
+	}
+}
+
+// newReplica serves the real handlers with stores shared through Redis
+// at url, and repositories over db
+func newReplica(t *testing.T, url string, cfg *config.Config, db *gorm.DB) (*repos.AccountRepository,
+	store.BlobStore, *httptest.Server, func()) {
+	sessions, err := store.NewRedisSessionStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := store.NewRedisBlobStore(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountRepo, appRepo := newRepositories(t, cfg, db, sessions, blobs)
+	handler := handlers.NewRouter(handlers.NewAccountHandler(accountRepo),
+		handlers.NewAppHandler(sessions, blobs, appRepo, cfg.Limits))
+	server := httptest.NewServer(handler)
+	return accountRepo, blobs, server, func() {
+		server.Close()
+		sessions.Close()
+		blobs.Close()
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	cfg, db := openDatabase(t)
+	defer db.Close()
+
+	redis, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+
+	accountsA, blobsA, serverA, closeA := newReplica(t, redis.URL(), cfg, db)
+	defer closeA()
+
+	_, _, serverB, closeB := newReplica(t, redis.URL(), cfg, db)
+	defer closeB()
+
+	// a session started on replica A authenticates on replica B
+	id := time.Now().UnixNano()
+	admin, err := accountsA.CreateAdmin("Ada", "Admin", fmt.Sprintf("admin%d@blober.io", id), "a-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a blob cached by replica A is served from cache by replica B
+	if err := blobsA.Set(services.CacheKey("photos", "h1"), &models.Blob{Hash: "h1", AppName: "photos"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &store.CacheStats{}
+	c := New(serverB.URL, admin.Cred.PrivateAccessKey)
+	if err := c.call(ctx, "GET", "/admin/cache", nil, nil, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Entries != 1 {
+		t.Errorf("expected 1 cached blob shared by replicas, found %d", stats.Entries)
+	}
+
+	// accounts that are not admins are refused by every replica
+	if _, err := accountsA.CreateNewAccount("Lekan", "Adigun", fmt.Sprintf("user%d@blober.io", id), "a-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := accountsA.AuthenticateAccount(fmt.Sprintf("user%d@blober.io", id), "a-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, server := range []*httptest.Server{serverA, serverB} {
+		err := New(server.URL, user.Cred.PrivateAccessKey).call(ctx, "GET", "/admin/cache", nil, nil, nil)
+		if !IsUnauthorized(err) {
+			t.Errorf("expected an unauthorized error from %s, got %v", server.URL, err)
+		}
+	}
+
+	// sessions predating a demotion are refused, the flag is read from the database
+	if err := db.Model(admin).UpdateColumn("is_admin", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.call(ctx, "GET", "/admin/cache", nil, nil, nil); !IsUnauthorized(err) {
+		t.Errorf("expected a demoted admin to be refused, got %v", err)
+	}
+}
+
+func TestPolicyError(t *testing.T) {
+	r := &response{Error: true, Message: "a.exe: content type is not allowed",
+		Data: []byte(`{"filename":"a.exe","code":"type_not_allowed","message":"content type is not allowed"}`)}
+	err := newError(400, r)
+	e, ok := err.(*PolicyError)
+	if !ok || e.Violation.Code != "type_not_allowed" || !IsPolicyViolation(err) {
+		t.Fatalf("expected a policy error, got %v", err)
+	}
+
+	// partial archive reports are not violations
+	r.Data = []byte(`{"success_count":1,"failure_count":0}`)
+	if err := newError(400, r); IsPolicyViolation(err) {
+		t.Errorf("expected a plain error, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var attempts, failures int32
+	c, closeServer := newAuthServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= atomic.LoadInt32(&failures) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	defer closeServer()
+
+	// idempotent calls are retried until the real handler answers
+	failures = 2
+	if _, err := c.Apps(ctx); !IsUnauthorized(err) || attempts != 3 {
+		t.Errorf("expected 3 attempts and an unauthorized error, got %d %v", attempts, err)
+	}
+
+	// and give up after MaxRetries
+	attempts, failures = 0, 10
+	_, err := c.Apps(ctx)
+	if e, ok := err.(*Error); !ok || e.StatusCode != 503 || attempts != int32(c.MaxRetries)+1 {
+		t.Errorf("expected %d attempts and a 503 error, got %d %v", c.MaxRetries+1, attempts, err)
+	}
+
+	// other calls are sent once
+	attempts, failures = 0, 1
+	_, err = c.CreateApp(ctx, "photos")
+	if e, ok := err.(*Error); !ok || e.StatusCode != 503 || attempts != 1 {
+		t.Errorf("expected a single attempt, got %d %v", attempts, err)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	c := New("http://localhost:9008", "")
+	if _, err := c.SignedURL("GET", "photos", "a.jpg", time.Hour); err == nil {
+		t.Error("expected signing without S3URL to fail")
+	}
+
+	c.S3URL = "http://localhost:9009/"
+	c.Key = "0123456789abcdefghijklmnopqrstuvwxyz"
+	signed, err := c.SignedURL("GET", "photos", "2019/summer trip.jpg", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", signed, nil)
+	if r.URL.Path != "/photos/2019/summer trip.jpg" {
+		t.Errorf("unexpected signed url %s", signed)
+	}
+
+	s, err := services.ParseSigV4(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.AccessKey != c.Key[:20] || s.Region != DefaultRegion {
+		t.Errorf("expected access key %s in %s, got %s in %s", c.Key[:20], DefaultRegion, s.AccessKey, s.Region)
+	}
+
+	if err := s.Verify(r, c.Key, time.Now()); err != nil {
+		t.Error(err)
+	}
+
+	// urls are scoped to the region of the server
+	c.Region = "eu-west-1"
+	if signed, err = c.SignedURL("GET", "photos", "a.jpg", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = services.ParseSigV4(httptest.NewRequest("GET", signed, nil)); err != nil || s.Region != "eu-west-1" {
+		t.Errorf("expected a url scoped to eu-west-1, got %s, %v", signed, err)
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	c, app, closeServer := newServer(t)
+	defer closeServer()
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var sent, total int64
+	opt := &UploadOptions{Key: "docs/numbers.txt", Private: true, Metadata: map[string]string{"origin": "sdk"},
+		Tags: map[string]string{"kind": "test"}, Progress: func(s, t int64) { sent, total = s, t }}
+	blob, err := c.Upload(ctx, app.Name, &File{Name: "numbers.txt", Reader: bytes.NewReader(content),
+		Size: int64(len(content))}, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("expected progress up to %d bytes, got %d/%d", len(content), sent, total)
+	}
+
+	if blob.Key != "docs/numbers.txt" || !blob.IsPrivate || blob.Tags["kind"] != "test" {
+		t.Errorf("unexpected blob %+v", blob)
+	}
+
+	obj, err := c.Download(ctx, app.Name, blob.Hash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(obj)
+	obj.Close()
+	if !bytes.Equal(data, content) || obj.Metadata["origin"] != "sdk" || obj.Filename != "numbers.txt" {
+		t.Errorf("unexpected download %q %+v", obj.Filename, obj.Metadata)
+	}
+
+	obj, err = c.DownloadByKey(ctx, app.Name, "docs/numbers.txt", &DownloadOptions{Offset: 15, Length: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(obj)
+	obj.Close()
+	if string(data) != "5678901234" || obj.Size != 10 || obj.TotalSize != int64(len(content)) {
+		t.Errorf("unexpected range %q %d/%d", data, obj.Size, obj.TotalSize)
+	}
+
+	// private blobs are refused to public keys
+	public := New(c.BaseURL, "")
+	if _, err := public.Download(ctx, app.Name, blob.Hash, nil); !IsUnauthorized(err) {
+		t.Errorf("expected an unauthorized download, got %v", err)
+	}
+
+	if _, err := c.Download(ctx, app.Name, "no-such-hash", nil); err == nil {
+		t.Error("expected a missing blob to fail")
+	}
+}
+
+func TestBlobsIterator(t *testing.T) {
+	c, app, closeServer := newServer(t)
+	defer closeServer()
+
+	// more blobs than a single page
+	files := make([]*File, 25)
+	for i := range files {
+		files[i] = &File{Name: fmt.Sprintf("%02d.txt", i), Reader: strings.NewReader(fmt.Sprint(i)), Size: -1}
+	}
+
+	result, err := c.UploadMultiple(ctx, app.Name, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 25 || len(result.Blobs) != 25 {
+		t.Fatalf("expected 25 uploads, got %+v", result)
+	}
+
+	count := 0
+	it := c.Blobs(ctx, app.ID, nil)
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 25 {
+		t.Errorf("expected 25 blobs, got %d %v", count, it.Err())
+	}
+
+	if _, err := c.DeleteBlobs(ctx, app.Name, []string{result.Blobs[0].Hash, result.Blobs[1].Hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	count = 0
+	it = c.Trash(ctx, app.Name)
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 2 {
+		t.Errorf("expected 2 trashed blobs, got %d %v", count, it.Err())
+	}
+}
+
+func TestUploadPolicyViolation(t *testing.T) {
+	c, app, closeServer := newServer(t)
+	defer closeServer()
+
+	if _, err := c.SetUploadPolicy(ctx, app.Name, &models.UploadPolicy{MaxSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Upload(ctx, app.Name, &File{Name: "a.txt", Reader: strings.NewReader("too large"), Size: 9}, nil)
+	if e, ok := err.(*PolicyError); !ok || e.Violation.Code != models.ViolationTooLarge {
+		t.Errorf("expected a policy violation, got %v", err)
+	}
+}
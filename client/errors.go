@@ -0,0 +1,82 @@
+package client
+
+import (
+	"blober.io/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string
+	Data       json.RawMessage // details sent along, e.g a partial upload report
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("blober: %s (%d)", e.Message, e.StatusCode)
+}
+
+// PolicyError is returned for uploads refused by the upload policy of an app
+type PolicyError struct {
+	StatusCode int
+	Violation  *models.PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("blober: %s (%d)", e.Violation.Error(), e.StatusCode)
+}
+
+// newError creates the typed error of an error response
+func newError(code int, r *response) error {
+	e := &Error{StatusCode: code, Message: r.Message, Data: r.Data}
+	if code == http.StatusBadRequest && len(r.Data) > 0 {
+		violation := &models.PolicyViolation{}
+		if json.Unmarshal(r.Data, violation) == nil && violation.Code != "" {
+			return &PolicyError{StatusCode: code, Violation: violation}
+		}
+	}
+
+	return e
+}
+
+// apiError returns the *Error of err, if it is an API error
+func apiError(err error) (*Error, bool) {
+	switch e := err.(type) {
+	case *Error:
+		return e, true
+	case *PolicyError:
+		return &Error{StatusCode: e.StatusCode, Message: e.Violation.Error()}, true
+	}
+
+	return nil, false
+}
+
+// IsNotFound reports whether err is returned for a missing
+// app, blob or resource. Some endpoints report missing
+// resources with a 200 status, they are matched by message
+func IsNotFound(err error) bool {
+	e, ok := apiError(err)
+	return ok && (e.StatusCode == http.StatusNotFound || strings.HasSuffix(e.Message, "not found"))
+}
+
+// IsUnauthorized reports whether err is returned for a
+// missing or invalid key, or a public key used for a call
+// requiring the private key
+func IsUnauthorized(err error) bool {
+	e, ok := apiError(err)
+	return ok && (e.StatusCode == http.StatusUnauthorized ||
+		(e.StatusCode == http.StatusForbidden && e.Message == unauthorizedMessage))
+}
+
+// unauthorizedMessage is the message of handlers.UnAuthorizedResponse,
+// infected blobs are refused with the same status
+const unauthorizedMessage = "Unauthorized request"
+
+// IsPolicyViolation reports whether err is an upload refused by the upload policy
+func IsPolicyViolation(err error) bool {
+	_, ok := err.(*PolicyError)
+	return ok
+}
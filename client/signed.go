@@ -0,0 +1,34 @@
+package client
+
+import (
+	"blober.io/services"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRegion is the region signed urls are scoped to when Region
+// is not set, the default region of the server
+const DefaultRegion = "us-east-1"
+
+// SignedURL returns a url of the S3 API allowing anyone to send a method
+// request, e.g GET or PUT, on key of app until expires, at most 7 days.
+// S3URL and the private key must be set
+func (c *Client) SignedURL(method, app, key string, expires time.Duration) (string, error) {
+	if c.S3URL == "" {
+		return "", errors.New("the S3 API url is not set")
+	}
+
+	if len(c.Key) < 20 {
+		return "", errors.New("a private key is required to sign urls")
+	}
+
+	region := c.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	u := strings.TrimRight(c.S3URL, "/") + "/" + url.PathEscape(app) + "/" + escapeKey(key)
+	return services.Presign(method, u, c.Key[:20], c.Key, region, time.Now(), expires)
+}
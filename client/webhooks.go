@@ -0,0 +1,159 @@
+package client
+
+import (
+	"blober.io/models"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webhooksPath returns the path of webhooks of app, or
+// of webhooks receiving events of every app when app is empty
+func webhooksPath(app string) string {
+	if app == "" {
+		return "/me/webhooks"
+	}
+
+	return appPath(app, "webhooks")
+}
+
+// CreateWebhook subscribes url to events of app, or of every app when
+// app is empty. The secret signing deliveries is only returned here
+func (c *Client) CreateWebhook(ctx context.Context, app, url string, events []string) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	in := map[string]interface{}{"url": url, "events": events}
+	if err := c.call(ctx, "POST", webhooksPath(app), nil, in, hook); err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// Webhooks lists webhooks of app, or every webhook of the account when app is empty
+func (c *Client) Webhooks(ctx context.Context, app string) ([]*models.Webhook, error) {
+	hooks := make([]*models.Webhook, 0)
+	if err := c.call(ctx, "GET", webhooksPath(app), nil, nil, &hooks); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes a webhook
+func (c *Client) DeleteWebhook(ctx context.Context, id uint) error {
+	return c.call(ctx, "DELETE", "/me/webhooks/"+strconv.Itoa(int(id)), nil, nil, nil)
+}
+
+// Deliveries returns a page of the delivery log of a webhook, newest first
+func (c *Client) Deliveries(ctx context.Context, webhookId uint, page int) ([]*models.WebhookDelivery, error) {
+	deliveries := make([]*models.WebhookDelivery, 0)
+	path := "/me/webhooks/" + strconv.Itoa(int(webhookId)) + "/deliveries/" + strconv.Itoa(page)
+	if err := c.call(ctx, "GET", path, nil, nil, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Redeliver sends a past delivery again
+func (c *Client) Redeliver(ctx context.Context, deliveryId uint) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+	path := "/me/webhooks/deliveries/" + strconv.Itoa(int(deliveryId)) + "/redeliver"
+	if err := c.call(ctx, "POST", path, nil, nil, delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// CreateImport queues remote urls to be fetched into app
+func (c *Client) CreateImport(ctx context.Context, app string, items []*models.ImportURL, private bool) (*models.Import, error) {
+	im := &models.Import{}
+	in := map[string]interface{}{"items": items, "private": private}
+	if err := c.call(ctx, "POST", appPath(app, "import"), nil, in, im); err != nil {
+		return nil, err
+	}
+
+	return im, nil
+}
+
+// Import returns an import and the progress of its urls
+func (c *Client) Import(ctx context.Context, app string, id uint) (*models.Import, error) {
+	im := &models.Import{}
+	if err := c.call(ctx, "GET", appPath(app, "import", strconv.Itoa(int(id))), nil, nil, im); err != nil {
+		return nil, err
+	}
+
+	return im, nil
+}
+
+// EventStream is the activity stream of an app
+type EventStream struct {
+	LastEventId string // id of the last event read, to resume a stream
+
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// Events opens the activity stream of app. Events published
+// after lastEventId are replayed first when it is not empty
+func (c *Client) Events(ctx context.Context, app, lastEventId string) (*EventStream, error) {
+	header := make(http.Header)
+	if lastEventId != "" {
+		header.Set("Last-Event-ID", lastEventId)
+	}
+
+	resp, err := c.send(ctx, "GET", appPath(app, "events"), nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		defer resp.Body.Close()
+		return nil, decode(resp, nil)
+	}
+
+	return &EventStream{LastEventId: lastEventId, body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next blocks until the next event. Event data is decoded as generic json
+func (s *EventStream) Next() (*models.Event, error) {
+	var id, data string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			// heartbeats are comments, they end without data
+			if data == "" {
+				continue
+			}
+
+			e := &models.Event{}
+			if err := json.Unmarshal([]byte(data), e); err != nil {
+				return nil, err
+			}
+			if id != "" {
+				s.LastEventId = id
+			}
+			return e, nil
+		case strings.HasPrefix(line, "id:"):
+			id = strings.TrimSpace(line[len("id:"):])
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(line[len("data:"):])
+		}
+	}
+}
+
+// Close closes the stream
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
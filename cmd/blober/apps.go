@@ -0,0 +1,71 @@
+package main
+
+import (
+	"blober.io/models"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// runApp creates, lists and deletes apps
+func runApp(cmd *cli, args []string) error {
+	force := cmd.flags.Bool("force", false, "delete an app and every blob it holds")
+	// flags follow the app command, e.g app delete -force NAME
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = append(args[:1:1], cmd.parse(args[1:])...)
+	} else {
+		args = cmd.parse(args)
+	}
+
+	if len(args) == 0 {
+		return cmd.usageError("missing app command")
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		app, err := api.CreateApp(cmd.ctx, args[1])
+		if err != nil {
+			return err
+		}
+
+		return cmd.output(app, func(w io.Writer) {
+			fmt.Fprintf(w, "created app %s\n", app.Name)
+		})
+	case args[0] == "list" && len(args) == 1:
+		apps, err := api.Apps(cmd.ctx)
+		if err != nil {
+			return err
+		}
+
+		return cmd.output(apps, func(w io.Writer) {
+			printApps(w, apps)
+		})
+	case args[0] == "delete" && len(args) == 2:
+		app, err := api.DeleteApp(cmd.ctx, args[1], *force)
+		if err != nil {
+			return err
+		}
+
+		return cmd.output(app, func(w io.Writer) {
+			fmt.Fprintf(w, "deleted app %s\n", app.Name)
+		})
+	}
+
+	return cmd.usageError("invalid app command")
+}
+
+// printApps prints a table of apps
+func printApps(w io.Writer, apps []*models.App) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVERSIONING\tCREATED")
+	for _, app := range apps {
+		fmt.Fprintf(tw, "%s\t%t\t%s\n", app.Name, app.Versioning, app.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"blober.io/client"
+	"blober.io/models"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partialSuffix names files being downloaded, they
+// are renamed once their content is complete
+const partialSuffix = ".blober-partial"
+
+// transfer is an upload or download reported by cp and sync
+type transfer struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// tagFlag collects repeated -tag KEY=VALUE flags
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 1 {
+		return fmt.Errorf("tags are written KEY=VALUE, got %q", value)
+	}
+
+	t[value[:i]] = value[i+1:]
+	return nil
+}
+
+// transferFlags registers the flags of commands transferring files
+func transferFlags(flags *flag.FlagSet) (workers *int, private *bool, tags tagFlag) {
+	tags = make(tagFlag)
+	workers = flags.Int("parallel", 4, "number of files transferred at once")
+	private = flags.Bool("private", false, "upload private blobs, only downloadable with the private key")
+	flags.Var(tags, "tag", "tag of uploaded blobs, KEY=VALUE, repeatable")
+	return workers, private, tags
+}
+
+// runCp uploads local files to an app, or downloads blobs to a local directory
+func runCp(cmd *cli, args []string) error {
+	recursive := cmd.flags.Bool("r", false, "copy directories, or every key under a prefix")
+	workers, private, tags := transferFlags(cmd.flags)
+	args = cmd.parse(args)
+	if len(args) < 2 {
+		return cmd.usageError("missing source or destination")
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	sources, destination := args[:len(args)-1], args[len(args)-1]
+	if dst, ok := parseRemote(destination); ok {
+		for _, src := range sources {
+			if _, ok := parseRemote(src); ok {
+				return fmt.Errorf("copies between apps are not supported, %s", src)
+			}
+		}
+
+		files, err := localFiles(sources, *recursive)
+		if err != nil {
+			return err
+		}
+
+		// a single file is uploaded as the key, unless it is a prefix
+		single := len(files) == 1 && dst.Key != "" && !strings.HasSuffix(dst.Key, "/") && !isDir(sources[0])
+		keys := make([]string, len(files))
+		for i, f := range files {
+			keys[i] = uploadKey(dst.Key, f.Rel, single)
+		}
+
+		opt := &client.UploadOptions{Private: *private, Tags: tags}
+		transfers, errs := uploadFiles(cmd, api, dst.App, files, keys, opt, *workers)
+		return cmd.report(transfers, errs)
+	}
+
+	files := make([]*models.Blob, 0)
+	paths := make([]string, 0)
+	for _, source := range sources {
+		src, ok := parseRemote(source)
+		if !ok {
+			return fmt.Errorf("either the source or the destination must be APP:KEY, %s is local", source)
+		}
+
+		app, err := findApp(cmd.ctx, api, src.App)
+		if err != nil {
+			return err
+		}
+
+		blobs, err := listRemote(cmd.ctx, api, app, src.Key, *recursive, nil)
+		if err != nil {
+			return err
+		}
+		if len(blobs) == 0 {
+			return fmt.Errorf("no blob matches %s", src)
+		}
+
+		// a single key is downloaded as the destination,
+		// unless it is a directory
+		single := len(sources) == 1 && len(blobs) == 1 && !*recursive && !isGlob(src.Key) &&
+			!strings.HasSuffix(src.Key, "/") && !isDir(destination) && !strings.HasSuffix(destination, "/") &&
+			!strings.HasSuffix(destination, string(filepath.Separator))
+		base := keyBase(src.Key)
+		for _, b := range blobs {
+			p := destination
+			if !single {
+				if p, err = localPath(destination, strings.TrimPrefix(blobKey(b), base)); err != nil {
+					return err
+				}
+			}
+
+			files = append(files, b)
+			paths = append(paths, p)
+		}
+	}
+
+	transfers, errs := downloadBlobs(cmd, api, files, paths, *workers)
+	return cmd.report(transfers, errs)
+}
+
+// uploadKey returns the key of a file named rel uploaded to
+// prefix, or to the key itself for a single file
+func uploadKey(prefix, rel string, single bool) string {
+	if single {
+		return prefix
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return prefix + rel
+}
+
+// uploadFiles uploads files to app as keys, workers at a time
+func uploadFiles(cmd *cli, api *client.Client, app string, files []*localFile, keys []string,
+	opt *client.UploadOptions, workers int) ([]*transfer, []error) {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+
+	bar := newProgress(cmd, len(files), total)
+	defer bar.finish()
+
+	transfers := make([]*transfer, len(files))
+	errs := parallel(cmd.ctx, len(files), workers, func(i int) error {
+		f := files[i]
+		t := &transfer{Source: f.Path, Destination: remote{App: app, Key: keys[i]}.String(), Size: f.Size}
+		transfers[i] = t
+
+		blob, err := uploadFile(cmd.ctx, api, app, f, keys[i], opt, bar)
+		if err != nil {
+			t.Error = err.Error()
+			bar.logf("failed to upload %s, %v", f.Path, err)
+			return err
+		}
+
+		t.Hash = blob.Hash
+		bar.done()
+		return nil
+	})
+
+	return transfers, errs
+}
+
+// uploadFile uploads f to app as key, sent bytes are counted in bar
+func uploadFile(ctx context.Context, api *client.Client, app string, f *localFile, key string,
+	opt *client.UploadOptions, bar *progress) (*models.Blob, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sent int64
+	fileOpt := *opt
+	fileOpt.Key = key
+	fileOpt.Progress = func(n, _ int64) {
+		bar.add(n - sent)
+		sent = n
+	}
+
+	blob, err := api.Upload(ctx, app, &client.File{Name: filepath.Base(f.Path), Reader: file, Size: f.Size}, &fileOpt)
+	if err != nil {
+		bar.add(-sent)
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// downloadBlobs downloads blobs to paths, workers at a time
+func downloadBlobs(cmd *cli, api *client.Client, blobs []*models.Blob, paths []string, workers int) ([]*transfer, []error) {
+	var total int64
+	for _, b := range blobs {
+		total += b.Size
+	}
+
+	bar := newProgress(cmd, len(blobs), total)
+	defer bar.finish()
+
+	transfers := make([]*transfer, len(blobs))
+	errs := parallel(cmd.ctx, len(blobs), workers, func(i int) error {
+		b := blobs[i]
+		t := &transfer{Source: remote{App: b.AppName, Key: blobKey(b)}.String(), Destination: paths[i],
+			Size: b.Size, Hash: b.Hash}
+		transfers[i] = t
+
+		if err := downloadBlob(cmd.ctx, api, b, paths[i], bar); err != nil {
+			t.Error = err.Error()
+			bar.logf("failed to download %s, %v", t.Source, err)
+			return err
+		}
+
+		bar.done()
+		return nil
+	})
+
+	return transfers, errs
+}
+
+// downloadBlob downloads blob to dst, received bytes are counted in bar.
+// The content is written next to dst and renamed once complete
+func downloadBlob(ctx context.Context, api *client.Client, blob *models.Blob, dst string, bar *progress) error {
+	obj, err := api.Download(ctx, blob.AppName, blob.Hash, nil)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	partial := dst + partialSuffix
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+
+	r := &countingReader{Reader: obj, progress: bar}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		bar.add(-r.read)
+		os.Remove(partial)
+		return err
+	}
+
+	return os.Rename(partial, dst)
+}
+
+// report prints transfers and returns an error when some failed
+func (c *cli) report(transfers []*transfer, errs []error) error {
+	err := c.output(transfers, func(w io.Writer) {
+		if c.quiet {
+			return
+		}
+
+		for _, t := range transfers {
+			if t != nil && t.Error == "" {
+				fmt.Fprintf(w, "%s -> %s\n", t.Source, t.Destination)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return failures(errs)
+}
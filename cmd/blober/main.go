@@ -0,0 +1,160 @@
+// Command blober is the command-line tool of blober.io.
+//
+//	blober login -url http://localhost:9008 -email user@mail.com
+//	blober app create FileShareApp
+//	blober cp -r ./photos FileShareApp:photos/
+//	blober ls FileShareApp:photos/
+//	blober sync -delete ./site FileShareApp:site/
+//	blober url -expires 1h FileShareApp:photos/a.jpg
+//
+// Remote paths are written APP:KEY, a key ending with / or used
+// with -r is a prefix, keys holding *, ? or [ are glob patterns.
+// Every command accepts -profile, -json and -quiet
+package main
+
+import (
+	"blober.io/client"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	usage   string // arguments, after the command name
+	summary string
+	run     func(cmd *cli, args []string) error
+}
+
+var commands = map[string]*command{
+	"login": {"[-url URL] [-s3-url URL] [-email EMAIL] [-password PASSWORD | -key KEY]",
+		"authenticate and store the key in a profile", runLogin},
+	"app":  {"create NAME | list | delete [-force] NAME", "manage apps", runApp},
+	"cp":   {"[-r] [-parallel N] [-private] SOURCE... DESTINATION", "upload or download files", runCp},
+	"ls":   {"[-type TYPE] [-tag KEY=VALUE] [APP[:PREFIX]]", "list apps, or blobs of an app", runLs},
+	"rm":   {"[-r] APP:KEY...", "move blobs to the trash of their app", runRm},
+	"sync": {"[-delete] [-dry-run] [-parallel N] SOURCE DESTINATION", "mirror a directory to or from an app", runSync},
+	"url":  {"[-method METHOD] [-expires DURATION] APP:KEY", "create a signed url of a key", runURL},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" && name != "-h" && name != "-help" {
+			fmt.Fprintf(os.Stderr, "blober: unknown command %q\n", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	c := newCLI(ctx, name, cmd)
+	if err := cmd.run(c, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "blober %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the commands of the tool
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: blober <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-6s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun blober <command> -h for the flags of a command")
+}
+
+// cli holds the flags shared by every command
+type cli struct {
+	ctx     context.Context
+	flags   *flag.FlagSet
+	profile string
+	json    bool
+	quiet   bool
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// newCLI creates the flag set of a command with the shared flags
+func newCLI(ctx context.Context, name string, cmd *command) *cli {
+	c := &cli{ctx: ctx, flags: flag.NewFlagSet(name, flag.ExitOnError), stdout: os.Stdout, stderr: os.Stderr}
+	c.flags.StringVar(&c.profile, "profile", defaultProfile(), "profile holding the url and key, $BLOBER_PROFILE")
+	c.flags.BoolVar(&c.json, "json", false, "print results as json")
+	c.flags.BoolVar(&c.quiet, "quiet", false, "do not print progress")
+	c.flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: blober %s %s\n\n%s\n\nflags:\n", name, cmd.usage, cmd.summary)
+		c.flags.PrintDefaults()
+	}
+	return c
+}
+
+// parse parses the flags of the command and returns its arguments
+func (c *cli) parse(args []string) []string {
+	c.flags.Parse(args)
+	return c.flags.Args()
+}
+
+// client returns an API client configured by the profile
+func (c *cli) client() (*client.Client, error) {
+	p, err := loadProfile(c.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	api := client.New(p.URL, p.Key)
+	api.S3URL = p.S3URL
+	return api, nil
+}
+
+// output prints v as json with -json, or calls text
+func (c *cli) output(v interface{}, text func(w io.Writer)) error {
+	if !c.json {
+		text(c.stdout)
+		return nil
+	}
+
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// logf prints a line about the progress of a command,
+// silenced by -quiet and -json
+func (c *cli) logf(format string, args ...interface{}) {
+	if c.quiet || c.json {
+		return
+	}
+
+	fmt.Fprintf(c.stderr, format+"\n", args...)
+}
+
+// usageError prints the usage of the command and returns an error
+func (c *cli) usageError(format string, args ...interface{}) error {
+	c.flags.Usage()
+	return fmt.Errorf(format, args...)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"blober.io/client"
+	"blober.io/models"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// deleteBatch is the number of blobs trashed per request
+const deleteBatch = 100
+
+// runLs lists apps, or blobs of an app under a prefix
+func runLs(cmd *cli, args []string) error {
+	contentType := cmd.flags.String("type", "", "content type prefix of listed blobs, e.g image/")
+	tags := make(tagFlag)
+	cmd.flags.Var(tags, "tag", "tag listed blobs carry, KEY=VALUE, repeatable")
+	args = cmd.parse(args)
+	if len(args) > 1 {
+		return cmd.usageError("too many arguments")
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		apps, err := api.Apps(cmd.ctx)
+		if err != nil {
+			return err
+		}
+
+		return cmd.output(apps, func(w io.Writer) {
+			printApps(w, apps)
+		})
+	}
+
+	src, ok := parseRemote(args[0])
+	if !ok {
+		src = remote{App: args[0]}
+	}
+
+	app, err := findApp(cmd.ctx, api, src.App)
+	if err != nil {
+		return err
+	}
+
+	filter := &client.BlobFilter{ContentType: *contentType, Tags: tags}
+	blobs, err := listRemote(cmd.ctx, api, app, src.Key, true, filter)
+	if err != nil {
+		return err
+	}
+
+	return cmd.output(blobs, func(w io.Writer) {
+		var total int64
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, b := range blobs {
+			access := "public"
+			if b.IsPrivate {
+				access = "private"
+			}
+
+			total += b.Size
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", b.UpdatedAt.Format("2006-01-02 15:04"), formatSize(b.Size),
+				access, b.Hash, blobKey(b))
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "%d blobs, %s\n", len(blobs), formatSize(total))
+	})
+}
+
+// runRm moves blobs to the trash of their app
+func runRm(cmd *cli, args []string) error {
+	recursive := cmd.flags.Bool("r", false, "remove every key under a prefix")
+	args = cmd.parse(args)
+	if len(args) == 0 {
+		return cmd.usageError("missing APP:KEY")
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	deleted := make([]*models.Blob, 0)
+	for _, arg := range args {
+		src, ok := parseRemote(arg)
+		if !ok {
+			return fmt.Errorf("%s is not APP:KEY", arg)
+		}
+
+		// an empty key would remove the whole app
+		if src.Key == "" && !*recursive {
+			return fmt.Errorf("%s is not a key, use -r to remove every blob", arg)
+		}
+
+		app, err := findApp(cmd.ctx, api, src.App)
+		if err != nil {
+			return err
+		}
+
+		blobs, err := listRemote(cmd.ctx, api, app, src.Key, *recursive, nil)
+		if err != nil {
+			return err
+		}
+		if len(blobs) == 0 {
+			return fmt.Errorf("no blob matches %s", arg)
+		}
+
+		trashed, err := deleteBlobs(cmd, api, app.Name, blobs)
+		deleted = append(deleted, trashed...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cmd.output(deleted, func(w io.Writer) {
+		for _, b := range deleted {
+			fmt.Fprintf(w, "removed %s\n", remote{App: b.AppName, Key: blobKey(b)})
+		}
+	})
+}
+
+// deleteBlobs moves blobs of app to its trash, in batches
+func deleteBlobs(cmd *cli, api *client.Client, app string, blobs []*models.Blob) ([]*models.Blob, error) {
+	deleted := make([]*models.Blob, 0, len(blobs))
+	for start := 0; start < len(blobs); start += deleteBatch {
+		end := start + deleteBatch
+		if end > len(blobs) {
+			end = len(blobs)
+		}
+
+		hashes := make([]string, 0, end-start)
+		for _, b := range blobs[start:end] {
+			hashes = append(hashes, b.Hash)
+		}
+
+		trashed, err := api.DeleteBlobs(cmd.ctx, app, hashes)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, trashed...)
+	}
+
+	return deleted, nil
+}
+
+// runURL prints a signed url of a key
+func runURL(cmd *cli, args []string) error {
+	method := cmd.flags.String("method", "GET", "method the url allows, e.g PUT to upload")
+	expires := cmd.flags.Duration("expires", time.Hour, "validity of the url, at most 168h")
+	args = cmd.parse(args)
+	if len(args) != 1 {
+		return cmd.usageError("expected a single APP:KEY")
+	}
+
+	src, ok := parseRemote(args[0])
+	if !ok || src.Key == "" {
+		return fmt.Errorf("%s is not APP:KEY", args[0])
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	if api.S3URL == "" {
+		return fmt.Errorf("the S3 API url of profile %q is not set, run blober login -s3-url URL", cmd.profile)
+	}
+
+	signed, err := api.SignedURL(*method, src.App, src.Key, *expires)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{"url": signed, "method": *method, "expires_at": time.Now().Add(*expires)}
+	return cmd.output(result, func(w io.Writer) {
+		fmt.Fprintln(w, signed)
+	})
+}
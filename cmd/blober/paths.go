@@ -0,0 +1,261 @@
+package main
+
+import (
+	"blober.io/client"
+	"blober.io/models"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// remote is a location in an app, written APP:KEY
+type remote struct {
+	App string
+	Key string
+}
+
+func (r remote) String() string {
+	return r.App + ":" + r.Key
+}
+
+// parseRemote parses APP:KEY, ok is false for local paths.
+// Drive letters(C:\dir) and paths holding a separator
+// before the colon(./a:b) are local
+func parseRemote(arg string) (r remote, ok bool) {
+	i := strings.Index(arg, ":")
+	if i < 2 || strings.ContainsAny(arg[:i], `/\`) || strings.HasPrefix(arg, ".") {
+		return remote{}, false
+	}
+
+	return remote{App: arg[:i], Key: strings.TrimLeft(arg[i+1:], "/")}, true
+}
+
+// isGlob reports whether key is a glob pattern
+func isGlob(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// match reports whether key is selected by pattern. Glob patterns match
+// keys, or with recursive their parent directories. Otherwise keys match
+// exactly, and prefixes ending with / or used with recursive select every
+// key under them. An empty pattern selects every key when recursive
+func match(pattern, key string, recursive bool) bool {
+	if isGlob(pattern) {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+
+		for i := 0; recursive && i < len(key); i++ {
+			if key[i] != '/' {
+				continue
+			}
+
+			if ok, _ := path.Match(pattern, key[:i]); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if strings.HasSuffix(pattern, "/") || recursive {
+		dir := strings.TrimSuffix(pattern, "/")
+		return dir == "" || key == dir || strings.HasPrefix(key, dir+"/")
+	}
+
+	return key == pattern
+}
+
+// keyBase returns the part of pattern stripped from matched keys
+// to name their copies, the directory holding what pattern selects
+func keyBase(pattern string) string {
+	if isGlob(pattern) {
+		pattern = pattern[:strings.IndexAny(pattern, "*?[")]
+	} else if strings.HasSuffix(pattern, "/") {
+		return pattern
+	}
+
+	if i := strings.LastIndex(pattern, "/"); i >= 0 {
+		return pattern[:i+1]
+	}
+
+	return ""
+}
+
+// blobKey returns the key of blob, blobs uploaded
+// before keys were introduced go by their filename
+func blobKey(blob *models.Blob) string {
+	if blob.Key != "" {
+		return blob.Key
+	}
+
+	return blob.Filename
+}
+
+// findApp returns the app of the account named name
+func findApp(ctx context.Context, api *client.Client, name string) (*models.App, error) {
+	apps, err := api.Apps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, app := range apps {
+		if app.Name == name {
+			return app, nil
+		}
+	}
+
+	return nil, fmt.Errorf("app %s not found", name)
+}
+
+// listRemote returns blobs of an app matching pattern, ordered by key.
+// Several blobs of a key are ordered from the oldest
+func listRemote(ctx context.Context, api *client.Client, app *models.App, pattern string, recursive bool,
+	filter *client.BlobFilter) ([]*models.Blob, error) {
+	blobs := make([]*models.Blob, 0)
+	it := api.Blobs(ctx, app.ID, filter)
+	for it.Next() {
+		if b := it.Blob(); match(pattern, blobKey(b), recursive) {
+			b.AppName = app.Name
+			blobs = append(blobs, b)
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(blobs, func(i, j int) bool {
+		return blobKey(blobs[i]) < blobKey(blobs[j])
+	})
+	return blobs, nil
+}
+
+// localFile is a file to upload or to compare with a blob
+type localFile struct {
+	Path string // path on disk
+	Rel  string // slash separated path naming the blob
+	Size int64
+	md5  string
+}
+
+// MD5 returns the md5 of the content of the file, computed once
+func (f *localFile) MD5() (string, error) {
+	if f.md5 != "" {
+		return f.md5, nil
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	f.md5 = fmt.Sprintf("%x", h.Sum(nil))
+	return f.md5, nil
+}
+
+// localFiles expands paths and glob patterns into files. Directories require
+// recursive, their files are named after the directory unless the path ends
+// with a separator, like rsync
+func localFiles(args []string, recursive bool) ([]*localFile, error) {
+	files := make([]*localFile, 0)
+	for _, arg := range args {
+		paths := []string{arg}
+		if isGlob(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no file matches %s", arg)
+			}
+			paths = matches
+		}
+
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				files = append(files, &localFile{Path: p, Rel: filepath.Base(p), Size: info.Size()})
+				continue
+			}
+
+			if !recursive {
+				return nil, fmt.Errorf("%s is a directory, use -r", p)
+			}
+
+			prefix := filepath.Base(p) + "/"
+			if strings.HasSuffix(p, string(filepath.Separator)) || strings.HasSuffix(p, "/") {
+				prefix = ""
+			}
+
+			dir, err := walkDir(p)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range dir {
+				f.Rel = prefix + f.Rel
+				files = append(files, f)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// walkDir returns regular files under dir, named by their slash
+// separated path relative to dir. Partial downloads are skipped
+func walkDir(dir string) ([]*localFile, error) {
+	files := make([]*localFile, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || strings.HasSuffix(p, partialSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &localFile{Path: p, Rel: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+
+	return files, err
+}
+
+// localPath returns the path under dir a blob named rel is downloaded
+// to, keys are cleaned so they can not escape dir, e.g ../a
+func localPath(dir, rel string) (string, error) {
+	clean := path.Clean("/" + rel)[1:]
+	if clean == "" {
+		return "", fmt.Errorf("invalid key %q", rel)
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// isDir reports whether p is an existing directory
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRemote(t *testing.T) {
+	remotes := map[string]remote{
+		"photos:":             {App: "photos"},
+		"photos:2019/a.jpg":   {App: "photos", Key: "2019/a.jpg"},
+		"photos:/2019/":       {App: "photos", Key: "2019/"},
+		"photos:2019/*.jpg":   {App: "photos", Key: "2019/*.jpg"},
+		"photos:a:b/c.jpg":    {App: "photos", Key: "a:b/c.jpg"},
+		"FileShareApp:report": {App: "FileShareApp", Key: "report"},
+	}
+	for arg, expected := range remotes {
+		r, ok := parseRemote(arg)
+		if !ok || r != expected {
+			t.Errorf("expected %s to be %+v, found %+v", arg, expected, r)
+		}
+	}
+
+	for _, arg := range []string{"photos", "./a:b", "dir/a:b", `C:\photos`, ":key", ".hidden:a"} {
+		if r, ok := parseRemote(arg); ok {
+			t.Errorf("expected %s to be local, found %+v", arg, r)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		recursive    bool
+		expected     bool
+	}{
+		{"a.jpg", "a.jpg", false, true},
+		{"a.jpg", "a.jpg.bak", false, false},
+		{"photos", "photos/a.jpg", false, false},
+		{"photos", "photos/a.jpg", true, true},
+		{"photos", "photos2/a.jpg", true, false},
+		{"photos/", "photos/2019/a.jpg", false, true},
+		{"", "a.jpg", true, true},
+		{"", "a.jpg", false, false},
+		{"photos/*.jpg", "photos/a.jpg", false, true},
+		{"photos/*.jpg", "photos/2019/a.jpg", false, false},
+		{"photos/*", "photos/2019/a.jpg", true, true},
+		{"photos/*", "photos/2019/a.jpg", false, false},
+		{"[ab].txt", "b.txt", false, true},
+	}
+
+	for _, c := range cases {
+		if match(c.pattern, c.key, c.recursive) != c.expected {
+			t.Errorf("expected match(%q, %q, %t) to be %t", c.pattern, c.key, c.recursive, c.expected)
+		}
+	}
+}
+
+func TestKeyBase(t *testing.T) {
+	bases := map[string]string{
+		"a.jpg":             "",
+		"photos":            "",
+		"photos/":           "photos/",
+		"photos/2019/a.jpg": "photos/2019/",
+		"photos/*.jpg":      "photos/",
+		"photos/20*/a.jpg":  "photos/",
+	}
+	for pattern, expected := range bases {
+		if base := keyBase(pattern); base != expected {
+			t.Errorf("expected base of %s to be %q, found %q", pattern, expected, base)
+		}
+	}
+}
+
+func TestUploadKey(t *testing.T) {
+	if key := uploadKey("docs/report.pdf", "a.pdf", true); key != "docs/report.pdf" {
+		t.Errorf("expected a single file to be uploaded as the key, found %s", key)
+	}
+
+	if key := uploadKey("docs", "site/index.html", false); key != "docs/site/index.html" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	if key := uploadKey("", "index.html", false); key != "index.html" {
+		t.Errorf("unexpected key %s", key)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	paths := map[string]string{
+		"a.jpg":         filepath.Join("out", "a.jpg"),
+		"2019/a.jpg":    filepath.Join("out", "2019", "a.jpg"),
+		"../../etc/pwd": filepath.Join("out", "etc", "pwd"),
+		"/a.jpg":        filepath.Join("out", "a.jpg"),
+	}
+	for rel, expected := range paths {
+		p, err := localPath("out", rel)
+		if err != nil || p != expected {
+			t.Errorf("expected %s to be downloaded to %s, found %s %v", rel, expected, p, err)
+		}
+	}
+
+	if _, err := localPath("out", ".."); err == nil {
+		t.Error("expected keys naming the directory to be refused")
+	}
+}
+
+func TestLocalFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blober-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	site := filepath.Join(dir, "site")
+	os.MkdirAll(filepath.Join(site, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(site, "index.html"), []byte("<html>"), 0644)
+	ioutil.WriteFile(filepath.Join(site, "css", "main.css"), []byte("body{}"), 0644)
+	ioutil.WriteFile(filepath.Join(site, "css", "old.css"+partialSuffix), []byte("body"), 0644)
+
+	if _, err := localFiles([]string{site}, false); err == nil {
+		t.Error("expected directories to require recursive")
+	}
+
+	files, err := localFiles([]string{site}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Rel != "site/css/main.css" || files[1].Rel != "site/index.html" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+
+	files, err = localFiles([]string{site + string(filepath.Separator)}, true)
+	if err != nil || len(files) != 2 || files[0].Rel != "css/main.css" {
+		t.Fatalf("expected a trailing separator to drop the directory name, found %+v %v", files, err)
+	}
+
+	files, err = localFiles([]string{filepath.Join(site, "*.html")}, false)
+	if err != nil || len(files) != 1 || files[0].Rel != "index.html" || files[0].Size != 6 {
+		t.Fatalf("unexpected glob files %+v %v", files, err)
+	}
+
+	sum, err := files[0].MD5()
+	if err != nil || sum != "166248a6129a1e4370d20adc2d4c23f3" {
+		t.Errorf("unexpected md5 %s %v", sum, err)
+	}
+}
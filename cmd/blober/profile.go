@@ -0,0 +1,183 @@
+package main
+
+import (
+	"blober.io/client"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// profile is a stored blober.io endpoint and the key used to call it
+type profile struct {
+	URL   string `json:"url"`
+	S3URL string `json:"s3_url,omitempty"`
+	Email string `json:"email,omitempty"`
+	Key   string `json:"key"`
+}
+
+// defaultURL is the API url of new profiles
+const defaultURL = "http://localhost:9008"
+
+// defaultProfile returns the profile used without -profile
+func defaultProfile() string {
+	if name := os.Getenv("BLOBER_PROFILE"); name != "" {
+		return name
+	}
+
+	return "default"
+}
+
+// configPath returns the path of the file holding profiles,
+// $BLOBER_CONFIG or ~/.blober/config.json
+func configPath() (string, error) {
+	if path := os.Getenv("BLOBER_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".blober", "config.json"), nil
+}
+
+// loadProfiles reads every stored profile
+func loadProfiles() (map[string]*profile, error) {
+	profiles := make(map[string]*profile)
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid config %s, %v", path, err)
+	}
+
+	return profiles, nil
+}
+
+// loadProfile reads the profile name
+func loadProfile(name string) (*profile, error) {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := profiles[name]
+	if !ok || p.Key == "" {
+		return nil, fmt.Errorf("profile %q not found, run blober login first", name)
+	}
+
+	return p, nil
+}
+
+// saveProfile stores p as name, the file is only readable by its owner
+func saveProfile(name string, p *profile) error {
+	profiles, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	profiles[name] = p
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// runLogin authenticates with an email and password, or checks a key,
+// and stores the private key in a profile
+func runLogin(cmd *cli, args []string) error {
+	apiURL := cmd.flags.String("url", "", "url of the blober.io API, defaults to "+defaultURL)
+	s3URL := cmd.flags.String("s3-url", "", "url of the S3 API signed urls point to, e.g http://localhost:9009")
+	email := cmd.flags.String("email", "", "email of the account")
+	password := cmd.flags.String("password", "", "password of the account, read from stdin when empty")
+	key := cmd.flags.String("key", "", "private key to store instead of authenticating")
+	if len(cmd.parse(args)) > 0 {
+		return cmd.usageError("unexpected arguments")
+	}
+
+	// login again keeps the urls of an existing profile
+	p, err := loadProfile(cmd.profile)
+	if err != nil {
+		p = &profile{URL: defaultURL}
+	}
+
+	if *apiURL != "" {
+		p.URL = *apiURL
+	}
+	if *s3URL != "" {
+		p.S3URL = *s3URL
+	}
+
+	api := client.New(p.URL, *key)
+	if *key == "" {
+		if *email == "" {
+			*email = p.Email
+		}
+		if *email == "" {
+			return cmd.usageError("either -email or -key is required")
+		}
+
+		if *password == "" {
+			fmt.Fprint(cmd.stderr, "password: ")
+			if *password, err = readLine(os.Stdin); err != nil {
+				return err
+			}
+		}
+
+		if _, err := api.Authenticate(cmd.ctx, *email, *password); err != nil {
+			return err
+		}
+		p.Email = *email
+	} else if _, err := api.Apps(cmd.ctx); err != nil {
+		// only private keys list apps
+		return fmt.Errorf("failed to check the key, %v", err)
+	}
+
+	p.Key = api.Key
+	if err := saveProfile(cmd.profile, p); err != nil {
+		return err
+	}
+
+	return cmd.output(p, func(w io.Writer) {
+		fmt.Fprintf(w, "logged in to %s, profile %q saved\n", p.URL, cmd.profile)
+	})
+}
+
+// readLine reads a line from r without its line ending
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.New("no password given")
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
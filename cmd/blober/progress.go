@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redrawEvery bounds how often progress bars are redrawn
+const redrawEvery = 100 * time.Millisecond
+
+// barWidth is the number of characters of progress bars
+const barWidth = 30
+
+// progress draws the bytes and files transferred by a command
+// as a bar on a single line of stderr, when it is a terminal
+type progress struct {
+	mu         sync.Mutex
+	w          io.Writer
+	enabled    bool
+	files      int
+	totalFiles int
+	bytes      int64
+	totalBytes int64
+	started    time.Time
+	drawn      time.Time
+	width      int // characters of the last drawn line
+}
+
+// newProgress creates the progress of transfers of files totalling totalBytes
+func newProgress(cmd *cli, files int, totalBytes int64) *progress {
+	return &progress{w: cmd.stderr, enabled: !cmd.quiet && !cmd.json && isTerminal(cmd.stderr),
+		totalFiles: files, totalBytes: totalBytes, started: time.Now()}
+}
+
+// add counts n transferred bytes, n is negative when a failed transfer is undone
+func (p *progress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += n
+	p.draw(false)
+}
+
+// done counts a finished file
+func (p *progress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files++
+	p.draw(false)
+}
+
+// logf prints a line above the bar
+func (p *progress) logf(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintf(p.w, format+"\n", args...)
+	p.draw(true)
+}
+
+// finish draws the final state of the bar and ends its line
+func (p *progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled || p.totalFiles == 0 {
+		return
+	}
+
+	p.draw(true)
+	fmt.Fprintln(p.w)
+	p.width = 0
+}
+
+// clear erases the bar
+func (p *progress) clear() {
+	if p.enabled && p.width > 0 {
+		fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
+
+// draw redraws the bar, at most every redrawEvery unless forced
+func (p *progress) draw(force bool) {
+	if !p.enabled || p.totalFiles == 0 || (!force && time.Since(p.drawn) < redrawEvery) {
+		return
+	}
+
+	p.drawn = time.Now()
+	ratio := 1.0
+	if p.totalBytes > 0 {
+		ratio = float64(p.bytes) / float64(p.totalBytes)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	filled := int(ratio * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	if filled > 0 && filled < barWidth {
+		bar = bar[:filled-1] + ">" + bar[filled:]
+	}
+
+	rate := float64(p.bytes) / time.Since(p.started).Seconds()
+	line := fmt.Sprintf("[%s] %3.0f%%  %s/%s  %d/%d files  %s/s", bar, ratio*100, formatSize(p.bytes),
+		formatSize(p.totalBytes), p.files, p.totalFiles, formatSize(int64(rate)))
+	padding := ""
+	if len(line) < p.width {
+		padding = strings.Repeat(" ", p.width-len(line))
+	}
+
+	fmt.Fprintf(p.w, "\r%s%s", line, padding)
+	p.width = len(line)
+}
+
+// countingReader counts bytes read from a reader into a progress
+type countingReader struct {
+	io.Reader
+	progress *progress
+	read     int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.read += int64(n)
+	r.progress.add(int64(n))
+	return n, err
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// formatSize formats a size in bytes with a binary unit, e.g 1.5 MiB
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	size := float64(n) / 1024
+	for _, unit := range []string{"KiB", "MiB", "GiB"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+
+	return fmt.Sprintf("%.1f TiB", size)
+}
+
+// parallel calls task for every index in [0, n), at most workers
+// at a time. Every task runs even when others fail, tasks not started
+// when ctx is canceled fail with its error. errs[i] is the error of task i
+func parallel(ctx context.Context, n, workers int, task func(i int) error) (errs []error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs = make([]error, n)
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				errs[i] = task(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errs
+}
+
+// failures returns an error counting the failed tasks of errs
+func failures(errs []error) error {
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d of %d transfers failed", failed, len(errs))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"blober.io/client"
+	"blober.io/models"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sync actions
+const (
+	actionUpload   = "upload"
+	actionDownload = "download"
+	actionDelete   = "delete"
+)
+
+// syncAction is a change making the destination of a sync match its source
+type syncAction struct {
+	Action string `json:"action"`
+	Path   string `json:"path"` // slash separated, relative to the directory and the prefix
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+
+	file  *localFile
+	blobs []*models.Blob // blobs of the path, the current one last
+}
+
+// syncReport is the result of a sync
+type syncReport struct {
+	DryRun    bool          `json:"dry_run"`
+	Actions   []*syncAction `json:"actions"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// runSync mirrors a local directory to a prefix of an app, or a prefix
+// to a local directory. Files are compared by size and content md5
+func runSync(cmd *cli, args []string) error {
+	del := cmd.flags.Bool("delete", false, "delete destination files missing from the source")
+	dryRun := cmd.flags.Bool("dry-run", false, "print the changes without applying them")
+	workers, private, tags := transferFlags(cmd.flags)
+	args = cmd.parse(args)
+	if len(args) != 2 {
+		return cmd.usageError("expected a source and a destination")
+	}
+
+	src, srcRemote := parseRemote(args[0])
+	dst, dstRemote := parseRemote(args[1])
+	if srcRemote == dstRemote {
+		return cmd.usageError("one of the source and the destination must be APP:KEY, the other a directory")
+	}
+
+	upload := dstRemote
+	dir, prefix := args[0], dst
+	if !upload {
+		dir, prefix = args[1], src
+	}
+
+	if prefix.Key != "" && !strings.HasSuffix(prefix.Key, "/") {
+		prefix.Key += "/"
+	}
+
+	api, err := cmd.client()
+	if err != nil {
+		return err
+	}
+
+	app, err := findApp(cmd.ctx, api, prefix.App)
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string]*localFile)
+	if upload || isDir(dir) {
+		files, err := walkDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			local[f.Rel] = f
+		}
+	}
+
+	blobs, err := listRemote(cmd.ctx, api, app, prefix.Key, true, nil)
+	if err != nil {
+		return err
+	}
+
+	remote := make(map[string][]*models.Blob)
+	for _, b := range blobs {
+		rel := strings.TrimPrefix(blobKey(b), prefix.Key)
+		remote[rel] = append(remote[rel], b)
+	}
+
+	report, err := planSync(local, remote, upload, *del)
+	if err != nil {
+		return err
+	}
+
+	report.DryRun = *dryRun
+	if !*dryRun {
+		opt := &client.UploadOptions{Private: *private, Tags: tags}
+		err = applySync(cmd, api, app, dir, prefix.Key, report.Actions, opt, *workers)
+	}
+
+	outErr := cmd.output(report, func(w io.Writer) {
+		printSync(w, report)
+	})
+	if err != nil {
+		return err
+	}
+
+	return outErr
+}
+
+// planSync returns the actions making the destination match the source,
+// local files when upload is set, blobs otherwise. Destination files
+// missing from the source are only deleted with del
+func planSync(local map[string]*localFile, remote map[string][]*models.Blob, upload, del bool) (*syncReport, error) {
+	paths := make([]string, 0, len(local)+len(remote))
+	for p := range local {
+		paths = append(paths, p)
+	}
+	for p := range remote {
+		if _, ok := local[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	report := &syncReport{Actions: make([]*syncAction, 0)}
+	for _, p := range paths {
+		f, blobs := local[p], remote[p]
+		action := &syncAction{Path: p, file: f, blobs: blobs}
+		switch {
+		case f != nil && blobs == nil && upload:
+			action.Action, action.Size = actionUpload, f.Size
+		case f != nil && blobs == nil && del:
+			action.Action, action.Size = actionDelete, f.Size
+		case f == nil && !upload:
+			action.Action, action.Size = actionDownload, blobs[len(blobs)-1].Size
+		case f == nil && del:
+			action.Action, action.Size = actionDelete, blobs[len(blobs)-1].Size
+		case f != nil && blobs != nil:
+			same, err := sameContent(f, blobs[len(blobs)-1])
+			if err != nil {
+				return nil, err
+			}
+
+			if same {
+				report.Unchanged++
+				continue
+			}
+
+			action.Action, action.Size = actionDownload, blobs[len(blobs)-1].Size
+			if upload {
+				action.Action, action.Size = actionUpload, f.Size
+			}
+		default:
+			continue
+		}
+
+		report.Actions = append(report.Actions, action)
+	}
+
+	return report, nil
+}
+
+// sameContent reports whether f has the content of blob. Sizes are compared
+// first, then the md5 of f with the ETag of blob. ETags of blobs uploaded in
+// parts are not the md5 of their content, those are compared by size only
+func sameContent(f *localFile, blob *models.Blob) (bool, error) {
+	if f.Size != blob.Size {
+		return false, nil
+	}
+
+	if blob.ETag == "" || strings.Contains(blob.ETag, "-") {
+		return true, nil
+	}
+
+	sum, err := f.MD5()
+	if err != nil {
+		return false, err
+	}
+
+	return sum == blob.ETag, nil
+}
+
+// applySync applies actions between dir and prefix of app
+func applySync(cmd *cli, api *client.Client, app *models.App, dir, prefix string, actions []*syncAction,
+	opt *client.UploadOptions, workers int) error {
+	var total int64
+	transfers := 0
+	for _, a := range actions {
+		if a.Action != actionDelete {
+			total += a.Size
+			transfers++
+		}
+	}
+
+	bar := newProgress(cmd, transfers, total)
+	errs := parallel(cmd.ctx, len(actions), workers, func(i int) error {
+		a := actions[i]
+		err := applyAction(cmd, api, app, dir, prefix, a, opt, bar)
+		if err != nil {
+			a.Error = err.Error()
+			bar.logf("failed to %s %s, %v", a.Action, a.Path, err)
+			return err
+		}
+
+		if a.Action != actionDelete {
+			bar.done()
+		}
+		return nil
+	})
+	bar.finish()
+
+	return failures(errs)
+}
+
+// applyAction uploads, downloads or deletes the file of a sync action
+func applyAction(cmd *cli, api *client.Client, app *models.App, dir, prefix string, a *syncAction,
+	opt *client.UploadOptions, bar *progress) error {
+	switch {
+	case a.Action == actionUpload:
+		if _, err := uploadFile(cmd.ctx, api, app.Name, a.file, prefix+a.Path, opt, bar); err != nil {
+			return err
+		}
+
+		// uploads of a key add a blob, versioned apps keep older
+		// ones as versions, the others drop them
+		if len(a.blobs) > 0 && !app.Versioning {
+			_, err := deleteBlobs(cmd, api, app.Name, a.blobs)
+			return err
+		}
+		return nil
+	case a.Action == actionDownload:
+		p, err := localPath(dir, a.Path)
+		if err != nil {
+			return err
+		}
+		return downloadBlob(cmd.ctx, api, a.blobs[len(a.blobs)-1], p, bar)
+	case a.file != nil:
+		return os.Remove(a.file.Path)
+	default:
+		_, err := deleteBlobs(cmd, api, app.Name, a.blobs)
+		return err
+	}
+}
+
+// printSync prints the actions of a sync
+func printSync(w io.Writer, report *syncReport) {
+	prefix := ""
+	if report.DryRun {
+		prefix = "(dry run) "
+	}
+
+	failed := 0
+	for _, a := range report.Actions {
+		if a.Error != "" {
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "%s%s %s\n", prefix, a.Action, a.Path)
+	}
+
+	fmt.Fprintf(w, "%s%d changed, %d failed, %d unchanged\n", prefix, len(report.Actions)-failed, failed, report.Unchanged)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"blober.io/models"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPlanSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blober-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// md5 of "same", and of other content
+	same, changed := "51037a4a37730f52c8732586d3aaa316", "2a0c0b2e4c7a53a1a3b1ad1e2a2d3f83"
+	write := func(name, content string) *localFile {
+		p := filepath.Join(dir, name)
+		ioutil.WriteFile(p, []byte(content), 0644)
+		return &localFile{Path: p, Rel: name, Size: int64(len(content))}
+	}
+
+	local := map[string]*localFile{
+		"same.txt":      write("same.txt", "same"),
+		"changed.txt":   write("changed.txt", "chang3d"),
+		"resized.txt":   write("resized.txt", "longer content"),
+		"multipart.bin": write("multipart.bin", "parts"),
+		"local.txt":     write("local.txt", "local"),
+	}
+	remote := map[string][]*models.Blob{
+		"same.txt":      {{Hash: "old", Size: 4, ETag: changed}, {Hash: "s", Size: 4, ETag: same}},
+		"changed.txt":   {{Hash: "c", Size: 7, ETag: changed}},
+		"resized.txt":   {{Hash: "r", Size: 3, ETag: same}},
+		"multipart.bin": {{Hash: "m", Size: 5, ETag: "0123-2"}},
+		"remote.txt":    {{Hash: "x", Size: 6}},
+	}
+
+	report, err := planSync(local, remote, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := make(map[string]string)
+	for _, a := range report.Actions {
+		actions[a.Path] = a.Action
+	}
+	expected := map[string]string{"changed.txt": actionUpload, "resized.txt": actionUpload, "local.txt": actionUpload}
+	if len(actions) != len(expected) || report.Unchanged != 2 {
+		t.Fatalf("expected %v and 2 unchanged, found %v and %d", expected, actions, report.Unchanged)
+	}
+	for p, action := range expected {
+		if actions[p] != action {
+			t.Errorf("expected %s of %s, found %s", action, p, actions[p])
+		}
+	}
+
+	// deletions are opt in
+	report, _ = planSync(local, remote, true, true)
+	for _, a := range report.Actions {
+		if a.Path == "remote.txt" && (a.Action != actionDelete || a.file != nil || len(a.blobs) != 1) {
+			t.Errorf("expected remote.txt to be deleted, found %+v", a)
+		}
+	}
+	if len(report.Actions) != 4 {
+		t.Errorf("expected 4 actions, found %d", len(report.Actions))
+	}
+
+	report, _ = planSync(local, remote, false, true)
+	actions = make(map[string]string)
+	for _, a := range report.Actions {
+		actions[a.Path] = a.Action
+	}
+	if actions["remote.txt"] != actionDownload || actions["changed.txt"] != actionDownload ||
+		actions["local.txt"] != actionDelete || actions["same.txt"] != "" {
+		t.Errorf("unexpected download actions %v", actions)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	var running, max int32
+	errs := parallel(context.Background(), 20, 3, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		defer atomic.AddInt32(&running, -1)
+
+		if i%5 == 0 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	if max > 3 {
+		t.Errorf("expected at most 3 tasks at once, found %d", max)
+	}
+
+	if err := failures(errs); err == nil || err.Error() != "4 of 20 transfers failed" {
+		t.Errorf("unexpected failures %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = parallel(ctx, 2, 2, func(i int) error { return nil })
+	if errs[0] != context.Canceled || errs[1] != context.Canceled {
+		t.Errorf("expected canceled tasks, found %v", errs)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	sizes := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB"}
+	for n, expected := range sizes {
+		if s := formatSize(n); s != expected {
+			t.Errorf("expected %d bytes to be %s, found %s", n, expected, s)
+		}
+	}
+}
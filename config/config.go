@@ -0,0 +1,443 @@
+// Package config loads the configuration of the blober.io server.
+// Settings have defaults, and are read from a file of KEY=value
+// lines, the environment then command-line flags, each source
+// overriding the previous one
+package config
+
+import (
+	"blober.io/models"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultCacheSize is the number of blobs cached when BLOB_CACHE_SIZE
+// is not set, and warmed up when the cache is unbounded
+const DefaultCacheSize = 100000
+
+// Config is the configuration of the server
+type Config struct {
+	DatabaseURL string
+
+	Server      Server
+	Storage     Storage
+	Stores      Stores
+	Limits      Limits
+	Jobs        Jobs
+	Maintenance Maintenance
+}
+
+// Server configures the APIs
+type Server struct {
+	Port      int    // port of the API
+	S3Port    int    // port of the S3 API
+	PublicURL string // url the API is reached at, download urls start with it
+}
+
+// Storage configures the MinIO object storage
+type Storage struct {
+	Host      string
+	AccessKey string
+	SecretKey string
+	UseTLS    bool
+	Region    string // region buckets are created in
+}
+
+// Stores configures the session store and the blob cache
+type Stores struct {
+	Dir         string // directory of badgerDB stores
+	Sessions    string // badger, postgres or redis
+	Blobs       string // badger, postgres or redis
+	RedisURL    string
+	CacheSize   int // blobs cached at most, 0 for unbounded
+	CacheWarmup bool
+}
+
+// Limits bound requests and listings
+type Limits struct {
+	MaxMemory      int64 // bytes of multipart forms kept in memory, the rest is stored in temporary files
+	PageSize       int64 // items of a listing page
+	MaxPutSize     int64 // bytes of an object uploaded in a single S3 PutObject request
+	MaxArchiveSize int64 // bytes of blobs downloaded as a single archive
+	MaxImportSize  int64 // bytes of a file imported from a remote url
+
+	// archives uploaded for extraction
+	UnarchiveEntries   int   // files of an archive
+	UnarchiveEntrySize int64 // uncompressed bytes of a single file
+	UnarchiveSize      int64 // uncompressed bytes of the whole archive
+	UnarchiveRatio     int64 // uncompressed/compressed size of a zip entry
+}
+
+// Jobs configures background jobs and what they may access
+type Jobs struct {
+	Workers         int
+	Scanner         string // clamd, eicar, noop or empty when scanning is disabled
+	ClamdAddress    string
+	ImportAllowlist []string      // internal hosts or networks imports may fetch from
+	ImportTimeout   time.Duration // time a single import may take, body included
+
+	PollInterval      time.Duration // time between checks for due jobs by idle workers
+	ClaimTimeout      time.Duration // time a running job stays claimed without heartbeat
+	HeartbeatInterval time.Duration // time between refreshes of the claim of a running job
+}
+
+// Maintenance configures reconciliations and admin commands
+type Maintenance struct {
+	ReconcileInterval time.Duration
+	Repair            models.RepairPolicy // issues repaired by periodic reconciliations
+	OrphanGrace       time.Duration       // age of objects without blob reported as orphans
+	BatchSize         int                 // blobs loaded at once
+	LifecycleInterval time.Duration       // time between applications of lifecycle rules
+	TrashInterval     time.Duration       // time between purges of expired trash
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Server: Server{Port: 9008, S3Port: 9009, PublicURL: "http://blober.io"},
+		Storage: Storage{
+			Host: "localhost:9000", Region: "us-east-1",
+		},
+		Stores: Stores{
+			Sessions: "badger", Blobs: "badger", CacheSize: DefaultCacheSize,
+		},
+		Limits: Limits{
+			MaxMemory: 1024 << 20, PageSize: 20,
+			MaxPutSize: 1 << 30, MaxArchiveSize: 4 << 30, MaxImportSize: 512 << 20,
+			UnarchiveEntries: 1000, UnarchiveEntrySize: 256 << 20, UnarchiveSize: 1 << 30, UnarchiveRatio: 100,
+		},
+		Jobs: Jobs{
+			Workers: 4, ImportTimeout: 5 * time.Minute,
+			PollInterval: 2 * time.Second, ClaimTimeout: time.Hour, HeartbeatInterval: 5 * time.Minute,
+		},
+		Maintenance: Maintenance{
+			ReconcileInterval: 6 * time.Hour, Repair: models.DefaultRepairPolicy(),
+			OrphanGrace: time.Hour, BatchSize: 500,
+			LifecycleInterval: time.Hour, TrashInterval: time.Hour,
+		},
+	}
+}
+
+// setting is a configurable value, named by its environment
+// variable and file key. Its flag is the lowercased key
+// with dashes, e.g -s3-port for S3_PORT
+type setting struct {
+	key   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"DATABASE_URL", "url of the Postgres database", func(c *Config, v string) error {
+		c.DatabaseURL = v
+		return nil
+	}},
+	{"PORT", "port of the API", func(c *Config, v string) error {
+		return parseInt(v, &c.Server.Port)
+	}},
+	{"S3_PORT", "port of the S3 API", func(c *Config, v string) error {
+		return parseInt(v, &c.Server.S3Port)
+	}},
+	{"PUBLIC_URL", "url the API is reached at, download urls start with it", func(c *Config, v string) error {
+		c.Server.PublicURL = strings.TrimRight(v, "/")
+		return nil
+	}},
+	{"MINIO_HOST", "host:port of the MinIO server", func(c *Config, v string) error {
+		c.Storage.Host = v
+		return nil
+	}},
+	{"MINIO_ACCESS_KEY", "access key of the MinIO server", func(c *Config, v string) error {
+		c.Storage.AccessKey = v
+		return nil
+	}},
+	{"MINIO_SECRET_KEY", "secret key of the MinIO server", func(c *Config, v string) error {
+		c.Storage.SecretKey = v
+		return nil
+	}},
+	{"MINIO_TLS", "connect to the MinIO server with TLS", func(c *Config, v string) error {
+		return parseBool(v, &c.Storage.UseTLS)
+	}},
+	{"BUCKET_REGION", "region buckets are created in", func(c *Config, v string) error {
+		c.Storage.Region = v
+		return nil
+	}},
+	{"DB_DIR", "directory of badgerDB stores", func(c *Config, v string) error {
+		c.Stores.Dir = v
+		return nil
+	}},
+	{"SESSION_STORE", "session store: badger, postgres or redis", func(c *Config, v string) error {
+		c.Stores.Sessions = v
+		return nil
+	}},
+	{"BLOB_STORE", "blob cache store: badger, postgres or redis", func(c *Config, v string) error {
+		c.Stores.Blobs = v
+		return nil
+	}},
+	{"REDIS_URL", "url of the Redis server, e.g redis://:password@localhost:6379/0", func(c *Config, v string) error {
+		c.Stores.RedisURL = v
+		return nil
+	}},
+	{"BLOB_CACHE_SIZE", "blobs cached at most, 0 for unbounded", func(c *Config, v string) error {
+		return parseInt(v, &c.Stores.CacheSize)
+	}},
+	{"BLOB_CACHE_WARMUP", "cache the most recent blobs on start", func(c *Config, v string) error {
+		return parseBool(v, &c.Stores.CacheWarmup)
+	}},
+	{"MAX_MEMORY", "bytes of multipart forms kept in memory", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.MaxMemory)
+	}},
+	{"PAGE_SIZE", "items of a listing page", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.PageSize)
+	}},
+	{"MAX_PUT_SIZE", "bytes of an object uploaded in a single S3 PutObject request", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.MaxPutSize)
+	}},
+	{"MAX_ARCHIVE_SIZE", "bytes of blobs downloaded as a single archive", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.MaxArchiveSize)
+	}},
+	{"MAX_IMPORT_SIZE", "bytes of a file imported from a remote url", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.MaxImportSize)
+	}},
+	{"UNARCHIVE_MAX_ENTRIES", "files of an archive uploaded for extraction", func(c *Config, v string) error {
+		return parseInt(v, &c.Limits.UnarchiveEntries)
+	}},
+	{"UNARCHIVE_MAX_ENTRY_SIZE", "uncompressed bytes of a single extracted file", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.UnarchiveEntrySize)
+	}},
+	{"UNARCHIVE_MAX_SIZE", "uncompressed bytes of an archive uploaded for extraction", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.UnarchiveSize)
+	}},
+	{"UNARCHIVE_MAX_RATIO", "uncompressed/compressed size of an extracted zip entry", func(c *Config, v string) error {
+		return parseInt64(v, &c.Limits.UnarchiveRatio)
+	}},
+	{"JOB_WORKERS", "number of background job workers", func(c *Config, v string) error {
+		return parseInt(v, &c.Jobs.Workers)
+	}},
+	{"SCANNER", "malware scanner of uploads: clamd, eicar or noop", func(c *Config, v string) error {
+		c.Jobs.Scanner = v
+		return nil
+	}},
+	{"CLAMD_ADDRESS", "address of the clamd daemon", func(c *Config, v string) error {
+		c.Jobs.ClamdAddress = v
+		return nil
+	}},
+	{"IMPORT_ALLOWLIST", "comma separated internal hosts or networks imports may fetch from", func(c *Config, v string) error {
+		c.Jobs.ImportAllowlist = strings.Split(v, ",")
+		return nil
+	}},
+	{"IMPORT_TIMEOUT", "time a single import may take, e.g 5m", func(c *Config, v string) error {
+		return parseDuration(v, &c.Jobs.ImportTimeout)
+	}},
+	{"JOB_POLL_INTERVAL", "time between checks for due jobs by idle workers, e.g 2s", func(c *Config, v string) error {
+		return parseDuration(v, &c.Jobs.PollInterval)
+	}},
+	{"JOB_CLAIM_TIMEOUT", "time a running job stays claimed without heartbeat, e.g 1h", func(c *Config, v string) error {
+		return parseDuration(v, &c.Jobs.ClaimTimeout)
+	}},
+	{"JOB_HEARTBEAT_INTERVAL", "time between refreshes of the claim of a running job, e.g 5m", func(c *Config, v string) error {
+		return parseDuration(v, &c.Jobs.HeartbeatInterval)
+	}},
+	{"RECONCILE_INTERVAL", "time between reconciliations, e.g 6h", func(c *Config, v string) error {
+		return parseDuration(v, &c.Maintenance.ReconcileInterval)
+	}},
+	{"RECONCILE_REPAIR", "issues repaired by reconciliations: all, none or a comma separated list", func(c *Config, v string) error {
+		policy, err := models.ParseRepairPolicy(v)
+		if err != nil {
+			return err
+		}
+
+		c.Maintenance.Repair = policy
+		return nil
+	}},
+	{"ORPHAN_GRACE", "age of objects without blob reported as orphans, e.g 1h", func(c *Config, v string) error {
+		return parseDuration(v, &c.Maintenance.OrphanGrace)
+	}},
+	{"MAINTENANCE_BATCH", "blobs loaded at once by maintenance tasks", func(c *Config, v string) error {
+		return parseInt(v, &c.Maintenance.BatchSize)
+	}},
+	{"LIFECYCLE_INTERVAL", "time between applications of lifecycle rules, e.g 1h", func(c *Config, v string) error {
+		return parseDuration(v, &c.Maintenance.LifecycleInterval)
+	}},
+	{"TRASH_INTERVAL", "time between purges of expired trash, e.g 1h", func(c *Config, v string) error {
+		return parseDuration(v, &c.Maintenance.TrashInterval)
+	}},
+}
+
+// flagName returns the flag of key
+func flagName(key string) string {
+	return strings.ToLower(strings.Replace(key, "_", "-", -1))
+}
+
+// Load loads the configuration from the file of -config or $CONFIG_FILE,
+// the environment and flags parsed from args. Arguments following the
+// flags are returned. Settings set to an empty value are ignored, and
+// keys of the file that are not settings are too, e.g a .env file
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("blober.io", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "file of KEY=value settings")
+	flags := make(map[string]string, len(settings))
+	for _, s := range settings {
+		fs.String(flagName(s.key), "", s.usage+", $"+s.key)
+		flags[flagName(s.key)] = s.key
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]string)
+	if *path != "" {
+		file, err := godotenv.Read(*path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read config file, %v", err)
+		}
+
+		for key, value := range file {
+			values[key] = value
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.key); ok {
+			values[s.key] = value
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := flags[f.Name]; ok {
+			values[key] = f.Value.String()
+		}
+	})
+
+	c, err := Parse(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, fs.Args(), nil
+}
+
+// Parse returns the default configuration overridden by values,
+// keyed by setting. The configuration is validated
+func Parse(values map[string]string) (*Config, error) {
+	c := Default()
+	for _, s := range settings {
+		value := strings.TrimSpace(values[s.key])
+		if value == "" {
+			continue
+		}
+
+		if err := s.set(c, value); err != nil {
+			return nil, fmt.Errorf("invalid %s, %v", s.key, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate reports every invalid setting
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.DatabaseURL != "", "DATABASE_URL is required")
+	check(validPort(c.Server.Port), "PORT must be between 1 and 65535")
+	check(validPort(c.Server.S3Port), "S3_PORT must be between 1 and 65535")
+	check(c.Server.Port != c.Server.S3Port, "PORT and S3_PORT must differ")
+
+	u, err := url.Parse(c.Server.PublicURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"PUBLIC_URL must be an http or https url")
+
+	check(c.Storage.Host != "", "MINIO_HOST is required")
+	check(c.Storage.Region != "", "BUCKET_REGION is required")
+
+	for _, kind := range []struct{ key, value string }{
+		{"SESSION_STORE", c.Stores.Sessions}, {"BLOB_STORE", c.Stores.Blobs},
+	} {
+		switch kind.value {
+		case "badger":
+			check(c.Stores.Dir != "", "DB_DIR is required by the badger %s", kind.key)
+		case "postgres":
+		case "redis":
+			check(strings.HasPrefix(c.Stores.RedisURL, "redis://"), "REDIS_URL must be a redis:// url for the redis %s", kind.key)
+		default:
+			check(false, "%s must be badger, postgres or redis", kind.key)
+		}
+	}
+	check(c.Stores.CacheSize >= 0, "BLOB_CACHE_SIZE must not be negative")
+
+	check(c.Limits.MaxMemory > 0, "MAX_MEMORY must be positive")
+	check(c.Limits.PageSize > 0, "PAGE_SIZE must be positive")
+	check(c.Limits.MaxPutSize > 0, "MAX_PUT_SIZE must be positive")
+	check(c.Limits.MaxArchiveSize > 0, "MAX_ARCHIVE_SIZE must be positive")
+	check(c.Limits.MaxImportSize > 0, "MAX_IMPORT_SIZE must be positive")
+	check(c.Limits.UnarchiveEntries > 0, "UNARCHIVE_MAX_ENTRIES must be positive")
+	check(c.Limits.UnarchiveEntrySize > 0, "UNARCHIVE_MAX_ENTRY_SIZE must be positive")
+	check(c.Limits.UnarchiveSize > 0, "UNARCHIVE_MAX_SIZE must be positive")
+	check(c.Limits.UnarchiveRatio > 0, "UNARCHIVE_MAX_RATIO must be positive")
+
+	check(c.Jobs.Workers > 0, "JOB_WORKERS must be positive")
+	switch c.Jobs.Scanner {
+	case "", "eicar", "noop":
+	case "clamd":
+		check(c.Jobs.ClamdAddress != "", "CLAMD_ADDRESS is required by the clamd SCANNER")
+	default:
+		check(false, "SCANNER must be clamd, eicar or noop")
+	}
+	check(c.Jobs.ImportTimeout > 0, "IMPORT_TIMEOUT must be positive")
+	check(c.Jobs.PollInterval > 0, "JOB_POLL_INTERVAL must be positive")
+	check(c.Jobs.HeartbeatInterval > 0, "JOB_HEARTBEAT_INTERVAL must be positive")
+	check(c.Jobs.ClaimTimeout > c.Jobs.HeartbeatInterval, "JOB_CLAIM_TIMEOUT must be longer than JOB_HEARTBEAT_INTERVAL")
+
+	check(c.Maintenance.ReconcileInterval > 0, "RECONCILE_INTERVAL must be positive")
+	check(c.Maintenance.OrphanGrace >= 0, "ORPHAN_GRACE must not be negative")
+	check(c.Maintenance.BatchSize > 0, "MAINTENANCE_BATCH must be positive")
+	check(c.Maintenance.LifecycleInterval > 0, "LIFECYCLE_INTERVAL must be positive")
+	check(c.Maintenance.TrashInterval > 0, "TRASH_INTERVAL must be positive")
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
+
+func parseInt(v string, n *int) (err error) {
+	*n, err = strconv.Atoi(v)
+	return err
+}
+
+func parseInt64(v string, n *int64) (err error) {
+	*n, err = strconv.ParseInt(v, 10, 64)
+	return err
+}
+
+func parseBool(v string, b *bool) (err error) {
+	*b, err = strconv.ParseBool(v)
+	return err
+}
+
+func parseDuration(v string, d *time.Duration) (err error) {
+	*d, err = time.ParseDuration(v)
+	return err
+}
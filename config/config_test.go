@@ -0,0 +1,140 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// required are the settings without default
+var required = map[string]string{
+	"DATABASE_URL": "postgres://localhost/blober",
+	"DB_DIR":       "/var/blober",
+}
+
+func TestParse_Defaults(t *testing.T) {
+	c, err := Parse(required)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Server.Port != 9008 || c.Server.S3Port != 9009 || c.Storage.Region != "us-east-1" || c.Storage.UseTLS {
+		t.Fatalf("unexpected defaults %+v %+v", c.Server, c.Storage)
+	}
+
+	if c.Limits.MaxMemory != 1024<<20 || c.Limits.PageSize != 20 || c.Stores.CacheSize != DefaultCacheSize {
+		t.Fatalf("unexpected defaults %+v %+v", c.Limits, c.Stores)
+	}
+
+	if c.Maintenance.ReconcileInterval != 6*time.Hour || len(c.Maintenance.Repair) == 0 {
+		t.Fatalf("unexpected defaults %+v", c.Maintenance)
+	}
+}
+
+func TestParse(t *testing.T) {
+	values := map[string]string{
+		"PUBLIC_URL":       "https://files.example.com/",
+		"MINIO_TLS":        "true",
+		"BUCKET_REGION":    "eu-west-1",
+		"BLOB_CACHE_SIZE":  "0",
+		"IMPORT_ALLOWLIST": "files.internal,10.1.0.0/16",
+		"ORPHAN_GRACE":     "30m",
+		"RECONCILE_REPAIR": "none",
+		"JOB_WORKERS":      " ",
+		"MAX_PUT_SIZE":     "1048576",
+		"TRASH_INTERVAL":   "10m",
+	}
+	for key, value := range required {
+		values[key] = value
+	}
+
+	c, err := Parse(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Server.PublicURL != "https://files.example.com" || !c.Storage.UseTLS || c.Storage.Region != "eu-west-1" {
+		t.Fatalf("unexpected config %+v %+v", c.Server, c.Storage)
+	}
+
+	if c.Stores.CacheSize != 0 || len(c.Jobs.ImportAllowlist) != 2 || c.Maintenance.OrphanGrace != 30*time.Minute {
+		t.Fatalf("unexpected config %+v %+v %+v", c.Stores, c.Jobs, c.Maintenance)
+	}
+
+	if c.Limits.MaxPutSize != 1<<20 || c.Maintenance.TrashInterval != 10*time.Minute {
+		t.Fatalf("unexpected config %+v %+v", c.Limits, c.Maintenance)
+	}
+
+	// empty values keep the default
+	if len(c.Maintenance.Repair) != 0 || c.Jobs.Workers != 4 {
+		t.Fatalf("unexpected config %+v %+v", c.Jobs, c.Maintenance)
+	}
+
+	if _, err := Parse(map[string]string{"PORT": "http"}); err == nil || !strings.Contains(err.Error(), "PORT") {
+		t.Fatalf("expected an invalid PORT, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Server.S3Port = c.Server.Port
+	c.Server.PublicURL = "blober.io"
+	c.Stores.Sessions = "redis"
+	c.Stores.Blobs = "memcached"
+	c.Jobs.Scanner = "clamd"
+	c.Limits.PageSize = 0
+	c.Jobs.ClaimTimeout = c.Jobs.HeartbeatInterval
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected an invalid configuration")
+	}
+
+	for _, problem := range []string{"DATABASE_URL", "S3_PORT must differ", "PUBLIC_URL", "REDIS_URL",
+		"BLOB_STORE", "CLAMD_ADDRESS", "PAGE_SIZE", "JOB_CLAIM_TIMEOUT"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %s to be reported, got %v", problem, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "blober.env")
+	file := "DATABASE_URL=postgres://localhost/blober\nDB_DIR=/var/blober\nPORT=7000\nS3_PORT=7001\n" +
+		"JOB_WORKERS=2\nAPP_NAME=Blober.io\n"
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment overrides the file, flags override both
+	os.Setenv("S3_PORT", "8001")
+	os.Setenv("JOB_WORKERS", "3")
+	defer os.Unsetenv("S3_PORT")
+	defer os.Unsetenv("JOB_WORKERS")
+
+	c, args, err := Load([]string{"-config", path, "-job-workers", "8", "verify", "-json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Server.Port != 7000 || c.Server.S3Port != 8001 || c.Jobs.Workers != 8 {
+		t.Fatalf("unexpected config %+v %+v", c.Server, c.Jobs)
+	}
+
+	if len(args) != 2 || args[0] != "verify" {
+		t.Fatalf("expected the command and its flags, got %v", args)
+	}
+
+	if _, _, err := Load([]string{"-config", filepath.Join(dir, "missing.env")}); err == nil {
+		t.Fatal("expected a missing config file to fail")
+	}
+}
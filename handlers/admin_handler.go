@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"blober.io/models"
+	"net/http"
+)
+
+// authenticateAdmin authenticates requests made with the private key
+// of an admin account. Sessions cache the account, so its admin flag
+// is checked against the database
+func (handler *AppHandler) authenticateAdmin(r *http.Request) (*models.Account, bool) {
+	account, ok := handler.authenticate(r, true)
+	if !ok || !handler.repo.IsAdmin(account.ID) {
+		return nil, false
+	}
+
+	return account, true
+}
+
+// ReconcileHandler reconciles the database, the blob cache and object
+// storage and responds with the report. Issues are repaired with the
+// server's policy, or the kinds listed in repair, dry_run only reports them
+func (handler *AppHandler) ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := handler.authenticateAdmin(r); !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	var policy models.RepairPolicy
+	query := r.URL.Query()
+	if query.Get("dry_run") == "true" {
+		policy = models.RepairPolicy{}
+	} else if kinds := query.Get("repair"); kinds != "" {
+		var err error
+		if policy, err = models.ParseRepairPolicy(kinds); err != nil {
+			JSON(w, 400, &Response{Error: true, Message: err.Error()})
+			return
+		}
+	}
+
+	report, err := handler.repo.Reconcile(policy)
+	if err != nil {
+		JSON(w, 500, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: report})
+}
+
+// GetReconcileReportHandler responds with the report of the last
+// reconciliation, periodic or requested
+func (handler *AppHandler) GetReconcileReportHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := handler.authenticateAdmin(r); !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	report := handler.repo.LastReconciliation()
+	if report == nil {
+		JSON(w, 404, &Response{Error: true, Message: "no reconciliation has run yet"})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: report})
+}
+
+// CacheStatsHandler responds with the counters of the blob cache
+func (handler *AppHandler) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := handler.authenticateAdmin(r); !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: handler.blobStore.Stats()})
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"blober.io/config"
 	"blober.io/models"
 	"blober.io/repos"
+	"blober.io/services"
 	"blober.io/store"
 	"encoding/json"
 	"fmt"
@@ -10,23 +12,25 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
-// max memory for multipart form data
-var maxMemory int64 = 1024 << 20
-
 // AppHandler handles all app related
 // http requests
 type AppHandler struct {
-	store     *store.SessionStore
-	blobStore *store.BlobStore
-	repo      *repos.AppRepository
+	store      store.SessionStore
+	blobStore  store.BlobStore
+	repo       *repos.AppRepository
+	maxMemory  int64 // max memory for multipart form data
+	maxArchive int64 // max size of blobs downloaded as an archive
 }
 
-// NewAppHandler creates a new AppHandler
-func NewAppHandler(store *store.SessionStore, blobStore *store.BlobStore, repo *repos.AppRepository) *AppHandler {
-	return &AppHandler{repo: repo, blobStore: blobStore, store: store}
+// NewAppHandler creates a new AppHandler, requests are bounded by limits
+func NewAppHandler(store store.SessionStore, blobStore store.BlobStore, repo *repos.AppRepository, limits config.Limits) *AppHandler {
+	return &AppHandler{repo: repo, blobStore: blobStore, store: store, maxMemory: limits.MaxMemory,
+		maxArchive: limits.MaxArchiveSize}
 }
 
 // CreateNewAppHandler handles requests to create a new app
@@ -97,6 +101,29 @@ func (handler *AppHandler) GetAccountAppsHandler(w http.ResponseWriter, r *http.
 	JSON(w, 200, &Response{Error: false, Message: "success", Data: data})
 }
 
+// DeleteAppHandler handles requests to delete an app,
+// apps holding blobs are only deleted with ?force=true
+func (handler *AppHandler) DeleteAppHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	app, err := handler.repo.DeleteApp(account.ID, mux.Vars(r)["appName"], force)
+	switch err {
+	case nil:
+		JSON(w, 200, &Response{Error: false, Message: "app deleted", Data: app})
+	case repos.ErrAppNotFound:
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+	case repos.ErrAppNotEmpty:
+		JSON(w, 409, &Response{Error: true, Message: err.Error()})
+	default:
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+	}
+}
+
 // UploadBlobHandler handles file upload requests
 func (handler *AppHandler) UploadBlobHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -119,6 +146,9 @@ func (handler *AppHandler) UploadBlobHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// report upload progress to activity streams
+	handler.trackUploadProgress(w, r, account.ID, mux.Vars(r)["appName"])
+
 	file, header, err := r.FormFile("file_data")
 	if err != nil {
 		BadRequestResponse(w)
@@ -147,11 +177,23 @@ func (handler *AppHandler) UploadBlobHandler(w http.ResponseWriter, r *http.Requ
 	// by client.
 	// private files can only be downloaded
 	// with private keys
-	private := r.FormValue("private")
-	isPrivate := private == "true"
+	opt, err := parseUploadOptions(r)
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	// logical name of the file, versioned
+	// apps keep every upload of the same key
+	opt.Key = r.FormValue("key")
 
 	// upload file.
-	blob, err := handler.repo.UploadBlob(account.ID, appName, isPrivate, header)
+	blob, err := handler.repo.UploadBlob(account.ID, appName, opt, header)
+	if violation, ok := err.(*models.PolicyViolation); ok {
+		JSON(w, 400, &Response{Error: true, Message: violation.Error(), Data: violation})
+		return
+	}
+
 	if err != nil {
 		JSON(w, 200, &Response{Error: true, Message: err.Error()})
 		return
@@ -192,8 +234,11 @@ func (handler *AppHandler) UploadMultipleBlobsHandler(w http.ResponseWriter, r *
 		return
 	}
 
+	// report upload progress to activity streams
+	handler.trackUploadProgress(w, r, account.ID, appName)
+
 	// parse it! The uploaded files
-	err = r.ParseMultipartForm(maxMemory)
+	err = r.ParseMultipartForm(handler.maxMemory)
 	if err != nil {
 		BadRequestResponse(w)
 		return
@@ -215,13 +260,34 @@ func (handler *AppHandler) UploadMultipleBlobsHandler(w http.ResponseWriter, r *
 	}
 
 	// should the files be private?
-	isPrivate := r.FormValue("private") == "true"
-	for _, value := range files {
+	// metadata and tags are applied to every file
+	opt, err := parseUploadOptions(r)
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	// the whole request is refused when it
+	// carries more files than the app allows
+	if app := handler.repo.GetAppByName(account.ID, appName); app != nil {
+		if err := app.UploadPolicy().CheckCount(len(files)); err != nil {
+			JSON(w, 400, &Response{Error: true, Message: err.Error(), Data: err})
+			return
+		}
+	}
+
+	uploadErrors := make([]*models.UploadError, 0)
+	for i, value := range files {
 
 		// do the upload
-		blob, err := handler.repo.UploadBlob(account.ID, appName, isPrivate, value)
+		blob, err := handler.repo.UploadBlob(account.ID, appName, opt, value)
 		if err != nil {
 			log.Printf("failed to process upload, %v", err)
+			uploadError := &models.UploadError{Index: i, Filename: value.Filename, Message: err.Error()}
+			if violation, ok := err.(*models.PolicyViolation); ok {
+				uploadError.Code, uploadError.Message = violation.Code, violation.Message
+			}
+			uploadErrors = append(uploadErrors, uploadError)
 			errorCount += 1
 			continue
 		}
@@ -232,7 +298,7 @@ func (handler *AppHandler) UploadMultipleBlobsHandler(w http.ResponseWriter, r *
 
 	// put together the response body and respond
 	response := &models.UploadMultipleResponse{SuccessCount: int64(successCount),
-		FailureCount: int64(errorCount), Blobs: blobs}
+		FailureCount: int64(errorCount), Blobs: blobs, Errors: uploadErrors}
 	JSON(w, 200, &Response{Error: false, Message: "success", Data: response})
 }
 
@@ -250,21 +316,60 @@ func (handler *AppHandler) DownloadBlobHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// images can be transformed on the fly,
+	// e.g ?w=200&h=200&fit=cover&format=png
+	t, err := services.ParseTransformation(r.URL.Query())
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if t != nil {
+		// access to private blobs is checked before transforming
+		var accountId uint
+		if account, ok := handler.authenticate(r, true); ok {
+			accountId = account.ID
+		}
+
+		file, blob, err := handler.repo.DownloadTransformed(appName, hash, t, accountId)
+		if err == repos.ErrPrivateBlob {
+			UnAuthorizedResponse(w)
+			return
+		}
+
+		if err == services.ErrNotAnImage || err == services.ErrSourceTooLarge || err == repos.ErrTooManyDerivatives {
+			JSON(w, 400, &Response{Error: true, Message: err.Error()})
+			return
+		}
+
+		if err != nil {
+			DownloadErrorResponse(w, err, "failed to transform blob")
+			return
+		}
+
+		handler.serveBlob(w, r, file, blob)
+		return
+	}
+
 	// download file from minio
 	// along with the cached blob data
 	file, blob, err := handler.repo.DownloadBlob(appName, hash)
 	if err != nil {
-		JSON(w, 500, &Response{Error: true, Message: "failed to download blob"})
+		DownloadErrorResponse(w, err, "failed to download blob")
 		return
 	}
 
+	handler.serveBlob(w, r, file, blob)
+}
+
+// serveBlob writes file to w.
+// public files are served immediately
+// private files are only served to authenticated accounts
+func (handler *AppHandler) serveBlob(w http.ResponseWriter, r *http.Request, file io.Reader, blob *models.Blob) {
 	// not a private file, serve it!
 	if !blob.IsPrivate {
 		WriteHeaderInfo(w, blob)
-		_, err := io.Copy(w, file)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		writeBlob(w, r, file, blob)
 		return
 	}
 
@@ -291,7 +396,21 @@ func (handler *AppHandler) DownloadBlobHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	WriteHeaderInfo(w, blob)
-	_, err = io.Copy(w, file)
+	writeBlob(w, r, file, blob)
+}
+
+// writeBlob writes the content of blob to w. Stored files are
+// seekable and served with range and conditional requests support
+func writeBlob(w http.ResponseWriter, r *http.Request, file io.Reader, blob *models.Blob) {
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		if blob.ETag != "" {
+			w.Header().Set("ETag", `"`+blob.ETag+`"`)
+		}
+		http.ServeContent(w, r, "", blob.UpdatedAt, seeker)
+		return
+	}
+
+	_, err := io.Copy(w, file)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -344,13 +463,288 @@ func (handler *AppHandler) GetAppBlobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := handler.repo.GetAppBlobs(uint(appId), int64(page))
+	filter, err := parseBlobFilter(r.URL.Query())
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	data := handler.repo.GetAppBlobs(uint(appId), int64(page), filter)
 	JSON(w, 200, &Response{Error: false, Message: "success", Data: data})
 }
 
+// parseBlobFilter reads listing filters from query parameters:
+// tag=key:value, exif=key:value, content_type, min_width, max_width,
+// min_height, max_height, min_duration, max_duration, min_pages and max_pages
+func parseBlobFilter(query url.Values) (*repos.BlobFilter, error) {
+	filter := &repos.BlobFilter{Tags: make(map[string]string), Exif: make(map[string]string),
+		ContentType: query.Get("content_type")}
+
+	pairs := map[string]map[string]string{"tag": filter.Tags, "exif": filter.Exif}
+	for name, values := range pairs {
+		for _, t := range query[name] {
+			parts := strings.SplitN(t, ":", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("%s filter must be in the form key:value", name)
+			}
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	ints := map[string]*int{
+		"min_width": &filter.MinWidth, "max_width": &filter.MaxWidth,
+		"min_height": &filter.MinHeight, "max_height": &filter.MaxHeight,
+		"min_pages": &filter.MinPages, "max_pages": &filter.MaxPages,
+	}
+	for name, dst := range ints {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a positive integer", name)
+			}
+			*dst = n
+		}
+	}
+
+	floats := map[string]*float64{"min_duration": &filter.MinDuration, "max_duration": &filter.MaxDuration}
+	for name, dst := range floats {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a positive number", name)
+			}
+			*dst = n
+		}
+	}
+
+	return filter, nil
+}
+
+// SetStripGPSHandler sets whether GPS position is
+// removed from images uploaded to an app
+func (handler *AppHandler) SetStripGPSHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &struct {
+		StripGPS bool `json:"strip_gps"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetStripGPS(account.ID, mux.Vars(r)["appName"], payload.StripGPS)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "gps policy updated", Data: app})
+}
+
+// GetBlobTagsHandler returns the tag set of a blob
+func (handler *AppHandler) GetBlobTagsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blob, err := handler.repo.GetBlob(account.ID, vars["appName"], vars["hash"])
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: blob.Tags})
+}
+
+// SetBlobTagsHandler replaces the tag set of a blob
+func (handler *AppHandler) SetBlobTagsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	tags := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blob, err := handler.repo.SetBlobTags(account.ID, vars["appName"], vars["hash"], tags)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "tags updated", Data: blob.Tags})
+}
+
+// authenticate returns the account that owns the key
+// attached to r. Only private keys are accepted when private is true
+func (handler *AppHandler) authenticate(r *http.Request, private bool) (*models.Account, bool) {
+	key := ParseAuthorizationKey(r)
+	if key == "" || len(key) < 20 {
+		return nil, false
+	}
+
+	account, err := handler.store.Get(key[:20])
+	if err != nil || account.Cred == nil {
+		return nil, false
+	}
+
+	cred := account.Cred
+	if cred.PrivateAccessKey == key || (!private && cred.PublicAccessKey == key) {
+		return &account, true
+	}
+
+	return nil, false
+}
+
+// parseUploadOptions reads upload options from r.
+// metadata are sent as X-Blober-Meta-* headers and
+// tags as url encoded X-Blober-Tagging header or tags form value
+func parseUploadOptions(r *http.Request) (*repos.UploadOptions, error) {
+	opt := &repos.UploadOptions{
+		Private:  r.FormValue("private") == "true",
+		Metadata: make(map[string]string),
+	}
+
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, metaHeaderPrefix) && len(k) > len(metaHeaderPrefix) && len(v) > 0 {
+			opt.Metadata[strings.ToLower(k[len(metaHeaderPrefix):])] = v[0]
+		}
+	}
+
+	tagging := r.Header.Get("X-Blober-Tagging")
+	if tagging == "" {
+		tagging = r.FormValue("tags")
+	}
+
+	tags, err := models.ParseTagging(tagging)
+	if err != nil {
+		return nil, err
+	}
+
+	opt.Tags = tags
+	return opt, nil
+}
+
+// metaHeaderPrefix is the prefix of user metadata headers
+const metaHeaderPrefix = "X-Blober-Meta-"
+
 func WriteHeaderInfo(w http.ResponseWriter, blob *models.Blob) {
 	headers := map[string]string{"Content-Type": blob.ContentType, "Content-Disposition": fmt.Sprintf("attachment; filename=%s", blob.Filename)}
 	for key, val := range headers {
 		w.Header().Add(key, val)
 	}
+
+	for key, val := range blob.Metadata {
+		w.Header().Set(metaHeaderPrefix+key, val)
+	}
+
+	if len(blob.Tags) > 0 {
+		w.Header().Set("X-Blober-Tagging", blob.TaggingString())
+	}
+}
+
+// SetScanPolicyHandler sets whether downloads of an
+// app's blobs are blocked until they are scanned clean
+func (handler *AppHandler) SetScanPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &struct {
+		BlockUntilScanned bool `json:"block_until_scanned"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetBlockUntilScanned(account.ID, mux.Vars(r)["appName"], payload.BlockUntilScanned)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "scan policy updated", Data: app})
+}
+
+// GetUploadPolicyHandler returns the upload policy of an app
+func (handler *AppHandler) GetUploadPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	policy, err := handler.repo.GetUploadPolicy(account.ID, mux.Vars(r)["appName"])
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: policy})
+}
+
+// SetUploadPolicyHandler sets allowed types, size limits
+// and filename policy of files uploaded to an app
+func (handler *AppHandler) SetUploadPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	policy := &models.UploadPolicy{}
+	if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetUploadPolicy(account.ID, mux.Vars(r)["appName"], policy)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "upload policy updated", Data: app.UploadPolicy()})
+}
+
+// SetThumbnailSizesHandler sets thumbnail sizes generated
+// for images uploaded to an app
+func (handler *AppHandler) SetThumbnailSizesHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &struct {
+		Sizes []string `json:"sizes"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetThumbnailSizes(account.ID, mux.Vars(r)["appName"], payload.Sizes)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "thumbnail sizes updated", Data: app})
 }
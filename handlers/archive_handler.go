@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"blober.io/repos"
+	"blober.io/services"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// archiveRequest is the body of POST archive requests
+type archiveRequest struct {
+	Hashes []string `json:"hashes"`
+	Prefix string   `json:"prefix"`
+	Format string   `json:"format"` // zip(default) or tar.gz
+	Name   string   `json:"name"`   // archive filename, without extension
+}
+
+// DownloadArchiveHandler streams blobs of an app as a zip or tar.gz
+// archive. Blobs are selected by hash(?hash=a&hash=b) or by key
+// prefix(?prefix=invoices/), long hash lists can be POSTed as json.
+// Private blobs and prefixes require the private key of the app
+func (handler *AppHandler) DownloadArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	payload := &archiveRequest{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+			BadRequestResponse(w)
+			return
+		}
+	} else {
+		query := r.URL.Query()
+		payload.Hashes = query["hash"]
+		payload.Prefix = query.Get("prefix")
+		payload.Format = query.Get("format")
+		payload.Name = query.Get("name")
+	}
+
+	format := services.ParseArchiveFormat(payload.Format)
+	if format == "" {
+		JSON(w, 400, &Response{Error: true, Message: "format must be zip or tar.gz"})
+		return
+	}
+
+	req := &repos.ArchiveRequest{Hashes: payload.Hashes, Prefix: payload.Prefix}
+	if account, ok := handler.authenticate(r, true); ok {
+		req.Account = account.ID
+	}
+
+	appName := mux.Vars(r)["appName"]
+	blobs, err := handler.repo.GetArchiveBlobs(appName, req)
+	switch err {
+	case nil:
+	case repos.ErrBlobNotFound:
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	case repos.ErrPrivateBlob:
+		UnAuthorizedResponse(w)
+		return
+	case repos.ErrArchiveTooLarge:
+		JSON(w, 413, &Response{Error: true,
+			Message: fmt.Sprintf("archive can not be larger than %d bytes", handler.maxArchive)})
+		return
+	default:
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	name := payload.Name
+	if name == "" {
+		name = appName
+	}
+	name = strings.NewReplacer(`"`, "", "/", "_", `\`, "_").Replace(name) + "." + format
+
+	archive, err := services.NewArchiveWriter(format, w)
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", archive.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	// the archive is built while it is sent, errors after this
+	// point leave the client with a truncated archive
+	if err := handler.repo.WriteArchive(r.Context(), archive, blobs); err != nil {
+		log.Printf("archive download of app %s stopped, %v", appName, err)
+	}
+}
+
+// UploadArchiveHandler extracts an uploaded zip, tar or tar.gz
+// archive(archive field) into one blob per file. Paths inside the
+// archive become keys, under the optional prefix field, e.g
+// prefix=photos stores 2019/a.jpg as photos/2019/a.jpg
+func (handler *AppHandler) UploadArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	key := ParseAuthorizationKey(r)
+	if key == "" || len(key) < 20 {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	account, err := handler.store.Get(key[:20])
+	if err != nil {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	// archives can be uploaded with either private or public key
+	cred := account.Cred
+	if cred.PrivateAccessKey != key && cred.PublicAccessKey != key {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	appName := mux.Vars(r)["appName"]
+
+	// report upload progress to activity streams
+	handler.trackUploadProgress(w, r, account.ID, appName)
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: "no archive found"})
+		return
+	}
+	defer file.Close()
+
+	// private, metadata and tags are applied to every entry
+	opt, err := parseUploadOptions(r)
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+	opt.Key = strings.Trim(r.FormValue("prefix"), "/")
+
+	report, err := handler.repo.UploadArchive(account.ID, appName, opt, header)
+	if err != nil {
+		if report == nil {
+			JSON(w, 200, &Response{Error: true, Message: err.Error()})
+			return
+		}
+
+		// extraction stopped, entries stored so far are reported
+		code := 400
+		if err == services.ErrUnarchiveTooLarge {
+			code = 413
+		}
+		JSON(w, code, &Response{Error: true, Message: err.Error(), Data: report})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: report})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"blober.io/models"
+	"blober.io/repos"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// CopyBlobHandler copies a blob into another app of the caller
+func (handler *AppHandler) CopyBlobHandler(w http.ResponseWriter, r *http.Request) {
+	handler.copyBlob(w, r, handler.repo.CopyBlob, "blob copied")
+}
+
+// MoveBlobHandler moves a blob into another app of the caller
+func (handler *AppHandler) MoveBlobHandler(w http.ResponseWriter, r *http.Request) {
+	handler.copyBlob(w, r, handler.repo.MoveBlob, "blob moved")
+}
+
+func (handler *AppHandler) copyBlob(w http.ResponseWriter, r *http.Request,
+	fn func(uint, string, string, *repos.CopyOptions) (*models.Blob, error), message string) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	opt := &repos.CopyOptions{}
+	if err := json.NewDecoder(r.Body).Decode(opt); err != nil || opt.TargetApp == "" {
+		BadRequestResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blob, err := fn(account.ID, vars["appName"], vars["hash"], opt)
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: message, Data: blob})
+}
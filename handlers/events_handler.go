@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"blober.io/models"
+	"blober.io/repos"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// heartbeatInterval is the time interval at which idle
+// streams get a comment, keeping proxies from closing them
+var heartbeatInterval = 15 * time.Second
+
+// progressInterval is the minimum time between
+// two progress events of an upload
+var progressInterval = 250 * time.Millisecond
+
+// EventsHandler streams activity of an app as server-sent events.
+// Clients resume with the Last-Event-ID header(or last_event_id
+// query parameter), events they missed are sent first
+func (handler *AppHandler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		JSON(w, 500, &Response{Error: true, Message: "streaming is not supported"})
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastSeq uint64
+	if lastEventId != "" {
+		seq, err := strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			JSON(w, 400, &Response{Error: true, Message: "invalid Last-Event-ID"})
+			return
+		}
+		lastSeq = seq
+	}
+
+	subscription, missed, err := handler.repo.Subscribe(account.ID, mux.Vars(r)["appName"], lastSeq)
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+	defer handler.repo.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-subscription.C:
+			// the stream fell behind, the client
+			// reconnects and resumes from its last event
+			if !ok {
+				return
+			}
+
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e in the server-sent events format
+func writeEvent(w io.Writer, e *models.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if e.Transient {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+	return err
+}
+
+// progressReader publishes progress of an upload as its body is read
+type progressReader struct {
+	io.ReadCloser
+	progress *repos.UploadProgress
+	last     time.Time
+	publish  func(*repos.UploadProgress)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.progress.Received += int64(n)
+
+	if err == io.EOF && !p.progress.Done {
+		p.progress.Done = true
+		p.publish(p.progress)
+	} else if time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.publish(p.progress)
+	}
+
+	return n, err
+}
+
+// trackUploadProgress reports progress of reading r's body to
+// activity streams of appName. Clients can correlate progress with
+// their upload by sending an X-Blober-Upload-Id header
+func (handler *AppHandler) trackUploadProgress(w http.ResponseWriter, r *http.Request, account uint, appName string) {
+	app := handler.repo.GetAppByName(account, appName)
+	if app == nil {
+		return
+	}
+
+	uploadId := r.Header.Get("X-Blober-Upload-Id")
+	if uploadId == "" || len(uploadId) > 64 {
+		uploadId = uuid.New().String()
+	}
+	w.Header().Set("X-Blober-Upload-Id", uploadId)
+
+	progress := &repos.UploadProgress{UploadId: uploadId, Total: r.ContentLength}
+	r.Body = &progressReader{ReadCloser: r.Body, progress: progress, last: time.Now(),
+		publish: func(p *repos.UploadProgress) {
+			// a copy, the reader keeps updating p
+			snapshot := *p
+			handler.repo.PublishUploadProgress(app, &snapshot)
+		}}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"blober.io/repos"
 	"encoding/json"
 	"net/http"
 )
@@ -43,6 +44,21 @@ func BadRequestResponse(w http.ResponseWriter) {
 	JSON(w, 400, &Response{Error: true, Message: "bad request"})
 }
 
+// DownloadErrorResponse responds to failed downloads.
+// Quarantined and unscanned blobs are refused with a
+// specific status, other errors are reported with message
+func DownloadErrorResponse(w http.ResponseWriter, err error, message string) {
+	switch err {
+	case repos.ErrBlobInfected:
+		JSON(w, http.StatusForbidden, &Response{Error: true, Message: err.Error()})
+	case repos.ErrScanPending, repos.ErrGPSPending:
+		w.Header().Set("Retry-After", "30")
+		JSON(w, http.StatusLocked, &Response{Error: true, Message: err.Error()})
+	default:
+		JSON(w, 500, &Response{Error: true, Message: message})
+	}
+}
+
 // ParseAuthorizationKey gets authentication data from
 // a request.
 // It checks request's header, query parameters and cookie data
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"blober.io/models"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// importRequest is the body of import requests, urls are
+// stored under their filename, items can set the key
+type importRequest struct {
+	URLs    []string            `json:"urls"`
+	Items   []*models.ImportURL `json:"items"`
+	Private bool                `json:"private"`
+}
+
+// CreateImportHandler queues remote urls to be fetched into an app.
+// The import is returned right away, its progress is polled with
+// GetImportHandler or followed on the activity stream
+func (handler *AppHandler) CreateImportHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &importRequest{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	urls := payload.Items
+	for _, u := range payload.URLs {
+		urls = append(urls, &models.ImportURL{URL: u})
+	}
+
+	im, err := handler.repo.CreateImport(account.ID, mux.Vars(r)["appName"], payload.Private, urls)
+	if err != nil {
+		JSON(w, 400, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 202, &Response{Error: false, Message: "success", Data: im})
+}
+
+// GetImportHandler returns the progress of an import
+func (handler *AppHandler) GetImportHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	im, err := handler.repo.GetImport(account.ID, vars["appName"], uint(id))
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: im})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// GetBlobJobsHandler returns processing jobs of a blob
+func (handler *AppHandler) GetBlobJobsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	jobs, err := handler.repo.GetBlobJobs(account.ID, vars["appName"], vars["hash"])
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: jobs})
+}
+
+// GetAppJobsHandler lists jobs of an app,
+// ?status=dead returns the dead letter list
+func (handler *AppHandler) GetAppJobsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	page, err := strconv.Atoi(vars["page"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	jobs, err := handler.repo.GetAppJobs(account.ID, vars["appName"], r.URL.Query().Get("status"), int64(page))
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: jobs})
+}
+
+// RetryJobHandler moves a dead job back to the queue
+func (handler *AppHandler) RetryJobHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	job, err := handler.repo.RetryJob(account.ID, vars["appName"], uint(id))
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "job queued", Data: job})
+}
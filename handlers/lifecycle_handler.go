@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"blober.io/models"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// GetLifecycleRulesHandler returns lifecycle rules of an app
+func (handler *AppHandler) GetLifecycleRulesHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	rules, err := handler.repo.GetLifecycleRules(account.ID, mux.Vars(r)["appName"])
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: rules})
+}
+
+// SetLifecycleRulesHandler replaces lifecycle rules of an app
+func (handler *AppHandler) SetLifecycleRulesHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	rules := make([]*models.LifecycleRule, 0)
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	rules, err := handler.repo.SetLifecycleRules(account.ID, mux.Vars(r)["appName"], rules)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "lifecycle rules updated", Data: rules})
+}
+
+// RunLifecycleHandler applies lifecycle rules of an app immediately.
+// With ?dry_run=true, the report lists blobs that would be removed
+func (handler *AppHandler) RunLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	report, err := handler.repo.RunLifecycle(account.ID, mux.Vars(r)["appName"], dryRun)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: report})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import "github.com/gorilla/mux"
+
+// NewRouter creates the router of the blober.io API
+func NewRouter(accounts *AccountHandler, apps *AppHandler) *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = &NotFoundHandler{}
+	router.MethodNotAllowedHandler = &MethodNotAllowedHandler{}
+
+	router.HandleFunc("/echo", EchoHandler)
+	router.HandleFunc("/account/new", accounts.CreateNewAccountHandler).Methods("POST")
+	router.HandleFunc("/account/authenticate", accounts.AuthenticateAccountHandler).Methods("POST")
+	router.HandleFunc("/app/new", apps.CreateNewAppHandler).Methods("POST")
+	router.HandleFunc("/me/apps", apps.GetAccountAppsHandler).Methods("GET")
+	router.HandleFunc("/me/webhooks", apps.CreateWebhookHandler).Methods("POST")
+	router.HandleFunc("/me/webhooks", apps.GetWebhooksHandler).Methods("GET")
+	router.HandleFunc("/me/webhooks/{id:[0-9]+}", apps.DeleteWebhookHandler).Methods("DELETE")
+	router.HandleFunc("/me/webhooks/{id:[0-9]+}/deliveries/{page:[0-9]+}", apps.GetDeliveriesHandler).Methods("GET")
+	router.HandleFunc("/me/webhooks/deliveries/{id:[0-9]+}/redeliver", apps.RedeliverHandler).Methods("POST")
+	router.HandleFunc("/admin/reconcile", apps.ReconcileHandler).Methods("POST")
+	router.HandleFunc("/admin/reconcile", apps.GetReconcileReportHandler).Methods("GET")
+	router.HandleFunc("/admin/cache", apps.CacheStatsHandler).Methods("GET")
+	router.HandleFunc("/{appName}", apps.DeleteAppHandler).Methods("DELETE")
+	router.HandleFunc("/{appName}/upload", apps.UploadBlobHandler).Methods("POST")
+	router.HandleFunc("/{appName}/uploads", apps.UploadMultipleBlobsHandler).Methods("POST")
+	router.HandleFunc("/{appName}/uploads/archive", apps.UploadArchiveHandler).Methods("POST")
+	router.HandleFunc("/res/{appName}/{hash}", apps.DownloadBlobHandler).Methods("GET")
+	router.HandleFunc("/apps/{appId}/blobs/{page}", apps.GetAppBlobs).Methods("GET")
+	router.HandleFunc("/{appName}/blobs/{hash}/tags", apps.GetBlobTagsHandler).Methods("GET")
+	router.HandleFunc("/{appName}/blobs/{hash}/tags", apps.SetBlobTagsHandler).Methods("PUT")
+	router.HandleFunc("/res/{appName}/key/{key:.+}", apps.DownloadBlobByKeyHandler).Methods("GET")
+	router.HandleFunc("/{appName}/versioning", apps.SetVersioningHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/thumbnails", apps.SetThumbnailSizesHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/scanning", apps.SetScanPolicyHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/gps", apps.SetStripGPSHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/policy", apps.GetUploadPolicyHandler).Methods("GET")
+	router.HandleFunc("/{appName}/policy", apps.SetUploadPolicyHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/webhooks", apps.CreateWebhookHandler).Methods("POST")
+	router.HandleFunc("/{appName}/webhooks", apps.GetWebhooksHandler).Methods("GET")
+	router.HandleFunc("/{appName}/events", apps.EventsHandler).Methods("GET")
+	router.HandleFunc("/{appName}/archive", apps.DownloadArchiveHandler).Methods("GET", "POST")
+	router.HandleFunc("/{appName}/import", apps.CreateImportHandler).Methods("POST")
+	router.HandleFunc("/{appName}/import/{id:[0-9]+}", apps.GetImportHandler).Methods("GET")
+	router.HandleFunc("/{appName}/blobs/{hash}/jobs", apps.GetBlobJobsHandler).Methods("GET")
+	router.HandleFunc("/{appName}/jobs/{page:[0-9]+}", apps.GetAppJobsHandler).Methods("GET")
+	router.HandleFunc("/{appName}/jobs/{id:[0-9]+}/retry", apps.RetryJobHandler).Methods("POST")
+	router.HandleFunc("/{appName}/versions", apps.GetVersionsHandler).Methods("GET")
+	router.HandleFunc("/{appName}/versions/{versionId}/restore", apps.RestoreVersionHandler).Methods("POST")
+	router.HandleFunc("/{appName}/objects", apps.DeleteObjectHandler).Methods("DELETE")
+	router.HandleFunc("/{appName}/lifecycle", apps.GetLifecycleRulesHandler).Methods("GET")
+	router.HandleFunc("/{appName}/lifecycle", apps.SetLifecycleRulesHandler).Methods("PUT")
+	router.HandleFunc("/{appName}/lifecycle/run", apps.RunLifecycleHandler).Methods("POST")
+	router.HandleFunc("/{appName}/blobs/delete", apps.DeleteBlobsHandler).Methods("POST")
+	router.HandleFunc("/{appName}/blobs/{hash}", apps.DeleteBlobHandler).Methods("DELETE")
+	router.HandleFunc("/{appName}/blobs/{hash}/copy", apps.CopyBlobHandler).Methods("POST")
+	router.HandleFunc("/{appName}/blobs/{hash}/move", apps.MoveBlobHandler).Methods("POST")
+	router.HandleFunc("/{appName}/trash/{page:[0-9]+}", apps.GetTrashHandler).Methods("GET")
+	router.HandleFunc("/{appName}/trash/restore", apps.RestoreBlobsHandler).Methods("POST")
+	router.HandleFunc("/{appName}/trash/purge", apps.PurgeBlobsHandler).Methods("POST")
+	router.HandleFunc("/{appName}/trash/retention", apps.SetTrashRetentionHandler).Methods("PUT")
+
+	return router
+}
@@ -0,0 +1,773 @@
+package handlers
+
+import (
+	"blober.io/config"
+	"blober.io/models"
+	"blober.io/repos"
+	"blober.io/services"
+	"blober.io/store"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Namespace is the xml namespace of S3 responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3TimeFormat is the time format of S3 xml responses
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// maxListKeys is the highest number of keys listed at once
+const maxListKeys = 1000
+
+// unsupportedSubresources are S3 sub-resources
+// answered with NotImplemented
+var unsupportedSubresources = []string{"acl", "tagging", "versioning", "versions", "policy", "cors",
+	"lifecycle", "website", "logging", "notification", "replication", "encryption", "object-lock",
+	"retention", "legal-hold", "torrent", "accelerate", "requestPayment", "analytics", "inventory",
+	"metrics", "ownershipControls", "publicAccessBlock", "intelligent-tiering", "select", "restore"}
+
+// s3AccountKey is the context key of the authenticated account
+type s3AccountKey struct{}
+
+// S3Handler serves a subset of the Amazon S3 API. Buckets are
+// apps of the authenticated account and objects are blobs
+// addressed by key. Requests are signed with AWS Signature V4,
+// the access key is the first 20 characters of a private key
+// and the secret key is the whole private key
+type S3Handler struct {
+	store  store.SessionStore
+	repo   *repos.AppRepository
+	region string // region of every bucket
+	maxPut int64  // max size of an object uploaded in a single request
+}
+
+// NewS3Handler creates a new S3Handler, buckets are in region
+// and requests are bounded by limits
+func NewS3Handler(store store.SessionStore, repo *repos.AppRepository, region string, limits config.Limits) *S3Handler {
+	return &S3Handler{store: store, repo: repo, region: region, maxPut: limits.MaxPutSize}
+}
+
+// Router returns the path-style router of the S3 API
+func (handler *S3Handler) Router() http.Handler {
+	router := mux.NewRouter().SkipClean(true)
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3Error(w, r, 404, "NoSuchKey", "the specified key does not exist")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3Error(w, r, 405, "MethodNotAllowed", "the specified method is not allowed against this resource")
+	})
+	router.Use(handler.authenticate)
+
+	router.HandleFunc("/", handler.ListBuckets).Methods("GET")
+
+	bucket := "/{bucket}"
+	router.HandleFunc(bucket, handler.GetBucketLocation).Methods("GET").MatcherFunc(hasQuery("location"))
+	router.HandleFunc(bucket, notImplemented).Methods("GET").MatcherFunc(hasQuery("uploads"))
+	router.HandleFunc(bucket, handler.ListObjects).Methods("GET")
+	router.HandleFunc(bucket, handler.CreateBucket).Methods("PUT")
+	router.HandleFunc(bucket, handler.HeadBucket).Methods("HEAD")
+	router.HandleFunc(bucket, handler.DeleteObjects).Methods("POST").MatcherFunc(hasQuery("delete"))
+	router.HandleFunc(bucket, notImplemented).Methods("DELETE")
+
+	object := "/{bucket}/{key:.+}"
+	router.HandleFunc(object, handler.CreateMultipartUpload).Methods("POST").MatcherFunc(hasQuery("uploads"))
+	router.HandleFunc(object, handler.CompleteMultipartUpload).Methods("POST").MatcherFunc(hasQuery("uploadId"))
+	router.HandleFunc(object, handler.UploadPart).Methods("PUT").MatcherFunc(hasQuery("uploadId", "partNumber"))
+	router.HandleFunc(object, handler.ListParts).Methods("GET").MatcherFunc(hasQuery("uploadId"))
+	router.HandleFunc(object, handler.AbortMultipartUpload).Methods("DELETE").MatcherFunc(hasQuery("uploadId"))
+	router.HandleFunc(object, notImplemented).Methods("PUT").HeadersRegexp("X-Amz-Copy-Source", ".+")
+	router.HandleFunc(object, handler.PutObject).Methods("PUT")
+	router.HandleFunc(object, handler.GetObject).Methods("GET", "HEAD")
+	router.HandleFunc(object, handler.DeleteObject).Methods("DELETE")
+
+	return router
+}
+
+// authenticate verifies the signature of requests and
+// adds the account of the access key to their context
+func (handler *S3Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, name := range unsupportedSubresources {
+			if _, ok := query[name]; ok {
+				notImplemented(w, r)
+				return
+			}
+		}
+
+		sig, err := services.ParseSigV4(r)
+		if err != nil {
+			s3Error(w, r, 403, "AccessDenied", err.Error())
+			return
+		}
+
+		account, err := handler.store.Get(sig.AccessKey)
+		if err != nil || account.Cred == nil || len(account.Cred.PrivateAccessKey) < 20 ||
+			account.Cred.PrivateAccessKey[:20] != sig.AccessKey {
+			s3Error(w, r, 403, "InvalidAccessKeyId", "the access key does not exist")
+			return
+		}
+
+		if err := sig.Verify(r, account.Cred.PrivateAccessKey, time.Now()); err != nil {
+			s3RepoError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), s3AccountKey{}, &account)))
+	})
+}
+
+// s3Account returns the authenticated account of r
+func s3Account(r *http.Request) *models.Account {
+	return r.Context().Value(s3AccountKey{}).(*models.Account)
+}
+
+// hasQuery matches requests carrying every query parameter of names
+func hasQuery(names ...string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		query := r.URL.Query()
+		for _, name := range names {
+			if _, ok := query[name]; !ok {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// S3ErrorResponse is the xml body of S3 errors
+type S3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string
+}
+
+// s3Error writes an S3 error response
+func s3Error(w http.ResponseWriter, r *http.Request, code int, s3Code, message string) {
+	requestId := fmt.Sprintf("%X", time.Now().UnixNano())
+	w.Header().Set("X-Amz-Request-Id", requestId)
+	if r.Method == "HEAD" {
+		w.WriteHeader(code)
+		return
+	}
+
+	XML(w, code, &S3ErrorResponse{Code: s3Code, Message: message, Resource: r.URL.Path, RequestId: requestId})
+}
+
+// s3RepoError maps repository and signature errors to S3 errors
+func s3RepoError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case repos.ErrAppNotFound:
+		s3Error(w, r, 404, "NoSuchBucket", "the specified bucket does not exist")
+	case repos.ErrBlobNotFound:
+		s3Error(w, r, 404, "NoSuchKey", "the specified key does not exist")
+	case repos.ErrNoSuchUpload:
+		s3Error(w, r, 404, "NoSuchUpload", err.Error())
+	case repos.ErrObjectTooLarge:
+		s3Error(w, r, 400, "EntityTooLarge", err.Error())
+	case repos.ErrBlobInfected:
+		s3Error(w, r, 403, "AccessDenied", err.Error())
+	case repos.ErrScanPending, repos.ErrGPSPending:
+		w.Header().Set("Retry-After", "30")
+		s3Error(w, r, 503, "ServiceUnavailable", err.Error())
+	case services.ErrNotSigned:
+		s3Error(w, r, 403, "AccessDenied", err.Error())
+	case services.ErrSignatureMismatch:
+		s3Error(w, r, 403, "SignatureDoesNotMatch", err.Error())
+	case services.ErrRequestExpired:
+		s3Error(w, r, 403, "RequestTimeTooSkewed", err.Error())
+	case services.ErrBadDigest:
+		s3Error(w, r, 400, "BadDigest", err.Error())
+	default:
+		switch e := err.(type) {
+		case *models.PartError:
+			s3Error(w, r, 400, e.Code, e.Message)
+		case *models.PolicyViolation:
+			if e.Code == models.ViolationTooLarge {
+				s3Error(w, r, 400, "EntityTooLarge", e.Error())
+				return
+			}
+			s3Error(w, r, 403, "AccessDenied", e.Error())
+		default:
+			s3Error(w, r, 500, "InternalError", err.Error())
+		}
+	}
+}
+
+// notImplemented answers requests of unsupported S3 operations
+func notImplemented(w http.ResponseWriter, r *http.Request) {
+	s3Error(w, r, 501, "NotImplemented", "this operation is not implemented")
+}
+
+// XML format and output an xml response
+func XML(w http.ResponseWriter, code int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// S3Owner is the owner of buckets
+type S3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+// S3Bucket is a bucket of a ListBuckets response
+type S3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+// ListAllMyBucketsResult is the response of ListBuckets
+type ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   S3Owner    `xml:"Owner"`
+	Buckets []S3Bucket `xml:"Buckets>Bucket"`
+}
+
+// ListBuckets lists apps of the authenticated account
+func (handler *S3Handler) ListBuckets(w http.ResponseWriter, r *http.Request) {
+	account := s3Account(r)
+	result := &ListAllMyBucketsResult{Xmlns: s3Namespace, Buckets: make([]S3Bucket, 0),
+		Owner: S3Owner{ID: strconv.Itoa(int(account.ID)), DisplayName: account.Email}}
+	for _, app := range handler.repo.GetAccountApps(account.ID) {
+		result.Buckets = append(result.Buckets, S3Bucket{Name: app.Name,
+			CreationDate: app.CreatedAt.UTC().Format(s3TimeFormat)})
+	}
+
+	XML(w, 200, result)
+}
+
+// CreateBucket creates an app
+func (handler *S3Handler) CreateBucket(w http.ResponseWriter, r *http.Request) {
+	account := s3Account(r)
+	name := mux.Vars(r)["bucket"]
+	if handler.repo.GetAppByName(account.ID, name) != nil {
+		s3Error(w, r, 409, "BucketAlreadyOwnedByYou", "the bucket already exists and is owned by you")
+		return
+	}
+
+	if handler.repo.GetAppByAttr("name", name) != nil {
+		s3Error(w, r, 409, "BucketAlreadyExists", "the bucket name is not available")
+		return
+	}
+
+	if _, err := handler.repo.CreateNewApp(account.ID, name); err != nil {
+		s3Error(w, r, 400, "InvalidBucketName", err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(200)
+}
+
+// HeadBucket checks an app exists and belongs to the authenticated account
+func (handler *S3Handler) HeadBucket(w http.ResponseWriter, r *http.Request) {
+	if handler.repo.GetAppByName(s3Account(r).ID, mux.Vars(r)["bucket"]) == nil {
+		s3RepoError(w, r, repos.ErrAppNotFound)
+		return
+	}
+
+	w.WriteHeader(200)
+}
+
+// LocationConstraint is the response of GetBucketLocation
+type LocationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+// GetBucketLocation answers the region of every app, empty for us-east-1
+func (handler *S3Handler) GetBucketLocation(w http.ResponseWriter, r *http.Request) {
+	if handler.repo.GetAppByName(s3Account(r).ID, mux.Vars(r)["bucket"]) == nil {
+		s3RepoError(w, r, repos.ErrAppNotFound)
+		return
+	}
+
+	location := handler.region
+	if location == "us-east-1" {
+		location = ""
+	}
+
+	XML(w, 200, &LocationConstraint{Xmlns: s3Namespace, Location: location})
+}
+
+// S3Object is an object of a listing
+type S3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// S3CommonPrefix is a rolled up prefix of a listing
+type S3CommonPrefix struct {
+	Prefix string
+}
+
+// ListBucketResult is the response of ListObjects and ListObjectsV2
+type ListBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Marker                *string          `xml:"Marker,omitempty"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int             `xml:"KeyCount,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []S3Object       `xml:"Contents"`
+	CommonPrefixes        []S3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// ListObjects lists objects of an app, ListObjectsV2 when
+// list-type=2 is set and ListObjects(V1) otherwise
+func (handler *S3Handler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opt := &repos.ListObjectsOptions{Prefix: query.Get("prefix"), Delimiter: query.Get("delimiter"),
+		MaxKeys: maxListKeys}
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s3Error(w, r, 400, "InvalidArgument", "max-keys must be a positive integer")
+			return
+		}
+		if n < maxListKeys {
+			opt.MaxKeys = n
+		}
+	}
+
+	result := &ListBucketResult{Xmlns: s3Namespace, Name: mux.Vars(r)["bucket"], MaxKeys: opt.MaxKeys,
+		Contents: make([]S3Object, 0), CommonPrefixes: make([]S3CommonPrefix, 0)}
+	v2 := query.Get("list-type") == "2"
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+		opt.StartAfter = result.StartAfter
+		if result.ContinuationToken != "" {
+			marker, err := base64.StdEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				s3Error(w, r, 400, "InvalidArgument", "the continuation token is not valid")
+				return
+			}
+			opt.StartAfter = string(marker)
+		}
+	} else {
+		marker := query.Get("marker")
+		result.Marker = &marker
+		opt.StartAfter = marker
+	}
+
+	listing, err := handler.repo.ListObjects(s3Account(r).ID, result.Name, opt)
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		encode = func(s string) string { return strings.Replace(url.QueryEscape(s), "+", "%20", -1) }
+	}
+
+	result.Prefix = encode(opt.Prefix)
+	result.Delimiter = encode(opt.Delimiter)
+	result.IsTruncated = listing.IsTruncated
+	for _, blob := range listing.Blobs {
+		result.Contents = append(result.Contents, S3Object{Key: encode(blob.Key), Size: blob.Size,
+			LastModified: blob.UpdatedAt.UTC().Format(s3TimeFormat), ETag: `"` + blob.ETag + `"`,
+			StorageClass: "STANDARD"})
+	}
+	for _, prefix := range listing.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, S3CommonPrefix{Prefix: encode(prefix)})
+	}
+
+	if v2 {
+		count := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &count
+		result.StartAfter = encode(result.StartAfter)
+		if listing.IsTruncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(listing.NextMarker))
+		}
+	} else {
+		*result.Marker = encode(*result.Marker)
+		if listing.IsTruncated {
+			result.NextMarker = encode(listing.NextMarker)
+		}
+	}
+
+	XML(w, 200, result)
+}
+
+// s3UploadOptions reads upload options from S3 headers. Objects are
+// private unless uploaded with the public-read canned ACL
+func s3UploadOptions(r *http.Request) (*repos.UploadOptions, error) {
+	opt := &repos.UploadOptions{
+		Private:  r.Header.Get("X-Amz-Acl") != "public-read",
+		Metadata: make(map[string]string),
+	}
+
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, s3MetaHeaderPrefix) && len(k) > len(s3MetaHeaderPrefix) && len(v) > 0 {
+			opt.Metadata[strings.ToLower(k[len(s3MetaHeaderPrefix):])] = v[0]
+		}
+	}
+
+	tags, err := models.ParseTagging(r.Header.Get("X-Amz-Tagging"))
+	if err != nil {
+		return nil, err
+	}
+
+	opt.Tags = tags
+	return opt, nil
+}
+
+// s3MetaHeaderPrefix is the prefix of S3 user metadata headers
+const s3MetaHeaderPrefix = "X-Amz-Meta-"
+
+// PutObject uploads an object
+func (handler *S3Handler) PutObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	opt, err := s3UploadOptions(r)
+	if err != nil {
+		s3Error(w, r, 400, "InvalidArgument", err.Error())
+		return
+	}
+
+	// the body is streamed to storage, its size must be known
+	if r.ContentLength < 0 {
+		s3Error(w, r, 411, "MissingContentLength", "the Content-Length header is required")
+		return
+	}
+
+	if r.ContentLength > handler.maxPut {
+		s3RepoError(w, r, repos.ErrObjectTooLarge)
+		return
+	}
+
+	blob, err := handler.repo.PutObject(s3Account(r).ID, vars["bucket"], vars["key"], opt, r.Body, r.ContentLength)
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+blob.ETag+`"`)
+	if blob.VersionId != "" {
+		w.Header().Set("X-Amz-Version-Id", blob.VersionId)
+	}
+	w.WriteHeader(200)
+}
+
+// GetObject downloads an object, or only its headers on HEAD
+// requests. Range and conditional requests are supported
+func (handler *S3Handler) GetObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	file, blob, err := handler.repo.GetObject(s3Account(r).ID, vars["bucket"], vars["key"], r.URL.Query().Get("versionId"))
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", blob.ContentType)
+	header.Set("ETag", `"`+blob.ETag+`"`)
+	header.Set("Accept-Ranges", "bytes")
+	for key, val := range blob.Metadata {
+		header.Set(s3MetaHeaderPrefix+key, val)
+	}
+	if blob.VersionId != "" {
+		header.Set("X-Amz-Version-Id", blob.VersionId)
+	}
+
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", blob.UpdatedAt, seeker)
+		return
+	}
+
+	header.Set("Last-Modified", blob.UpdatedAt.UTC().Format(http.TimeFormat))
+	header.Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	w.WriteHeader(200)
+	if r.Method != "HEAD" {
+		io.Copy(w, file)
+	}
+}
+
+// DeleteObject deletes an object, or a version of it.
+// Deleting a missing key succeeds like on S3
+func (handler *S3Handler) DeleteObject(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blob, err := handler.repo.DeleteObject(s3Account(r).ID, vars["bucket"], vars["key"], r.URL.Query().Get("versionId"))
+	if err != nil && err != repos.ErrBlobNotFound {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	if blob != nil && blob.IsDeleteMarker {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		w.Header().Set("X-Amz-Version-Id", blob.VersionId)
+	}
+	w.WriteHeader(204)
+}
+
+// DeleteRequest is the body of DeleteObjects
+type DeleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key       string `xml:"Key"`
+		VersionId string `xml:"VersionId"`
+	} `xml:"Object"`
+}
+
+// DeletedObject is a deleted key of a DeleteObjects response
+type DeletedObject struct {
+	Key                   string
+	VersionId             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+// DeleteError is a key that failed to be deleted
+type DeleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+// DeleteResult is the response of DeleteObjects
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+// DeleteObjects deletes up to 1000 objects
+func (handler *S3Handler) DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	req := &DeleteRequest{}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 2<<20)).Decode(req); err != nil || len(req.Objects) > maxListKeys {
+		s3Error(w, r, 400, "MalformedXML", "the delete request is not valid")
+		return
+	}
+
+	account, bucket := s3Account(r), mux.Vars(r)["bucket"]
+	if handler.repo.GetAppByName(account.ID, bucket) == nil {
+		s3RepoError(w, r, repos.ErrAppNotFound)
+		return
+	}
+
+	result := &DeleteResult{Xmlns: s3Namespace}
+	for _, o := range req.Objects {
+		blob, err := handler.repo.DeleteObject(account.ID, bucket, o.Key, o.VersionId)
+		if err != nil && err != repos.ErrBlobNotFound {
+			result.Errors = append(result.Errors, DeleteError{Key: o.Key, Code: "InternalError", Message: err.Error()})
+			continue
+		}
+
+		if req.Quiet {
+			continue
+		}
+
+		deleted := DeletedObject{Key: o.Key, VersionId: o.VersionId}
+		if blob != nil && blob.IsDeleteMarker {
+			deleted.DeleteMarker, deleted.DeleteMarkerVersionId = true, blob.VersionId
+		}
+		result.Deleted = append(result.Deleted, deleted)
+	}
+
+	XML(w, 200, result)
+}
+
+// InitiateMultipartUploadResult is the response of CreateMultipartUpload
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+// CreateMultipartUpload starts a multipart upload
+func (handler *S3Handler) CreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	opt, err := s3UploadOptions(r)
+	if err != nil {
+		s3Error(w, r, 400, "InvalidArgument", err.Error())
+		return
+	}
+
+	upload, err := handler.repo.CreateMultipartUpload(s3Account(r).ID, vars["bucket"], vars["key"], opt)
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	XML(w, 200, &InitiateMultipartUploadResult{Xmlns: s3Namespace, Bucket: vars["bucket"], Key: vars["key"],
+		UploadId: upload.UploadId})
+}
+
+// UploadPart uploads a part of a multipart upload
+func (handler *S3Handler) UploadPart(w http.ResponseWriter, r *http.Request) {
+	vars, query := mux.Vars(r), r.URL.Query()
+	n, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || models.ValidatePartNumber(n) != nil {
+		s3Error(w, r, 400, "InvalidArgument", fmt.Sprintf("part number must be between 1 and %d", models.MaxPartNumber))
+		return
+	}
+
+	if r.ContentLength < 0 {
+		s3Error(w, r, 411, "MissingContentLength", "the Content-Length header is required")
+		return
+	}
+
+	part, err := handler.repo.UploadPart(s3Account(r).ID, vars["bucket"], query.Get("uploadId"), n, r.Body, r.ContentLength)
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+part.ETag+`"`)
+	w.WriteHeader(200)
+}
+
+// CompleteMultipartUploadRequest is the body of CompleteMultipartUpload
+type CompleteMultipartUploadRequest struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+// CompleteMultipartUploadResult is the response of CompleteMultipartUpload
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// CompleteMultipartUpload assembles uploaded parts into an object
+func (handler *S3Handler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req := &CompleteMultipartUploadRequest{}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 2<<20)).Decode(req); err != nil {
+		s3Error(w, r, 400, "MalformedXML", "the complete multipart upload request is not valid")
+		return
+	}
+
+	completed := make([]*models.CompletedPart, len(req.Parts))
+	for i, p := range req.Parts {
+		completed[i] = &models.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag}
+	}
+
+	blob, err := handler.repo.CompleteMultipartUpload(s3Account(r).ID, vars["bucket"], r.URL.Query().Get("uploadId"), completed)
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	if blob.VersionId != "" {
+		w.Header().Set("X-Amz-Version-Id", blob.VersionId)
+	}
+	XML(w, 200, &CompleteMultipartUploadResult{Xmlns: s3Namespace, Location: "/" + vars["bucket"] + "/" + vars["key"],
+		Bucket: vars["bucket"], Key: vars["key"], ETag: `"` + blob.ETag + `"`})
+}
+
+// S3Part is an uploaded part of a ListParts response
+type S3Part struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+// ListPartsResult is the response of ListParts
+type ListPartsResult struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Xmlns                string   `xml:"xmlns,attr"`
+	Bucket               string
+	Key                  string
+	UploadId             string
+	PartNumberMarker     int
+	NextPartNumberMarker int
+	MaxParts             int
+	IsTruncated          bool
+	Parts                []S3Part `xml:"Part"`
+}
+
+// ListParts lists uploaded parts of a multipart upload
+func (handler *S3Handler) ListParts(w http.ResponseWriter, r *http.Request) {
+	vars, query := mux.Vars(r), r.URL.Query()
+	upload, parts, err := handler.repo.GetParts(s3Account(r).ID, vars["bucket"], query.Get("uploadId"))
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	result := &ListPartsResult{Xmlns: s3Namespace, Bucket: vars["bucket"], Key: upload.Key, UploadId: upload.UploadId,
+		MaxParts: maxListKeys, Parts: make([]S3Part, 0)}
+	result.PartNumberMarker, _ = strconv.Atoi(query.Get("part-number-marker"))
+	if n, err := strconv.Atoi(query.Get("max-parts")); err == nil && n >= 0 && n < maxListKeys {
+		result.MaxParts = n
+	}
+
+	i := sort.Search(len(parts), func(i int) bool { return parts[i].PartNumber > result.PartNumberMarker })
+	for _, p := range parts[i:] {
+		if len(result.Parts) == result.MaxParts {
+			result.IsTruncated = true
+			break
+		}
+
+		result.Parts = append(result.Parts, S3Part{PartNumber: p.PartNumber, ETag: `"` + p.ETag + `"`, Size: p.Size,
+			LastModified: p.UpdatedAt.UTC().Format(s3TimeFormat)})
+		result.NextPartNumberMarker = p.PartNumber
+	}
+
+	XML(w, 200, result)
+}
+
+// AbortMultipartUpload removes a multipart upload and its parts
+func (handler *S3Handler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	err := handler.repo.AbortMultipartUpload(s3Account(r).ID, mux.Vars(r)["bucket"], r.URL.Query().Get("uploadId"))
+	if err != nil {
+		s3RepoError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
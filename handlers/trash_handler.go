@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"blober.io/repos"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// trashRequest is the body of bulk delete,
+// restore and purge requests
+type trashRequest struct {
+	Hashes []string   `json:"hashes"`
+	Since  *time.Time `json:"since"`
+}
+
+// DeleteBlobHandler moves a single blob to trash
+func (handler *AppHandler) DeleteBlobHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blobs, err := handler.repo.DeleteBlobs(account.ID, vars["appName"], []string{vars["hash"]})
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "moved to trash", Data: blobs[0]})
+}
+
+// DeleteBlobsHandler moves many blobs to trash at once
+func (handler *AppHandler) DeleteBlobsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &trashRequest{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil || len(payload.Hashes) == 0 {
+		BadRequestResponse(w)
+		return
+	}
+
+	blobs, err := handler.repo.DeleteBlobs(account.ID, mux.Vars(r)["appName"], payload.Hashes)
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "moved to trash", Data: blobs})
+}
+
+// GetTrashHandler lists trashed blobs of an app
+func (handler *AppHandler) GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	page, err := strconv.Atoi(vars["page"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	data, err := handler.repo.GetTrash(account.ID, vars["appName"], int64(page))
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: data})
+}
+
+// RestoreBlobsHandler restores trashed blobs by hash,
+// or every blob trashed since a point in time
+func (handler *AppHandler) RestoreBlobsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &trashRequest{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	if len(payload.Hashes) == 0 && payload.Since == nil {
+		JSON(w, 400, &Response{Error: true, Message: "hashes or since is required"})
+		return
+	}
+
+	blobs, err := handler.repo.RestoreBlobs(account.ID, mux.Vars(r)["appName"], payload.Hashes, payload.Since)
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "restored", Data: blobs})
+}
+
+// PurgeBlobsHandler permanently removes trashed blobs,
+// the whole trash is emptied when no hash is given
+func (handler *AppHandler) PurgeBlobsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &trashRequest{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	blobs, err := handler.repo.PurgeBlobs(account.ID, mux.Vars(r)["appName"], payload.Hashes)
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "purged", Data: blobs})
+}
+
+// SetTrashRetentionHandler sets how long trashed blobs are kept
+func (handler *AppHandler) SetTrashRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &struct {
+		Days int `json:"days"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetTrashRetention(account.ID, mux.Vars(r)["appName"], payload.Days)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "trash retention updated", Data: app})
+}
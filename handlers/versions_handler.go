@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"blober.io/repos"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// SetVersioningHandler enables or suspends versioning of an app
+func (handler *AppHandler) SetVersioningHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &struct {
+		Enabled bool `json:"enabled"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	app, err := handler.repo.SetVersioning(account.ID, mux.Vars(r)["appName"], payload.Enabled)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "versioning updated", Data: app})
+}
+
+// DownloadBlobByKeyHandler downloads the current version of a key,
+// or the version identified by versionId query parameter
+func (handler *AppHandler) DownloadBlobByKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appName := vars["appName"]
+	key := vars["key"]
+	if appName == "" || key == "" {
+		BadRequestResponse(w)
+		return
+	}
+
+	file, blob, err := handler.repo.DownloadBlobByKey(appName, key, r.URL.Query().Get("versionId"))
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		DownloadErrorResponse(w, err, "failed to download blob")
+		return
+	}
+
+	handler.serveBlob(w, r, file, blob)
+}
+
+// GetVersionsHandler lists versions of a key, or of every
+// key when key query parameter is not set
+func (handler *AppHandler) GetVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	data, err := handler.repo.GetVersions(account.ID, mux.Vars(r)["appName"], r.URL.Query().Get("key"))
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: data})
+}
+
+// DeleteObjectHandler deletes a key or a single version of it
+func (handler *AppHandler) DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	if key == "" {
+		BadRequestResponse(w)
+		return
+	}
+
+	blob, err := handler.repo.DeleteObject(account.ID, mux.Vars(r)["appName"], key, query.Get("versionId"))
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "deleted", Data: blob})
+}
+
+// RestoreVersionHandler makes an older version current
+func (handler *AppHandler) RestoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	blob, err := handler.repo.RestoreVersion(account.ID, vars["appName"], vars["versionId"])
+	if err == repos.ErrBlobNotFound {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "version restored", Data: blob})
+}
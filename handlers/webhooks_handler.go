@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// webhookRequest is the body of webhook creation requests
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// CreateWebhookHandler subscribes a url to events of an app.
+// On /me/webhooks, the webhook receives events of every app
+func (handler *AppHandler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	payload := &webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	hook, err := handler.repo.CreateWebhook(account.ID, mux.Vars(r)["appName"], payload.URL, payload.Events)
+	if err != nil {
+		JSON(w, 200, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "webhook created, keep its secret to verify deliveries", Data: hook})
+}
+
+// GetWebhooksHandler lists webhooks of an app, or
+// every webhook of the account on /me/webhooks
+func (handler *AppHandler) GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	hooks, err := handler.repo.GetWebhooks(account.ID, mux.Vars(r)["appName"])
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: hooks})
+}
+
+// DeleteWebhookHandler removes a webhook
+func (handler *AppHandler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	if err := handler.repo.DeleteWebhook(account.ID, uint(id)); err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "webhook deleted"})
+}
+
+// GetDeliveriesHandler returns the delivery log of a webhook
+func (handler *AppHandler) GetDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	page, err := strconv.Atoi(vars["page"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	deliveries, err := handler.repo.GetDeliveries(account.ID, uint(id), int64(page))
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "success", Data: deliveries})
+}
+
+// RedeliverHandler sends a past delivery again
+func (handler *AppHandler) RedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := handler.authenticate(r, true)
+	if !ok {
+		UnAuthorizedResponse(w)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		BadRequestResponse(w)
+		return
+	}
+
+	delivery, err := handler.repo.Redeliver(account.ID, uint(id))
+	if err != nil {
+		JSON(w, 404, &Response{Error: true, Message: err.Error()})
+		return
+	}
+
+	JSON(w, 200, &Response{Error: false, Message: "redelivery queued", Data: delivery})
+}
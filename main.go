@@ -1,105 +1,349 @@
 package main
 
 import (
+	"blober.io/config"
 	"blober.io/handlers"
 	"blober.io/repos"
 	"blober.io/services"
 	"blober.io/store"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 )
 
+// shutdownTimeout bounds the time requests in
+// progress are given to complete on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// commands run by the server binary, serve is the default
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	"serve":         func(cfg *config.Config, _ []string) error { serve(cfg); return nil },
+	"migrate":       runMigrate,
+	"create-admin":  runCreateAdmin,
+	"reindex-cache": runReindexCache,
+	"verify":        runVerify,
+	"gc":            runGC,
+	"reconcile":     runReconcile,
+	"export":        runExport,
+	"import":        runImport,
+}
+
 func main() {
 
-	// load env variables
-	err := godotenv.Load()
-	if err != nil {
+	// load env variables of .env, when there is one
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
 		log.Fatalf("failed to load env variables, %v", err)
 	}
 
-	// connect to database or quit app
-	db, err := services.CreateDatabaseConnection(os.Getenv("DATABASE_URL"))
+	// settings flags precede the command, e.g ./main -port 8080 serve
+	cfg, args, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
 	if err != nil {
-		log.Fatalf("failed to connect to database, %v", err)
+		log.Fatal(err)
 	}
 
-	// initialize/open badgerDB backed session store
-	sessionStore, err := store.NewSessionStore()
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected one of serve, migrate, create-admin, "+
+			"reindex-cache, verify, gc, reconcile, export, import\n", command)
+		os.Exit(2)
+	}
+
+	if err := run(cfg, args); err != nil {
+		log.Fatalf("%s failed, %v", command, err)
+	}
+}
+
+// stack holds the connections and repositories
+// shared by the server and admin commands
+type stack struct {
+	db           *gorm.DB
+	sessionStore store.SessionStore
+	blobStore    store.BlobStore
+	storage      *services.StorageService
+	queue        *services.JobQueue
+	accountRepo  *repos.AccountRepository
+	appRepo      *repos.AppRepository
+}
+
+// openStack connects to the database, object storage and opens the
+// stores. BadgerDB stores are locked while open, admin commands
+// using them can't run next to a running server
+func openStack(cfg *config.Config) (*stack, error) {
+	s := &stack{}
+
+	// connect to database
+	db, err := services.CreateDatabaseConnection(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatalf("failed to open session store %v, quiting...", err)
+		return nil, fmt.Errorf("failed to connect to database, %v", err)
 	}
+	s.db = db
 
-	// initialize/open badgerDB backed blob struct cache
-	blobStore, err := store.NewBlobStore()
+	// open the session store and the blob struct cache, replicas
+	// must share them: SESSION_STORE/BLOB_STORE=postgres or redis
+	s.sessionStore, err = openSessionStore(cfg.Stores, db)
 	if err != nil {
-		log.Fatalf("failed to open blob store, %v", err)
+		s.close()
+		return nil, fmt.Errorf("failed to open session store, %v", err)
+	}
+
+	// the blob cache is bounded to BLOB_CACHE_SIZE blobs, 0 for unbounded
+	s.blobStore, err = openBlobStore(cfg.Stores, db)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to open blob store, %v", err)
+	}
+	s.blobStore.SetMaxEntries(cfg.Stores.CacheSize)
+
+	// open and connect to minio object storage server
+	s.storage, err = services.NewStorageService(&services.StorageServiceOption{
+		AccessKey: cfg.Storage.AccessKey,
+		SecretKey: cfg.Storage.SecretKey,
+		Host:      cfg.Storage.Host,
+		UseTLS:    cfg.Storage.UseTLS,
+		Region:    cfg.Storage.Region,
+		PublicURL: cfg.Server.PublicURL,
+		Store:     s.blobStore,
+	})
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to start minio server, %v", err)
+	}
+
+	// durable background job queue,
+	// processes uploaded blobs
+	s.queue = services.NewJobQueue(s.db, &services.JobQueueOption{
+		PollInterval:      cfg.Jobs.PollInterval,
+		ClaimTimeout:      cfg.Jobs.ClaimTimeout,
+		HeartbeatInterval: cfg.Jobs.HeartbeatInterval,
+	})
+
+	// create app dependencies
+	s.accountRepo = repos.NewAccountRepository(s.sessionStore, s.db)
+	s.appRepo = repos.NewAppRepository(s.db, s.accountRepo, s.storage, s.queue, cfg)
+	return s, nil
+}
+
+// openSessionStore opens the session store of cfg:
+// badger (the default), postgres or redis
+func openSessionStore(cfg config.Stores, db *gorm.DB) (store.SessionStore, error) {
+	var (
+		s   store.SessionStore
+		err error
+	)
+
+	// typed nil stores must not be returned on errors
+	switch cfg.Sessions {
+	case "", "badger":
+		var badgerStore *store.BadgerSessionStore
+		if badgerStore, err = store.NewBadgerSessionStore(cfg.Dir); err == nil {
+			s = badgerStore
+		}
+	case "postgres":
+		var postgresStore *store.PostgresSessionStore
+		if postgresStore, err = store.NewPostgresSessionStore(db); err == nil {
+			s = postgresStore
+		}
+	case "redis":
+		var redisStore *store.RedisSessionStore
+		if redisStore, err = store.NewRedisSessionStore(cfg.RedisURL); err == nil {
+			s = redisStore
+		}
+	default:
+		err = fmt.Errorf("unknown session store %q", cfg.Sessions)
+	}
+
+	return s, err
+}
+
+// openBlobStore opens the blob store of cfg:
+// badger (the default), postgres or redis
+func openBlobStore(cfg config.Stores, db *gorm.DB) (store.BlobStore, error) {
+	var (
+		s   store.BlobStore
+		err error
+	)
+
+	// typed nil stores must not be returned on errors
+	switch cfg.Blobs {
+	case "", "badger":
+		var badgerStore *store.BadgerBlobStore
+		if badgerStore, err = store.NewBadgerBlobStore(cfg.Dir); err == nil {
+			s = badgerStore
+		}
+	case "postgres":
+		var postgresStore *store.PostgresBlobStore
+		if postgresStore, err = store.NewPostgresBlobStore(db); err == nil {
+			s = postgresStore
+		}
+	case "redis":
+		var redisStore *store.RedisBlobStore
+		if redisStore, err = store.NewRedisBlobStore(cfg.RedisURL); err == nil {
+			s = redisStore
+		}
+	default:
+		err = fmt.Errorf("unknown blob store %q", cfg.Blobs)
 	}
 
-	defer func() {
-		err := db.Close()
-		if err != nil {
+	return s, err
+}
+
+// close closes the database and the stores
+func (s *stack) close() {
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
 			log.Printf("failed to close DB, %v", err)
 		}
+	}
 
-		err = sessionStore.Close()
-		if err != nil {
+	if s.sessionStore != nil {
+		if err := s.sessionStore.Close(); err != nil {
 			log.Printf("failed to close session store, %v", err)
 		}
+	}
 
-		err = blobStore.Close()
-		if err != nil {
+	if s.blobStore != nil {
+		if err := s.blobStore.Close(); err != nil {
 			log.Printf("failed to close blob store, %v", err)
 		}
-	}()
+	}
+}
 
-	// open and connect to minio object storage server
-	storage, err := services.NewStorageService(&services.StorageServiceOption{
-		AccessKey: os.Getenv("MINIO_ACCESS_KEY"),
-		SecretKey: os.Getenv("MINIO_SECRET_KEY"),
-		Host:      os.Getenv("MINIO_HOST"),
-		Store:     blobStore,
-	})
+// serve runs the API servers and background workers
+func serve(cfg *config.Config) {
+	s, err := openStack(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.close()
 
+	appRepo := s.appRepo
+
+	// finish or undo operations interrupted by a crash, intents
+	// too young to be resolved now are resolved in the background
+	forward, back, err := appRepo.RecoverIntents()
 	if err != nil {
-		log.Fatalf("failed to start minio server %v", err)
+		log.Fatalf("failed to recover unfinished operations, %v", err)
+	}
+	if forward+back > 0 {
+		log.Printf("recovered unfinished operations, %d rolled forward, %d rolled back", forward, back)
 	}
 
-	// create app dependencies
-	accountRepo := repos.NewAccountRepository(sessionStore, db)
-	appRepo := repos.NewAppRepository(db, accountRepo, storage)
-	accountHandler := handlers.NewAccountHandler(accountRepo)
-	appHandler := handlers.NewAppHandler(sessionStore, blobStore, appRepo)
-
-	router := mux.NewRouter()
-	router.NotFoundHandler = &handlers.NotFoundHandler{}
-	router.MethodNotAllowedHandler = &handlers.MethodNotAllowedHandler{}
-
-	router.HandleFunc("/echo", handlers.EchoHandler)
-	router.HandleFunc("/account/new", accountHandler.CreateNewAccountHandler).Methods("POST")
-	router.HandleFunc("/account/authenticate", accountHandler.AuthenticateAccountHandler).Methods("POST")
-	router.HandleFunc("/app/new", appHandler.CreateNewAppHandler).Methods("POST")
-	router.HandleFunc("/me/apps", appHandler.GetAccountAppsHandler).Methods("GET")
-	router.HandleFunc("/{appName}/upload", appHandler.UploadBlobHandler).Methods("POST")
-	router.HandleFunc("/{appName}/uploads", appHandler.UploadMultipleBlobsHandler).Methods("POST")
-	router.HandleFunc("/res/{appName}/{hash}", appHandler.DownloadBlobHandler).Methods("GET")
-	router.HandleFunc("/apps/{appId}/blobs/{page}", appHandler.GetAppBlobs).Methods("GET")
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9008"
-	}
-
-	address := "0.0.0.0:" + port
-	fmt.Println("Server started at ", address)
-
-	handler := cors.Default().Handler(router)
-	if err := http.ListenAndServe(address, handler); err != nil {
+	// optionally cache the most recent blobs,
+	// misses are loaded from the database anyway
+	if cfg.Stores.CacheWarmup {
+		go func() {
+			n := s.blobStore.Stats().MaxEntries
+			if n == 0 {
+				n = config.DefaultCacheSize
+			}
+
+			cached, err := appRepo.WarmCache(n)
+			if err != nil {
+				log.Printf("failed to warm up blob cache, %v", err)
+			}
+			log.Printf("warmed up blob cache with %d blobs", cached)
+		}()
+	}
+
+	accountHandler := handlers.NewAccountHandler(s.accountRepo)
+	appHandler := handlers.NewAppHandler(s.sessionStore, s.blobStore, appRepo, cfg.Limits)
+
+	// scan uploaded files for malware
+	switch cfg.Jobs.Scanner {
+	case "clamd":
+		appRepo.EnableScanning(services.NewClamdScanner(cfg.Jobs.ClamdAddress))
+	case "eicar":
+		appRepo.EnableScanning(services.EicarScanner{})
+	case "noop":
+		appRepo.EnableScanning(services.NoopScanner{})
+	}
+
+	// imports from internal hosts are refused unless allowlisted,
+	// e.g IMPORT_ALLOWLIST=files.internal,10.1.0.0/16
+	if len(cfg.Jobs.ImportAllowlist) > 0 {
+		if err := appRepo.AllowImportsFrom(cfg.Jobs.ImportAllowlist); err != nil {
+			log.Fatalf("invalid IMPORT_ALLOWLIST, %v", err)
+		}
+	}
+
+	s.queue.Start(cfg.Jobs.Workers)
+
+	// apply lifecycle rules and purge expired
+	// trash in the background
+	lifecycle := repos.NewLifecycleWorker(appRepo)
+	reaper := repos.NewTrashReaper(appRepo)
+	recoverer := repos.NewIntentRecoverer(appRepo)
+
+	// reconcile the database, the blob cache and object storage,
+	// e.g RECONCILE_REPAIR=none only reports issues
+	reconciler := repos.NewReconciler(appRepo)
+
+	go lifecycle.Start()
+	go reaper.Start()
+	go recoverer.Start()
+	go reconciler.Start()
+
+	router := handlers.NewRouter(accountHandler, appHandler)
+
+	// S3 compatible API, served path-style on its own port
+	s3Handler := handlers.NewS3Handler(s.sessionStore, appRepo, cfg.Storage.Region, cfg.Limits)
+	s3Server := &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(cfg.Server.S3Port), Handler: s3Handler.Router()}
+	go func() {
+		if err := s3Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve S3 API, %v", err)
+		}
+	}()
+
+	server := &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(cfg.Server.Port), Handler: cors.Default().Handler(router)}
+	fmt.Println("Server started at ", server.Addr)
+
+	// stop background workers and drain requests on
+	// interrupt, stores are closed once they are done
+	stopped := make(chan struct{})
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+
+		lifecycle.Stop()
+		reaper.Stop()
+		recoverer.Stop()
+		reconciler.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, srv := range []*http.Server{s3Server, server} {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("failed to shut down server, %v", err)
+			}
+		}
+
+		// running jobs use the database and storage
+		s.queue.Stop()
+		close(stopped)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
 }
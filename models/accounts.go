@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,6 +33,10 @@ type Account struct {
 	Email     string `json:"email"`
 	Password  string `json:"password"`
 
+	// IsAdmin accounts operate the server, they
+	// are created with the create-admin command
+	IsAdmin bool `json:"is_admin" gorm:"default:false"`
+
 	Cred *Credential `json:"cred" gorm:"-" sql:"-"`
 }
 
@@ -116,7 +121,7 @@ func randomSHA256() string {
 	s := uuid.New().String()
 	sh := sha256.New()
 	sh.Write([]byte(s))
-	sh.Write([]byte(string(time.Now().UnixNano())))
+	sh.Write([]byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
 	return fmt.Sprintf("%x", sh.Sum(nil))
 }
 
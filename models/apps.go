@@ -4,13 +4,40 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
 )
 
 // App is an application created by a blober.io user
 type App struct {
 	gorm.Model
-	Name      string `json:"name"`
-	AccountId uint   `json:"account_id"`
+	Name       string `json:"name"`
+	AccountId  uint   `json:"account_id"`
+	Versioning bool   `json:"versioning" gorm:"default:false"`
+
+	// TrashRetentionDays is how long deleted blobs
+	// stay in trash before they are permanently removed
+	TrashRetentionDays int `json:"trash_retention_days" gorm:"default:30"`
+
+	// ThumbnailSizes are comma separated WIDTHxHEIGHT sizes
+	// of thumbnails generated for uploaded images
+	ThumbnailSizes string `json:"thumbnail_sizes"`
+
+	// BlockUntilScanned refuses downloads of
+	// blobs that have not been scanned clean yet
+	BlockUntilScanned bool `json:"block_until_scanned" gorm:"default:false"`
+
+	// StripGPS removes GPS position from the
+	// EXIF data of uploaded images
+	StripGPS bool `json:"strip_gps" gorm:"default:false"`
+
+	// upload policy, see UploadPolicy. AllowedTypes
+	// are comma separated content types or extensions
+	AllowedTypes   string `json:"allowed_types" gorm:"default:''"`
+	MinSize        int64  `json:"min_size" gorm:"default:0"`
+	MaxSize        int64  `json:"max_size" gorm:"default:0"`
+	MaxFiles       int    `json:"max_files" gorm:"default:0"`
+	FilenamePolicy string `json:"filename_policy" gorm:"default:''"`
 
 	Account *Account `json:"account" sql:"-" gorm:"-"`
 }
@@ -26,10 +53,55 @@ type Blob struct {
 	DownloadURL string `json:"download_url"`
 	AppName     string `json:"app_name"`
 	IsPrivate   bool   `json:"is_private"`
+	ETag        string `json:"etag" gorm:"default:''"` // md5 of the content
+
+	// Key is the stable logical name(filename/path) of a blob.
+	// In versioned apps, every upload with the same key creates
+	// a new version identified by VersionId, only one of them is current
+	Key            string     `json:"key" gorm:"index"`
+	VersionId      string     `json:"version_id"`
+	Noncurrent     bool       `json:"noncurrent" gorm:"default:false"`
+	NoncurrentAt   *time.Time `json:"noncurrent_at,omitempty"`
+	IsDeleteMarker bool       `json:"is_delete_marker" gorm:"default:false"`
+
+	// derivatives(e.g transformed images) are linked to
+	// their original through ParentHash, Variant describes them
+	ParentHash string `json:"parent_hash,omitempty" gorm:"index;default:''"`
+	Variant    string `json:"variant,omitempty" gorm:"default:''"`
+
+	// malware scan state, blobs uploaded before
+	// scanning was enabled have an empty status
+	ScanStatus    string     `json:"scan_status"`
+	ScanSignature string     `json:"scan_signature,omitempty"`
+	ScannedAt     *time.Time `json:"scanned_at,omitempty"`
+
+	// metadata extracted from the content after upload.
+	// Duration is in seconds, ExifData holds Exif as json
+	Width     int               `json:"width" gorm:"default:0"`
+	Height    int               `json:"height" gorm:"default:0"`
+	Duration  float64           `json:"duration" gorm:"default:0"`
+	PageCount int               `json:"page_count" gorm:"default:0"`
+	Exif      map[string]string `json:"exif,omitempty" gorm:"-" sql:"-"`
+	ExifData  string            `json:"-" gorm:"type:text;default:''"`
+
+	// GPSPending is set on images of apps stripping GPS
+	// position until it is removed, they can not be downloaded
+	GPSPending bool `json:"gps_pending" gorm:"default:false"`
+
+	Metadata   map[string]string `json:"metadata" gorm:"-" sql:"-"`
+	Tags       map[string]string `json:"tags" gorm:"-" sql:"-"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty" gorm:"-" sql:"-"` // size => download url
 
 	App *App `json:"-" gorm:"-" sql:"-"`
 }
 
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
 // NewApp creates new app
 func NewApp(name string, account uint) *App {
 	return &App{Name: name, AccountId: account}
@@ -61,25 +133,46 @@ func NewBlob(hash, contentType string, app *App, size int64) *Blob {
 	}
 
 	b.AppName = app.Name
-	b.PopulateDownloadURL()
 	return b
 }
 
-// PopulateDownloadURL formats blob download URL
-func (b *Blob) PopulateDownloadURL() {
-	b.DownloadURL = fmt.Sprintf("%s%s%s%s", "http://blober.io/res/", b.App.UniqueId(), "/", b.Hash)
+// NewDeleteMarker creates a delete marker for key.
+// Delete markers hide older versions of a key without
+// removing them, they have no stored object
+func NewDeleteMarker(app *App, key string) *Blob {
+	hash := randomMD5()
+	return &Blob{
+		Hash: hash, VersionId: hash, Key: key, AppId: app.ID, AppName: app.Name,
+		IsDeleteMarker: true, App: app,
+	}
+}
+
+// PopulateDownloadURL formats blob download URL,
+// publicURL is the url the API is reached at
+func (b *Blob) PopulateDownloadURL(publicURL string) {
+	b.DownloadURL = b.BlobDownloadURL(publicURL)
 }
 
 // BlobDownloadURL forms a file download url
 // useful to download files directly from clients
-func (b *Blob) BlobDownloadURL() string {
-	return fmt.Sprintf("%s%s%s%s", "http://blober.io/res/", b.App.UniqueId(), "/", b.Hash)
+func (b *Blob) BlobDownloadURL(publicURL string) string {
+	return fmt.Sprintf("%s%s%s%s%s", strings.TrimRight(publicURL, "/"), "/res/", b.App.UniqueId(), "/", b.Hash)
 }
 
 // UploadMultipleResponse holds response info for when
 // multiple files are uploaded at once
 type UploadMultipleResponse struct {
-	SuccessCount int64       `json:"success_count"`
-	FailureCount int64       `json:"failure_count"`
-	Blobs        interface{} `json:"blobs"`
+	SuccessCount int64          `json:"success_count"`
+	FailureCount int64          `json:"failure_count"`
+	Blobs        interface{}    `json:"blobs"`
+	Errors       []*UploadError `json:"errors"`
+}
+
+// UploadError describes why a single
+// file of a multiple files upload failed
+type UploadError struct {
+	Index    int    `json:"index"`
+	Filename string `json:"filename"`
+	Code     string `json:"code,omitempty"` // set for policy violations
+	Message  string `json:"message"`
 }
@@ -56,18 +56,33 @@ func TestBlob_BlobDownloadURL(t *testing.T) {
 	blob := NewBlob(hash, "img", &App{Name: "appName", AccountId: 1}, 200)
 
 	downloadUrl := "http://blober.io/res/appName/09876HGFiuyt"
-	if blob.BlobDownloadURL() != downloadUrl {
-		t.Fatalf("download URL should be %s, found %s", downloadUrl, blob.BlobDownloadURL())
+	if blob.BlobDownloadURL("http://blober.io/") != downloadUrl {
+		t.Fatalf("download URL should be %s, found %s", downloadUrl, blob.BlobDownloadURL("http://blober.io/"))
 	}
 }
 
 func TestBlob_PopulateDownloadURL(t *testing.T) {
 	hash := "09876HGFiuyt"
 	blob := NewBlob(hash, "img", &App{Name: "appName", AccountId: 1}, 200)
-	blob.PopulateDownloadURL()
+	blob.PopulateDownloadURL("http://blober.io")
 	downloadUrl := "http://blober.io/res/appName/09876HGFiuyt"
 
 	if blob.DownloadURL != downloadUrl {
 		t.Fatalf("expected downloadURL to be set as %s, found %s", downloadUrl, blob.DownloadURL)
 	}
 }
+
+func TestNewDeleteMarker(t *testing.T) {
+	marker := NewDeleteMarker(&App{Name: "appName", AccountId: 1}, "docs/report.pdf")
+	if !marker.IsDeleteMarker {
+		t.Fatal("expected a delete marker")
+	}
+
+	if marker.Key != "docs/report.pdf" || marker.AppName != "appName" {
+		t.Fatalf("unexpected key or app name, %s %s", marker.Key, marker.AppName)
+	}
+
+	if marker.VersionId == "" || marker.VersionId != marker.Hash {
+		t.Fatalf("delete marker should have a version id")
+	}
+}
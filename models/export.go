@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ExportVersion is the version of the export format
+const ExportVersion = 1
+
+// ErrExportVersion is returned when importing an export of an unknown version
+var ErrExportVersion = errors.New("unsupported export version")
+
+// Export is a portable copy of accounts, their apps and app settings.
+// Blobs are not exported, passwords are exported hashed
+type Export struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Accounts   []*ExportedAccount `json:"accounts"`
+}
+
+// ExportedAccount is an exported account and its apps
+type ExportedAccount struct {
+	*Account
+	Apps []*ExportedApp `json:"apps"`
+}
+
+// ExportedApp is an exported app and its lifecycle rules
+type ExportedApp struct {
+	*App
+	LifecycleRules []*LifecycleRule `json:"lifecycle_rules"`
+}
+
+// ImportReport is the result of an import. Accounts whose email and
+// apps whose name are already taken are skipped, and listed as such
+type ImportReport struct {
+	Accounts int      `json:"accounts"`
+	Apps     int      `json:"apps"`
+	Skipped  []string `json:"skipped"`
+}
+
+// Validate checks an export can be imported
+func (e *Export) Validate() error {
+	if e.Version != ExportVersion {
+		return ErrExportVersion
+	}
+
+	for _, a := range e.Accounts {
+		if a.Account == nil {
+			return errors.New("exported account is empty")
+		}
+
+		if err := validateEmail(a.Email); err != nil {
+			return err
+		}
+
+		if a.Password == "" {
+			return errors.New("exported account " + a.Email + " has no password")
+		}
+
+		for _, app := range a.Apps {
+			if app.App == nil || app.Name == "" {
+				return errors.New("exported app of " + a.Email + " has no name")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExport_Validate(t *testing.T) {
+	valid := func() *Export {
+		account := NewAccount("lekan", "hammed", "adigun@gmail.com", "$2a$10$hash")
+		return &Export{Version: ExportVersion, Accounts: []*ExportedAccount{
+			{Account: account, Apps: []*ExportedApp{{App: &App{Name: "photos"}}}},
+		}}
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("expected a valid export, found %v", err)
+	}
+
+	e := valid()
+	e.Version = ExportVersion + 1
+	if err := e.Validate(); err != ErrExportVersion {
+		t.Fatalf("expected %v, found %v", ErrExportVersion, err)
+	}
+
+	e = valid()
+	e.Accounts[0].Email = "adigun"
+	if err := e.Validate(); err != ErrInvalidEmail {
+		t.Fatalf("expected %v, found %v", ErrInvalidEmail, err)
+	}
+
+	e = valid()
+	e.Accounts[0].Password = ""
+	if err := e.Validate(); err == nil {
+		t.Fatal("expected an account without password to be refused")
+	}
+
+	e = valid()
+	e.Accounts[0].Apps[0].Name = ""
+	if err := e.Validate(); err == nil {
+		t.Fatal("expected an app without name to be refused")
+	}
+}
+
+func TestExport_JSON(t *testing.T) {
+	account := NewAccount("lekan", "hammed", "adigun@gmail.com", "$2a$10$hash")
+	account.IsAdmin = true
+	rules := []*LifecycleRule{{Prefix: "tmp/", ExpireDays: 7}}
+	e := &Export{Version: ExportVersion, Accounts: []*ExportedAccount{
+		{Account: account, Apps: []*ExportedApp{{App: &App{Name: "photos"}, LifecycleRules: rules}}},
+	}}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Export{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	a := decoded.Accounts[0]
+	if a.Email != account.Email || a.Password != account.Password || !a.IsAdmin {
+		t.Fatalf("unexpected account %+v", a.Account)
+	}
+
+	if a.Apps[0].Name != "photos" || a.Apps[0].LifecycleRules[0].Prefix != "tmp/" {
+		t.Fatalf("unexpected app %+v", a.Apps[0])
+	}
+}
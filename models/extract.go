@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// SetExif sets blob Exif and its stored json form
+func (b *Blob) SetExif(exif map[string]string) {
+	b.Exif = exif
+	b.ExifData = ""
+	if len(exif) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(exif)
+	if err != nil {
+		return
+	}
+	b.ExifData = string(data)
+}
+
+// AfterFind decodes Exif of blobs loaded from the database
+func (b *Blob) AfterFind() error {
+	if b.ExifData == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(b.ExifData), &b.Exif)
+}
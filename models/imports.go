@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"net/url"
+)
+
+const (
+	ImportPending = "pending" // waiting for a worker, or to be retried
+	ImportRunning = "running" // being fetched
+	ImportDone    = "done"    // stored as a blob
+	ImportFailed  = "failed"  // gave up, see Error
+)
+
+// MaxImportURLs is the number of urls a single import can fetch
+var MaxImportURLs = 50
+
+// Import is a batch of remote urls fetched into an app
+type Import struct {
+	gorm.Model
+	AccountId uint          `json:"-" gorm:"index"`
+	AppId     uint          `json:"app_id" gorm:"index"`
+	Private   bool          `json:"private"`
+	Status    string        `json:"status" gorm:"-"`
+	Completed int           `json:"completed" gorm:"-"`
+	Failed    int           `json:"failed" gorm:"-"`
+	Items     []*ImportItem `json:"items" gorm:"-"`
+}
+
+// ImportItem is a single url of an import
+type ImportItem struct {
+	gorm.Model
+	ImportId uint   `json:"import_id" gorm:"index"`
+	URL      string `json:"url" gorm:"type:text"`
+	Key      string `json:"key"`
+	Status   string `json:"status"`
+	Received int64  `json:"received"`
+	Size     int64  `json:"size"` // -1 until known
+	BlobHash string `json:"blob_hash"`
+	Error    string `json:"error"`
+}
+
+// ImportURL is a url to import, stored under Key when set
+type ImportURL struct {
+	URL string `json:"url"`
+	Key string `json:"key"`
+}
+
+// NewImport creates an import of urls into app
+func NewImport(account uint, app *App, private bool, urls []*ImportURL) (*Import, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one url is required")
+	}
+
+	if len(urls) > MaxImportURLs {
+		return nil, fmt.Errorf("at most %d urls can be imported at once", MaxImportURLs)
+	}
+
+	im := &Import{AccountId: account, AppId: app.ID, Private: private}
+	for _, u := range urls {
+		parsed, err := url.Parse(u.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid url %q, expected an absolute http or https url", u.URL)
+		}
+
+		im.Items = append(im.Items, &ImportItem{URL: u.URL, Key: u.Key, Status: ImportPending, Size: -1})
+	}
+
+	im.Summarize()
+	return im, nil
+}
+
+// Summarize sets the status and counters of im from its items.
+// An import is done once every item is done or failed
+func (im *Import) Summarize() {
+	im.Completed, im.Failed = 0, 0
+	running := false
+	for _, item := range im.Items {
+		switch item.Status {
+		case ImportDone:
+			im.Completed++
+		case ImportFailed:
+			im.Failed++
+		case ImportRunning:
+			running = true
+		}
+	}
+
+	switch {
+	case im.Completed+im.Failed == len(im.Items):
+		im.Status = ImportDone
+		if im.Completed == 0 {
+			im.Status = ImportFailed
+		}
+	case running || im.Completed+im.Failed > 0:
+		im.Status = ImportRunning
+	default:
+		im.Status = ImportPending
+	}
+}
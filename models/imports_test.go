@@ -0,0 +1,68 @@
+package models
+
+import "testing"
+
+func TestNewImport(t *testing.T) {
+	app := &App{Name: "photos"}
+	app.ID = 3
+
+	im, err := NewImport(1, app, true, []*ImportURL{{URL: "https://example.com/a.jpg"}, {URL: "http://example.com/b", Key: "docs/b.pdf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if im.AppId != 3 || !im.Private || len(im.Items) != 2 || im.Status != ImportPending {
+		t.Fatalf("unexpected import %+v", im)
+	}
+
+	if im.Items[1].Key != "docs/b.pdf" || im.Items[0].Size != -1 {
+		t.Errorf("unexpected items %+v %+v", im.Items[0], im.Items[1])
+	}
+
+	invalid := [][]*ImportURL{
+		{},
+		{{URL: "file:///etc/passwd"}},
+		{{URL: "/relative/path"}},
+		{{URL: "https://"}},
+	}
+	for _, urls := range invalid {
+		if _, err := NewImport(1, app, false, urls); err == nil {
+			t.Errorf("expected %v to be rejected", urls)
+		}
+	}
+
+	urls := make([]*ImportURL, MaxImportURLs+1)
+	for i := range urls {
+		urls[i] = &ImportURL{URL: "https://example.com/file"}
+	}
+	if _, err := NewImport(1, app, false, urls); err == nil {
+		t.Error("expected too many urls to be rejected")
+	}
+}
+
+func TestImportSummarize(t *testing.T) {
+	cases := []struct {
+		statuses          []string
+		status            string
+		completed, failed int
+	}{
+		{[]string{ImportPending, ImportPending}, ImportPending, 0, 0},
+		{[]string{ImportRunning, ImportPending}, ImportRunning, 0, 0},
+		{[]string{ImportDone, ImportPending}, ImportRunning, 1, 0},
+		{[]string{ImportDone, ImportFailed}, ImportDone, 1, 1},
+		{[]string{ImportFailed, ImportFailed}, ImportFailed, 0, 2},
+	}
+
+	for _, c := range cases {
+		im := &Import{}
+		for _, s := range c.statuses {
+			im.Items = append(im.Items, &ImportItem{Status: s})
+		}
+		im.Summarize()
+
+		if im.Status != c.status || im.Completed != c.completed || im.Failed != c.failed {
+			t.Errorf("%v: expected %s %d/%d, got %s %d/%d", c.statuses, c.status, c.completed, c.failed,
+				im.Status, im.Completed, im.Failed)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// operations spanning the database, object storage and the blob cache
+const (
+	IntentUpload     = "upload"      // an object is stored or copied, then its blob is created
+	IntentCreateApp  = "create_app"  // an app is created, then its bucket
+	IntentDeleteBlob = "delete_blob" // a blob is removed, then its object
+	IntentDeleteApp  = "delete_app"  // an app is removed, then its bucket
+)
+
+// Intent is recorded before an operation spanning several stores starts
+// and removed once it completed or was undone. Intents left by a crash
+// are rolled forward or back when the server starts
+type Intent struct {
+	gorm.Model
+	Kind    string `json:"kind"`
+	AppId   uint   `json:"app_id"`   // unset for app creations
+	AppName string `json:"app_name"` // names the bucket
+	Hash    string `json:"hash"`     // object of blob operations
+}
+
+// NewIntent creates the intent of an operation of kind on app
+func NewIntent(kind string, app *App, hash string) *Intent {
+	return &Intent{Kind: kind, AppId: app.ID, AppName: app.Name, Hash: hash}
+}
+
+// NewUploadIntent creates the intent of an upload
+// to app, the object is stored under a new hash
+func NewUploadIntent(app *App) *Intent {
+	return NewIntent(IntentUpload, app, randomMD5())
+}
+
+// App returns the app of the intent, only its id and name are set
+func (i *Intent) App() *App {
+	app := &App{Name: i.AppName}
+	app.ID = i.AppId
+	return app
+}
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestNewUploadIntent(t *testing.T) {
+	app := NewApp("photos", 1)
+	app.ID = 7
+
+	a, b := NewUploadIntent(app), NewUploadIntent(app)
+	if a.Kind != IntentUpload || a.AppId != 7 || a.AppName != "photos" {
+		t.Fatalf("unexpected intent %+v", a)
+	}
+
+	if a.Hash == "" || a.Hash == b.Hash {
+		t.Fatalf("expected unique hashes, found %s and %s", a.Hash, b.Hash)
+	}
+}
+
+func TestIntent_App(t *testing.T) {
+	app := NewApp("photos", 1)
+	app.ID = 7
+
+	intent := NewIntent(IntentDeleteApp, app, "")
+	if got := intent.App(); got.ID != 7 || got.UniqueId() != app.UniqueId() {
+		t.Fatalf("unexpected app %+v", got)
+	}
+}
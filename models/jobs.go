@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+const (
+	JobPending = "pending" // waiting to run, or to be retried
+	JobRunning = "running" // claimed by a worker
+	JobDone    = "done"    // completed successfully
+	JobDead    = "dead"    // failed too many times, kept for inspection
+)
+
+// Job is a unit of background work, e.g processing
+// an uploaded blob. Jobs are persisted so they survive restarts
+type Job struct {
+	gorm.Model
+	Kind        string    `json:"kind" gorm:"index"`
+	AppId       uint      `json:"app_id" gorm:"index"`
+	BlobId      uint      `json:"blob_id" gorm:"index"`
+	Payload     string    `json:"payload"`
+	Status      string    `json:"status" gorm:"index"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	RunAt       time.Time `json:"run_at" gorm:"index"`
+	LastError   string    `json:"last_error"`
+}
+
+// NewJob creates a pending job of kind
+func NewJob(kind string, appId, blobId uint, payload string) *Job {
+	return &Job{Kind: kind, AppId: appId, BlobId: blobId, Payload: payload,
+		Status: JobPending, RunAt: time.Now()}
+}
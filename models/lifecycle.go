@@ -0,0 +1,105 @@
+package models
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	// LifecycleExpire expires the current version of a blob
+	LifecycleExpire = "expire"
+
+	// LifecycleDeleteNoncurrent permanently removes a noncurrent version
+	LifecycleDeleteNoncurrent = "delete-noncurrent"
+)
+
+// LifecycleRule automatically cleans up blobs of an app.
+// A rule applies to blobs whose key starts with Prefix
+// and, when TagKey is set, that carry the tag TagKey=TagValue
+type LifecycleRule struct {
+	gorm.Model
+	AppId          uint   `json:"app_id" gorm:"index"`
+	Name           string `json:"name"`
+	Prefix         string `json:"prefix"`
+	TagKey         string `json:"tag_key"`
+	TagValue       string `json:"tag_value"`
+	ExpireDays     int    `json:"expire_days"`     // expire current versions N days after upload
+	NoncurrentDays int    `json:"noncurrent_days"` // remove versions N days after they became noncurrent
+	Enabled        bool   `json:"enabled"`
+}
+
+// Validate validates a lifecycle rule
+func (r *LifecycleRule) Validate() error {
+	if r.ExpireDays < 0 || r.NoncurrentDays < 0 {
+		return errors.New("lifecycle days can not be negative")
+	}
+
+	if r.ExpireDays == 0 && r.NoncurrentDays == 0 {
+		return errors.New("lifecycle rule must set expire_days or noncurrent_days")
+	}
+
+	return nil
+}
+
+// Action returns the lifecycle action due for blob at now,
+// or an empty string when the rule does not apply.
+// blob tags must be loaded
+func (r *LifecycleRule) Action(blob *Blob, now time.Time) string {
+	if !r.Enabled || !strings.HasPrefix(blob.Key, r.Prefix) {
+		return ""
+	}
+
+	if r.TagKey != "" {
+		if v, ok := blob.Tags[r.TagKey]; !ok || v != r.TagValue {
+			return ""
+		}
+	}
+
+	if blob.Noncurrent {
+		if r.NoncurrentDays > 0 && blob.NoncurrentAt != nil &&
+			now.Sub(*blob.NoncurrentAt) >= days(r.NoncurrentDays) {
+			return LifecycleDeleteNoncurrent
+		}
+		return ""
+	}
+
+	// a current delete marker is already expired
+	if r.ExpireDays > 0 && !blob.IsDeleteMarker && now.Sub(blob.CreatedAt) >= days(r.ExpireDays) {
+		return LifecycleExpire
+	}
+
+	return ""
+}
+
+// LifecycleRun records the progress of applying an
+// app's lifecycle rules, so an interrupted run can be resumed
+type LifecycleRun struct {
+	gorm.Model
+	AppId    uint `json:"app_id" gorm:"index"`
+	Cursor   uint `json:"cursor"` // ID of the last processed blob
+	Finished bool `json:"finished"`
+}
+
+// LifecycleAction is a single cleanup done(or that would be done) by a run
+type LifecycleAction struct {
+	Rule      string `json:"rule"`
+	Action    string `json:"action"`
+	Hash      string `json:"hash"`
+	Key       string `json:"key"`
+	VersionId string `json:"version_id"`
+	Size      int64  `json:"size"`
+}
+
+// LifecycleReport is the result of a lifecycle run
+type LifecycleReport struct {
+	AppId   uint               `json:"app_id"`
+	DryRun  bool               `json:"dry_run"`
+	Actions []*LifecycleAction `json:"actions"`
+	Errors  []string           `json:"errors"`
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
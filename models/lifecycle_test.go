@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleRule_ActionExpire(t *testing.T) {
+	now := time.Now()
+	rule := &LifecycleRule{Name: "exports", Prefix: "exports/", ExpireDays: 7, Enabled: true}
+
+	blob := &Blob{Key: "exports/report.csv"}
+	blob.CreatedAt = now.Add(-8 * 24 * time.Hour)
+	if a := rule.Action(blob, now); a != LifecycleExpire {
+		t.Fatalf("expected %s, found %s", LifecycleExpire, a)
+	}
+
+	blob.CreatedAt = now.Add(-6 * 24 * time.Hour)
+	if a := rule.Action(blob, now); a != "" {
+		t.Fatalf("expected no action, found %s", a)
+	}
+
+	other := &Blob{Key: "images/cat.png"}
+	other.CreatedAt = now.Add(-30 * 24 * time.Hour)
+	if a := rule.Action(other, now); a != "" {
+		t.Fatalf("expected prefix mismatch to be ignored, found %s", a)
+	}
+}
+
+func TestLifecycleRule_ActionTag(t *testing.T) {
+	now := time.Now()
+	rule := &LifecycleRule{TagKey: "temporary", TagValue: "true", ExpireDays: 1, Enabled: true}
+
+	blob := &Blob{Key: "a.txt", Tags: map[string]string{"temporary": "true"}}
+	blob.CreatedAt = now.Add(-48 * time.Hour)
+	if a := rule.Action(blob, now); a != LifecycleExpire {
+		t.Fatalf("expected %s, found %s", LifecycleExpire, a)
+	}
+
+	blob.Tags["temporary"] = "false"
+	if a := rule.Action(blob, now); a != "" {
+		t.Fatalf("expected tag mismatch to be ignored, found %s", a)
+	}
+}
+
+func TestLifecycleRule_ActionNoncurrent(t *testing.T) {
+	now := time.Now()
+	since := now.Add(-3 * 24 * time.Hour)
+	rule := &LifecycleRule{NoncurrentDays: 2, Enabled: true}
+
+	blob := &Blob{Key: "a.txt", Noncurrent: true, NoncurrentAt: &since}
+	if a := rule.Action(blob, now); a != LifecycleDeleteNoncurrent {
+		t.Fatalf("expected %s, found %s", LifecycleDeleteNoncurrent, a)
+	}
+
+	rule.Enabled = false
+	if a := rule.Action(blob, now); a != "" {
+		t.Fatalf("disabled rule should not apply, found %s", a)
+	}
+}
+
+func TestLifecycleRule_Validate(t *testing.T) {
+	if err := (&LifecycleRule{}).Validate(); err == nil {
+		t.Fatal("rule without days should be invalid")
+	}
+
+	if err := (&LifecycleRule{ExpireDays: 7}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+const (
+	// MinPartSize is the smallest size of a part, but the last one
+	MinPartSize = 5 << 20
+
+	// MaxPartNumber is the highest part number of a multipart upload
+	MaxPartNumber = 10000
+)
+
+// MultipartUpload is an object uploaded in parts, parts are
+// stored as separate objects until the upload is completed
+type MultipartUpload struct {
+	gorm.Model
+	UploadId     string `json:"upload_id" gorm:"unique_index"`
+	AppId        uint   `json:"app_id" gorm:"index"`
+	Key          string `json:"key"`
+	Private      bool   `json:"private"`
+	MetadataData string `json:"-" gorm:"type:text;default:''"`
+
+	Metadata map[string]string `json:"metadata" gorm:"-" sql:"-"`
+}
+
+// MultipartPart is an uploaded part of a multipart upload
+type MultipartPart struct {
+	gorm.Model
+	UploadId   string `json:"upload_id" gorm:"index"`
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"` // md5 of the part
+	Size       int64  `json:"size"`
+}
+
+// NewMultipartUpload creates an upload of key
+func NewMultipartUpload(app *App, key string, private bool, metadata map[string]string) (*MultipartUpload, error) {
+	if key == "" {
+		return nil, errors.New("key can not be empty")
+	}
+
+	if err := ValidateMetadata(metadata); err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{UploadId: randomMD5() + randomMD5()[:16], AppId: app.ID, Key: key,
+		Private: private, Metadata: metadata}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	upload.MetadataData = string(data)
+
+	return upload, nil
+}
+
+// AfterFind decodes metadata of an upload loaded from the database
+func (u *MultipartUpload) AfterFind() error {
+	if u.MetadataData == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(u.MetadataData), &u.Metadata)
+}
+
+// ObjectName returns the name of the stored object of part n
+func (u *MultipartUpload) ObjectName(n int) string {
+	return fmt.Sprintf("multipart-%s-%05d", u.UploadId, n)
+}
+
+// IsPartObject reports whether name is the stored object of an upload part
+func IsPartObject(name string) bool {
+	return strings.HasPrefix(name, "multipart-")
+}
+
+// ValidatePartNumber checks n is a valid part number
+func ValidatePartNumber(n int) error {
+	if n < 1 || n > MaxPartNumber {
+		return fmt.Errorf("part number must be between 1 and %d", MaxPartNumber)
+	}
+
+	return nil
+}
+
+// CompletedPart is a part listed by a client completing an upload
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// PartError is returned when the parts listed to
+// complete an upload do not match uploaded parts
+type PartError struct {
+	Code    string // S3 error code, e.g InvalidPart
+	Message string
+}
+
+func (e *PartError) Error() string {
+	return e.Message
+}
+
+// MatchParts returns the uploaded parts listed by completed,
+// in order. Listed parts must be ascending, exist with the same
+// ETag and all but the last must be at least MinPartSize
+func MatchParts(uploaded []*MultipartPart, completed []*CompletedPart) ([]*MultipartPart, error) {
+	if len(completed) == 0 {
+		return nil, &PartError{Code: "MalformedXML", Message: "at least one part must be listed"}
+	}
+
+	byNumber := make(map[int]*MultipartPart)
+	for _, p := range uploaded {
+		byNumber[p.PartNumber] = p
+	}
+
+	parts := make([]*MultipartPart, len(completed))
+	for i, c := range completed {
+		if i > 0 && c.PartNumber <= completed[i-1].PartNumber {
+			return nil, &PartError{Code: "InvalidPartOrder", Message: "parts must be listed in ascending order"}
+		}
+
+		p, ok := byNumber[c.PartNumber]
+		if !ok || strings.Trim(c.ETag, `"`) != p.ETag {
+			return nil, &PartError{Code: "InvalidPart",
+				Message: fmt.Sprintf("part %d was not uploaded or its etag does not match", c.PartNumber)}
+		}
+
+		parts[i] = p
+	}
+
+	for _, p := range parts[:len(parts)-1] {
+		if p.Size < MinPartSize {
+			return nil, &PartError{Code: "EntityTooSmall",
+				Message: fmt.Sprintf("part %d is smaller than the %d bytes minimum", p.PartNumber, MinPartSize)}
+		}
+	}
+
+	return parts, nil
+}
+
+// MultipartETag returns the S3 style ETag of an object
+// made of parts, the md5 of part md5s followed by the part count
+func MultipartETag(parts []*MultipartPart) string {
+	h := md5.New()
+	for _, p := range parts {
+		sum, _ := hex.DecodeString(p.ETag)
+		h.Write(sum)
+	}
+
+	return fmt.Sprintf("%x-%d", h.Sum(nil), len(parts))
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestMatchParts(t *testing.T) {
+	uploaded := []*MultipartPart{
+		{PartNumber: 3, ETag: "c", Size: 10},
+		{PartNumber: 1, ETag: "a", Size: MinPartSize},
+		{PartNumber: 2, ETag: "b", Size: MinPartSize},
+	}
+
+	parts, err := MatchParts(uploaded, []*CompletedPart{{1, `"a"`}, {2, "b"}, {3, "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 3 || parts[0].PartNumber != 1 || parts[2].PartNumber != 3 {
+		t.Fatalf("unexpected parts %v", parts)
+	}
+
+	// the last part can be smaller, parts can be skipped
+	if _, err := MatchParts(uploaded, []*CompletedPart{{1, "a"}, {3, "c"}}); err != nil {
+		t.Error(err)
+	}
+
+	invalid := map[string][]*CompletedPart{
+		"MalformedXML":     {},
+		"InvalidPartOrder": {{2, "b"}, {1, "a"}},
+		"InvalidPart":      {{1, "a"}, {2, "wrong"}},
+		"EntityTooSmall":   {{2, "b"}, {3, "c"}, {4, "d"}},
+	}
+	uploaded = append(uploaded, &MultipartPart{PartNumber: 4, ETag: "d", Size: 1})
+	for code, completed := range invalid {
+		_, err := MatchParts(uploaded, completed)
+		if e, ok := err.(*PartError); !ok || e.Code != code {
+			t.Errorf("expected %s, got %v", code, err)
+		}
+	}
+}
+
+func TestMultipartETag(t *testing.T) {
+	one, two := md5.Sum([]byte("one")), md5.Sum([]byte("two"))
+	parts := []*MultipartPart{{ETag: hex.EncodeToString(one[:])}, {ETag: hex.EncodeToString(two[:])}}
+
+	expected := md5.Sum(append(one[:], two[:]...))
+	if etag := MultipartETag(parts); etag != fmt.Sprintf("%x-2", expected) {
+		t.Errorf("unexpected etag %s", etag)
+	}
+}
+
+func TestIsPartObject(t *testing.T) {
+	upload := &MultipartUpload{UploadId: "abc"}
+	if name := upload.ObjectName(7); name != "multipart-abc-00007" || !IsPartObject(name) {
+		t.Errorf("unexpected part object name %s", name)
+	}
+
+	if IsPartObject("5d41402abc4b2a76b9719d911017c592") {
+		t.Error("expected a blob hash not to be a part object")
+	}
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	FilenameKeep     = "keep"     // filenames are stored as uploaded
+	FilenameSanitize = "sanitize" // unsafe characters are replaced
+	FilenameReject   = "reject"   // files with unsafe names are rejected
+)
+
+// codes of upload policy violations
+const (
+	ViolationType     = "type_not_allowed"
+	ViolationTooLarge = "file_too_large"
+	ViolationTooSmall = "file_too_small"
+	ViolationFilename = "invalid_filename"
+	ViolationCount    = "too_many_files"
+)
+
+// maxFilenameLength is the longest sanitized filename
+var maxFilenameLength = 255
+
+// unsafeFilenameChars are replaced when sanitizing filenames
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sniffedExtensions maps extensions to the content type
+// detected for files of that kind. A file with one of these
+// extensions must have matching content, and files whose content
+// is one of these types must carry a matching extension
+var sniffedExtensions = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif",
+	".webp": "image/webp", ".bmp": "image/bmp", ".pdf": "application/pdf", ".zip": "application/zip",
+	".gz": "application/x-gzip", ".mp4": "video/mp4", ".webm": "video/webm", ".mp3": "audio/mpeg",
+	".wav": "audio/wave", ".ogg": "application/ogg", ".html": "text/html", ".htm": "text/html",
+}
+
+// UploadPolicy restricts files uploaded to an app.
+// Zero values mean no restriction
+type UploadPolicy struct {
+	// AllowedTypes are content types(e.g image/png, image/*)
+	// or extensions(e.g .pdf). Types are matched against the
+	// sniffed content, not the type claimed by clients
+	AllowedTypes   []string `json:"allowed_types"`
+	MinSize        int64    `json:"min_size"`
+	MaxSize        int64    `json:"max_size"`
+	MaxFiles       int      `json:"max_files"` // per multiple files upload
+	FilenamePolicy string   `json:"filename_policy"`
+}
+
+// PolicyViolation is returned when an uploaded
+// file does not satisfy its app's upload policy
+type PolicyViolation struct {
+	Filename string `json:"filename"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (v *PolicyViolation) Error() string {
+	if v.Filename == "" {
+		return v.Message
+	}
+
+	return fmt.Sprintf("%s: %s", v.Filename, v.Message)
+}
+
+// Validate validates an upload policy and normalizes allowed types
+func (p *UploadPolicy) Validate() error {
+	if p.MinSize < 0 || p.MaxSize < 0 || p.MaxFiles < 0 {
+		return errors.New("upload limits can not be negative")
+	}
+
+	if p.MaxSize > 0 && p.MinSize > p.MaxSize {
+		return errors.New("min_size can not be larger than max_size")
+	}
+
+	switch p.FilenamePolicy {
+	case "":
+		p.FilenamePolicy = FilenameKeep
+	case FilenameKeep, FilenameSanitize, FilenameReject:
+	default:
+		return fmt.Errorf("unknown filename policy %s", p.FilenamePolicy)
+	}
+
+	types := make([]string, 0, len(p.AllowedTypes))
+	for _, t := range p.AllowedTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case t == "":
+			continue
+		case strings.HasPrefix(t, "."):
+			if strings.ContainsAny(t[1:], "./,") {
+				return fmt.Errorf("invalid extension %s", t)
+			}
+		case strings.Count(t, "/") != 1 || strings.Contains(t, ","):
+			return fmt.Errorf("invalid type %s, expected a content type or an extension", t)
+		}
+		types = append(types, t)
+	}
+	p.AllowedTypes = types
+
+	return nil
+}
+
+// SetUploadPolicy validates and sets the upload policy of an app
+func (a *App) SetUploadPolicy(p *UploadPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	a.AllowedTypes = strings.Join(p.AllowedTypes, ",")
+	a.MinSize, a.MaxSize, a.MaxFiles = p.MinSize, p.MaxSize, p.MaxFiles
+	a.FilenamePolicy = p.FilenamePolicy
+	return nil
+}
+
+// UploadPolicy returns the upload policy of an app
+func (a *App) UploadPolicy() *UploadPolicy {
+	p := &UploadPolicy{AllowedTypes: make([]string, 0), MinSize: a.MinSize, MaxSize: a.MaxSize,
+		MaxFiles: a.MaxFiles, FilenamePolicy: a.FilenamePolicy}
+	if p.FilenamePolicy == "" {
+		p.FilenamePolicy = FilenameKeep
+	}
+
+	for _, t := range strings.Split(a.AllowedTypes, ",") {
+		if t != "" {
+			p.AllowedTypes = append(p.AllowedTypes, t)
+		}
+	}
+
+	return p
+}
+
+// CheckCount checks the number of files of a multiple files upload
+func (p *UploadPolicy) CheckCount(n int) error {
+	if p.MaxFiles > 0 && n > p.MaxFiles {
+		return &PolicyViolation{Code: ViolationCount,
+			Message: fmt.Sprintf("at most %d files can be uploaded at once, got %d", p.MaxFiles, n)}
+	}
+
+	return nil
+}
+
+// Check checks an uploaded file against the policy. sniffed is the
+// content type detected from the file content. The filename the file
+// should be stored under is returned
+func (p *UploadPolicy) Check(filename string, size int64, sniffed string) (string, error) {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return "", &PolicyViolation{Filename: filename, Code: ViolationTooLarge,
+			Message: fmt.Sprintf("file is %d bytes, larger than the %d bytes limit", size, p.MaxSize)}
+	}
+
+	if size < p.MinSize {
+		return "", &PolicyViolation{Filename: filename, Code: ViolationTooSmall,
+			Message: fmt.Sprintf("file is %d bytes, smaller than the %d bytes minimum", size, p.MinSize)}
+	}
+
+	if err := p.checkType(filename, sniffed); err != nil {
+		return "", err
+	}
+
+	switch p.FilenamePolicy {
+	case FilenameSanitize:
+		return SanitizeFilename(filename), nil
+	case FilenameReject:
+		if SanitizeFilename(filename) != filename {
+			return "", &PolicyViolation{Filename: filename, Code: ViolationFilename,
+				Message: "filename may only contain letters, digits, dots, dashes and underscores"}
+		}
+	}
+
+	return filename, nil
+}
+
+// checkType checks the sniffed content type and extension of a file
+func (p *UploadPolicy) checkType(filename, sniffed string) error {
+	if len(p.AllowedTypes) == 0 {
+		return nil
+	}
+
+	sniffed = strings.ToLower(strings.TrimSpace(strings.SplitN(sniffed, ";", 2)[0]))
+	ext := strings.ToLower(path.Ext(filename))
+	for _, t := range p.AllowedTypes {
+		if strings.HasPrefix(t, ".") {
+			if t == ext && extensionMatches(ext, sniffed) {
+				return nil
+			}
+			continue
+		}
+
+		if t == sniffed || (strings.HasSuffix(t, "/*") && strings.HasPrefix(sniffed, strings.TrimSuffix(t, "*"))) {
+			return nil
+		}
+	}
+
+	if ext != "" && !extensionMatches(ext, sniffed) {
+		return &PolicyViolation{Filename: filename, Code: ViolationType,
+			Message: fmt.Sprintf("content(%s) does not match the %s extension", sniffed, ext)}
+	}
+
+	return &PolicyViolation{Filename: filename, Code: ViolationType,
+		Message: fmt.Sprintf("%s files are not allowed, allowed types are %s", sniffed, strings.Join(p.AllowedTypes, ", "))}
+}
+
+// extensionMatches reports whether content of type sniffed
+// can be stored with extension ext
+func extensionMatches(ext, sniffed string) bool {
+	if expected, ok := sniffedExtensions[ext]; ok {
+		return expected == sniffed
+	}
+
+	for _, t := range sniffedExtensions {
+		if t == sniffed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SanitizeFilename strips directories from filename and replaces
+// characters other than letters, digits, dots, dashes and underscores
+func SanitizeFilename(filename string) string {
+	name := path.Base(strings.Replace(filename, "\\", "/", -1))
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, ".")
+
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = name[:maxFilenameLength-len(ext)] + ext
+	}
+
+	if name == "" || name == "_" {
+		return "file"
+	}
+
+	return name
+}
@@ -0,0 +1,104 @@
+package models
+
+import "testing"
+
+func TestApp_SetUploadPolicy(t *testing.T) {
+	app := NewApp("appName", 1)
+	policy := &UploadPolicy{AllowedTypes: []string{" Image/* ", ".PDF"}, MaxSize: 100, MaxFiles: 3}
+	if err := app.SetUploadPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	if app.AllowedTypes != "image/*,.pdf" {
+		t.Fatalf("expected normalized types, found %s", app.AllowedTypes)
+	}
+
+	p := app.UploadPolicy()
+	if len(p.AllowedTypes) != 2 || p.MaxSize != 100 || p.MaxFiles != 3 || p.FilenamePolicy != FilenameKeep {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+}
+
+func TestApp_SetUploadPolicyInvalid(t *testing.T) {
+	app := NewApp("appName", 1)
+	for _, p := range []*UploadPolicy{{MinSize: -1}, {MinSize: 10, MaxSize: 5}, {FilenamePolicy: "rename"},
+		{AllowedTypes: []string{"image"}}, {AllowedTypes: []string{".tar.gz"}}} {
+		if err := app.SetUploadPolicy(p); err == nil {
+			t.Fatalf("expected %+v to be rejected", p)
+		}
+	}
+}
+
+func TestUploadPolicy_Check(t *testing.T) {
+	p := &UploadPolicy{AllowedTypes: []string{"image/*", ".pdf", ".csv"}, MinSize: 1, MaxSize: 100}
+
+	cases := []struct {
+		filename string
+		size     int64
+		sniffed  string
+		code     string
+	}{
+		{"a.png", 10, "image/png", ""},
+		{"a.pdf", 10, "application/pdf", ""},
+		{"a.csv", 10, "text/plain; charset=utf-8", ""},
+		{"a.png", 101, "image/png", ViolationTooLarge},
+		{"a.png", 0, "image/png", ViolationTooSmall},
+		{"a.txt", 10, "text/plain; charset=utf-8", ViolationType},
+		{"a.pdf", 10, "application/octet-stream", ViolationType}, // not a pdf
+		{"a.csv", 10, "application/zip", ViolationType},          // zip renamed to csv
+	}
+
+	for _, c := range cases {
+		_, err := p.Check(c.filename, c.size, c.sniffed)
+		if c.code == "" {
+			if err != nil {
+				t.Errorf("expected %s(%s) to be accepted, got %v", c.filename, c.sniffed, err)
+			}
+			continue
+		}
+
+		violation, ok := err.(*PolicyViolation)
+		if !ok || violation.Code != c.code {
+			t.Errorf("expected %s(%s) to be rejected with %s, got %v", c.filename, c.sniffed, c.code, err)
+		}
+	}
+}
+
+func TestUploadPolicy_CheckFilename(t *testing.T) {
+	p := &UploadPolicy{FilenamePolicy: FilenameSanitize}
+	name, err := p.Check("../../etc/my report (1).pdf", 10, "application/pdf")
+	if err != nil || name != "my_report_1_.pdf" {
+		t.Fatalf("expected sanitized filename, got %s, %v", name, err)
+	}
+
+	p.FilenamePolicy = FilenameReject
+	if _, err := p.Check("my report.pdf", 10, "application/pdf"); err == nil {
+		t.Fatal("expected unsafe filename to be rejected")
+	}
+
+	if name, err := p.Check("report-1.pdf", 10, "application/pdf"); err != nil || name != "report-1.pdf" {
+		t.Fatalf("expected safe filename to be kept, got %s, %v", name, err)
+	}
+}
+
+func TestUploadPolicy_CheckCount(t *testing.T) {
+	p := &UploadPolicy{MaxFiles: 2}
+	if err := p.CheckCount(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CheckCount(3); err == nil {
+		t.Fatal("expected too many files to be rejected")
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	for in, out := range map[string]string{
+		"photo.jpg": "photo.jpg", `C:\Users\me\photo.jpg`: "photo.jpg", ".htaccess": "htaccess",
+		"": "file", "/": "file", "résumé.pdf": "r_sum_.pdf",
+	} {
+		if got := SanitizeFilename(in); got != out {
+			t.Errorf("SanitizeFilename(%q) = %q, expected %q", in, got, out)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// repairable kinds of issues, orphan buckets are
+// only reported as they may not belong to blober
+var repairable = map[string]bool{
+	IssueMissingObject: true, // the blob is purged
+	IssueOrphanObject:  true, // the object is removed
+	IssueMissingCache:  true, // the blob is cached
+	IssueStaleCache:    true, // the cache entry is removed
+	IssueCacheMismatch: true, // the blob is cached again
+	IssueMissingBucket: true, // the bucket is created
+}
+
+// RepairPolicy is the set of issue kinds a reconciliation repairs,
+// other issues are only reported
+type RepairPolicy map[string]bool
+
+// DefaultRepairPolicy repairs every issue but blobs missing their
+// object, purging them loses their metadata and needs a decision
+func DefaultRepairPolicy() RepairPolicy {
+	return RepairPolicy{IssueOrphanObject: true, IssueMissingCache: true, IssueStaleCache: true,
+		IssueCacheMismatch: true, IssueMissingBucket: true}
+}
+
+// GCPolicy only removes orphan objects and stale cache entries
+func GCPolicy() RepairPolicy {
+	return RepairPolicy{IssueOrphanObject: true, IssueStaleCache: true}
+}
+
+// ParseRepairPolicy parses a comma separated list of issue
+// kinds, "none" repairs nothing and "all" every repairable kind
+func ParseRepairPolicy(s string) (RepairPolicy, error) {
+	policy := make(RepairPolicy)
+	switch s = strings.TrimSpace(s); s {
+	case "none", "":
+		return policy, nil
+	case "all":
+		for kind := range repairable {
+			policy[kind] = true
+		}
+		return policy, nil
+	}
+
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.TrimSpace(kind)
+		if !repairable[kind] {
+			return nil, errors.New("unknown or unrepairable issue kind " + kind)
+		}
+		policy[kind] = true
+	}
+
+	return policy, nil
+}
+
+// String returns the sorted comma separated kinds of p
+func (p RepairPolicy) String() string {
+	kinds := make([]string, 0, len(p))
+	for kind, ok := range p {
+		if ok {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	if len(kinds) == 0 {
+		return "none"
+	}
+
+	sort.Strings(kinds)
+	return strings.Join(kinds, ",")
+}
@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+func TestParseRepairPolicy(t *testing.T) {
+	policy, err := ParseRepairPolicy("orphan_object, stale_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy[IssueOrphanObject] || !policy[IssueStaleCache] || policy[IssueMissingObject] {
+		t.Fatalf("unexpected policy %v", policy)
+	}
+
+	if s := policy.String(); s != "orphan_object,stale_cache" {
+		t.Fatalf("expected orphan_object,stale_cache, found %s", s)
+	}
+
+	none, err := ParseRepairPolicy("none")
+	if err != nil || len(none) != 0 || none.String() != "none" {
+		t.Fatalf("expected an empty policy, found %v, %v", none, err)
+	}
+
+	all, err := ParseRepairPolicy("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all[IssueOrphanBucket] || !all[IssueMissingObject] || !all[IssueMissingBucket] {
+		t.Fatalf("unexpected policy %v", all)
+	}
+
+	if _, err := ParseRepairPolicy("orphan_bucket"); err == nil {
+		t.Fatal("expected orphan buckets to be unrepairable")
+	}
+
+	if _, err := ParseRepairPolicy("orphan_object,typo"); err == nil {
+		t.Fatal("expected an unknown kind to be refused")
+	}
+}
+
+func TestDefaultRepairPolicy(t *testing.T) {
+	policy := DefaultRepairPolicy()
+	if policy[IssueMissingObject] {
+		t.Fatal("expected blobs missing their object not to be purged by default")
+	}
+
+	parsed, err := ParseRepairPolicy(policy.String())
+	if err != nil || parsed.String() != policy.String() {
+		t.Fatalf("expected %s, found %s, %v", policy, parsed, err)
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maxTags is the maximum number of tags
+// a single blob can carry
+var maxTags = 10
+
+// maxMetadataSize is the maximum total size(keys + values)
+// of user metadata attached to a blob
+var maxMetadataSize = 2 << 10 // 2KiB
+
+// ErrTooManyTags is returned when a blob is given
+// more than maxTags tags
+var ErrTooManyTags = fmt.Errorf("a blob can not have more than %d tags", maxTags)
+
+// ErrMetadataTooLarge is returned when user metadata
+// exceeds maxMetadataSize
+var ErrMetadataTooLarge = fmt.Errorf("blob metadata can not be larger than %d bytes", maxMetadataSize)
+
+// BlobMetadata is a single user supplied key/value
+// pair attached to a blob at upload time.
+// metadata is immutable once the blob has been created
+type BlobMetadata struct {
+	ID     uint   `json:"-" gorm:"primary_key"`
+	BlobId uint   `json:"-" gorm:"index"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
+// TableName sets BlobMetadata table name
+func (BlobMetadata) TableName() string {
+	return "blob_metadata"
+}
+
+// BlobTag is a single editable key/value tag
+// attached to a blob. Tags can be used to filter listings
+type BlobTag struct {
+	ID     uint   `json:"-" gorm:"primary_key"`
+	BlobId uint   `json:"-" gorm:"index"`
+	Key    string `json:"key" gorm:"index"`
+	Value  string `json:"value"`
+}
+
+// TableName sets BlobTag table name
+func (BlobTag) TableName() string {
+	return "blob_tags"
+}
+
+// ValidateMetadata validates user supplied metadata
+func ValidateMetadata(meta map[string]string) error {
+	size := 0
+	for k, v := range meta {
+		if k == "" {
+			return errors.New("metadata key can not be empty")
+		}
+		size += len(k) + len(v)
+	}
+
+	if size > maxMetadataSize {
+		return ErrMetadataTooLarge
+	}
+
+	return nil
+}
+
+// ValidateTags validates a tag set
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return ErrTooManyTags
+	}
+
+	for k, v := range tags {
+		if k == "" || len(k) > 128 {
+			return errors.New("tag key must be between 1 and 128 characters")
+		}
+
+		if len(v) > 256 {
+			return fmt.Errorf("value of tag %s is longer than 256 characters", k)
+		}
+	}
+
+	return nil
+}
+
+// ParseTagging parses a url encoded tag set
+// e.g owner=12&type=invoice
+func ParseTagging(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return tags, nil
+	}
+
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, errors.New("malformed tags, expected key=value pairs separated by &")
+	}
+
+	for k := range values {
+		tags[k] = values.Get(k)
+	}
+
+	return tags, ValidateTags(tags)
+}
+
+// TaggingString url encodes blob tags in
+// a stable order. It is the reverse of ParseTagging
+func (b *Blob) TaggingString() string {
+	keys := make([]string, 0, len(b.Tags))
+	for k := range b.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(b.Tags[k]))
+	}
+
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,49 @@
+package models
+
+import "testing"
+
+func TestParseTagging(t *testing.T) {
+	tags, err := ParseTagging("owner=12&type=invoice&status=pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags["owner"] != "12" || tags["type"] != "invoice" || tags["status"] != "pending" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestParseTaggingTooMany(t *testing.T) {
+	_, err := ParseTagging("a=1&b=2&c=3&d=4&e=5&f=6&g=7&h=8&i=9&j=10&k=11")
+	if err != ErrTooManyTags {
+		t.Fatalf("expected %v, found %v", ErrTooManyTags, err)
+	}
+}
+
+func TestBlob_TaggingString(t *testing.T) {
+	blob := &Blob{Tags: map[string]string{"type": "invoice", "owner": "a b"}}
+	expected := "owner=a+b&type=invoice"
+	if blob.TaggingString() != expected {
+		t.Fatalf("expected %s, found %s", expected, blob.TaggingString())
+	}
+
+	tags, err := ParseTagging(blob.TaggingString())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags["owner"] != "a b" {
+		t.Fatalf("expected tags to round trip, found %v", tags)
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := ValidateMetadata(map[string]string{"owner": "12"}); err != nil {
+		t.Fatal(err)
+	}
+
+	large := make([]byte, maxMetadataSize)
+	if err := ValidateMetadata(map[string]string{"data": string(large)}); err != ErrMetadataTooLarge {
+		t.Fatalf("expected %v, found %v", ErrMetadataTooLarge, err)
+	}
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxThumbnailSizes is the maximum number of
+// thumbnail sizes an app can have
+var maxThumbnailSizes = 5
+
+// maxThumbnailDimension is the largest width or height of a thumbnail
+var maxThumbnailDimension = 1024
+
+// thumbnailVariantPrefix prefixes Variant of thumbnail derivatives
+const thumbnailVariantPrefix = "thumbnail:"
+
+// ThumbnailSize is a thumbnail width and height
+type ThumbnailSize struct {
+	Width  int
+	Height int
+}
+
+// String formats size as WIDTHxHEIGHT
+func (s ThumbnailSize) String() string {
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// Variant returns the Variant of thumbnails of this size
+func (s ThumbnailSize) Variant() string {
+	return thumbnailVariantPrefix + s.String()
+}
+
+// ParseThumbnailSize parses a WIDTHxHEIGHT size
+func ParseThumbnailSize(s string) (ThumbnailSize, error) {
+	size := ThumbnailSize{}
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "x")
+	if len(parts) != 2 {
+		return size, fmt.Errorf("invalid thumbnail size %s, expected WIDTHxHEIGHT", s)
+	}
+
+	var err error
+	if size.Width, err = strconv.Atoi(parts[0]); err != nil {
+		return size, fmt.Errorf("invalid thumbnail size %s, expected WIDTHxHEIGHT", s)
+	}
+
+	if size.Height, err = strconv.Atoi(parts[1]); err != nil {
+		return size, fmt.Errorf("invalid thumbnail size %s, expected WIDTHxHEIGHT", s)
+	}
+
+	if size.Width < 1 || size.Height < 1 || size.Width > maxThumbnailDimension || size.Height > maxThumbnailDimension {
+		return size, fmt.Errorf("thumbnail sides must be between 1 and %d", maxThumbnailDimension)
+	}
+
+	return size, nil
+}
+
+// SetThumbnailSizes validates and sets thumbnail sizes of an app
+func (a *App) SetThumbnailSizes(sizes []string) error {
+	if len(sizes) > maxThumbnailSizes {
+		return fmt.Errorf("an app can not have more than %d thumbnail sizes", maxThumbnailSizes)
+	}
+
+	normalized := make([]string, 0, len(sizes))
+	seen := make(map[string]bool)
+	for _, s := range sizes {
+		size, err := ParseThumbnailSize(s)
+		if err != nil {
+			return err
+		}
+
+		if seen[size.String()] {
+			return errors.New("duplicate thumbnail size " + size.String())
+		}
+		seen[size.String()] = true
+		normalized = append(normalized, size.String())
+	}
+
+	a.ThumbnailSizes = strings.Join(normalized, ",")
+	return nil
+}
+
+// Thumbnails returns thumbnail sizes generated for
+// images uploaded to this app
+func (a *App) Thumbnails() []ThumbnailSize {
+	sizes := make([]ThumbnailSize, 0)
+	for _, s := range strings.Split(a.ThumbnailSizes, ",") {
+		if size, err := ParseThumbnailSize(s); err == nil {
+			sizes = append(sizes, size)
+		}
+	}
+
+	return sizes
+}
+
+// ThumbnailName returns the size name of a thumbnail
+// derivative, or an empty string when b is not a thumbnail
+func (b *Blob) ThumbnailName() string {
+	if b.ParentHash == "" || !strings.HasPrefix(b.Variant, thumbnailVariantPrefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(b.Variant, thumbnailVariantPrefix)
+}
@@ -0,0 +1,42 @@
+package models
+
+import "testing"
+
+func TestApp_SetThumbnailSizes(t *testing.T) {
+	app := NewApp("appName", 1)
+	if err := app.SetThumbnailSizes([]string{"64x64", " 256X128"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if app.ThumbnailSizes != "64x64,256x128" {
+		t.Fatalf("expected normalized sizes, found %s", app.ThumbnailSizes)
+	}
+
+	sizes := app.Thumbnails()
+	if len(sizes) != 2 || sizes[1].Width != 256 || sizes[1].Height != 128 {
+		t.Fatalf("unexpected sizes %v", sizes)
+	}
+}
+
+func TestApp_SetThumbnailSizesInvalid(t *testing.T) {
+	app := NewApp("appName", 1)
+	for _, sizes := range [][]string{{"64"}, {"0x10"}, {"2000x10"}, {"10x10", "10x10"},
+		{"1x1", "2x2", "3x3", "4x4", "5x5", "6x6"}} {
+		if err := app.SetThumbnailSizes(sizes); err == nil {
+			t.Fatalf("expected %v to be rejected", sizes)
+		}
+	}
+}
+
+func TestBlob_ThumbnailName(t *testing.T) {
+	size, _ := ParseThumbnailSize("64x64")
+	blob := &Blob{ParentHash: "abc", Variant: size.Variant()}
+	if blob.ThumbnailName() != "64x64" {
+		t.Fatalf("expected 64x64, found %s", blob.ThumbnailName())
+	}
+
+	blob.Variant = "w=64;h=64;fit=cover"
+	if blob.ThumbnailName() != "" {
+		t.Fatalf("transformations are not thumbnails, found %s", blob.ThumbnailName())
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// inconsistencies between the database, the blob cache and object storage
+const (
+	IssueMissingObject = "missing_object" // a blob without stored object
+	IssueOrphanObject  = "orphan_object"  // a stored object without blob
+	IssueMissingCache  = "missing_cache"  // a downloadable blob missing from a cache holding every blob
+	IssueStaleCache    = "stale_cache"    // a cache entry without downloadable blob
+	IssueCacheMismatch = "cache_mismatch" // a cache entry differing from its blob
+	IssueMissingBucket = "missing_bucket" // an app without bucket
+	IssueOrphanBucket  = "orphan_bucket"  // a bucket without app
+)
+
+// Issue is an inconsistency found by a verification
+type Issue struct {
+	Kind     string `json:"kind"`
+	App      string `json:"app,omitempty"`
+	Hash     string `json:"hash,omitempty"`      // blob hash or object name
+	CacheKey string `json:"cache_key,omitempty"` // blob cache entry
+	Size     int64  `json:"size,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"` // why a repair failed
+}
+
+// VerifyReport is the result of a cross-check of the
+// database, the blob cache and object storage
+type VerifyReport struct {
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Apps         int       `json:"apps"`
+	Blobs        int       `json:"blobs"`
+	Objects      int       `json:"objects"`
+	CacheEntries int       `json:"cache_entries"`
+	Issues       []*Issue  `json:"issues"`
+	Repaired     int       `json:"repaired"`
+}
+
+// Add records an issue
+func (r *VerifyReport) Add(issue *Issue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+// Count returns the number of issues of kind
+func (r *VerifyReport) Count(kind string) int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Kind == kind {
+			n++
+		}
+	}
+
+	return n
+}
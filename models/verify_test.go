@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestVerifyReport_Count(t *testing.T) {
+	report := &VerifyReport{}
+	report.Add(&Issue{Kind: IssueOrphanObject, App: "photos", Hash: "a"})
+	report.Add(&Issue{Kind: IssueMissingCache, App: "photos", Hash: "b"})
+	report.Add(&Issue{Kind: IssueOrphanObject, App: "docs", Hash: "c"})
+
+	if n := report.Count(IssueOrphanObject); n != 2 {
+		t.Fatalf("expected 2 orphan objects, found %d", n)
+	}
+
+	if n := report.Count(IssueMissingCache); n != 1 {
+		t.Fatalf("expected 1 missing cache entry, found %d", n)
+	}
+
+	if n := report.Count(IssueMissingBucket); n != 0 {
+		t.Fatalf("expected no missing bucket, found %d", n)
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// events sent to webhooks
+const (
+	EventBlobCreated = "blob.created"
+	EventBlobDeleted = "blob.deleted"
+	EventBlobScanned = "blob.scanned"
+	EventAppCreated  = "app.created"
+	EventAppDeleted  = "app.deleted"
+)
+
+// events only sent to activity streams
+const (
+	EventUploadProgress = "upload.progress"
+	EventBlobProcessed  = "blob.processed"
+	EventImportProgress = "import.progress"
+)
+
+// Events are the events webhooks can subscribe to
+var Events = []string{EventBlobCreated, EventBlobDeleted, EventBlobScanned, EventAppCreated, EventAppDeleted}
+
+const (
+	DeliveryPending   = "pending"   // waiting to be sent, or to be retried
+	DeliveryDelivered = "delivered" // the receiver responded with 2xx
+	DeliveryFailed    = "failed"    // every attempt failed
+)
+
+// Webhook is a subscription of a URL to events of an account's apps.
+// Webhooks with no AppId receive events of every app of the account
+type Webhook struct {
+	gorm.Model
+	AccountId uint   `json:"account_id" gorm:"index"`
+	AppId     uint   `json:"app_id" gorm:"index"`
+	URL       string `json:"url"`
+	Events    string `json:"events"` // comma separated, * for every event
+	Secret    string `json:"secret,omitempty"`
+	Active    bool   `json:"active" gorm:"default:true"`
+}
+
+// NewWebhook creates a webhook with a random signing secret
+func NewWebhook(account, app uint, rawURL string, events []string) (*Webhook, error) {
+	hook := &Webhook{AccountId: account, AppId: app, URL: strings.TrimSpace(rawURL), Secret: randomSHA256(), Active: true}
+	if err := hook.SetEvents(events); err != nil {
+		return nil, err
+	}
+
+	return hook, hook.Validate()
+}
+
+// Validate validates a webhook
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url must be an absolute http or https url")
+	}
+
+	if w.Events == "" {
+		return errors.New("webhook must subscribe to at least one event")
+	}
+
+	return nil
+}
+
+// SetEvents validates and sets the events of a webhook
+func (w *Webhook) SetEvents(events []string) error {
+	normalized := make([]string, 0, len(events))
+	for _, e := range events {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "*" {
+			w.Events = "*"
+			return nil
+		}
+
+		known := false
+		for _, k := range Events {
+			known = known || k == e
+		}
+
+		if !known {
+			return fmt.Errorf("unknown event %s, events are %s", e, strings.Join(Events, ", "))
+		}
+		normalized = append(normalized, e)
+	}
+
+	w.Events = strings.Join(normalized, ",")
+	return nil
+}
+
+// Subscribed reports whether w receives event
+func (w *Webhook) Subscribed(event string) bool {
+	if !w.Active {
+		return false
+	}
+
+	for _, e := range strings.Split(w.Events, ",") {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event is something that happened to an app or its blobs.
+// Seq orders events of activity streams, transient events
+// (e.g upload progress) can not be replayed
+type Event struct {
+	ID        string      `json:"id"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Type      string      `json:"type"`
+	AppId     uint        `json:"app_id"`
+	AppName   string      `json:"app_name"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+	Transient bool        `json:"-"`
+}
+
+// NewEvent creates an event of app
+func NewEvent(eventType string, app *App, data interface{}) *Event {
+	return &Event{ID: randomMD5(), Type: eventType, AppId: app.ID, AppName: app.Name,
+		CreatedAt: time.Now().UTC(), Data: data}
+}
+
+// WebhookDelivery records a single event sent to a webhook
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookId    uint       `json:"webhook_id" gorm:"index"`
+	AppId        uint       `json:"app_id" gorm:"index"`
+	EventId      string     `json:"event_id"`
+	Event        string     `json:"event"`
+	Payload      string     `json:"payload" gorm:"type:text"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	ResponseCode int        `json:"response_code"`
+	Error        string     `json:"error"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+	RedeliveryOf uint       `json:"redelivery_of,omitempty"` // ID of the redelivered delivery
+}
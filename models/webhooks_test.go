@@ -0,0 +1,54 @@
+package models
+
+import "testing"
+
+func TestNewWebhook(t *testing.T) {
+	hook, err := NewWebhook(1, 2, "https://example.com/hooks", []string{"blob.created", " BLOB.DELETED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hook.Secret == "" || !hook.Active {
+		t.Fatal("expected an active webhook with a secret")
+	}
+
+	if !hook.Subscribed(EventBlobCreated) || !hook.Subscribed(EventBlobDeleted) || hook.Subscribed(EventAppCreated) {
+		t.Fatalf("unexpected subscriptions %s", hook.Events)
+	}
+
+	hook.Active = false
+	if hook.Subscribed(EventBlobCreated) {
+		t.Fatal("inactive webhooks are not subscribed")
+	}
+}
+
+func TestNewWebhookInvalid(t *testing.T) {
+	cases := []struct {
+		url    string
+		events []string
+	}{
+		{"ftp://example.com", []string{"*"}},
+		{"/hooks", []string{"*"}},
+		{"https://example.com", []string{}},
+		{"https://example.com", []string{"blob.updated"}},
+	}
+
+	for _, c := range cases {
+		if _, err := NewWebhook(1, 0, c.url, c.events); err == nil {
+			t.Errorf("expected %s %v to be rejected", c.url, c.events)
+		}
+	}
+}
+
+func TestWebhook_SubscribedWildcard(t *testing.T) {
+	hook, err := NewWebhook(1, 0, "http://localhost:8080", []string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range Events {
+		if !hook.Subscribed(e) {
+			t.Errorf("expected wildcard webhook to receive %s", e)
+		}
+	}
+}
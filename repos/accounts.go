@@ -12,12 +12,12 @@ import (
 // AccountRepository encapsulates database
 // access dealing with user accounts
 type AccountRepository struct {
-	store *store.SessionStore // session store
-	db    *gorm.DB            // database handle
+	store store.SessionStore // session store
+	db    *gorm.DB           // database handle
 }
 
 // NewAccountRepository creates a new repository
-func NewAccountRepository(s *store.SessionStore, db *gorm.DB) *AccountRepository {
+func NewAccountRepository(s store.SessionStore, db *gorm.DB) *AccountRepository {
 	return &AccountRepository{store: s, db: db}
 }
 
@@ -68,6 +68,41 @@ func (repo *AccountRepository) CreateNewAccount(firstName, lastName, email, pass
 	return account, nil
 }
 
+// CreateAdmin creates an admin account, or makes the existing account
+// of email an admin, its password is replaced when one is given. A new
+// session is created, so the returned account holds keys whether it
+// was created or promoted
+func (repo *AccountRepository) CreateAdmin(firstName, lastName, email, password string) (*models.Account, error) {
+	columns := map[string]interface{}{"is_admin": true}
+	account := repo.GetAccountByAttr("email", email)
+	if account == nil {
+		created, err := repo.CreateNewAccount(firstName, lastName, email, password)
+		if err != nil {
+			return nil, err
+		}
+		account = created
+	} else if password != "" {
+		if err := (&models.Account{Email: email, Password: password}).Validate(); err != nil {
+			return nil, err
+		}
+		columns["password"] = repo.hashPassword(password)
+	}
+
+	if err := repo.db.Model(account).UpdateColumns(columns).Error; err != nil {
+		return nil, err
+	}
+
+	account.IsAdmin = true
+	account.Password = ""
+	account.Cred = models.NewCredential()
+	if err := repo.store.Set(account.Cred.StripKey(), account); err != nil {
+		log.Printf("failed to set session %v", err)
+		return nil, err
+	}
+
+	return account, nil
+}
+
 // AuthenticateAccount authenticates an account
 // and create a credential then store the account
 // in sessionstore
@@ -0,0 +1,238 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+// IsAdmin reports whether the account identified by id is an admin.
+// The flag is read from the database, sessions may predate a change
+func (repo *AppRepository) IsAdmin(id uint) bool {
+	account := repo.account.GetAccountByAttr("id", id)
+	return account != nil && account.IsAdmin
+}
+
+// eachBlobBatch calls fn with batches of blobs matched by query, in id order
+func (repo *AppRepository) eachBlobBatch(query *gorm.DB, fn func(blobs []*models.Blob) error) error {
+	var last uint
+	for {
+		blobs := make([]*models.Blob, 0, repo.blobBatch)
+		if err := query.Where("id > ?", last).Order("id").Limit(repo.blobBatch).Find(&blobs).Error; err != nil {
+			return err
+		}
+
+		if len(blobs) == 0 {
+			return nil
+		}
+
+		if err := fn(blobs); err != nil {
+			return err
+		}
+
+		if len(blobs) < repo.blobBatch {
+			return nil
+		}
+		last = blobs[len(blobs)-1].ID
+	}
+}
+
+// allApps returns every app of every account
+func (repo *AppRepository) allApps() ([]*models.App, error) {
+	apps := make([]*models.App, 0)
+	if err := repo.db.Order("id").Find(&apps).Error; err != nil {
+		return nil, err
+	}
+
+	return apps, nil
+}
+
+// ReindexCache rebuilds the blob cache from the database. Every
+// downloadable blob is cached again and entries without blob are
+// removed. It returns the number of cached blobs and removed entries
+func (repo *AppRepository) ReindexCache() (cached, removed int, err error) {
+	apps, err := repo.allApps()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	live := make(map[string]bool)
+	for _, app := range apps {
+		query := repo.db.Where("app_id = ? AND is_delete_marker = ?", app.ID, false)
+		err := repo.eachBlobBatch(query, func(blobs []*models.Blob) error {
+			repo.loadAttributes(blobs)
+			for _, b := range blobs {
+				b.AppName = app.Name
+				if err := repo.storage.CacheBlob(b); err != nil {
+					return err
+				}
+
+				live[services.CacheKey(b.AppName, b.Hash)] = true
+				cached++
+			}
+			return nil
+		})
+		if err != nil {
+			return cached, removed, err
+		}
+	}
+
+	stale := make([]string, 0)
+	err = repo.storage.EachCachedBlob(func(key string, _ models.Blob) error {
+		if !live[key] {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return cached, removed, err
+	}
+
+	for _, key := range stale {
+		if err := repo.storage.UncacheKey(key); err != nil {
+			return cached, removed, err
+		}
+		removed++
+	}
+
+	return cached, removed, nil
+}
+
+// Verify cross-checks blobs of the database with the objects of every
+// bucket and with the blob cache. Parts of multipart uploads and objects
+// younger than the orphan grace are not reported as orphans
+func (repo *AppRepository) Verify() (*models.VerifyReport, error) {
+	report := &models.VerifyReport{StartedAt: time.Now(), Issues: make([]*models.Issue, 0)}
+	apps, err := repo.allApps()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := repo.storage.Buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	orphanBuckets := make(map[string]bool, len(buckets))
+	for _, name := range buckets {
+		orphanBuckets[name] = true
+	}
+
+	live := make(map[string]bool)
+	for _, app := range apps {
+		report.Apps++
+		bucket := strings.ToLower(app.UniqueId())
+		if !orphanBuckets[bucket] {
+			report.Add(&models.Issue{Kind: models.IssueMissingBucket, App: app.Name})
+			continue
+		}
+
+		delete(orphanBuckets, bucket)
+		if err := repo.verifyApp(app, report, live); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range orphanBuckets {
+		report.Add(&models.Issue{Kind: models.IssueOrphanBucket, App: name})
+	}
+
+	err = repo.storage.EachCachedBlob(func(key string, blob models.Blob) error {
+		report.CacheEntries++
+		if !live[key] {
+			report.Add(&models.Issue{Kind: models.IssueStaleCache, App: blob.AppName, Hash: blob.Hash, CacheKey: key})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// verifyApp checks blobs of app against its objects and the cache,
+// cache keys of its downloadable blobs are added to live. Blobs missing
+// from a partial cache are not reported, they are loaded on download
+func (repo *AppRepository) verifyApp(app *models.App, report *models.VerifyReport, live map[string]bool) error {
+	partial := repo.storage.PartialCache()
+	// blobs holding an object, trashed ones included, by hash
+	stored := make(map[string]*models.Blob)
+	query := repo.db.Unscoped().Where("app_id = ? AND is_delete_marker = ?", app.ID, false)
+	err := repo.eachBlobBatch(query, func(blobs []*models.Blob) error {
+		for _, b := range blobs {
+			report.Blobs++
+			stored[b.Hash] = &models.Blob{Hash: b.Hash, Key: b.Key, Size: b.Size}
+			if b.DeletedAt != nil {
+				continue
+			}
+
+			key := services.CacheKey(app.Name, b.Hash)
+			live[key] = true
+			cached, err := repo.storage.CachedBlob(app.Name, b.Hash)
+			if err != nil {
+				if partial {
+					continue
+				}
+				report.Add(&models.Issue{Kind: models.IssueMissingCache, App: app.Name, Hash: b.Hash, CacheKey: key})
+			} else if diff := cacheDiff(b, &cached); diff != "" {
+				report.Add(&models.Issue{Kind: models.IssueCacheMismatch, App: app.Name, Hash: b.Hash, CacheKey: key,
+					Detail: diff})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = repo.storage.EachObject(app, func(obj *services.StoredObject) error {
+		report.Objects++
+		if models.IsPartObject(obj.Name) {
+			return nil
+		}
+
+		if _, ok := stored[obj.Name]; ok {
+			delete(stored, obj.Name)
+			return nil
+		}
+
+		if time.Since(obj.LastModified) >= repo.orphanGrace {
+			report.Add(&models.Issue{Kind: models.IssueOrphanObject, App: app.Name, Hash: obj.Name, Size: obj.Size})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for hash, b := range stored {
+		report.Add(&models.Issue{Kind: models.IssueMissingObject, App: app.Name, Hash: hash, Size: b.Size, Detail: b.Key})
+	}
+
+	return nil
+}
+
+// cacheDiff describes the fields of a cached blob
+// differing from its row, or returns an empty string
+func cacheDiff(row, cached *models.Blob) string {
+	diffs := make([]string, 0)
+	add := func(field string, want, got interface{}) {
+		if want != got {
+			diffs = append(diffs, fmt.Sprintf("%s %v != %v", field, got, want))
+		}
+	}
+
+	add("size", row.Size, cached.Size)
+	add("etag", row.ETag, cached.ETag)
+	add("key", row.Key, cached.Key)
+	add("content_type", row.ContentType, cached.ContentType)
+	add("is_private", row.IsPrivate, cached.IsPrivate)
+	add("noncurrent", row.Noncurrent, cached.Noncurrent)
+	add("scan_status", row.ScanStatus, cached.ScanStatus)
+	return strings.Join(diffs, ", ")
+}
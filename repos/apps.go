@@ -1,6 +1,7 @@
 package repos
 
 import (
+	"blober.io/config"
 	"blober.io/models"
 	"blober.io/services"
 	"errors"
@@ -8,22 +9,70 @@ import (
 	"io"
 	"log"
 	"mime/multipart"
+	"sync"
+	"time"
 )
 
-// apps query paging limit
-var Limit int64 = 20
-
 // AppRepository encapsulates database
 // access dealing with apps
 type AppRepository struct {
 	db      *gorm.DB
 	account *AccountRepository
 	storage *services.StorageService
+	queue   *services.JobQueue
+	scanner services.Scanner // nil when scanning is disabled
+
+	webhooks *services.WebhookSender
+	events   *services.EventBus // feeds activity streams
+	fetcher  *services.Fetcher  // fetches imported urls
+
+	processors []string // job kinds enqueued for every created blob
+
+	pageSize          int64         // items of a listing page
+	blobBatch         int           // blobs loaded at once by maintenance tasks
+	orphanGrace       time.Duration // age of objects without blob reported as orphans
+	reconcileInterval time.Duration // time between periodic reconciliations
+	lifecycleInterval time.Duration // time between applications of lifecycle rules
+	trashInterval     time.Duration // time between purges of expired trash
+
+	maxPutSize      int64                    // bytes of an object uploaded in a single PutObject request
+	maxArchiveSize  int64                    // bytes of blobs downloaded as a single archive
+	unarchiveLimits services.UnarchiveLimits // bound archives uploaded for extraction
+	fetchOption     services.FetcherOption   // bounds of imported files
+
+	lifecycleMu     sync.Mutex // serializes lifecycle runs
+	derivativeLocks keyedMutex // serializes generation of a derivative
+
+	repairPolicy models.RepairPolicy  // issues repaired by periodic reconciliations
+	reconcileMu  sync.Mutex           // serializes reconciliations
+	reportMu     sync.Mutex           // guards lastReport
+	lastReport   *models.VerifyReport // report of the last reconciliation
 }
 
-// NewAppRepository creates new AppRepository
-func NewAppRepository(db *gorm.DB, account *AccountRepository, storage *services.StorageService) *AppRepository {
-	return &AppRepository{db: db, account: account, storage: storage}
+// NewAppRepository creates new AppRepository, limits, listings,
+// imports and maintenance tasks are configured by cfg
+func NewAppRepository(db *gorm.DB, account *AccountRepository, storage *services.StorageService,
+	queue *services.JobQueue, cfg *config.Config) *AppRepository {
+	repo := &AppRepository{db: db, account: account, storage: storage, queue: queue,
+		events:   services.NewEventBus(eventHistory),
+		pageSize: cfg.Limits.PageSize, blobBatch: cfg.Maintenance.BatchSize,
+		orphanGrace: cfg.Maintenance.OrphanGrace, reconcileInterval: cfg.Maintenance.ReconcileInterval,
+		repairPolicy: cfg.Maintenance.Repair, lifecycleInterval: cfg.Maintenance.LifecycleInterval,
+		trashInterval: cfg.Maintenance.TrashInterval,
+		maxPutSize:    cfg.Limits.MaxPutSize, maxArchiveSize: cfg.Limits.MaxArchiveSize,
+		unarchiveLimits: services.UnarchiveLimits{
+			MaxEntries: cfg.Limits.UnarchiveEntries, MaxEntrySize: cfg.Limits.UnarchiveEntrySize,
+			MaxTotalSize: cfg.Limits.UnarchiveSize, MaxRatio: cfg.Limits.UnarchiveRatio,
+		},
+		fetchOption: services.FetcherOption{MaxSize: cfg.Limits.MaxImportSize, Timeout: cfg.Jobs.ImportTimeout}}
+	repo.fetcher, _ = services.NewFetcher(&repo.fetchOption)
+	repo.webhooks = services.NewWebhookSender(repo.fetcher)
+	queue.Register(JobWebhook, repo.deliverWebhook)
+	queue.Register(JobImport, repo.importURL)
+	repo.RegisterProcessor(JobThumbnails, repo.generateThumbnails)
+	repo.RegisterProcessor(JobExtract, repo.extractMetadata)
+	storage.SetBlobLoader(repo.loadBlob)
+	return repo
 }
 
 // CreateNewApp creates a new app
@@ -47,37 +96,155 @@ func (repo *AppRepository) CreateNewApp(account uint, name string) (*models.App,
 		return nil, errors.New("user account not found")
 	}
 
-	tx := repo.db.Begin()
-	err := tx.Error
-	if err != nil {
+	// the bucket is created once the app is, an
+	// intent covers a crash in between
+	intent := models.NewIntent(models.IntentCreateApp, app, "")
+	if err := repo.beginIntent(intent); err != nil {
 		return nil, err
 	}
 
-	if err := tx.Create(app).Error; err != nil {
+	if err := repo.db.Create(app).Error; err != nil {
 		log.Printf("failed to create app, %v", err)
-		tx.Rollback()
+		repo.endIntent(intent)
 		return nil, err
 	}
 
 	app.Account = user
 	if err := repo.storage.CreateBucketForApp(app); err != nil {
 		log.Printf("failed to create bucket %v", err)
+		if err := repo.db.Unscoped().Delete(app).Error; err != nil {
+			log.Printf("failed to remove app without bucket, %v", err)
+			return nil, err
+		}
+		repo.endIntent(intent)
+		return nil, err
+	}
+
+	repo.endIntent(intent)
+	repo.notify(app, models.EventAppCreated, app)
+	return app, nil
+}
+
+// ErrAppNotEmpty is returned when deleting an app
+// that still holds blobs without forcing it
+var ErrAppNotEmpty = errors.New("app is not empty, delete its blobs first or force the deletion")
+
+// DeleteApp deletes an app of account and its bucket. Apps holding blobs,
+// trashed and noncurrent ones included, or incomplete multipart uploads
+// are only deleted when force is set, their blobs are purged first
+func (repo *AppRepository) DeleteApp(account uint, appName string, force bool) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, ErrAppNotFound
+	}
+
+	blobs := make([]*models.Blob, 0)
+	if err := repo.db.Unscoped().Where("app_id = ?", app.ID).Order("id").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	uploads := make([]*models.MultipartUpload, 0)
+	if err := repo.db.Where("app_id = ?", app.ID).Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+
+	if !force && (len(blobs) > 0 || len(uploads) > 0) {
+		return nil, ErrAppNotEmpty
+	}
+
+	for _, upload := range uploads {
+		repo.removeUpload(app, upload)
+	}
+
+	for _, b := range blobs {
+		if err := repo.purgeBlob(b); err != nil {
+			return nil, err
+		}
+	}
+
+	intent := models.NewIntent(models.IntentDeleteApp, app, "")
+	if err := repo.beginIntent(intent); err != nil {
+		return nil, err
+	}
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		repo.endIntent(intent)
+		return nil, err
+	}
+
+	if err := deleteAppRecords(tx, app); err != nil {
 		tx.Rollback()
+		repo.endIntent(intent)
+		return nil, err
+	}
+
+	// apps are removed for good so their name can be taken again
+	if err := tx.Unscoped().Delete(app).Error; err != nil {
+		tx.Rollback()
+		repo.endIntent(intent)
 		return nil, err
 	}
 
 	if err := tx.Commit().Error; err != nil {
-		log.Printf("failed to commit create app transaction, %v", err)
+		log.Printf("failed to commit delete app transaction, %v", err)
+		repo.endIntent(intent)
 		return nil, err
 	}
 
+	// webhooks of the app are gone, account wide ones get the event
+	repo.notify(app, models.EventAppDeleted, app)
+
+	// the intent is kept when the bucket remains,
+	// its removal is retried on the next start
+	if err := repo.storage.RemoveBucketForApp(app); err != nil {
+		log.Printf("failed to remove bucket of app %s, %v", app.Name, err)
+		return app, nil
+	}
+
+	repo.endIntent(intent)
 	return app, nil
 }
 
+// deleteAppRecords removes lifecycle rules and runs, webhooks and
+// their deliveries, imports and jobs of app. Webhook jobs are kept,
+// they deliver events of the app to account wide webhooks
+func deleteAppRecords(tx *gorm.DB, app *models.App) error {
+	deletes := []struct {
+		model interface{}
+		where string
+	}{
+		{&models.LifecycleRule{}, "app_id = ?"},
+		{&models.LifecycleRun{}, "app_id = ?"},
+		{&models.WebhookDelivery{}, "webhook_id IN (SELECT id FROM webhooks WHERE app_id = ?)"},
+		{&models.Webhook{}, "app_id = ?"},
+		{&models.ImportItem{}, "import_id IN (SELECT id FROM imports WHERE app_id = ?)"},
+		{&models.Import{}, "app_id = ?"},
+	}
+
+	for _, d := range deletes {
+		if err := tx.Unscoped().Where(d.where, app.ID).Delete(d.model).Error; err != nil {
+			log.Printf("failed to delete records of app %s, %v", app.Name, err)
+			return err
+		}
+	}
+
+	return tx.Unscoped().Where("app_id = ? AND kind <> ?", app.ID, JobWebhook).Delete(&models.Job{}).Error
+}
+
+// UploadOptions holds optional attributes
+// of an uploaded file
+type UploadOptions struct {
+	Private  bool              // only downloadable with private key
+	Metadata map[string]string // immutable user metadata
+	Tags     map[string]string // editable tags
+	Key      string            // logical name, defaults to the filename
+}
+
 // UploadBlob uploads a file
 // the file is uploaded to minio server
 // using services.StorageService{}
-func (repo *AppRepository) UploadBlob(account uint, appName string, private bool, body *multipart.FileHeader) (*models.Blob, error) {
+func (repo *AppRepository) UploadBlob(account uint, appName string, opt *UploadOptions, body *multipart.FileHeader) (*models.Blob, error) {
 
 	// make sure app exists
 	app := repo.GetAppByName(account, appName)
@@ -85,27 +252,283 @@ func (repo *AppRepository) UploadBlob(account uint, appName string, private bool
 		return nil, errors.New("app not found")
 	}
 
+	if err := models.ValidateMetadata(opt.Metadata); err != nil {
+		return nil, err
+	}
+
+	if err := models.ValidateTags(opt.Tags); err != nil {
+		return nil, err
+	}
+
+	// files violating the app upload policy are
+	// rejected before anything is stored
+	filename, err := repo.checkUploadPolicy(app, body)
+	if err != nil {
+		return nil, err
+	}
+
 	// converts file to io.Reader
 	file, err := body.Open()
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	key := opt.Key
+	if key == "" {
+		key = filename
+	}
+
+	return repo.storeBlob(app, opt, filename, key, file, body.Size)
+}
+
+// storeBlob streams size bytes of file to minio server and creates
+// its record in the database, the blob is then queued for processing.
+// An upload intent is recorded first, a failed upload is undone
+func (repo *AppRepository) storeBlob(app *models.App, opt *UploadOptions, filename, key string, file io.Reader, size int64) (*models.Blob, error) {
+	var blob *models.Blob
+	intent := models.NewUploadIntent(app)
+	err := repo.runIntent(intent, func() error {
+		// send file to minio server
+		stored, err := repo.storage.UploadBlob(app, intent.Hash, opt.Private, file, size)
+		if err != nil {
+			return err
+		}
+
+		blob, err = repo.createBlob(app, opt, stored, filename, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// createBlob creates the database record of a stored blob
+// and queues it for processing
+func (repo *AppRepository) createBlob(app *models.App, opt *UploadOptions, blob *models.Blob, filename, key string) (*models.Blob, error) {
+	blob.IsPrivate = opt.Private
+	blob.Filename = filename
+	blob.Metadata = opt.Metadata
+	blob.Tags = opt.Tags
+	blob.Key = key
+
+	if app.Versioning {
+		blob.VersionId = blob.Hash
+	}
+
+	if repo.scanner != nil {
+		blob.ScanStatus = models.ScanPending
+	}
+	repo.holdForGPS(app, blob)
+
+	if err := repo.saveBlob(app, blob); err != nil {
+		return nil, err
+	}
+
+	repo.processBlob(app, blob)
+	return blob, nil
+}
+
+// saveBlob creates blob record along with its metadata and tags.
+// In versioned apps, blob becomes the current version of its key
+func (repo *AppRepository) saveBlob(app *models.App, blob *models.Blob) error {
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	// previous versions are uncached once they are noncurrent
+	previous := make([]string, 0)
+	if app.Versioning && blob.ParentHash == "" {
+		// saves of a key are serialized until commit, concurrent
+		// ones would both find the same current version
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(CAST(? AS integer), hashtext(?))", app.ID, blob.Key).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		query := tx.Table("blobs").Where("app_id = ? AND key = ? AND noncurrent = ?", app.ID, blob.Key, false)
+		if err := query.Pluck("hash", &previous).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err := query.UpdateColumns(map[string]interface{}{"noncurrent": true, "noncurrent_at": time.Now()}).Error
+		if err != nil {
+			log.Printf("failed to update previous versions, %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Create(blob).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for k, v := range blob.Metadata {
+		if err := tx.Create(&models.BlobMetadata{BlobId: blob.ID, Key: k, Value: v}).Error; err != nil {
+			log.Printf("failed to save blob metadata, %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := repo.saveTags(tx, blob.ID, blob.Tags); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit blob creation, %v", err)
+		return err
+	}
+	repo.uncacheBlobs(app, previous)
+
+	// re-cache now that blob has an ID, filename and attributes.
+	// delete markers are never served
+	if !blob.IsDeleteMarker {
+		if err := repo.storage.CacheBlob(blob); err != nil {
+			log.Printf("failed to cache blob, %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetBlob returns the blob identified by hash, it must
+// belong to appName and appName must belong to account
+func (repo *AppRepository) GetBlob(account uint, appName, hash string) (*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	blob := &models.Blob{}
+	err := repo.db.Table("blobs").Where("app_id = ? AND hash = ?", app.ID, hash).First(blob).Error
+	if err != nil {
+		return nil, errors.New("blob not found")
+	}
+
+	blob.App = app
+	repo.loadAttributes([]*models.Blob{blob})
+	return blob, nil
+}
+
+// SetBlobTags replaces the tag set of a blob
+func (repo *AppRepository) SetBlobTags(account uint, appName, hash string, tags map[string]string) (*models.Blob, error) {
+	if err := models.ValidateTags(tags); err != nil {
+		return nil, err
+	}
 
-	// send file to minio server
-	blob, err := repo.storage.UploadBlob(app, private, file)
+	blob, err := repo.GetBlob(account, appName, hash)
 	if err != nil {
 		return nil, err
 	}
 
-	// create file record in the database
-	blob.Filename = body.Filename
-	if err := repo.db.Create(blob).Error; err != nil {
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
 		return nil, err
 	}
 
+	if err := tx.Where("blob_id = ?", blob.ID).Delete(&models.BlobTag{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := repo.saveTags(tx, blob.ID, tags); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit tags update, %v", err)
+		return nil, err
+	}
+
+	// keep cached copy in sync, downloads
+	// are served from the cache
+	blob.Tags = tags
+	if err := repo.storage.CacheBlob(blob); err != nil {
+		log.Printf("failed to update cached blob, %v", err)
+	}
+
 	return blob, nil
 }
 
+// saveTags inserts tags of blobId using tx
+func (repo *AppRepository) saveTags(tx *gorm.DB, blobId uint, tags map[string]string) error {
+	for k, v := range tags {
+		if err := tx.Create(&models.BlobTag{BlobId: blobId, Key: k, Value: v}).Error; err != nil {
+			log.Printf("failed to save blob tag, %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadAttributes populates metadata and tags
+// of blobs using two queries
+func (repo *AppRepository) loadAttributes(blobs []*models.Blob) {
+	if len(blobs) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(blobs))
+	index := make(map[uint]*models.Blob, len(blobs))
+	for _, b := range blobs {
+		ids = append(ids, b.ID)
+		index[b.ID] = b
+		b.Metadata = make(map[string]string)
+		b.Tags = make(map[string]string)
+	}
+
+	metadata := make([]*models.BlobMetadata, 0)
+	if err := repo.db.Where("blob_id IN (?)", ids).Find(&metadata).Error; err != nil {
+		log.Printf("failed to load blobs metadata, %v", err)
+	}
+	for _, m := range metadata {
+		index[m.BlobId].Metadata[m.Key] = m.Value
+	}
+
+	tags := make([]*models.BlobTag, 0)
+	if err := repo.db.Where("blob_id IN (?)", ids).Find(&tags).Error; err != nil {
+		log.Printf("failed to load blobs tags, %v", err)
+	}
+	for _, t := range tags {
+		index[t.BlobId].Tags[t.Key] = t.Value
+	}
+
+	// thumbnails are derivatives linked by parent hash
+	parents := make(map[string]*models.Blob, len(blobs))
+	hashes := make([]string, 0, len(blobs))
+	for _, b := range blobs {
+		parents[b.Hash] = b
+		hashes = append(hashes, b.Hash)
+	}
+
+	derivatives := make([]*models.Blob, 0)
+	err := repo.db.Table("blobs").Where("parent_hash IN (?) AND variant LIKE ?", hashes, "thumbnail:%").
+		Find(&derivatives).Error
+	if err != nil {
+		log.Printf("failed to load blobs thumbnails, %v", err)
+	}
+	for _, d := range derivatives {
+		parent, ok := parents[d.ParentHash]
+		if !ok || parent.AppId != d.AppId {
+			continue
+		}
+
+		if parent.Thumbnails == nil {
+			parent.Thumbnails = make(map[string]string)
+		}
+		parent.Thumbnails[d.ThumbnailName()] = d.DownloadURL
+	}
+}
+
 // GetAccountApps fetch apps created by accountId
 func (repo *AppRepository) GetAccountApps(accountId uint) []*models.App {
 	data := make([]*models.App, 0)
@@ -128,6 +551,10 @@ func (repo *AppRepository) DownloadBlob(appName, hash string) (io.Reader, *model
 		return nil, nil, err
 	}
 
+	if err := repo.checkScan(&blob); err != nil {
+		return nil, nil, err
+	}
+
 	// get file from minio server
 	file, err := repo.storage.GetFile(appName, hash)
 	if err != nil {
@@ -164,14 +591,21 @@ func (repo *AppRepository) GetAppByAttr(attr string, value interface{}) *models.
 }
 
 // GetAppBlobs returns all files that belongs
-// to appId, paginated. A single call returns 20 items
-func (repo *AppRepository) GetAppBlobs(appId uint, page int64) []*models.Blob {
+// to appId, paginated. A single call returns repo.pageSize items.
+// Only blobs matching filter are returned
+func (repo *AppRepository) GetAppBlobs(appId uint, page int64, filter *BlobFilter) []*models.Blob {
 	data := make([]*models.Blob, 0)
-	err := repo.db.Table("blobs").Where("app_id = ?", appId).Offset(page * Limit).Limit(Limit).
-		Find(&data).Error
+	query := repo.db.Table("blobs").Where("app_id = ? AND noncurrent = ? AND is_delete_marker = ? AND parent_hash = ''",
+		appId, false, false)
+	if filter != nil {
+		query = filter.apply(query)
+	}
+
+	err := query.Order("id").Offset(page * repo.pageSize).Limit(repo.pageSize).Find(&data).Error
 	if err != nil {
 		return nil
 	}
 
+	repo.loadAttributes(data)
 	return data
 }
@@ -0,0 +1,137 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// maxArchiveEntries is the maximum number of blobs of an archive
+var maxArchiveEntries = 10000
+
+// ErrPrivateBlob is returned when private blobs are
+// requested without the private key of their app
+var ErrPrivateBlob = errors.New("private blobs can only be downloaded with the private key of their app")
+
+// ErrArchiveTooLarge is returned when the blobs of an archive
+// are larger than the archive size limit
+var ErrArchiveTooLarge = errors.New("archive is larger than the size limit")
+
+// ArchiveRequest selects the blobs of an app to download as an archive
+type ArchiveRequest struct {
+	Hashes []string // blobs identified by hash
+	Prefix string   // current versions of keys starting with Prefix
+
+	// Account is the account authenticated with its private key,
+	// 0 for anonymous callers. Private blobs and prefixes can
+	// only be archived by the account owning the app
+	Account uint
+}
+
+// GetArchiveBlobs returns the blobs of appName selected by req.
+// Downloading a prefix requires the private key of the app
+func (repo *AppRepository) GetArchiveBlobs(appName string, req *ArchiveRequest) ([]*models.Blob, error) {
+	app := repo.GetAppByAttr("name", appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	private := req.Account != 0 && req.Account == app.AccountId
+	if len(req.Hashes) == 0 && req.Prefix == "" && !private {
+		return nil, errors.New("hashes or prefix are required")
+	}
+
+	if len(req.Hashes) > maxArchiveEntries {
+		return nil, fmt.Errorf("an archive can not have more than %d blobs", maxArchiveEntries)
+	}
+
+	query := repo.db.Table("blobs").Where("app_id = ? AND is_delete_marker = ? AND parent_hash = ''", app.ID, false)
+	if len(req.Hashes) > 0 {
+		query = query.Where("hash IN (?)", req.Hashes)
+	} else {
+		if !private {
+			return nil, errors.New("archiving a prefix requires the private key of the app")
+		}
+
+		query = query.Where("noncurrent = ? AND key LIKE ?", false, escapeLike(req.Prefix)+"%")
+	}
+
+	blobs := make([]*models.Blob, 0)
+	if err := query.Order("id").Limit(maxArchiveEntries + 1).Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	if len(blobs) == 0 {
+		return nil, ErrBlobNotFound
+	}
+
+	if len(blobs) > maxArchiveEntries {
+		return nil, fmt.Errorf("an archive can not have more than %d blobs", maxArchiveEntries)
+	}
+
+	var size int64
+	for _, b := range blobs {
+		if b.IsPrivate && !private {
+			return nil, ErrPrivateBlob
+		}
+
+		if err := repo.checkScan(b); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Hash, err)
+		}
+
+		size += b.Size
+	}
+
+	if size > repo.maxArchiveSize {
+		return nil, ErrArchiveTooLarge
+	}
+
+	return blobs, nil
+}
+
+// WriteArchive streams the stored objects of blobs into archive.
+// Entries are named after blob filenames. Writing stops as soon as
+// ctx is done, e.g when the client disconnects
+func (repo *AppRepository) WriteArchive(ctx context.Context, archive services.ArchiveWriter, blobs []*models.Blob) error {
+	filenames := make([]string, len(blobs))
+	for i, b := range blobs {
+		filenames[i] = b.Filename
+		if filenames[i] == "" {
+			filenames[i] = b.Hash
+		}
+	}
+
+	names := services.ArchiveNames(filenames)
+	for i, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		file, err := repo.storage.GetFile(b.AppName, b.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = archive.Add(names[i], b.ContentType, b.Size, b.CreatedAt, file)
+		if closer, ok := file.(io.Closer); ok {
+			closer.Close()
+		}
+
+		if err != nil {
+			log.Printf("failed to archive blob %s, %v", b.Hash, err)
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// escapeLike escapes LIKE wildcards of s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,89 @@
+package repos
+
+import (
+	"blober.io/models"
+	"github.com/jinzhu/gorm"
+	"log"
+	"strings"
+)
+
+// loadBlob loads the downloadable blob hash of appName, the database is
+// the source of truth of the blob cache. It returns nil when there is none
+func (repo *AppRepository) loadBlob(appName, hash string) (*models.Blob, error) {
+	// cache keys ignore the case of app names
+	app := repo.GetAppByAttr("LOWER(name)", strings.ToLower(appName))
+	if app == nil {
+		return nil, nil
+	}
+
+	blob := &models.Blob{}
+	err := repo.db.Where("app_id = ? AND hash = ? AND is_delete_marker = ?", app.ID, hash, false).First(blob).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	repo.loadAttributes([]*models.Blob{blob})
+	blob.AppName = app.Name
+	return blob, nil
+}
+
+// uncacheBlobs removes blobs of app identified by hashes from the
+// cache, they are loaded again from the database when downloaded
+func (repo *AppRepository) uncacheBlobs(app *models.App, hashes []string) {
+	for _, hash := range hashes {
+		if err := repo.storage.UncacheBlob(&models.Blob{AppName: app.Name, Hash: hash}); err != nil {
+			log.Printf("failed to remove cached blob %s, %v", hash, err)
+		}
+	}
+}
+
+// WarmCache caches the n most recently created downloadable blobs,
+// so the first downloads after a start are served from the cache.
+// It returns the number of cached blobs
+func (repo *AppRepository) WarmCache(n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	// the id of the nth most recent blob, older ones are skipped
+	ids := make([]uint, 0, 1)
+	err := repo.db.Table("blobs").Where("deleted_at IS NULL AND is_delete_marker = ?", false).
+		Order("id desc").Offset(n-1).Limit(1).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var from uint
+	if len(ids) > 0 {
+		from = ids[0]
+	}
+
+	apps, err := repo.allApps()
+	if err != nil {
+		return 0, err
+	}
+
+	names := make(map[uint]string, len(apps))
+	for _, app := range apps {
+		names[app.ID] = app.Name
+	}
+
+	cached := 0
+	query := repo.db.Where("id >= ? AND is_delete_marker = ?", from, false)
+	err = repo.eachBlobBatch(query, func(blobs []*models.Blob) error {
+		repo.loadAttributes(blobs)
+		for _, b := range blobs {
+			b.AppName = names[b.AppId]
+			if err := repo.storage.CacheBlob(b); err != nil {
+				return err
+			}
+			cached++
+		}
+		return nil
+	})
+
+	return cached, err
+}
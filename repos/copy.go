@@ -0,0 +1,139 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"log"
+)
+
+// CopyOptions holds the destination and overrides
+// of a blob copy or move
+type CopyOptions struct {
+	TargetApp string            `json:"target_app"`
+	Key       string            `json:"key"`      // defaults to the source key
+	Private   *bool             `json:"private"`  // defaults to the source private flag
+	Metadata  map[string]string `json:"metadata"` // replaces source metadata when set
+	Tags      map[string]string `json:"tags"`     // replaces source tags when set
+}
+
+// CopyBlob copies a blob of appName into another app owned
+// by the same account. The object is copied by the storage server,
+// the copy gets its own hash, record and cache entry
+func (repo *AppRepository) CopyBlob(account uint, appName, hash string, opt *CopyOptions) (*models.Blob, error) {
+	source, err := repo.GetBlob(account, appName, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.IsDeleteMarker {
+		return nil, ErrBlobNotFound
+	}
+
+	if source.ScanStatus == models.ScanInfected {
+		return nil, ErrBlobInfected
+	}
+
+	// the copy of a held image would not be held by its app
+	if source.GPSPending {
+		return nil, ErrGPSPending
+	}
+
+	// callers can only copy into their own apps
+	target := repo.GetAppByName(account, opt.TargetApp)
+	if target == nil {
+		return nil, errors.New("target app not found")
+	}
+
+	if opt.Metadata != nil {
+		if err := models.ValidateMetadata(opt.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Tags != nil {
+		if err := models.ValidateTags(opt.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	var copied *models.Blob
+	intent := models.NewUploadIntent(target)
+	err = repo.runIntent(intent, func() error {
+		copied, err = repo.storage.CopyBlob(source, target, intent.Hash)
+		if err != nil {
+			log.Printf("failed to copy blob %s to app %s, %v", hash, target.Name, err)
+			return err
+		}
+
+		return repo.saveCopy(source, target, copied, opt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repo.processBlob(target, copied)
+	return copied, nil
+}
+
+// saveCopy applies the overrides of opt to copied, a copy
+// of source stored in target, then saves it
+func (repo *AppRepository) saveCopy(source *models.Blob, target *models.App, copied *models.Blob, opt *CopyOptions) error {
+	if opt.Key != "" {
+		copied.Key = opt.Key
+	}
+
+	if opt.Private != nil {
+		copied.IsPrivate = *opt.Private
+	}
+
+	if opt.Metadata != nil {
+		copied.Metadata = opt.Metadata
+	}
+
+	if opt.Tags != nil {
+		copied.Tags = opt.Tags
+	}
+
+	if target.Versioning {
+		copied.VersionId = copied.Hash
+	}
+
+	// copies are scanned again, unless the source was found clean
+	copied.ScanStatus, copied.ScanSignature, copied.ScannedAt = source.ScanStatus, source.ScanSignature, source.ScannedAt
+	if repo.scanner != nil && source.ScanStatus != models.ScanClean {
+		copied.ScanStatus = models.ScanPending
+	}
+	repo.holdForGPS(target, copied)
+	return repo.saveBlob(target, copied)
+}
+
+// MoveBlob copies a blob into another app then removes
+// the source. In versioned apps the source key gets a delete marker
+func (repo *AppRepository) MoveBlob(account uint, appName, hash string, opt *CopyOptions) (*models.Blob, error) {
+	if opt.TargetApp == appName {
+		return nil, errors.New("a blob can not be moved into its own app")
+	}
+
+	copied, err := repo.CopyBlob(account, appName, hash, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := repo.GetBlob(account, appName, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.App.Versioning && !source.Noncurrent {
+		err = repo.saveBlob(source.App, models.NewDeleteMarker(source.App, source.Key))
+	} else {
+		err = repo.purgeBlob(source)
+	}
+
+	if err != nil {
+		log.Printf("failed to remove moved blob %s, %v", hash, err)
+		return nil, err
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,198 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+)
+
+// keyedMutex hands out a lock per key
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks key and returns its unlock func
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// maxDerivatives is the maximum number of transformed
+// images generated on request from a single blob
+var maxDerivatives = 20
+
+// ErrTooManyDerivatives is returned when a new transformation is
+// requested on a blob that already has maxDerivatives derivatives
+var ErrTooManyDerivatives = fmt.Errorf("a blob can not have more than %d transformed images", maxDerivatives)
+
+// DownloadTransformed downloads the image derived from the blob
+// identified by hash using t. The derived image is generated and
+// stored as a derivative blob on first request, later requests are
+// served from storage. account is the account authenticated with
+// its private key, 0 for anonymous callers. Private blobs can only
+// be transformed by the account owning the app
+func (repo *AppRepository) DownloadTransformed(appName, hash string, t *services.Transformation,
+	account uint) (io.Reader, *models.Blob, error) {
+	parent, err := repo.storage.GetBlob(appName, hash)
+	if err != nil {
+		log.Printf("failed to get blob, %v", err)
+		return nil, nil, err
+	}
+
+	app := repo.GetAppByAttr("name", appName)
+	if app == nil {
+		return nil, nil, ErrBlobNotFound
+	}
+
+	if parent.IsPrivate && (account == 0 || account != app.AccountId) {
+		return nil, nil, ErrPrivateBlob
+	}
+
+	if !services.IsTransformable(parent.ContentType) {
+		return nil, nil, services.ErrNotAnImage
+	}
+
+	if err := repo.checkScan(&parent); err != nil {
+		return nil, nil, err
+	}
+
+	derivativeHash := t.DerivativeHash(hash)
+	unlock := repo.derivativeLocks.lock(derivativeHash)
+	defer unlock()
+
+	if _, err := repo.storage.GetBlob(appName, derivativeHash); err == nil {
+		return repo.DownloadBlob(appName, derivativeHash)
+	}
+
+	// generated before, but missing from the cache
+	existing := &models.Blob{}
+	err = repo.db.Table("blobs").Where("app_id = ? AND hash = ?", app.ID, derivativeHash).First(existing).Error
+	if err == nil {
+		if err := repo.storage.CacheBlob(existing); err != nil {
+			log.Printf("failed to cache derivative, %v", err)
+		}
+		return repo.DownloadBlob(appName, derivativeHash)
+	}
+
+	// every transformation is stored, bound them
+	// so a blob can not be used to fill storage
+	var count int
+	err = repo.db.Table("blobs").Where("app_id = ? AND parent_hash = ? AND deleted_at IS NULL", app.ID, hash).
+		Count(&count).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if count >= maxDerivatives+len(app.Thumbnails()) {
+		return nil, nil, ErrTooManyDerivatives
+	}
+
+	derivative, data, err := repo.createDerivative(app, &parent, derivativeHash, t.String(), t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bytes.NewReader(data), derivative, nil
+}
+
+// createDerivative transforms parent using t and saves the
+// result as a derivative blob identified by hash
+func (repo *AppRepository) createDerivative(app *models.App, parent *models.Blob, hash, variant string,
+	t *services.Transformation) (*models.Blob, []byte, error) {
+	if parent.Size > services.MaxSourceSize {
+		return nil, nil, services.ErrSourceTooLarge
+	}
+
+	file, err := repo.storage.GetFile(parent.AppName, parent.Hash)
+	if err != nil {
+		log.Printf("failed to get file from minio, %v", err)
+		return nil, nil, err
+	}
+
+	data, contentType, err := t.Apply(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var derivative *models.Blob
+	err = repo.runIntent(models.NewIntent(models.IntentUpload, app, hash), func() error {
+		derivative, err = repo.storage.UploadBytes(app, hash, data, contentType)
+		if err != nil {
+			log.Printf("failed to store derivative, %v", err)
+			return err
+		}
+
+		derivative.ParentHash = parent.Hash
+		derivative.Variant = variant
+		derivative.IsPrivate = parent.IsPrivate
+		derivative.ScanStatus, derivative.ScanSignature, derivative.ScannedAt = parent.ScanStatus, parent.ScanSignature, parent.ScannedAt
+		derivative.Filename = derivativeFilename(parent.Filename, contentType)
+		return repo.saveBlob(app, derivative)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return derivative, data, nil
+}
+
+// purgeDerivatives permanently removes derivatives of blob
+func (repo *AppRepository) purgeDerivatives(blob *models.Blob) {
+	if blob.ParentHash != "" || blob.IsDeleteMarker {
+		return
+	}
+
+	derivatives := make([]*models.Blob, 0)
+	err := repo.db.Unscoped().Table("blobs").Where("app_id = ? AND parent_hash = ?", blob.AppId, blob.Hash).
+		Find(&derivatives).Error
+	if err != nil {
+		log.Printf("failed to load derivatives of %s, %v", blob.Hash, err)
+		return
+	}
+
+	for _, d := range derivatives {
+		if err := repo.purgeBlob(d); err != nil {
+			log.Printf("failed to purge derivative %s, %v", d.Hash, err)
+		}
+	}
+}
+
+// derivativeFilename replaces the extension of
+// filename with one matching contentType
+func derivativeFilename(filename, contentType string) string {
+	ext := "." + strings.TrimPrefix(contentType, "image/")
+	return strings.TrimSuffix(filename, path.Ext(filename)) + ext
+}
@@ -0,0 +1,61 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"errors"
+)
+
+// eventHistory is the number of events of an app
+// kept for activity streams to resume from
+var eventHistory = 1000
+
+// UploadProgress reports bytes received of an upload in progress
+type UploadProgress struct {
+	UploadId string `json:"upload_id"`
+	Received int64  `json:"received"`
+	Total    int64  `json:"total"` // -1 when unknown
+	Done     bool   `json:"done"`
+}
+
+// BlobProcessed reports the result of a post upload processor
+type BlobProcessed struct {
+	Hash  string `json:"hash"`
+	Kind  string `json:"kind"`
+	Error string `json:"error,omitempty"`
+}
+
+// Subscribe subscribes to the activity of appName. Events published
+// after lastSeq that are still kept are returned for replay
+func (repo *AppRepository) Subscribe(account uint, appName string, lastSeq uint64) (*services.Subscription, []*models.Event, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, nil, errors.New("app not found")
+	}
+
+	s, missed := repo.events.Subscribe(app.ID, lastSeq)
+	return s, missed, nil
+}
+
+// Unsubscribe stops an activity subscription
+func (repo *AppRepository) Unsubscribe(s *services.Subscription) {
+	repo.events.Unsubscribe(s)
+}
+
+// PublishUploadProgress reports progress of an upload to activity
+// streams of app. Progress events are not kept for replay
+func (repo *AppRepository) PublishUploadProgress(app *models.App, progress *UploadProgress) {
+	event := models.NewEvent(models.EventUploadProgress, app, progress)
+	event.Transient = true
+	repo.events.Publish(event)
+}
+
+// publishProcessed reports a post upload processor run to activity streams
+func (repo *AppRepository) publishProcessed(app *models.App, blob *models.Blob, kind string, err error) {
+	data := &BlobProcessed{Hash: blob.Hash, Kind: kind}
+	if err != nil {
+		data.Error = err.Error()
+	}
+
+	repo.events.Publish(models.NewEvent(models.EventBlobProcessed, app, data))
+}
@@ -0,0 +1,131 @@
+package repos
+
+import (
+	"blober.io/models"
+	"log"
+	"time"
+)
+
+// ExportAccounts exports every account with its apps and their
+// lifecycle rules. Blobs are not exported
+func (repo *AppRepository) ExportAccounts() (*models.Export, error) {
+	accounts := make([]*models.Account, 0)
+	if err := repo.db.Order("id").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	apps, err := repo.allApps()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*models.LifecycleRule, 0)
+	if err := repo.db.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	appRules := make(map[uint][]*models.LifecycleRule)
+	for _, r := range rules {
+		appRules[r.AppId] = append(appRules[r.AppId], r)
+	}
+
+	accountApps := make(map[uint][]*models.ExportedApp)
+	for _, app := range apps {
+		exported := &models.ExportedApp{App: app, LifecycleRules: appRules[app.ID]}
+		if exported.LifecycleRules == nil {
+			exported.LifecycleRules = make([]*models.LifecycleRule, 0)
+		}
+		accountApps[app.AccountId] = append(accountApps[app.AccountId], exported)
+	}
+
+	export := &models.Export{Version: models.ExportVersion, ExportedAt: time.Now(),
+		Accounts: make([]*models.ExportedAccount, 0, len(accounts))}
+	for _, a := range accounts {
+		exported := &models.ExportedAccount{Account: a, Apps: accountApps[a.ID]}
+		if exported.Apps == nil {
+			exported.Apps = make([]*models.ExportedApp, 0)
+		}
+		export.Accounts = append(export.Accounts, exported)
+	}
+
+	return export, nil
+}
+
+// ImportAccounts creates the accounts and apps of an export, apps get
+// their bucket back. Accounts whose email is taken are kept as they are
+// but their exported apps are imported, apps whose name is taken are skipped
+func (repo *AppRepository) ImportAccounts(export *models.Export) (*models.ImportReport, error) {
+	if err := export.Validate(); err != nil {
+		return nil, err
+	}
+
+	report := &models.ImportReport{Skipped: make([]string, 0)}
+	for _, exported := range export.Accounts {
+		account := repo.account.GetAccountByAttr("email", exported.Email)
+		if account != nil {
+			report.Skipped = append(report.Skipped, "account "+exported.Email)
+		} else {
+			// passwords are exported hashed, they are stored as they are
+			account = &models.Account{FirstName: exported.FirstName, LastName: exported.LastName,
+				Email: exported.Email, Password: exported.Password, IsAdmin: exported.IsAdmin}
+			account.CreatedAt = exported.CreatedAt
+			if err := repo.db.Create(account).Error; err != nil {
+				return report, err
+			}
+			report.Accounts++
+		}
+
+		for _, a := range exported.Apps {
+			if repo.GetAppByAttr("name", a.Name) != nil {
+				report.Skipped = append(report.Skipped, "app "+a.Name)
+				continue
+			}
+
+			if err := repo.importApp(account, a); err != nil {
+				return report, err
+			}
+			report.Apps++
+		}
+	}
+
+	return report, nil
+}
+
+// importApp creates an exported app of account
+func (repo *AppRepository) importApp(account *models.Account, exported *models.ExportedApp) error {
+	app := *exported.App
+	app.ID, app.DeletedAt, app.AccountId, app.Account = 0, nil, account.ID, nil
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	if err := tx.Create(&app).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range exported.LifecycleRules {
+		rule := *r
+		rule.ID, rule.DeletedAt, rule.AppId = 0, nil, app.ID
+		if err := tx.Create(&rule).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// importing next to the original storage finds the bucket
+	// in place, its objects are orphans until blobs are restored
+	exists, err := repo.storage.BucketExists(&app)
+	if err == nil && !exists {
+		err = repo.storage.CreateBucketForApp(&app)
+	}
+	if err != nil {
+		log.Printf("failed to create bucket of imported app %s, %v", app.Name, err)
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
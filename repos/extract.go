@@ -0,0 +1,170 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// JobExtract is the kind of jobs extracting metadata from blobs
+const JobExtract = "extract"
+
+// ErrGPSPending is returned when an image is downloaded
+// before its GPS position has been removed
+var ErrGPSPending = errors.New("blob is being processed, retry later")
+
+// SetStripGPS sets whether GPS position is removed
+// from images uploaded to appName
+func (repo *AppRepository) SetStripGPS(account uint, appName string, strip bool) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := repo.db.Model(app).UpdateColumn("strip_gps", strip).Error; err != nil {
+		return nil, err
+	}
+
+	app.StripGPS = strip
+	return app, nil
+}
+
+// extractMetadata reads dimensions, duration, page count and EXIF
+// of blob. GPS position is removed from the stored object when
+// app strips it. Content that can not be parsed is left as is
+func (repo *AppRepository) extractMetadata(app *models.App, blob *models.Blob) error {
+	if blob.ParentHash != "" || blob.IsDeleteMarker || blob.Size > services.MaxExtractSize {
+		return nil
+	}
+
+	file, err := repo.storage.GetFile(blob.AppName, blob.Hash)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	extracted, err := services.Extract(blob.ContentType, blob.Filename, data)
+	if err != nil {
+		log.Printf("failed to extract metadata of %s, %v", blob.Hash, err)
+		return repo.releaseGPSHold(blob)
+	}
+
+	if app.StripGPS && extracted.HasGPS {
+		if stripped, ok := services.StripGPS(data); ok {
+			if err := repo.storage.ReplaceObject(blob, stripped); err != nil {
+				return err
+			}
+			delete(extracted.Exif, "GPSLatitude")
+			delete(extracted.Exif, "GPSLongitude")
+		}
+	}
+
+	blob.SetExif(extracted.Exif)
+	columns := map[string]interface{}{
+		"width": extracted.Width, "height": extracted.Height, "duration": extracted.Duration,
+		"page_count": extracted.PageCount, "exif_data": blob.ExifData, "e_tag": blob.ETag,
+		"gps_pending": false,
+	}
+	if err := repo.db.Model(blob).UpdateColumns(columns).Error; err != nil {
+		return err
+	}
+
+	repo.refreshCache(blob)
+	return nil
+}
+
+// holdForGPS keeps blob from being downloaded until the
+// extract job removed its GPS position, when app strips it.
+// Files too large to be extracted are never held
+func (repo *AppRepository) holdForGPS(app *models.App, blob *models.Blob) {
+	blob.GPSPending = app.StripGPS && blob.ParentHash == "" && !blob.IsDeleteMarker &&
+		strings.EqualFold(blob.ContentType, "image/jpeg") && blob.Size <= services.MaxExtractSize
+}
+
+// releaseGPSHold makes a held blob downloadable, its
+// content could not be parsed so there is nothing to remove
+func (repo *AppRepository) releaseGPSHold(blob *models.Blob) error {
+	if !blob.GPSPending {
+		return nil
+	}
+
+	if err := repo.db.Model(blob).UpdateColumn("gps_pending", false).Error; err != nil {
+		return err
+	}
+
+	repo.refreshCache(blob)
+	return nil
+}
+
+// refreshCache caches the saved state of blob. Processors update
+// different columns concurrently, so blob is reloaded first
+func (repo *AppRepository) refreshCache(blob *models.Blob) {
+	fresh := &models.Blob{}
+	if err := repo.db.Table("blobs").Where("id = ?", blob.ID).First(fresh).Error; err != nil {
+		log.Printf("failed to reload blob %s, %v", blob.Hash, err)
+		return
+	}
+
+	repo.loadAttributes([]*models.Blob{fresh})
+	if err := repo.storage.CacheBlob(fresh); err != nil {
+		log.Printf("failed to cache blob, %v", err)
+	}
+}
+
+// BlobFilter narrows down app blob listings. Zero values are ignored
+type BlobFilter struct {
+	Tags        map[string]string // blobs must carry every tag
+	ContentType string            // content type prefix, e.g image/
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	MinDuration float64
+	MaxDuration float64
+	MinPages    int
+	MaxPages    int
+	Exif        map[string]string // EXIF tags, e.g Make=Canon
+}
+
+// apply adds filter conditions to query
+func (filter *BlobFilter) apply(query *gorm.DB) *gorm.DB {
+	for k, v := range filter.Tags {
+		query = query.Where("id IN (SELECT blob_id FROM blob_tags WHERE key = ? AND value = ?)", k, v)
+	}
+
+	if filter.ContentType != "" {
+		query = query.Where("content_type LIKE ?", filter.ContentType+"%")
+	}
+
+	ranges := []struct {
+		column   string
+		min, max float64
+	}{
+		{"width", float64(filter.MinWidth), float64(filter.MaxWidth)},
+		{"height", float64(filter.MinHeight), float64(filter.MaxHeight)},
+		{"duration", filter.MinDuration, filter.MaxDuration},
+		{"page_count", float64(filter.MinPages), float64(filter.MaxPages)},
+	}
+	for _, r := range ranges {
+		if r.min > 0 {
+			query = query.Where(r.column+" >= ?", r.min)
+		}
+		if r.max > 0 {
+			query = query.Where(r.column+" <= ?", r.max)
+		}
+	}
+
+	for k, v := range filter.Exif {
+		query = query.Where("(CASE WHEN exif_data = '' THEN NULL ELSE exif_data::jsonb ->> ? END) = ?", k, v)
+	}
+
+	return query
+}
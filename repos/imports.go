@@ -0,0 +1,213 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+)
+
+// JobImport fetches a single url of an import
+const JobImport = "import"
+
+// importMaxAttempts is the number of times a url is fetched
+// before it is marked failed. Only temporary failures are retried
+var importMaxAttempts = 3
+
+// ImportProgress reports bytes received of an imported url
+type ImportProgress struct {
+	ImportId uint   `json:"import_id"`
+	ItemId   uint   `json:"item_id"`
+	URL      string `json:"url"`
+	Received int64  `json:"received"`
+	Total    int64  `json:"total"` // -1 when unknown
+}
+
+// AllowImportsFrom allows imports and webhook deliveries to internal
+// hosts or networks of allowlist, which are refused by default
+func (repo *AppRepository) AllowImportsFrom(allowlist []string) error {
+	opt := repo.fetchOption
+	opt.Allowlist = allowlist
+	fetcher, err := services.NewFetcher(&opt)
+	if err != nil {
+		return err
+	}
+
+	repo.fetcher = fetcher
+	repo.webhooks = services.NewWebhookSender(fetcher)
+	return nil
+}
+
+// CreateImport queues urls to be fetched into appName,
+// every url is fetched by its own job
+func (repo *AppRepository) CreateImport(account uint, appName string, private bool, urls []*models.ImportURL) (*models.Import, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	im, err := models.NewImport(account, app, private, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(im).Error; err != nil {
+		log.Printf("failed to create import, %v", err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, item := range im.Items {
+		item.ImportId = im.ID
+		if err := tx.Create(item).Error; err != nil {
+			log.Printf("failed to create import item, %v", err)
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit import, %v", err)
+		return nil, err
+	}
+
+	for _, item := range im.Items {
+		job := models.NewJob(JobImport, app.ID, 0, strconv.FormatUint(uint64(item.ID), 10))
+		job.MaxAttempts = importMaxAttempts
+		if err := repo.queue.Enqueue(job); err != nil {
+			log.Printf("failed to enqueue import of %s, %v", item.URL, err)
+		}
+	}
+
+	return im, nil
+}
+
+// GetImport returns an import of appName with the progress of its urls
+func (repo *AppRepository) GetImport(account uint, appName string, id uint) (*models.Import, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	im := &models.Import{}
+	if err := repo.db.Where("id = ? AND app_id = ?", id, app.ID).First(im).Error; err != nil {
+		return nil, errors.New("import not found")
+	}
+
+	if err := repo.db.Where("import_id = ?", im.ID).Order("id").Find(&im.Items).Error; err != nil {
+		return nil, err
+	}
+
+	im.Summarize()
+	return im, nil
+}
+
+// importURL is the JobImport handler, it fetches an
+// import item and stores it as a blob of the import's app
+func (repo *AppRepository) importURL(job *models.Job) error {
+	item := &models.ImportItem{}
+	if err := repo.db.Where("id = ?", job.Payload).First(item).Error; err != nil {
+		log.Printf("skipping import job %d, item %s not found", job.ID, job.Payload)
+		return nil
+	}
+
+	if item.Status == models.ImportDone {
+		return nil
+	}
+
+	im := &models.Import{}
+	if err := repo.db.Where("id = ?", item.ImportId).First(im).Error; err != nil {
+		return nil
+	}
+
+	app := repo.GetAppByAttr("id", im.AppId)
+	if app == nil {
+		repo.updateImportItem(item, map[string]interface{}{"status": models.ImportFailed, "error": "app not found"})
+		return nil
+	}
+
+	repo.updateImportItem(item, map[string]interface{}{"status": models.ImportRunning, "error": "", "received": 0})
+	blob, err := repo.fetchImportItem(app, im, item)
+	if err != nil {
+		log.Printf("failed to import %s, %v", item.URL, err)
+		if !temporaryImportError(err) {
+			repo.updateImportItem(item, map[string]interface{}{"status": models.ImportFailed, "error": err.Error()})
+			return nil
+		}
+
+		// retried with backoff, until the job runs out of attempts
+		status := models.ImportPending
+		if job.Attempts >= job.MaxAttempts {
+			status = models.ImportFailed
+		}
+		repo.updateImportItem(item, map[string]interface{}{"status": status, "error": err.Error()})
+		return err
+	}
+
+	repo.updateImportItem(item, map[string]interface{}{"status": models.ImportDone, "blob_hash": blob.Hash})
+	return nil
+}
+
+// fetchImportItem fetches the url of item into app. The fetched
+// file is checked against the upload policy of app like uploads are
+func (repo *AppRepository) fetchImportItem(app *models.App, im *models.Import, item *models.ImportItem) (*models.Blob, error) {
+	file, err := repo.fetcher.Fetch(context.Background(), item.URL, func(received, total int64) {
+		repo.updateImportItem(item, map[string]interface{}{"received": received, "size": total})
+
+		event := models.NewEvent(models.EventImportProgress, app,
+			&ImportProgress{ImportId: im.ID, ItemId: item.ID, URL: item.URL, Received: received, Total: total})
+		event.Transient = true
+		repo.events.Publish(event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// objects are stored with their size, bodies
+	// without Content-Length are counted first
+	if err := file.Spool(); err != nil {
+		return nil, err
+	}
+
+	// the policy is checked before anything is stored
+	r := bufio.NewReader(file.Body)
+	filename, err := app.UploadPolicy().Check(file.Filename, file.Size, services.SniffContentType(r))
+	if err != nil {
+		return nil, err
+	}
+
+	key := item.Key
+	if key == "" {
+		key = filename
+	}
+
+	return repo.storeBlob(app, &UploadOptions{Private: im.Private}, filename, key, r, file.Size)
+}
+
+func (repo *AppRepository) updateImportItem(item *models.ImportItem, columns map[string]interface{}) {
+	if err := repo.db.Model(item).UpdateColumns(columns).Error; err != nil {
+		log.Printf("failed to update import item %d, %v", item.ID, err)
+	}
+}
+
+// temporaryImportError reports whether fetching
+// again may succeed after err, e.g network errors
+func temporaryImportError(err error) bool {
+	switch e := err.(type) {
+	case *services.FetchError:
+		return e.Temporary()
+	case *models.PolicyViolation:
+		return false
+	}
+
+	return err != services.ErrBlockedAddress && err != services.ErrFetchTooLarge
+}
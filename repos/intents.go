@@ -0,0 +1,177 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"log"
+	"time"
+)
+
+// intentGrace is how long an operation may take, younger
+// intents may belong to operations running on other replicas
+var intentGrace = 10 * time.Minute
+
+// beginIntent records intent before its operation starts
+func (repo *AppRepository) beginIntent(intent *models.Intent) error {
+	if err := repo.db.Create(intent).Error; err != nil {
+		log.Printf("failed to record %s intent, %v", intent.Kind, err)
+		return err
+	}
+
+	return nil
+}
+
+// endIntent removes intent once its operation completed or was
+// undone. An intent failing to be removed is resolved again later
+func (repo *AppRepository) endIntent(intent *models.Intent) {
+	if err := repo.db.Unscoped().Delete(intent).Error; err != nil {
+		log.Printf("failed to remove %s intent %d, %v", intent.Kind, intent.ID, err)
+	}
+}
+
+// runIntent records intent and runs its operation. A failed
+// operation is undone at once, or by recovery if undoing fails too
+func (repo *AppRepository) runIntent(intent *models.Intent, operation func() error) error {
+	if err := repo.beginIntent(intent); err != nil {
+		return err
+	}
+
+	if err := operation(); err != nil {
+		if _, rerr := repo.resolveIntent(intent); rerr != nil {
+			log.Printf("failed to undo %s %s, %v", intent.Kind, intent.Hash, rerr)
+			return err
+		}
+		repo.endIntent(intent)
+		return err
+	}
+
+	repo.endIntent(intent)
+	return nil
+}
+
+// resolveIntent completes the operation of an unfinished intent
+// when its database write was committed, or undoes what it stored
+// otherwise. It reports whether the operation was rolled forward
+func (repo *AppRepository) resolveIntent(intent *models.Intent) (bool, error) {
+	app := intent.App()
+	switch intent.Kind {
+	case models.IntentUpload:
+		blob := repo.storedBlob(app, intent.Hash)
+		if blob == nil {
+			return false, repo.storage.RemoveObject(app, intent.Hash)
+		}
+
+		if blob.DeletedAt == nil {
+			repo.loadAttributes([]*models.Blob{blob})
+			blob.AppName = app.Name
+			return true, repo.storage.CacheBlob(blob)
+		}
+		return true, nil
+	case models.IntentCreateApp:
+		exists, err := repo.storage.BucketExists(app)
+		if err != nil {
+			return false, err
+		}
+
+		if repo.GetAppByAttr("name", app.Name) == nil {
+			if exists {
+				return false, repo.storage.RemoveBucketForApp(app)
+			}
+			return false, nil
+		}
+
+		if !exists {
+			return true, repo.storage.CreateBucketForApp(app)
+		}
+		return true, nil
+	case models.IntentDeleteBlob:
+		if repo.storedBlob(app, intent.Hash) != nil {
+			return false, nil
+		}
+
+		blob := &models.Blob{Hash: intent.Hash, AppName: app.Name}
+		return true, repo.storage.RemoveBlob(blob)
+	case models.IntentDeleteApp:
+		if repo.GetAppByAttr("id", app.ID) != nil {
+			return false, nil
+		}
+
+		exists, err := repo.storage.BucketExists(app)
+		if err != nil || !exists {
+			return true, err
+		}
+		return true, repo.storage.RemoveBucketForApp(app)
+	}
+
+	return false, errors.New("unknown intent " + intent.Kind)
+}
+
+// RecoverIntents resolves intents left unfinished by a crash. Intents
+// younger than intentGrace are skipped, their operation may still be
+// running on another replica. Intents failing to be resolved are kept
+// for the next run. It returns the number of operations rolled forward
+// and back
+func (repo *AppRepository) RecoverIntents() (forward, back int, err error) {
+	intents := make([]*models.Intent, 0)
+	err = repo.db.Where("created_at < ?", time.Now().Add(-intentGrace)).Order("id").Find(&intents).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, intent := range intents {
+		rolledForward, err := repo.resolveIntent(intent)
+		if err != nil {
+			log.Printf("failed to recover %s intent %d of %s, %v", intent.Kind, intent.ID, intent.AppName, err)
+			continue
+		}
+
+		if rolledForward {
+			forward++
+		} else {
+			back++
+		}
+		repo.endIntent(intent)
+	}
+
+	return forward, back, nil
+}
+
+// IntentRecoverer periodically resolves unfinished intents
+type IntentRecoverer struct {
+	repo *AppRepository
+	done chan struct{}
+}
+
+// NewIntentRecoverer creates a new IntentRecoverer
+func NewIntentRecoverer(repo *AppRepository) *IntentRecoverer {
+	return &IntentRecoverer{repo: repo, done: make(chan struct{})}
+}
+
+// Start resolves unfinished intents every intentGrace,
+// once they are old enough. It blocks until Stop
+func (r *IntentRecoverer) Start() {
+	ticker := time.NewTicker(intentGrace)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			forward, back, err := r.repo.RecoverIntents()
+			if err != nil {
+				log.Printf("failed to recover unfinished operations, %v", err)
+				continue
+			}
+
+			if forward+back > 0 {
+				log.Printf("recovered unfinished operations, %d rolled forward, %d rolled back", forward, back)
+			}
+		}
+	}
+}
+
+// Stop stops the recoverer
+func (r *IntentRecoverer) Stop() {
+	close(r.done)
+}
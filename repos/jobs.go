@@ -0,0 +1,107 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"log"
+)
+
+// ProcessorFunc processes a newly created blob of app
+type ProcessorFunc func(app *models.App, blob *models.Blob) error
+
+// RegisterProcessor registers fn as a post upload processor.
+// fn runs as a queued job of kind for every created blob,
+// failed runs are retried with backoff
+func (repo *AppRepository) RegisterProcessor(kind string, fn ProcessorFunc) {
+	repo.processors = append(repo.processors, kind)
+	repo.queue.Register(kind, func(job *models.Job) error {
+		blob := &models.Blob{}
+		if err := repo.db.Table("blobs").Where("id = ?", job.BlobId).First(blob).Error; err != nil {
+			// blob was removed before it was processed
+			log.Printf("skipping %s job %d, blob %d not found", job.Kind, job.ID, job.BlobId)
+			return nil
+		}
+
+		app := repo.GetAppByAttr("id", blob.AppId)
+		if app == nil {
+			return nil
+		}
+
+		repo.loadAttributes([]*models.Blob{blob})
+		blob.App = app
+		err := fn(app, blob)
+		repo.publishProcessed(app, blob, kind, err)
+		return err
+	})
+}
+
+// processBlob sends blob.created and enqueues a
+// job for every registered post upload processor
+func (repo *AppRepository) processBlob(app *models.App, blob *models.Blob) {
+	if blob.ParentHash != "" || blob.IsDeleteMarker {
+		return
+	}
+
+	repo.notify(app, models.EventBlobCreated, blob)
+	for _, kind := range repo.processors {
+		if err := repo.queue.Enqueue(models.NewJob(kind, app.ID, blob.ID, "")); err != nil {
+			log.Printf("failed to enqueue %s job of %s, %v", kind, blob.Hash, err)
+		}
+	}
+}
+
+// GetBlobJobs returns jobs of a blob, newest first
+func (repo *AppRepository) GetBlobJobs(account uint, appName, hash string) ([]*models.Job, error) {
+	blob, err := repo.GetBlob(account, appName, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*models.Job, 0)
+	if err := repo.db.Where("blob_id = ?", blob.ID).Order("id desc").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// GetAppJobs returns jobs of appName with status,
+// or every job when status is empty. A single call returns 20 items
+func (repo *AppRepository) GetAppJobs(account uint, appName, status string, page int64) ([]*models.Job, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	query := repo.db.Where("app_id = ?", app.ID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	jobs := make([]*models.Job, 0)
+	if err := query.Order("id desc").Offset(page * repo.pageSize).Limit(repo.pageSize).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// RetryJob moves a dead job of appName back to the queue
+func (repo *AppRepository) RetryJob(account uint, appName string, id uint) (*models.Job, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	job := &models.Job{}
+	if err := repo.db.Where("id = ? AND app_id = ?", id, app.ID).First(job).Error; err != nil {
+		return nil, errors.New("job not found")
+	}
+
+	if err := repo.queue.Retry(job); err != nil {
+		return nil, err
+	}
+
+	job.Status = models.JobPending
+	return job, nil
+}
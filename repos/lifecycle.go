@@ -0,0 +1,294 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"log"
+	"time"
+)
+
+// GetLifecycleRules returns lifecycle rules of appName
+func (repo *AppRepository) GetLifecycleRules(account uint, appName string) ([]*models.LifecycleRule, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	return repo.lifecycleRules(app.ID, false)
+}
+
+// SetLifecycleRules replaces lifecycle rules of appName
+func (repo *AppRepository) SetLifecycleRules(account uint, appName string, rules []*models.LifecycleRule) ([]*models.LifecycleRule, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Unscoped().Where("app_id = ?", app.ID).Delete(&models.LifecycleRule{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// a run of the previous rules is not resumed, its
+	// cursor would skip blobs the new rules apply to
+	if err := repo.finishLifecycleRuns(tx, app.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		rule.ID = 0
+		rule.AppId = app.ID
+		if err := tx.Create(rule).Error; err != nil {
+			log.Printf("failed to create lifecycle rule, %v", err)
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit lifecycle rules, %v", err)
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// RunLifecycle applies lifecycle rules of appName now.
+// Nothing is removed in dryRun mode, the report lists what would be
+func (repo *AppRepository) RunLifecycle(account uint, appName string, dryRun bool) (*models.LifecycleReport, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	return repo.ApplyLifecycle(app, dryRun)
+}
+
+// ApplyLifecycle applies lifecycle rules of app to its blobs.
+// Progress of a real run is saved after every batch, an
+// unfinished run is resumed from where it stopped
+func (repo *AppRepository) ApplyLifecycle(app *models.App, dryRun bool) (*models.LifecycleReport, error) {
+	repo.lifecycleMu.Lock()
+	defer repo.lifecycleMu.Unlock()
+
+	rules, err := repo.lifecycleRules(app.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.LifecycleReport{AppId: app.ID, DryRun: dryRun,
+		Actions: make([]*models.LifecycleAction, 0), Errors: make([]string, 0)}
+	if len(rules) == 0 {
+		if !dryRun {
+			return report, repo.finishLifecycleRuns(repo.db, app.ID)
+		}
+		return report, nil
+	}
+
+	var run *models.LifecycleRun
+	if !dryRun {
+		run = &models.LifecycleRun{}
+		err := repo.db.Where("app_id = ? AND finished = ?", app.ID, false).Order("id desc").First(run).Error
+		if err != nil {
+			run = &models.LifecycleRun{AppId: app.ID}
+			if err := repo.db.Create(run).Error; err != nil {
+				log.Printf("failed to create lifecycle run, %v", err)
+				return nil, err
+			}
+		} else {
+			log.Printf("resuming lifecycle run %d of app %s from blob %d", run.ID, app.Name, run.Cursor)
+		}
+	}
+
+	var cursor uint
+	if run != nil {
+		cursor = run.Cursor
+	}
+
+	now := time.Now()
+	for {
+		batch := make([]*models.Blob, 0)
+		// derivatives are removed along with their original
+		err := repo.db.Table("blobs").Where("app_id = ? AND id > ? AND parent_hash = ''", app.ID, cursor).Order("id").
+			Limit(repo.blobBatch).Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		repo.loadAttributes(batch)
+		for _, blob := range batch {
+			for _, rule := range rules {
+				action := rule.Action(blob, now)
+				if action == "" {
+					continue
+				}
+
+				report.Actions = append(report.Actions, &models.LifecycleAction{
+					Rule: rule.Name, Action: action, Hash: blob.Hash, Key: blob.Key,
+					VersionId: blob.VersionId, Size: blob.Size,
+				})
+
+				if !dryRun {
+					if err := repo.applyLifecycleAction(app, blob, action); err != nil {
+						report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", blob.Hash, err))
+					}
+				}
+				break
+			}
+		}
+
+		cursor = batch[len(batch)-1].ID
+		if run != nil {
+			if err := repo.db.Model(run).UpdateColumn("cursor", cursor).Error; err != nil {
+				log.Printf("failed to save lifecycle run progress, %v", err)
+			}
+		}
+	}
+
+	if run != nil {
+		if err := repo.db.Model(run).UpdateColumn("finished", true).Error; err != nil {
+			log.Printf("failed to finish lifecycle run, %v", err)
+		}
+	}
+
+	return report, nil
+}
+
+// applyLifecycleAction expires or removes blob. Expiring a blob of
+// a versioned app creates a delete marker, otherwise it is trashed
+func (repo *AppRepository) applyLifecycleAction(app *models.App, blob *models.Blob, action string) error {
+	if action == models.LifecycleExpire {
+		if app.Versioning {
+			_, err := repo.markDeleted(app, blob.Key)
+			return err
+		}
+		return repo.trashBlob(app, blob)
+	}
+
+	return repo.purgeBlob(blob)
+}
+
+// finishLifecycleRuns closes unfinished runs of appId
+func (repo *AppRepository) finishLifecycleRuns(db *gorm.DB, appId uint) error {
+	err := db.Model(&models.LifecycleRun{}).Where("app_id = ? AND finished = ?", appId, false).
+		UpdateColumn("finished", true).Error
+	if err != nil {
+		log.Printf("failed to finish lifecycle runs, %v", err)
+	}
+
+	return err
+}
+
+// lifecycleRules returns lifecycle rules of appId
+func (repo *AppRepository) lifecycleRules(appId uint, enabledOnly bool) ([]*models.LifecycleRule, error) {
+	rules := make([]*models.LifecycleRule, 0)
+	query := repo.db.Where("app_id = ?", appId)
+	if enabledOnly {
+		query = query.Where("enabled = ?", true)
+	}
+
+	if err := query.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// LifecycleWorker periodically applies
+// lifecycle rules of every app
+type LifecycleWorker struct {
+	repo *AppRepository
+	done chan struct{}
+}
+
+// NewLifecycleWorker creates a new LifecycleWorker
+func NewLifecycleWorker(repo *AppRepository) *LifecycleWorker {
+	return &LifecycleWorker{repo: repo, done: make(chan struct{})}
+}
+
+// Start resumes runs interrupted by a restart, then applies
+// lifecycle rules every lifecycle interval. It blocks until Stop
+func (w *LifecycleWorker) Start() {
+	w.resume()
+
+	ticker := time.NewTicker(w.repo.lifecycleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.applyAll()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Stop stops the worker, a run in progress is resumed on next start
+func (w *LifecycleWorker) Stop() {
+	close(w.done)
+}
+
+// resume finishes runs left unfinished
+func (w *LifecycleWorker) resume() {
+	runs := make([]*models.LifecycleRun, 0)
+	if err := w.repo.db.Where("finished = ?", false).Find(&runs).Error; err != nil {
+		log.Printf("failed to load unfinished lifecycle runs, %v", err)
+		return
+	}
+
+	for _, run := range runs {
+		w.apply(run.AppId)
+	}
+}
+
+// applyAll applies lifecycle rules of every app that has one
+func (w *LifecycleWorker) applyAll() {
+	ids := make([]uint, 0)
+	err := w.repo.db.Table("lifecycle_rules").Where("enabled = ? AND deleted_at IS NULL", true).
+		Pluck("DISTINCT app_id", &ids).Error
+	if err != nil {
+		log.Printf("failed to load lifecycle rules, %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		w.apply(id)
+	}
+}
+
+func (w *LifecycleWorker) apply(appId uint) {
+	app := w.repo.GetAppByAttr("id", appId)
+	if app == nil {
+		return
+	}
+
+	report, err := w.repo.ApplyLifecycle(app, false)
+	if err != nil {
+		log.Printf("failed to apply lifecycle rules of app %s, %v", app.Name, err)
+		return
+	}
+
+	if len(report.Actions) > 0 {
+		log.Printf("lifecycle: %d blobs of app %s cleaned up, %d errors", len(report.Actions),
+			app.Name, len(report.Errors))
+	}
+}
@@ -0,0 +1,62 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+)
+
+// SetUploadPolicy sets the policy files uploaded to appName must satisfy
+func (repo *AppRepository) SetUploadPolicy(account uint, appName string, policy *models.UploadPolicy) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := app.SetUploadPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	columns := map[string]interface{}{
+		"allowed_types": app.AllowedTypes, "min_size": app.MinSize, "max_size": app.MaxSize,
+		"max_files": app.MaxFiles, "filename_policy": app.FilenamePolicy,
+	}
+	if err := repo.db.Model(app).UpdateColumns(columns).Error; err != nil {
+		log.Printf("failed to update upload policy, %v", err)
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// GetUploadPolicy returns the upload policy of appName
+func (repo *AppRepository) GetUploadPolicy(account uint, appName string) (*models.UploadPolicy, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	return app.UploadPolicy(), nil
+}
+
+// checkUploadPolicy checks an uploaded file against the upload
+// policy of app. The content type is sniffed from the file content,
+// the filename the file should be stored under is returned
+func (repo *AppRepository) checkUploadPolicy(app *models.App, body *multipart.FileHeader) (string, error) {
+	file, err := body.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return app.UploadPolicy().Check(body.Filename, body.Size, http.DetectContentType(head[:n]))
+}
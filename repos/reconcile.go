@@ -0,0 +1,207 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"log"
+	"time"
+)
+
+// errResolved is recorded on issues that were resolved
+// between a verification and their repair
+var errResolved = errors.New("no longer inconsistent")
+
+// SetRepairPolicy sets the issues repaired by
+// reconciliations that don't choose a policy
+func (repo *AppRepository) SetRepairPolicy(policy models.RepairPolicy) {
+	repo.reconcileMu.Lock()
+	defer repo.reconcileMu.Unlock()
+	repo.repairPolicy = policy
+}
+
+// Reconcile verifies the database, the blob cache and object storage
+// then repairs issues selected by policy, or by the repository's
+// policy when nil. Reconciliations are serialized, the report
+// of the last one is kept, see LastReconciliation
+func (repo *AppRepository) Reconcile(policy models.RepairPolicy) (*models.VerifyReport, error) {
+	repo.reconcileMu.Lock()
+	defer repo.reconcileMu.Unlock()
+
+	if policy == nil {
+		policy = repo.repairPolicy
+	}
+
+	report, err := repo.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Repair(report, policy)
+	report.FinishedAt = time.Now()
+
+	repo.reportMu.Lock()
+	repo.lastReport = report
+	repo.reportMu.Unlock()
+	return report, nil
+}
+
+// LastReconciliation returns the report of the last
+// reconciliation, or nil when none has run
+func (repo *AppRepository) LastReconciliation() *models.VerifyReport {
+	repo.reportMu.Lock()
+	defer repo.reportMu.Unlock()
+	return repo.lastReport
+}
+
+// Repair repairs issues of report selected by policy, each issue is checked
+// again first. Repaired issues are marked as such, failed ones record their
+// error. It returns the number of repaired issues
+func (repo *AppRepository) Repair(report *models.VerifyReport, policy models.RepairPolicy) int {
+	apps := make(map[string]*models.App)
+	repaired := 0
+	for _, issue := range report.Issues {
+		if !policy[issue.Kind] || issue.Repaired {
+			continue
+		}
+
+		app, ok := apps[issue.App]
+		if !ok {
+			app = repo.GetAppByAttr("name", issue.App)
+			apps[issue.App] = app
+		}
+
+		if err := repo.repairIssue(app, issue); err != nil {
+			issue.Error = err.Error()
+			if err != errResolved {
+				log.Printf("failed to repair %s %s of %s, %v", issue.Kind, issue.Hash, issue.App, err)
+			}
+			continue
+		}
+
+		issue.Repaired = true
+		repaired++
+		log.Printf("repaired %s %s of %s", issue.Kind, issue.Hash, issue.App)
+	}
+
+	report.Repaired += repaired
+	return repaired
+}
+
+// repairIssue repairs issue of app, app is nil when it no longer exists
+func (repo *AppRepository) repairIssue(app *models.App, issue *models.Issue) error {
+	if app == nil && issue.Kind != models.IssueStaleCache {
+		return errors.New("app not found")
+	}
+
+	switch issue.Kind {
+	case models.IssueOrphanObject:
+		// the blob may have been created since
+		if repo.storedBlob(app, issue.Hash) != nil {
+			return errResolved
+		}
+		return repo.storage.RemoveObject(app, issue.Hash)
+	case models.IssueMissingObject:
+		blob := repo.storedBlob(app, issue.Hash)
+		if blob == nil {
+			return errResolved
+		}
+
+		exists, err := repo.storage.ObjectExists(app, issue.Hash)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errResolved
+		}
+
+		blob.AppName = app.Name
+		if err := repo.storage.UncacheBlob(blob); err != nil {
+			log.Printf("failed to remove cached blob, %v", err)
+		}
+		return repo.purgeBlob(blob)
+	case models.IssueMissingCache, models.IssueCacheMismatch:
+		blob := &models.Blob{}
+		err := repo.db.Where("app_id = ? AND hash = ? AND is_delete_marker = ?", app.ID, issue.Hash, false).
+			First(blob).Error
+		if err != nil {
+			return errResolved
+		}
+
+		repo.loadAttributes([]*models.Blob{blob})
+		blob.AppName = app.Name
+		return repo.storage.CacheBlob(blob)
+	case models.IssueStaleCache:
+		// a trashed blob may have been restored since
+		if app != nil {
+			blob := repo.storedBlob(app, issue.Hash)
+			if blob != nil && blob.DeletedAt == nil {
+				return errResolved
+			}
+		}
+		return repo.storage.UncacheKey(issue.CacheKey)
+	case models.IssueMissingBucket:
+		exists, err := repo.storage.BucketExists(app)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errResolved
+		}
+		return repo.storage.CreateBucketForApp(app)
+	}
+
+	return errors.New("issue can't be repaired")
+}
+
+// storedBlob returns the blob of app holding the object hash,
+// trashed blobs included, or nil when there is none
+func (repo *AppRepository) storedBlob(app *models.App, hash string) *models.Blob {
+	blob := &models.Blob{}
+	err := repo.db.Unscoped().Where("app_id = ? AND hash = ? AND is_delete_marker = ?", app.ID, hash, false).
+		First(blob).Error
+	if err != nil {
+		return nil
+	}
+
+	return blob
+}
+
+// Reconciler periodically reconciles the database,
+// the blob cache and object storage
+type Reconciler struct {
+	repo *AppRepository
+	done chan struct{}
+}
+
+// NewReconciler creates a new Reconciler
+func NewReconciler(repo *AppRepository) *Reconciler {
+	return &Reconciler{repo: repo, done: make(chan struct{})}
+}
+
+// Start reconciles at the repository's reconcile interval
+// with its repair policy. It blocks until Stop
+func (r *Reconciler) Start() {
+	ticker := time.NewTicker(r.repo.reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			report, err := r.repo.Reconcile(nil)
+			if err != nil {
+				log.Printf("failed to reconcile, %v", err)
+				continue
+			}
+
+			log.Printf("reconciled %d apps, %d blobs, %d objects, found %d issues, repaired %d",
+				report.Apps, report.Blobs, report.Objects, len(report.Issues), report.Repaired)
+		}
+	}
+}
+
+// Stop stops the reconciler
+func (r *Reconciler) Stop() {
+	close(r.done)
+}
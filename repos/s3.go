@@ -0,0 +1,399 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// listBatch is the number of rows loaded at once when listing objects
+var listBatch = 1000
+
+// multipartExpiry is the age after which incomplete
+// multipart uploads are aborted and their parts removed
+var multipartExpiry = 7 * 24 * time.Hour
+
+var (
+	// ErrAppNotFound is returned when an app does
+	// not exist or does not belong to an account
+	ErrAppNotFound = errors.New("app not found")
+
+	// ErrNoSuchUpload is returned for unknown,
+	// completed or aborted multipart uploads
+	ErrNoSuchUpload = errors.New("the multipart upload does not exist")
+
+	// ErrObjectTooLarge is returned for objects larger than the PutObject size limit
+	ErrObjectTooLarge = errors.New("object is larger than the size limit, upload it in parts")
+)
+
+// ListObjectsOptions selects current objects of an app
+type ListObjectsOptions struct {
+	Prefix     string
+	Delimiter  string // keys sharing a prefix up to Delimiter are rolled up
+	StartAfter string // keys and common prefixes up to StartAfter are skipped
+	MaxKeys    int
+}
+
+// ObjectListing is a page of objects of an app
+type ObjectListing struct {
+	Blobs          []*models.Blob
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextMarker     string // last key or common prefix of the page
+}
+
+// ListObjects lists current objects of appName in key order. Keys
+// containing Delimiter after Prefix are rolled up as common prefixes,
+// like folders. Blobs are returned without their attributes
+func (repo *AppRepository) ListObjects(account uint, appName string, opt *ListObjectsOptions) (*ObjectListing, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, ErrAppNotFound
+	}
+
+	listing := &ObjectListing{Blobs: make([]*models.Blob, 0), CommonPrefixes: make([]string, 0)}
+	query := repo.db.Table("blobs").Where("app_id = ? AND noncurrent = ? AND is_delete_marker = ? AND parent_hash = ''",
+		app.ID, false, false)
+	if opt.Prefix != "" {
+		query = query.Where("key LIKE ?", escapeLike(opt.Prefix)+"%")
+	}
+
+	// keys are compared bytewise, whatever the database collation
+	marker := opt.StartAfter
+	lastKey, lastPrefix := "", ""
+	count := 0
+	for count <= opt.MaxKeys {
+		batch := make([]*models.Blob, 0)
+		err := query.Where(`key COLLATE "C" > ?`, marker).Order(`key COLLATE "C", id desc`).
+			Limit(listBatch).Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range batch {
+			marker = b.Key
+
+			// unversioned apps may have older blobs with the same key
+			if b.Key == lastKey {
+				continue
+			}
+			lastKey = b.Key
+
+			entry, isPrefix := b.Key, false
+			if opt.Delimiter != "" {
+				if i := strings.Index(b.Key[len(opt.Prefix):], opt.Delimiter); i >= 0 {
+					entry, isPrefix = b.Key[:len(opt.Prefix)+i+len(opt.Delimiter)], true
+				}
+			}
+
+			if isPrefix && (entry == lastPrefix || entry <= opt.StartAfter) {
+				continue
+			}
+
+			if count == opt.MaxKeys {
+				listing.IsTruncated = true
+				return listing, nil
+			}
+			count++
+
+			listing.NextMarker = entry
+			if isPrefix {
+				listing.CommonPrefixes = append(listing.CommonPrefixes, entry)
+				lastPrefix = entry
+			} else {
+				listing.Blobs = append(listing.Blobs, b)
+			}
+		}
+
+		if len(batch) < listBatch {
+			break
+		}
+
+		// skips the remaining keys of the last common prefix
+		if lastPrefix != "" && strings.HasPrefix(marker, lastPrefix) {
+			marker = lastPrefix + "\U0010FFFF"
+		}
+	}
+
+	return listing, nil
+}
+
+// PutObject streams size bytes of body under key in appName. In
+// unversioned apps, blobs previously stored under key are moved to trash
+func (repo *AppRepository) PutObject(account uint, appName, key string, opt *UploadOptions, body io.Reader, size int64) (*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, ErrAppNotFound
+	}
+
+	if err := models.ValidateMetadata(opt.Metadata); err != nil {
+		return nil, err
+	}
+
+	if size > repo.maxPutSize {
+		return nil, ErrObjectTooLarge
+	}
+
+	// the policy is checked before anything is stored
+	r := bufio.NewReader(body)
+	filename, err := app.UploadPolicy().Check(path.Base(key), size, services.SniffContentType(r))
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := repo.storeBlob(app, opt, filename, key, r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.replaceKey(app, blob)
+	return blob, nil
+}
+
+// GetObject downloads the current version of key of appName,
+// or the version identified by versionId
+func (repo *AppRepository) GetObject(account uint, appName, key, versionId string) (io.Reader, *models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, nil, ErrAppNotFound
+	}
+
+	return repo.DownloadBlobByKey(app.Name, key, versionId)
+}
+
+// replaceKey moves blobs stored under the key of blob before it
+// to trash, so unversioned apps keep a single blob per key
+func (repo *AppRepository) replaceKey(app *models.App, blob *models.Blob) {
+	if app.Versioning {
+		return
+	}
+
+	previous := make([]*models.Blob, 0)
+	err := repo.db.Table("blobs").Where("app_id = ? AND key = ? AND parent_hash = '' AND id <> ?", app.ID, blob.Key, blob.ID).
+		Find(&previous).Error
+	if err != nil {
+		log.Printf("failed to load previous blobs of %s, %v", blob.Key, err)
+		return
+	}
+
+	for _, b := range previous {
+		if err := repo.trashBlob(app, b); err != nil {
+			log.Printf("failed to replace blob %s, %v", b.Hash, err)
+		}
+	}
+}
+
+// CreateMultipartUpload starts an upload of key in parts
+func (repo *AppRepository) CreateMultipartUpload(account uint, appName, key string, opt *UploadOptions) (*models.MultipartUpload, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, ErrAppNotFound
+	}
+
+	upload, err := models.NewMultipartUpload(app, key, opt.Private, opt.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.db.Create(upload).Error; err != nil {
+		log.Printf("failed to create multipart upload, %v", err)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// UploadPart stores part n of a multipart upload, size bytes
+// of body. Uploading a part number again replaces the part
+func (repo *AppRepository) UploadPart(account uint, appName, uploadId string, n int, body io.Reader, size int64) (*models.MultipartPart, error) {
+	app, upload, err := repo.multipartUpload(account, appName, uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := models.ValidatePartNumber(n); err != nil {
+		return nil, err
+	}
+
+	if size > repo.maxPutSize {
+		return nil, ErrObjectTooLarge
+	}
+
+	etag, err := repo.storage.PutObject(app, upload.ObjectName(n), body, size)
+	if err != nil {
+		return nil, err
+	}
+
+	part := &models.MultipartPart{}
+	err = repo.db.Where("upload_id = ? AND part_number = ?", uploadId, n).
+		Assign(models.MultipartPart{ETag: etag, Size: size}).
+		FirstOrCreate(part, models.MultipartPart{UploadId: uploadId, PartNumber: n}).Error
+	if err != nil {
+		log.Printf("failed to save part %d of %s, %v", n, uploadId, err)
+		return nil, err
+	}
+
+	return part, nil
+}
+
+// GetParts returns a multipart upload of appName and its uploaded parts
+func (repo *AppRepository) GetParts(account uint, appName, uploadId string) (*models.MultipartUpload, []*models.MultipartPart, error) {
+	_, upload, err := repo.multipartUpload(account, appName, uploadId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := make([]*models.MultipartPart, 0)
+	if err := repo.db.Where("upload_id = ?", uploadId).Order("part_number").Find(&parts).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return upload, parts, nil
+}
+
+// CompleteMultipartUpload concatenates the listed parts of an upload
+// into a blob. The blob goes through the upload policy and processing
+// like uploaded files do, the parts are then removed
+func (repo *AppRepository) CompleteMultipartUpload(account uint, appName, uploadId string, completed []*models.CompletedPart) (*models.Blob, error) {
+	app, upload, err := repo.multipartUpload(account, appName, uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := make([]*models.MultipartPart, 0)
+	if err := repo.db.Where("upload_id = ?", uploadId).Find(&uploaded).Error; err != nil {
+		return nil, err
+	}
+
+	parts, err := models.MatchParts(uploaded, completed)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		size += p.Size
+		names[i] = upload.ObjectName(p.PartNumber)
+	}
+
+	// the content type is sniffed from the first bytes, like uploads
+	head := make([]byte, 512)
+	file, err := repo.storage.GetFile(app.Name, names[0])
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.ReadFull(file, head)
+	if closer, ok := file.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head[:n])
+
+	filename, err := app.UploadPolicy().Check(path.Base(upload.Key), size, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var blob *models.Blob
+	intent := models.NewUploadIntent(app)
+	err = repo.runIntent(intent, func() error {
+		composed, err := repo.storage.ComposeBlob(app, intent.Hash, names, size, contentType)
+		if err != nil {
+			log.Printf("failed to compose parts of %s, %v", uploadId, err)
+			return err
+		}
+
+		composed.ETag = models.MultipartETag(parts)
+		opt := &UploadOptions{Private: upload.Private, Metadata: upload.Metadata}
+		blob, err = repo.createBlob(app, opt, composed, filename, upload.Key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repo.replaceKey(app, blob)
+	repo.removeUpload(app, upload)
+	return blob, nil
+}
+
+// AbortMultipartUpload removes a multipart upload and its parts
+func (repo *AppRepository) AbortMultipartUpload(account uint, appName, uploadId string) error {
+	app, upload, err := repo.multipartUpload(account, appName, uploadId)
+	if err != nil {
+		return err
+	}
+
+	repo.removeUpload(app, upload)
+	return nil
+}
+
+// AbortStaleUploads aborts multipart uploads
+// started more than multipartExpiry ago
+func (repo *AppRepository) AbortStaleUploads() int {
+	uploads := make([]*models.MultipartUpload, 0)
+	err := repo.db.Where("created_at < ?", time.Now().Add(-multipartExpiry)).Find(&uploads).Error
+	if err != nil {
+		log.Printf("failed to load stale multipart uploads, %v", err)
+		return 0
+	}
+
+	for _, upload := range uploads {
+		app := repo.GetAppByAttr("id", upload.AppId)
+		if app == nil {
+			continue
+		}
+
+		repo.removeUpload(app, upload)
+	}
+
+	return len(uploads)
+}
+
+// multipartUpload returns an upload of appName owned by account
+func (repo *AppRepository) multipartUpload(account uint, appName, uploadId string) (*models.App, *models.MultipartUpload, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, nil, ErrAppNotFound
+	}
+
+	upload := &models.MultipartUpload{}
+	if err := repo.db.Where("upload_id = ? AND app_id = ?", uploadId, app.ID).First(upload).Error; err != nil {
+		return nil, nil, ErrNoSuchUpload
+	}
+
+	return app, upload, nil
+}
+
+// removeUpload removes stored parts and records of upload
+func (repo *AppRepository) removeUpload(app *models.App, upload *models.MultipartUpload) {
+	parts := make([]*models.MultipartPart, 0)
+	if err := repo.db.Where("upload_id = ?", upload.UploadId).Find(&parts).Error; err != nil {
+		log.Printf("failed to load parts of %s, %v", upload.UploadId, err)
+		return
+	}
+
+	for _, p := range parts {
+		if err := repo.storage.RemoveObject(app, upload.ObjectName(p.PartNumber)); err != nil {
+			log.Printf("failed to remove part %d of %s, %v", p.PartNumber, upload.UploadId, err)
+		}
+	}
+
+	if err := repo.db.Unscoped().Where("upload_id = ?", upload.UploadId).Delete(&models.MultipartPart{}).Error; err != nil {
+		log.Printf("failed to delete parts of %s, %v", upload.UploadId, err)
+	}
+
+	if err := repo.db.Unscoped().Delete(upload).Error; err != nil {
+		log.Printf("failed to delete multipart upload %s, %v", upload.UploadId, err)
+	}
+}
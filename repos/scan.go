@@ -0,0 +1,121 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"errors"
+	"log"
+	"time"
+)
+
+// JobScan is the kind of jobs scanning blobs for malware
+const JobScan = "scan"
+
+// ErrBlobInfected is returned when an infected,
+// quarantined blob is downloaded
+var ErrBlobInfected = errors.New("blob is infected and has been quarantined")
+
+// ErrScanPending is returned when a blob of an app that blocks
+// downloads until scans complete has not been scanned clean yet
+var ErrScanPending = errors.New("blob has not been scanned yet, retry later")
+
+// EnableScanning scans every blob created from now on with scanner
+func (repo *AppRepository) EnableScanning(scanner services.Scanner) {
+	repo.scanner = scanner
+	repo.RegisterProcessor(JobScan, repo.scanBlob)
+}
+
+// SetBlockUntilScanned sets whether downloads of blobs
+// of appName are refused until they are scanned clean
+func (repo *AppRepository) SetBlockUntilScanned(account uint, appName string, block bool) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := repo.db.Model(app).UpdateColumn("block_until_scanned", block).Error; err != nil {
+		return nil, err
+	}
+
+	app.BlockUntilScanned = block
+	return app, nil
+}
+
+// scanBlob scans the stored object of blob and records
+// the result. Failed scans are retried by the job queue
+func (repo *AppRepository) scanBlob(app *models.App, blob *models.Blob) error {
+	file, err := repo.storage.GetFile(blob.AppName, blob.Hash)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := repo.scanner.Scan(file)
+	if err != nil {
+		repo.setScanStatus(blob, models.ScanError, "", &now)
+		return err
+	}
+
+	if result.Infected {
+		log.Printf("blob %s of app %s is infected with %s, quarantined", blob.Hash, app.Name, result.Signature)
+		repo.setScanStatus(blob, models.ScanInfected, result.Signature, &now)
+		return nil
+	}
+
+	repo.setScanStatus(blob, models.ScanClean, "", &now)
+	return nil
+}
+
+// setScanStatus saves scan state of blob and
+// refreshes its cached copy, downloads are served from cache
+func (repo *AppRepository) setScanStatus(blob *models.Blob, status, signature string, at *time.Time) {
+	columns := map[string]interface{}{"scan_status": status, "scan_signature": signature, "scanned_at": at}
+	if err := repo.db.Model(blob).UpdateColumns(columns).Error; err != nil {
+		log.Printf("failed to save scan status of %s, %v", blob.Hash, err)
+		return
+	}
+
+	blob.ScanStatus, blob.ScanSignature, blob.ScannedAt = status, signature, at
+	repo.refreshCache(blob)
+
+	app := repo.GetAppByAttr("id", blob.AppId)
+	if app == nil {
+		return
+	}
+
+	// derivatives generated before the scan completed
+	// are served with the status of their original
+	derivatives := make([]string, 0)
+	query := repo.db.Table("blobs").Where("app_id = ? AND parent_hash = ?", blob.AppId, blob.Hash)
+	if err := query.Pluck("hash", &derivatives).Error; err != nil {
+		log.Printf("failed to load derivatives of %s, %v", blob.Hash, err)
+	} else if len(derivatives) > 0 {
+		if err := query.UpdateColumns(columns).Error; err != nil {
+			log.Printf("failed to save scan status of derivatives of %s, %v", blob.Hash, err)
+		}
+		repo.uncacheBlobs(app, derivatives)
+	}
+
+	repo.notify(app, models.EventBlobScanned, blob)
+}
+
+// checkScan refuses downloads of infected blobs, of unscanned blobs
+// of apps that block until scans complete and of images whose GPS
+// position has not been removed yet
+func (repo *AppRepository) checkScan(blob *models.Blob) error {
+	if blob.GPSPending {
+		return ErrGPSPending
+	}
+
+	switch blob.ScanStatus {
+	case models.ScanInfected:
+		return ErrBlobInfected
+	case models.ScanPending, models.ScanError:
+		app := repo.GetAppByAttr("name", blob.AppName)
+		if app != nil && app.BlockUntilScanned {
+			return ErrScanPending
+		}
+	}
+
+	return nil
+}
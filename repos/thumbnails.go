@@ -0,0 +1,60 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"errors"
+	"log"
+)
+
+// JobThumbnails is the kind of jobs generating thumbnails
+const JobThumbnails = "thumbnails"
+
+// SetThumbnailSizes sets the thumbnail sizes generated
+// for images uploaded to appName
+func (repo *AppRepository) SetThumbnailSizes(account uint, appName string, sizes []string) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := app.SetThumbnailSizes(sizes); err != nil {
+		return nil, err
+	}
+
+	if err := repo.db.Model(app).UpdateColumn("thumbnail_sizes", app.ThumbnailSizes).Error; err != nil {
+		log.Printf("failed to update thumbnail sizes, %v", err)
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// generateThumbnails creates the missing thumbnails of blob
+// for every thumbnail size of app
+func (repo *AppRepository) generateThumbnails(app *models.App, blob *models.Blob) error {
+	if blob.ParentHash != "" || blob.IsDeleteMarker || !services.IsTransformable(blob.ContentType) {
+		return nil
+	}
+
+	for _, size := range app.Thumbnails() {
+		hash := services.DerivativeHash(blob.Hash, size.Variant())
+		count := 0
+		repo.db.Table("blobs").Where("app_id = ? AND hash = ?", app.ID, hash).Count(&count)
+		if count > 0 {
+			continue
+		}
+
+		t := &services.Transformation{Width: size.Width, Height: size.Height, Fit: services.FitCover}
+		// images too large to transform never get thumbnails
+		_, _, err := repo.createDerivative(app, blob, hash, size.Variant(), t)
+		if err == services.ErrSourceTooLarge {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"log"
+	"time"
+)
+
+// DeleteBlobs moves blobs identified by hashes to trash.
+// Trashed blobs are hidden from listings and downloads
+// until they are restored or purged
+func (repo *AppRepository) DeleteBlobs(account uint, appName string, hashes []string) ([]*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	blobs := make([]*models.Blob, 0)
+	err := repo.db.Table("blobs").Where("app_id = ? AND hash IN (?)", app.ID, hashes).Find(&blobs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blobs) == 0 {
+		return nil, ErrBlobNotFound
+	}
+
+	for _, b := range blobs {
+		if err := repo.trashBlob(app, b); err != nil {
+			return nil, err
+		}
+	}
+
+	return blobs, nil
+}
+
+// trashBlob soft deletes blob and removes it from
+// the cache. Its stored object is kept until it is purged
+func (repo *AppRepository) trashBlob(app *models.App, blob *models.Blob) error {
+	if err := repo.db.Delete(blob).Error; err != nil {
+		log.Printf("failed to move blob %s to trash, %v", blob.Hash, err)
+		return err
+	}
+
+	if err := repo.storage.UncacheBlob(blob); err != nil {
+		log.Printf("failed to remove cached blob, %v", err)
+	}
+
+	// derivatives are regenerated when needed
+	repo.purgeDerivatives(blob)
+
+	if app.Versioning && !blob.Noncurrent {
+		repo.promoteNewestVersion(app, blob.Key)
+	}
+
+	if blob.ParentHash == "" && !blob.IsDeleteMarker {
+		repo.notify(app, models.EventBlobDeleted, blob)
+	}
+
+	return nil
+}
+
+// GetTrash returns trashed blobs of appName, most
+// recently deleted first. A single call returns 20 items
+func (repo *AppRepository) GetTrash(account uint, appName string, page int64) ([]*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	data := make([]*models.Blob, 0)
+	err := repo.db.Unscoped().Table("blobs").Where("app_id = ? AND deleted_at IS NOT NULL", app.ID).
+		Order("deleted_at desc").Offset(page * repo.pageSize).Limit(repo.pageSize).Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+
+	repo.loadAttributes(data)
+	return data, nil
+}
+
+// RestoreBlobs restores trashed blobs identified by hashes,
+// or every blob trashed at or after since
+func (repo *AppRepository) RestoreBlobs(account uint, appName string, hashes []string, since *time.Time) ([]*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	blobs, err := repo.trashedBlobs(app, hashes, since)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range blobs {
+		if err := repo.db.Unscoped().Model(b).UpdateColumn("deleted_at", nil).Error; err != nil {
+			log.Printf("failed to restore blob %s, %v", b.Hash, err)
+			return nil, err
+		}
+		b.DeletedAt = nil
+
+		// a restored current version replaces the
+		// version promoted when it was trashed
+		if app.Versioning && !b.Noncurrent {
+			query := repo.db.Table("blobs").Where("app_id = ? AND key = ? AND noncurrent = ? AND id <> ?",
+				app.ID, b.Key, false, b.ID)
+			replaced := make([]string, 0)
+			err := query.Pluck("hash", &replaced).Error
+			if err == nil {
+				err = query.UpdateColumns(map[string]interface{}{"noncurrent": true, "noncurrent_at": time.Now()}).Error
+			}
+			if err != nil {
+				log.Printf("failed to update versions of %s, %v", b.Key, err)
+			}
+			repo.uncacheBlobs(app, replaced)
+		}
+	}
+
+	repo.loadAttributes(blobs)
+	for _, b := range blobs {
+		if b.IsDeleteMarker {
+			continue
+		}
+
+		if err := repo.storage.CacheBlob(b); err != nil {
+			log.Printf("failed to cache restored blob, %v", err)
+		}
+
+		// derivatives were removed when b was trashed
+		repo.processBlob(app, b)
+	}
+
+	return blobs, nil
+}
+
+// PurgeBlobs permanently removes trashed blobs identified
+// by hashes, or every trashed blob when hashes is empty
+func (repo *AppRepository) PurgeBlobs(account uint, appName string, hashes []string) ([]*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	blobs, err := repo.trashedBlobs(app, hashes, nil)
+	if err == ErrBlobNotFound && len(hashes) == 0 {
+		return blobs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range blobs {
+		if err := repo.purgeBlob(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return blobs, nil
+}
+
+// SetTrashRetention sets the number of days trashed
+// blobs of appName are kept
+func (repo *AppRepository) SetTrashRetention(account uint, appName string, days int) (*models.App, error) {
+	if days < 1 {
+		return nil, errors.New("trash retention must be at least one day")
+	}
+
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := repo.db.Model(app).UpdateColumn("trash_retention_days", days).Error; err != nil {
+		return nil, err
+	}
+
+	app.TrashRetentionDays = days
+	return app, nil
+}
+
+// PurgeExpiredTrash permanently removes blobs that have
+// been in app's trash longer than its retention window
+func (repo *AppRepository) PurgeExpiredTrash(app *models.App) (int, error) {
+	retention := app.TrashRetentionDays
+	if retention < 1 {
+		retention = 1
+	}
+
+	before := time.Now().Add(-time.Duration(retention) * 24 * time.Hour)
+	blobs := make([]*models.Blob, 0)
+	err := repo.db.Unscoped().Table("blobs").Where("app_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?",
+		app.ID, before).Find(&blobs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i, b := range blobs {
+		if err := repo.purgeBlob(b); err != nil {
+			return i, err
+		}
+	}
+
+	return len(blobs), nil
+}
+
+// trashedBlobs returns trashed blobs of app identified by
+// hashes and/or deleted at or after since
+func (repo *AppRepository) trashedBlobs(app *models.App, hashes []string, since *time.Time) ([]*models.Blob, error) {
+	query := repo.db.Unscoped().Table("blobs").Where("app_id = ? AND deleted_at IS NOT NULL", app.ID)
+	if len(hashes) > 0 {
+		query = query.Where("hash IN (?)", hashes)
+	}
+
+	if since != nil {
+		query = query.Where("deleted_at >= ?", *since)
+	}
+
+	blobs := make([]*models.Blob, 0)
+	if err := query.Order("id").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+
+	if len(blobs) == 0 {
+		return blobs, ErrBlobNotFound
+	}
+
+	return blobs, nil
+}
+
+// TrashReaper periodically purges blobs past their app's
+// trash retention window and abandoned multipart uploads
+type TrashReaper struct {
+	repo *AppRepository
+	done chan struct{}
+}
+
+// NewTrashReaper creates a new TrashReaper
+func NewTrashReaper(repo *AppRepository) *TrashReaper {
+	return &TrashReaper{repo: repo, done: make(chan struct{})}
+}
+
+// Start purges expired trash every trash interval. It blocks until Stop
+func (r *TrashReaper) Start() {
+	ticker := time.NewTicker(r.repo.trashInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reap()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Stop stops the reaper
+func (r *TrashReaper) Stop() {
+	close(r.done)
+}
+
+func (r *TrashReaper) reap() {
+	if n := r.repo.AbortStaleUploads(); n > 0 {
+		log.Printf("trash: %d stale multipart uploads aborted", n)
+	}
+
+	ids := make([]uint, 0)
+	err := r.repo.db.Unscoped().Table("blobs").Where("deleted_at IS NOT NULL").
+		Pluck("DISTINCT app_id", &ids).Error
+	if err != nil {
+		log.Printf("failed to load apps with trashed blobs, %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		app := r.repo.GetAppByAttr("id", id)
+		if app == nil {
+			continue
+		}
+
+		n, err := r.repo.PurgeExpiredTrash(app)
+		if err != nil {
+			log.Printf("failed to purge trash of app %s, %v", app.Name, err)
+		}
+
+		if n > 0 {
+			log.Printf("trash: %d blobs of app %s purged", n, app.Name)
+		}
+	}
+}
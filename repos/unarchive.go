@@ -0,0 +1,91 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"bytes"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+)
+
+// UploadArchive extracts the zip, tar or tar.gz archive body into
+// blobs of appName, one per file of the archive. Entry paths become
+// keys, prefixed by opt.Key, keeping the folders of the archive as
+// virtual folders. Every entry is checked against the upload policy
+// of the app. The report lists stored blobs and entries that failed,
+// it is returned along with the error when extraction stopped midway
+func (repo *AppRepository) UploadArchive(account uint, appName string, opt *UploadOptions, body *multipart.FileHeader) (*models.UploadMultipleResponse, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if err := models.ValidateMetadata(opt.Metadata); err != nil {
+		return nil, err
+	}
+
+	if err := models.ValidateTags(opt.Tags); err != nil {
+		return nil, err
+	}
+
+	file, err := body.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// apps limiting files per upload limit files per archive
+	policy := app.UploadPolicy()
+	limits := repo.unarchiveLimits
+	if policy.MaxFiles > 0 && policy.MaxFiles < limits.MaxEntries {
+		limits.MaxEntries = policy.MaxFiles
+	}
+
+	blobs := make([]*models.Blob, 0)
+	report := &models.UploadMultipleResponse{Blobs: blobs, Errors: make([]*models.UploadError, 0)}
+	index := 0
+	err = services.Unarchive(file, body.Size, &limits, func(entry string, data []byte, err error) {
+		i := index
+		index++
+
+		if err == nil {
+			var blob *models.Blob
+			blob, err = repo.storeEntry(app, opt, policy, entry, data)
+			if err == nil {
+				blobs = append(blobs, blob)
+				return
+			}
+		}
+
+		log.Printf("failed to extract archive entry %s, %v", entry, err)
+		uploadError := &models.UploadError{Index: i, Filename: entry, Message: err.Error()}
+		if violation, ok := err.(*models.PolicyViolation); ok {
+			uploadError.Code, uploadError.Message = violation.Code, violation.Message
+		}
+		report.Errors = append(report.Errors, uploadError)
+	})
+
+	report.Blobs = blobs
+	report.SuccessCount = int64(len(blobs))
+	report.FailureCount = int64(len(report.Errors))
+	return report, err
+}
+
+// storeEntry stores the extracted archive entry as a blob of app
+func (repo *AppRepository) storeEntry(app *models.App, opt *UploadOptions, policy *models.UploadPolicy, entry string, data []byte) (*models.Blob, error) {
+	dir, name := path.Split(entry)
+	filename, err := policy.Check(name, int64(len(data)), http.DetectContentType(data))
+	if err != nil {
+		return nil, err
+	}
+
+	key := dir + filename
+	if opt.Key != "" {
+		key = path.Join(opt.Key, key)
+	}
+
+	return repo.storeBlob(app, opt, filename, key, bytes.NewReader(data), int64(len(data)))
+}
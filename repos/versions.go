@@ -0,0 +1,285 @@
+package repos
+
+import (
+	"blober.io/models"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+// ErrBlobNotFound is returned when a key or version
+// does not exist or the current version is a delete marker
+var ErrBlobNotFound = errors.New("blob not found")
+
+// SetVersioning enables or suspends versioning of an app.
+// Existing versions are kept when versioning is suspended. Keys
+// uploaded more than once while versioning was suspended keep
+// their newest blob current when it is enabled again
+func (repo *AppRepository) SetVersioning(account uint, appName string, enabled bool) (*models.App, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(app).UpdateColumn("versioning", enabled).Error; err != nil {
+		log.Printf("failed to update app versioning, %v", err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	previous := make([]string, 0)
+	if enabled {
+		query := tx.Table("blobs").Where(`app_id = ? AND key <> '' AND parent_hash = '' AND noncurrent = ?
+			AND deleted_at IS NULL AND id < (SELECT MAX(newest.id) FROM blobs newest WHERE newest.app_id = blobs.app_id
+			AND newest.key = blobs.key AND newest.parent_hash = '' AND newest.noncurrent = ? AND newest.deleted_at IS NULL)`,
+			app.ID, false, false)
+		if err := query.Pluck("hash", &previous).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		err := query.UpdateColumns(map[string]interface{}{"noncurrent": true, "noncurrent_at": time.Now()}).Error
+		if err != nil {
+			log.Printf("failed to update previous versions, %v", err)
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit app versioning, %v", err)
+		return nil, err
+	}
+	repo.uncacheBlobs(app, previous)
+
+	app.Versioning = enabled
+	return app, nil
+}
+
+// DownloadBlobByKey downloads the current version of key.
+// When versionId is not empty, that specific version is downloaded
+func (repo *AppRepository) DownloadBlobByKey(appName, key, versionId string) (io.Reader, *models.Blob, error) {
+	blob := &models.Blob{}
+	query := repo.db.Table("blobs").Where("app_name = ? AND key = ?", appName, key)
+	if versionId != "" {
+		query = query.Where("version_id = ?", versionId)
+	} else {
+		query = query.Where("noncurrent = ?", false)
+	}
+
+	if err := query.Order("id desc").First(blob).Error; err != nil {
+		return nil, nil, ErrBlobNotFound
+	}
+
+	if blob.IsDeleteMarker {
+		return nil, nil, ErrBlobNotFound
+	}
+
+	return repo.DownloadBlob(appName, blob.Hash)
+}
+
+// GetVersions returns every version of key, delete markers
+// included. Newest versions come first
+func (repo *AppRepository) GetVersions(account uint, appName, key string) ([]*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	data := make([]*models.Blob, 0)
+	query := repo.db.Table("blobs").Where("app_id = ? AND parent_hash = ''", app.ID)
+	if key != "" {
+		query = query.Where("key = ?", key)
+	}
+
+	if err := query.Order("id desc").Find(&data).Error; err != nil {
+		return nil, err
+	}
+
+	repo.loadAttributes(data)
+	return data, nil
+}
+
+// DeleteObject deletes key. In versioned apps, a delete marker
+// is created and older versions are kept, unless versionId is
+// given in which case that version is moved to trash.
+// In unversioned apps the current blob of key is moved to trash
+func (repo *AppRepository) DeleteObject(account uint, appName, key, versionId string) (*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if versionId != "" {
+		return repo.deleteVersion(app, key, versionId)
+	}
+
+	current := &models.Blob{}
+	err := repo.db.Table("blobs").Where("app_id = ? AND key = ? AND parent_hash = '' AND noncurrent = ?", app.ID, key, false).
+		Order("id desc").First(current).Error
+	if err != nil || current.IsDeleteMarker {
+		return nil, ErrBlobNotFound
+	}
+
+	if app.Versioning {
+		return repo.markDeleted(app, key)
+	}
+
+	if err := repo.trashBlob(app, current); err != nil {
+		return nil, err
+	}
+
+	return current, nil
+}
+
+// markDeleted makes a delete marker the current version of key
+func (repo *AppRepository) markDeleted(app *models.App, key string) (*models.Blob, error) {
+	marker := models.NewDeleteMarker(app, key)
+	if err := repo.saveBlob(app, marker); err != nil {
+		return nil, err
+	}
+
+	repo.notify(app, models.EventBlobDeleted, marker)
+	return marker, nil
+}
+
+// deleteVersion moves a single version of key to trash
+func (repo *AppRepository) deleteVersion(app *models.App, key, versionId string) (*models.Blob, error) {
+	blob := &models.Blob{}
+	err := repo.db.Table("blobs").Where("app_id = ? AND key = ? AND version_id = ?", app.ID, key, versionId).
+		First(blob).Error
+	if err != nil {
+		return nil, ErrBlobNotFound
+	}
+
+	if err := repo.trashBlob(app, blob); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// promoteNewestVersion makes the newest remaining
+// version of key its current version
+func (repo *AppRepository) promoteNewestVersion(app *models.App, key string) {
+	newest := &models.Blob{}
+	err := repo.db.Table("blobs").Where("app_id = ? AND key = ?", app.ID, key).Order("id desc").
+		First(newest).Error
+	if err != nil {
+		return
+	}
+
+	columns := map[string]interface{}{"noncurrent": false, "noncurrent_at": nil}
+	if err := repo.db.Model(newest).UpdateColumns(columns).Error; err != nil {
+		log.Printf("failed to promote version %s, %v", newest.VersionId, err)
+		return
+	}
+	repo.uncacheBlobs(app, []string{newest.Hash})
+}
+
+// RestoreVersion makes an older version the current version
+// of its key. The version is copied, so history is preserved
+func (repo *AppRepository) RestoreVersion(account uint, appName, versionId string) (*models.Blob, error) {
+	app := repo.GetAppByName(account, appName)
+	if app == nil {
+		return nil, errors.New("app not found")
+	}
+
+	if !app.Versioning {
+		return nil, errors.New("versioning is not enabled for this app")
+	}
+
+	version := &models.Blob{}
+	err := repo.db.Table("blobs").Where("app_id = ? AND version_id = ?", app.ID, versionId).First(version).Error
+	if err != nil || version.IsDeleteMarker {
+		return nil, ErrBlobNotFound
+	}
+	repo.loadAttributes([]*models.Blob{version})
+
+	var restored *models.Blob
+	intent := models.NewUploadIntent(app)
+	err = repo.runIntent(intent, func() error {
+		restored, err = repo.storage.CopyBlob(version, app, intent.Hash)
+		if err != nil {
+			log.Printf("failed to copy version %s, %v", versionId, err)
+			return err
+		}
+
+		restored.VersionId = restored.Hash
+		restored.ScanStatus, restored.ScanSignature, restored.ScannedAt = version.ScanStatus, version.ScanSignature, version.ScannedAt
+		repo.holdForGPS(app, restored)
+		return repo.saveBlob(app, restored)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	repo.processBlob(app, restored)
+	return restored, nil
+}
+
+// purgeBlob permanently removes blob record, its attributes,
+// cached struct and stored object
+func (repo *AppRepository) purgeBlob(blob *models.Blob) error {
+	// the object is removed once the blob is, an
+	// intent covers a crash in between
+	intent := &models.Intent{Kind: models.IntentDeleteBlob, AppId: blob.AppId, AppName: blob.AppName, Hash: blob.Hash}
+	if err := repo.beginIntent(intent); err != nil {
+		return err
+	}
+
+	tx := repo.db.Begin()
+	if err := tx.Error; err != nil {
+		repo.endIntent(intent)
+		return err
+	}
+
+	if err := tx.Where("blob_id = ?", blob.ID).Delete(&models.BlobMetadata{}).Error; err != nil {
+		tx.Rollback()
+		repo.endIntent(intent)
+		return err
+	}
+
+	if err := tx.Where("blob_id = ?", blob.ID).Delete(&models.BlobTag{}).Error; err != nil {
+		tx.Rollback()
+		repo.endIntent(intent)
+		return err
+	}
+
+	if err := tx.Unscoped().Delete(blob).Error; err != nil {
+		tx.Rollback()
+		repo.endIntent(intent)
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("failed to commit blob removal, %v", err)
+		repo.endIntent(intent)
+		return err
+	}
+
+	// the intent is kept when the object remains,
+	// its removal is retried on the next start
+	if err := repo.storage.RemoveBlob(blob); err != nil {
+		log.Printf("failed to remove stored object %s, %v", blob.Hash, err)
+	} else {
+		repo.endIntent(intent)
+	}
+
+	repo.purgeDerivatives(blob)
+
+	// trashed blobs were reported deleted when they were trashed
+	if blob.DeletedAt == nil && blob.ParentHash == "" && !blob.IsDeleteMarker {
+		if app := repo.GetAppByAttr("id", blob.AppId); app != nil {
+			repo.notify(app, models.EventBlobDeleted, blob)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package repos
+
+import (
+	"blober.io/models"
+	"blober.io/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// JobWebhook is the kind of jobs delivering webhook events
+const JobWebhook = "webhook"
+
+// maxWebhooks is the maximum number of webhooks of an app,
+// or of an account for account wide webhooks
+var maxWebhooks = 10
+
+// webhookMaxAttempts is the number of times a delivery
+// is tried before it is marked failed
+var webhookMaxAttempts = 8
+
+// ErrWebhookNotFound is returned when a webhook or a delivery
+// does not exist or belongs to another account
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// CreateWebhook subscribes url to events of appName, or to events
+// of every app of account when appName is empty. The returned
+// webhook carries its signing secret, it is not shown again
+func (repo *AppRepository) CreateWebhook(account uint, appName, url string, events []string) (*models.Webhook, error) {
+	var appId uint
+	if appName != "" {
+		app := repo.GetAppByName(account, appName)
+		if app == nil {
+			return nil, errors.New("app not found")
+		}
+		appId = app.ID
+	}
+
+	hook, err := models.NewWebhook(account, appId, url, events)
+	if err != nil {
+		return nil, err
+	}
+
+	// deliveries are checked again when sent, the
+	// host may resolve to another address by then
+	ctx, cancel := context.WithTimeout(context.Background(), services.WebhookTimeout)
+	defer cancel()
+	if err := repo.fetcher.CheckURL(ctx, hook.URL); err != nil {
+		if err == services.ErrBlockedAddress {
+			return nil, errors.New("webhook url resolves to a private or loopback address")
+		}
+		return nil, fmt.Errorf("invalid webhook url, %v", err)
+	}
+
+	count := 0
+	repo.db.Model(&models.Webhook{}).Where("account_id = ? AND app_id = ?", account, appId).Count(&count)
+	if count >= maxWebhooks {
+		return nil, fmt.Errorf("can not have more than %d webhooks", maxWebhooks)
+	}
+
+	if err := repo.db.Create(hook).Error; err != nil {
+		log.Printf("failed to create webhook, %v", err)
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// GetWebhooks returns webhooks of appName, or every
+// webhook of account when appName is empty
+func (repo *AppRepository) GetWebhooks(account uint, appName string) ([]*models.Webhook, error) {
+	query := repo.db.Where("account_id = ?", account)
+	if appName != "" {
+		app := repo.GetAppByName(account, appName)
+		if app == nil {
+			return nil, errors.New("app not found")
+		}
+		query = query.Where("app_id = ?", app.ID)
+	}
+
+	hooks := make([]*models.Webhook, 0)
+	if err := query.Order("id").Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+
+	for _, h := range hooks {
+		h.Secret = ""
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes a webhook, pending deliveries are dropped
+func (repo *AppRepository) DeleteWebhook(account, id uint) error {
+	hook, err := repo.getWebhook(account, id)
+	if err != nil {
+		return err
+	}
+
+	return repo.db.Delete(hook).Error
+}
+
+// GetDeliveries returns deliveries of a webhook, newest first.
+// A single call returns 20 items
+func (repo *AppRepository) GetDeliveries(account, webhookId uint, page int64) ([]*models.WebhookDelivery, error) {
+	if _, err := repo.getWebhook(account, webhookId); err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	err := repo.db.Where("webhook_id = ?", webhookId).Order("id desc").Offset(page * repo.pageSize).Limit(repo.pageSize).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// Redeliver sends the payload of a delivery again as a new delivery
+func (repo *AppRepository) Redeliver(account, deliveryId uint) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+	if err := repo.db.Where("id = ?", deliveryId).First(delivery).Error; err != nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	hook, err := repo.getWebhook(account, delivery.WebhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	redelivery := &models.WebhookDelivery{WebhookId: hook.ID, AppId: delivery.AppId, EventId: delivery.EventId,
+		Event: delivery.Event, Payload: delivery.Payload, Status: models.DeliveryPending, RedeliveryOf: delivery.ID}
+	if err := repo.enqueueDelivery(redelivery); err != nil {
+		return nil, err
+	}
+
+	return redelivery, nil
+}
+
+func (repo *AppRepository) getWebhook(account, id uint) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	if err := repo.db.Where("id = ? AND account_id = ?", id, account).First(hook).Error; err != nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	return hook, nil
+}
+
+// notify publishes an event of app to activity streams and
+// creates a delivery of it for every webhook subscribed to it
+func (repo *AppRepository) notify(app *models.App, eventType string, data interface{}) {
+	event := models.NewEvent(eventType, app, data)
+	defer repo.events.Publish(event)
+
+	hooks := make([]*models.Webhook, 0)
+	err := repo.db.Where("account_id = ? AND (app_id = ? OR app_id = 0) AND active = ?", app.AccountId, app.ID, true).
+		Find(&hooks).Error
+	if err != nil {
+		log.Printf("failed to load webhooks of app %s, %v", app.Name, err)
+		return
+	}
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode %s event, %v", eventType, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Subscribed(eventType) {
+			continue
+		}
+
+		delivery := &models.WebhookDelivery{WebhookId: hook.ID, AppId: app.ID, EventId: event.ID,
+			Event: eventType, Payload: string(payload), Status: models.DeliveryPending}
+		if err := repo.enqueueDelivery(delivery); err != nil {
+			log.Printf("failed to enqueue %s delivery to webhook %d, %v", eventType, hook.ID, err)
+		}
+	}
+}
+
+// enqueueDelivery saves delivery and queues a job sending it
+func (repo *AppRepository) enqueueDelivery(delivery *models.WebhookDelivery) error {
+	if err := repo.db.Create(delivery).Error; err != nil {
+		return err
+	}
+
+	job := models.NewJob(JobWebhook, delivery.AppId, 0, strconv.FormatUint(uint64(delivery.ID), 10))
+	job.MaxAttempts = webhookMaxAttempts
+	return repo.queue.Enqueue(job)
+}
+
+// deliverWebhook sends a queued delivery. Failed attempts
+// are retried with backoff by the job queue
+func (repo *AppRepository) deliverWebhook(job *models.Job) error {
+	delivery := &models.WebhookDelivery{}
+	if err := repo.db.Where("id = ?", job.Payload).First(delivery).Error; err != nil {
+		log.Printf("skipping webhook job %d, delivery %s not found", job.ID, job.Payload)
+		return nil
+	}
+
+	hook := &models.Webhook{}
+	if err := repo.db.Where("id = ?", delivery.WebhookId).First(hook).Error; err != nil {
+		// webhook was removed
+		repo.db.Model(delivery).UpdateColumns(map[string]interface{}{
+			"status": models.DeliveryFailed, "error": "webhook was deleted",
+		})
+		return nil
+	}
+
+	deliveryId := strconv.FormatUint(uint64(delivery.ID), 10)
+	resp, err := repo.webhooks.Send(hook.URL, hook.Secret, delivery.Event, deliveryId, []byte(delivery.Payload))
+	columns := map[string]interface{}{"attempts": delivery.Attempts + 1, "error": ""}
+	if resp != nil {
+		columns["response_code"] = resp.StatusCode
+	}
+
+	if err == nil {
+		now := time.Now()
+		columns["status"], columns["delivered_at"] = models.DeliveryDelivered, &now
+	} else {
+		columns["error"] = err.Error()
+		if job.Attempts >= job.MaxAttempts {
+			columns["status"] = models.DeliveryFailed
+		}
+	}
+
+	if uerr := repo.db.Model(delivery).UpdateColumns(columns).Error; uerr != nil {
+		log.Printf("failed to update webhook delivery %d, %v", delivery.ID, uerr)
+	}
+
+	return err
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// storedTypes are content types already compressed,
+// they are stored in zip archives without deflating
+var storedTypes = []string{"image/", "video/", "audio/", "application/zip", "application/x-gzip", "application/pdf"}
+
+// ArchiveWriter writes entries of an archive to an underlying writer
+type ArchiveWriter interface {
+	// Add adds an entry named name holding size bytes read from r
+	Add(name, contentType string, size int64, modTime time.Time, r io.Reader) error
+
+	// Close finishes the archive, it does not close the underlying writer
+	Close() error
+
+	// ContentType returns the content type of the archive
+	ContentType() string
+}
+
+// NewArchiveWriter creates an ArchiveWriter of format
+// (zip or tar.gz) writing to w
+func NewArchiveWriter(format string, w io.Writer) (ArchiveWriter, error) {
+	switch ParseArchiveFormat(format) {
+	case ArchiveZip:
+		return &zipWriter{zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tar: tar.NewWriter(gz)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported archive format %s, expected zip or tar.gz", format)
+}
+
+// ParseArchiveFormat normalizes an archive format name,
+// an empty string is returned for unknown formats
+func ParseArchiveFormat(format string) string {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "", "zip":
+		return ArchiveZip
+	case "tar.gz", "tgz", "targz":
+		return ArchiveTarGz
+	}
+
+	return ""
+}
+
+type zipWriter struct {
+	w *zip.Writer
+}
+
+func (z *zipWriter) Add(name, contentType string, size int64, modTime time.Time, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetModTime(modTime)
+	for _, t := range storedTypes {
+		if strings.HasPrefix(contentType, t) {
+			header.Method = zip.Store
+			break
+		}
+	}
+
+	entry, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	return copyEntry(entry, r, size)
+}
+
+func (z *zipWriter) Close() error {
+	return z.w.Close()
+}
+
+func (z *zipWriter) ContentType() string {
+	return "application/zip"
+}
+
+type tarGzWriter struct {
+	gz  *gzip.Writer
+	tar *tar.Writer
+}
+
+func (t *tarGzWriter) Add(name, contentType string, size int64, modTime time.Time, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := t.tar.WriteHeader(header); err != nil {
+		return err
+	}
+
+	return copyEntry(t.tar, r, size)
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		return err
+	}
+
+	return t.gz.Close()
+}
+
+func (t *tarGzWriter) ContentType() string {
+	return "application/gzip"
+}
+
+// copyEntry copies exactly size bytes of r to w
+func copyEntry(w io.Writer, r io.Reader, size int64) error {
+	n, err := io.Copy(w, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+
+	if n != size {
+		return fmt.Errorf("entry is %d bytes, expected %d", n, size)
+	}
+
+	return nil
+}
+
+// ArchiveNames returns unique, flat entry names for filenames.
+// Directories are stripped and duplicates get a " (n)" suffix,
+// e.g report.pdf, report (1).pdf
+func ArchiveNames(filenames []string) []string {
+	names := make([]string, len(filenames))
+	used := make(map[string]bool)
+	for i, f := range filenames {
+		name := path.Base(strings.Replace(f, "\\", "/", -1))
+		if name == "." || name == "/" || name == ".." {
+			name = "file"
+		}
+
+		unique := name
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for n := 1; used[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s (%d)%s", base, n, ext)
+		}
+
+		used[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+
+	return names
+}
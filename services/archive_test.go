@@ -0,0 +1,118 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var archiveEntries = []struct {
+	name, contentType, content string
+}{
+	{"notes.txt", "text/plain; charset=utf-8", "hello"},
+	{"photo.png", "image/png", "\x89PNG not really"},
+}
+
+func writeArchive(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+	archive, err := NewArchiveWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range archiveEntries {
+		err := archive.Add(e.name, e.contentType, int64(len(e.content)), time.Now(), strings.NewReader(e.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestZipArchive(t *testing.T) {
+	data := writeArchive(t, "zip")
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.File) != len(archiveEntries) {
+		t.Fatalf("expected %d entries, found %d", len(archiveEntries), len(r.File))
+	}
+
+	for i, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+
+		if f.Name != archiveEntries[i].name || string(content) != archiveEntries[i].content {
+			t.Errorf("unexpected entry %s: %q", f.Name, content)
+		}
+	}
+
+	if r.File[1].Method != zip.Store {
+		t.Error("expected images to be stored without compression")
+	}
+}
+
+func TestTarGzArchive(t *testing.T) {
+	data := writeArchive(t, "tgz")
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := tar.NewReader(gz)
+	for i := 0; ; i++ {
+		header, err := r.Next()
+		if err == io.EOF {
+			if i != len(archiveEntries) {
+				t.Fatalf("expected %d entries, found %d", len(archiveEntries), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, _ := ioutil.ReadAll(r)
+		if header.Name != archiveEntries[i].name || string(content) != archiveEntries[i].content {
+			t.Errorf("unexpected entry %s: %q", header.Name, content)
+		}
+	}
+}
+
+func TestArchiveShortEntry(t *testing.T) {
+	archive, _ := NewArchiveWriter("zip", ioutil.Discard)
+	if err := archive.Add("a.txt", "text/plain", 10, time.Now(), strings.NewReader("short")); err == nil {
+		t.Fatal("expected an error for an entry shorter than its size")
+	}
+
+	if _, err := NewArchiveWriter("rar", ioutil.Discard); err == nil {
+		t.Fatal("expected unsupported format to be rejected")
+	}
+}
+
+func TestArchiveNames(t *testing.T) {
+	names := ArchiveNames([]string{"report.pdf", "Report.pdf", "../../etc/passwd", "report.pdf", "", `C:\tmp\a.txt`})
+	expected := []string{"report.pdf", "Report (1).pdf", "passwd", "report (2).pdf", "file", "a.txt"}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected %s, found %s", expected[i], names[i])
+		}
+	}
+}
@@ -16,6 +16,15 @@ func CreateDatabaseConnection(connectUri string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	db.AutoMigrate(&models.Account{}, &models.App{}, &models.Blob{})
-	return db, err
+	return db, Migrate(db)
+}
+
+// Migrate creates or updates the tables of every model,
+// columns are added but never dropped
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&models.Account{}, &models.App{}, &models.Blob{},
+		&models.BlobMetadata{}, &models.BlobTag{}, &models.LifecycleRule{}, &models.LifecycleRun{},
+		&models.Job{}, &models.Webhook{}, &models.WebhookDelivery{},
+		&models.Import{}, &models.ImportItem{}, &models.MultipartUpload{}, &models.MultipartPart{},
+		&models.Intent{}).Error
 }
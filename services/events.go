@@ -0,0 +1,109 @@
+package services
+
+import (
+	"blober.io/models"
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is the number of events a subscriber
+// can fall behind before it is dropped
+var subscriptionBuffer = 64
+
+// EventBus delivers events of apps to in process subscribers,
+// e.g activity streams. The last events of every app are kept,
+// so subscribers can resume from the last event they received.
+// Events are not shared by replicas, subscribers of a replica
+// only receive the events published by it
+type EventBus struct {
+	mu          sync.Mutex
+	seq         uint64
+	historySize int
+	history     map[uint][]*models.Event
+	subscribers map[uint]map[*Subscription]bool
+}
+
+// Subscription receives events of an app on C. C is closed
+// when the subscriber falls too far behind or unsubscribes
+type Subscription struct {
+	C     chan *models.Event
+	appId uint
+}
+
+// NewEventBus creates an EventBus keeping
+// historySize events of every app
+func NewEventBus(historySize int) *EventBus {
+	return &EventBus{
+		// sequences start from the clock so they keep
+		// increasing across restarts
+		seq:         uint64(time.Now().UnixNano()),
+		historySize: historySize,
+		history:     make(map[uint][]*models.Event),
+		subscribers: make(map[uint]map[*Subscription]bool),
+	}
+}
+
+// Publish assigns event its sequence number and sends it to
+// subscribers of its app. Transient events are not kept in history
+func (b *EventBus) Publish(event *models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Seq = b.seq
+
+	if !event.Transient && b.historySize > 0 {
+		history := append(b.history[event.AppId], event)
+		if len(history) > b.historySize {
+			history = history[len(history)-b.historySize:]
+		}
+		b.history[event.AppId] = history
+	}
+
+	for s := range b.subscribers[event.AppId] {
+		select {
+		case s.C <- event:
+		default:
+			// the subscriber resumes from its last event
+			// once it reconnects
+			delete(b.subscribers[event.AppId], s)
+			close(s.C)
+		}
+	}
+}
+
+// Subscribe subscribes to events of appId. Kept events
+// published after lastSeq are returned, they should be
+// handled before events received on the subscription
+func (b *EventBus) Subscribe(appId uint, lastSeq uint64) (*Subscription, []*models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	missed := make([]*models.Event, 0)
+	if lastSeq > 0 {
+		for _, e := range b.history[appId] {
+			if e.Seq > lastSeq {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	s := &Subscription{C: make(chan *models.Event, subscriptionBuffer), appId: appId}
+	if b.subscribers[appId] == nil {
+		b.subscribers[appId] = make(map[*Subscription]bool)
+	}
+	b.subscribers[appId][s] = true
+
+	return s, missed
+}
+
+// Unsubscribe stops deliveries to s
+func (b *EventBus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[s.appId][s] {
+		delete(b.subscribers[s.appId], s)
+		close(s.C)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"blober.io/models"
+	"testing"
+)
+
+func event(appId uint, eventType string) *models.Event {
+	return &models.Event{AppId: appId, Type: eventType}
+}
+
+func TestEventBus_Publish(t *testing.T) {
+	bus := NewEventBus(10)
+	s, missed := bus.Subscribe(1, 0)
+	if len(missed) != 0 {
+		t.Fatalf("expected no replay without a last event, got %d", len(missed))
+	}
+
+	bus.Publish(event(2, "blob.created")) // another app
+	bus.Publish(event(1, "blob.created"))
+
+	e := <-s.C
+	if e.AppId != 1 || e.Seq == 0 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	select {
+	case e := <-s.C:
+		t.Fatalf("unexpected event of another app %+v", e)
+	default:
+	}
+
+	bus.Unsubscribe(s)
+	if _, ok := <-s.C; ok {
+		t.Fatal("expected subscription to be closed")
+	}
+}
+
+func TestEventBus_Resume(t *testing.T) {
+	bus := NewEventBus(3)
+	first := event(1, "blob.created")
+	bus.Publish(first)
+
+	progress := event(1, "upload.progress")
+	progress.Transient = true
+	bus.Publish(progress)
+
+	for i := 0; i < 2; i++ {
+		bus.Publish(event(1, "blob.deleted"))
+	}
+
+	_, missed := bus.Subscribe(1, first.Seq)
+	if len(missed) != 2 {
+		t.Fatalf("expected 2 missed events, got %d", len(missed))
+	}
+
+	for _, e := range missed {
+		if e.Transient || e.Seq <= first.Seq {
+			t.Fatalf("unexpected replayed event %+v", e)
+		}
+	}
+
+	// only historySize events are kept
+	for i := 0; i < 5; i++ {
+		bus.Publish(event(1, "blob.created"))
+	}
+
+	if _, missed := bus.Subscribe(1, first.Seq); len(missed) != 3 {
+		t.Fatalf("expected 3 kept events, got %d", len(missed))
+	}
+}
+
+func TestEventBus_SlowSubscriber(t *testing.T) {
+	bus := NewEventBus(0)
+	s, _ := bus.Subscribe(1, 0)
+	for i := 0; i <= subscriptionBuffer; i++ {
+		bus.Publish(event(1, "blob.created"))
+	}
+
+	received := 0
+	for range s.C {
+		received++
+	}
+
+	if received != subscriptionBuffer {
+		t.Fatalf("expected %d buffered events before the subscriber was dropped, got %d", subscriptionBuffer, received)
+	}
+
+	// unsubscribing a dropped subscriber is safe
+	bus.Unsubscribe(s)
+}
@@ -0,0 +1,670 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxExtractSize is the largest file metadata
+// would be extracted from
+var MaxExtractSize int64 = 256 << 20
+
+// Extracted holds structured metadata read from a file
+type Extracted struct {
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Duration  float64           `json:"duration"` // seconds
+	PageCount int               `json:"page_count"`
+	Exif      map[string]string `json:"exif"`
+	HasGPS    bool              `json:"has_gps"`
+}
+
+// Extract reads metadata of a file from its content.
+// The parser is chosen using contentType, or filename
+// extension when content type was not detected
+func Extract(contentType, filename string, data []byte) (*Extracted, error) {
+	e := &Extracted{}
+	kind := strings.ToLower(contentType)
+	if kind == "" || kind == "application/octet-stream" || strings.HasPrefix(kind, "text/plain") {
+		kind = kindFromExtension(filename)
+	}
+
+	var err error
+	switch {
+	case strings.HasPrefix(kind, "image/"):
+		config, _, cerr := image.DecodeConfig(bytes.NewReader(data))
+		if cerr != nil {
+			return nil, cerr
+		}
+		e.Width, e.Height = config.Width, config.Height
+		if kind == "image/jpeg" {
+			e.Exif, e.HasGPS = readJPEGExif(data)
+		}
+	case kind == "application/pdf":
+		e.PageCount = pdfPageCount(data)
+	case kind == "video/mp4" || kind == "audio/mp4" || kind == "video/quicktime":
+		e.Duration, err = mp4Duration(data)
+	case kind == "video/webm" || kind == "audio/webm" || kind == "video/x-matroska":
+		e.Duration, err = matroskaDuration(data)
+	case kind == "audio/wave" || kind == "audio/wav" || kind == "audio/x-wav":
+		e.Duration, err = wavDuration(data)
+	case kind == "audio/mpeg":
+		e.Duration, err = mp3Duration(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func kindFromExtension(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".mp4", ".m4a", ".m4v":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".wav":
+		return "audio/wav"
+	case ".pdf":
+		return "application/pdf"
+	}
+
+	return ""
+}
+
+// ---- PDF ----
+
+var pdfPageRegexp = regexp.MustCompile(`/Type\s*/Page[^s]`)
+var pdfCountRegexp = regexp.MustCompile(`/Count\s+(\d+)`)
+
+// pdfPageCount counts page objects. When pages are hidden in
+// compressed object streams, the largest /Count of a page tree is used
+func pdfPageCount(data []byte) int {
+	pages := len(pdfPageRegexp.FindAllIndex(data, -1))
+	for _, m := range pdfCountRegexp.FindAllSubmatch(data, -1) {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && n > pages {
+			pages = n
+		}
+	}
+
+	return pages
+}
+
+// ---- MP4 / QuickTime ----
+
+// mp4Duration reads duration from the movie header(mvhd) box
+func mp4Duration(data []byte) (float64, error) {
+	moov := findBox(data, "moov")
+	if moov == nil {
+		return 0, errors.New("mp4: moov box not found")
+	}
+
+	mvhd := findBox(moov, "mvhd")
+	if mvhd == nil || len(mvhd) < 20 {
+		return 0, errors.New("mp4: mvhd box not found")
+	}
+
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, errors.New("mp4: mvhd box too short")
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0, errors.New("mp4: invalid timescale")
+	}
+
+	return float64(duration) / float64(timescale), nil
+}
+
+// findBox returns the content of the first top level box of kind in data
+func findBox(data []byte, kind string) []byte {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return nil
+		}
+
+		if string(data[4:8]) == kind {
+			return data[header:size]
+		}
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// ---- Matroska / WebM ----
+
+// matroskaDuration reads Segment > Info > Duration, scaled by TimecodeScale
+func matroskaDuration(data []byte) (float64, error) {
+	const (
+		idEBML          = 0x1A45DFA3
+		idSegment       = 0x18538067
+		idInfo          = 0x1549A966
+		idTimecodeScale = 0x2AD7B1
+		idDuration      = 0x4489
+	)
+
+	segment, ok := findElement(data, idSegment, idEBML)
+	if !ok {
+		return 0, errors.New("matroska: segment not found")
+	}
+
+	info, ok := findElement(segment, idInfo)
+	if !ok {
+		return 0, errors.New("matroska: info not found")
+	}
+
+	scale := 1000000.0 // default, nanoseconds per tick
+	if v, ok := findElement(info, idTimecodeScale); ok {
+		var n uint64
+		for _, b := range v {
+			n = n<<8 | uint64(b)
+		}
+		scale = float64(n)
+	}
+
+	v, ok := findElement(info, idDuration)
+	if !ok {
+		return 0, errors.New("matroska: duration not found")
+	}
+
+	var ticks float64
+	switch len(v) {
+	case 4:
+		ticks = float64(math.Float32frombits(binary.BigEndian.Uint32(v)))
+	case 8:
+		ticks = math.Float64frombits(binary.BigEndian.Uint64(v))
+	default:
+		return 0, errors.New("matroska: invalid duration")
+	}
+
+	return ticks * scale / 1e9, nil
+}
+
+// findElement returns the content of the first element with id
+// among the elements of data, skipping elements listed in skip.
+// Elements of unknown size(e.g live segments) extend to the end of data
+func findElement(data []byte, id uint64, skip ...uint64) ([]byte, bool) {
+	for len(data) > 0 {
+		eid, n := readVint(data, false)
+		if n == 0 {
+			return nil, false
+		}
+		data = data[n:]
+
+		size, m := readVint(data, true)
+		if m == 0 {
+			return nil, false
+		}
+		data = data[m:]
+
+		if size == math.MaxUint64 || size > uint64(len(data)) {
+			size = uint64(len(data))
+		}
+
+		if eid == id {
+			return data[:size], true
+		}
+		data = data[size:]
+	}
+
+	return nil, false
+}
+
+// readVint reads an EBML variable length integer. Ids keep their
+// length marker, sizes do not. All ones sizes are reported as unknown
+func readVint(data []byte, isSize bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+
+	if length > 8 || len(data) < length {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if isSize {
+		value &= uint64(0xFF >> uint(length))
+	}
+
+	allOnes := value == uint64(0xFF>>uint(length))
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(data[i])
+		allOnes = allOnes && data[i] == 0xFF
+	}
+
+	if isSize && allOnes {
+		return math.MaxUint64, length
+	}
+
+	return value, length
+}
+
+// ---- WAV ----
+
+// wavDuration divides the size of the data chunk by the byte rate
+func wavDuration(data []byte) (float64, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return 0, errors.New("wav: not a RIFF WAVE file")
+	}
+
+	var byteRate uint32
+	chunks := data[12:]
+	for len(chunks) >= 8 {
+		id := string(chunks[0:4])
+		size := binary.LittleEndian.Uint32(chunks[4:8])
+		body := chunks[8:]
+
+		switch id {
+		case "fmt ":
+			if len(body) < 12 {
+				return 0, errors.New("wav: fmt chunk too short")
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("wav: data chunk before fmt chunk")
+			}
+			return float64(size) / float64(byteRate), nil
+		}
+
+		// chunks are word aligned
+		next := uint64(size) + uint64(size%2)
+		if next > uint64(len(body)) {
+			break
+		}
+		chunks = body[next:]
+	}
+
+	return 0, errors.New("wav: data chunk not found")
+}
+
+// ---- MP3 ----
+
+var mp3Bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1 layer III
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2/2.5 layer III
+}
+
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+// mp3Duration reads the frame count of a Xing/Info header,
+// or estimates duration from the bitrate of the first frame
+func mp3Duration(data []byte) (float64, error) {
+	offset := 0
+	// skip ID3v2 tag
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+		offset = 10 + size
+	}
+
+	// find the first frame sync
+	for ; offset+4 <= len(data); offset++ {
+		if data[offset] == 0xFF && data[offset+1]&0xE0 == 0xE0 {
+			break
+		}
+	}
+
+	if offset+4 > len(data) {
+		return 0, errors.New("mp3: frame not found")
+	}
+
+	header := data[offset : offset+4]
+	version := (header[1] >> 3) & 0x03
+	layer := (header[1] >> 1) & 0x03
+	if version == 1 || layer != 1 {
+		return 0, errors.New("mp3: not an MPEG layer III stream")
+	}
+
+	table := 1
+	samplesPerFrame := 576
+	if version == 3 {
+		table = 0
+		samplesPerFrame = 1152
+	}
+
+	bitrate := mp3Bitrates[table][header[2]>>4] * 1000
+	rateIndex := (header[2] >> 2) & 0x03
+	if bitrate == 0 || rateIndex == 3 {
+		return 0, errors.New("mp3: invalid frame header")
+	}
+	sampleRate := mp3SampleRates[version][rateIndex]
+
+	// Xing/Info header follows side information
+	mono := header[3]>>6 == 3
+	side := 32
+	switch {
+	case version == 3 && mono:
+		side = 17
+	case version != 3 && mono:
+		side = 9
+	case version != 3:
+		side = 17
+	}
+
+	xing := offset + 4 + side
+	if xing+12 <= len(data) {
+		tag := string(data[xing : xing+4])
+		flags := binary.BigEndian.Uint32(data[xing+4 : xing+8])
+		if (tag == "Xing" || tag == "Info") && flags&0x01 != 0 {
+			frames := binary.BigEndian.Uint32(data[xing+8 : xing+12])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+		}
+	}
+
+	// constant bitrate estimate
+	return float64(len(data)-offset) * 8 / float64(bitrate), nil
+}
+
+// ---- EXIF ----
+
+var exifTagNames = map[uint16]string{
+	0x010F: "Make", 0x0110: "Model", 0x0112: "Orientation", 0x0131: "Software",
+	0x0132: "DateTime", 0x9003: "DateTimeOriginal", 0x829A: "ExposureTime",
+	0x829D: "FNumber", 0x8827: "ISOSpeedRatings", 0x920A: "FocalLength", 0xA434: "LensModel",
+}
+
+const (
+	exifIFDPointer = 0x8769
+	gpsIFDPointer  = 0x8825
+)
+
+// tiff holds a TIFF structure read from an EXIF segment
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// exifSegment returns the position of the TIFF structure
+// inside the EXIF APP1 segment of a JPEG, or -1
+func exifSegment(data []byte) (int, int) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return -1, 0
+	}
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return -1, 0
+		}
+
+		marker := data[i+1]
+		// start of scan, image data follows
+		if marker == 0xDA || marker == 0xD9 {
+			return -1, 0
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return -1, 0
+		}
+
+		if marker == 0xE1 && length >= 8 && string(data[i+4:i+10]) == "Exif\x00\x00" {
+			return i + 10, end
+		}
+		i = end
+	}
+
+	return -1, 0
+}
+
+func newTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, errors.New("exif: short tiff header")
+	}
+
+	t := &tiff{data: data}
+	switch string(data[0:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid byte order")
+	}
+
+	return t, nil
+}
+
+// ifdEntry is a single directory entry of a TIFF IFD
+type ifdEntry struct {
+	pos    int // position of the 12 bytes entry
+	tag    uint16
+	kind   uint16
+	count  uint32
+	offset int // position of the value
+	size   int // size of the value
+}
+
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// entries reads the entries of the IFD at offset
+func (t *tiff) entries(offset int) []ifdEntry {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil
+	}
+
+	n := int(t.order.Uint16(t.data[offset:]))
+	entries := make([]ifdEntry, 0, n)
+	for i := 0; i < n; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+
+		e := ifdEntry{pos: pos, tag: t.order.Uint16(t.data[pos:]), kind: t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:])}
+		e.size = tiffTypeSizes[e.kind] * int(e.count)
+		e.offset = pos + 8
+		if e.size > 4 {
+			e.offset = int(t.order.Uint32(t.data[pos+8:]))
+		}
+
+		// entries without a single value can not be read
+		if e.size < tiffTypeSizes[e.kind] || e.offset+e.size > len(t.data) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// value formats the value of e as a string
+func (t *tiff) value(e ifdEntry) string {
+	v := t.data[e.offset : e.offset+e.size]
+	switch e.kind {
+	case 2:
+		return strings.TrimRight(string(v), "\x00 ")
+	case 3:
+		return strconv.Itoa(int(t.order.Uint16(v)))
+	case 4:
+		return strconv.FormatUint(uint64(t.order.Uint32(v)), 10)
+	case 9:
+		return strconv.Itoa(int(int32(t.order.Uint32(v))))
+	case 5, 10:
+		return strconv.FormatFloat(t.rational(e, 0), 'f', -1, 64)
+	}
+
+	return ""
+}
+
+// rational returns the i-th rational value of e
+func (t *tiff) rational(e ifdEntry, i int) float64 {
+	pos := e.offset + i*8
+	if pos+8 > len(t.data) {
+		return 0
+	}
+
+	num, den := t.order.Uint32(t.data[pos:]), t.order.Uint32(t.data[pos+4:])
+	if e.kind == 10 {
+		if den == 0 {
+			return 0
+		}
+		return float64(int32(num)) / float64(int32(den))
+	}
+
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// readJPEGExif reads common EXIF tags and GPS position of a JPEG
+func readJPEGExif(data []byte) (map[string]string, bool) {
+	start, end := exifSegment(data)
+	if start < 0 {
+		return nil, false
+	}
+
+	t, err := newTIFF(data[start:end])
+	if err != nil {
+		return nil, false
+	}
+
+	tags := make(map[string]string)
+	hasGPS := false
+	ifd0 := int(t.order.Uint32(t.data[4:8]))
+	for _, e := range t.entries(ifd0) {
+		switch e.tag {
+		case exifIFDPointer:
+			for _, sub := range t.entries(int(t.order.Uint32(t.data[e.offset:]))) {
+				if name, ok := exifTagNames[sub.tag]; ok {
+					tags[name] = t.value(sub)
+				}
+			}
+		case gpsIFDPointer:
+			hasGPS = t.readGPS(int(t.order.Uint32(t.data[e.offset:])), tags)
+		default:
+			if name, ok := exifTagNames[e.tag]; ok {
+				tags[name] = t.value(e)
+			}
+		}
+	}
+
+	return tags, hasGPS
+}
+
+// readGPS reads latitude and longitude as decimal degrees
+func (t *tiff) readGPS(offset int, tags map[string]string) bool {
+	refs := map[uint16]string{}
+	coords := map[uint16]float64{}
+	for _, e := range t.entries(offset) {
+		switch e.tag {
+		case 1, 3:
+			refs[e.tag] = t.value(e)
+		case 2, 4:
+			if e.kind == 5 && e.count == 3 {
+				coords[e.tag] = t.rational(e, 0) + t.rational(e, 1)/60 + t.rational(e, 2)/3600
+			}
+		}
+	}
+
+	lat, okLat := coords[2]
+	lng, okLng := coords[4]
+	if !okLat || !okLng {
+		return false
+	}
+
+	if refs[1] == "S" {
+		lat = -lat
+	}
+	if refs[3] == "W" {
+		lng = -lng
+	}
+
+	tags["GPSLatitude"] = fmt.Sprintf("%.6f", lat)
+	tags["GPSLongitude"] = fmt.Sprintf("%.6f", lng)
+	return true
+}
+
+// StripGPS removes GPS information from the EXIF segment of a JPEG.
+// Values and entries of the GPS directory are zeroed in place, so the
+// rest of the file is untouched. It reports whether anything was removed
+func StripGPS(data []byte) ([]byte, bool) {
+	start, end := exifSegment(data)
+	if start < 0 {
+		return data, false
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	t, err := newTIFF(out[start:end])
+	if err != nil {
+		return data, false
+	}
+
+	ifd0 := int(t.order.Uint32(t.data[4:8]))
+	for _, e := range t.entries(ifd0) {
+		if e.tag != gpsIFDPointer {
+			continue
+		}
+
+		gps := int(t.order.Uint32(t.data[e.offset:]))
+		entries := t.entries(gps)
+		if len(entries) == 0 {
+			return data, false
+		}
+
+		for _, g := range entries {
+			zero(t.data[g.offset : g.offset+g.size])
+			zero(t.data[g.pos : g.pos+12])
+		}
+		t.order.PutUint16(t.data[gps:], 0)
+		return out, true
+	}
+
+	return data, false
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
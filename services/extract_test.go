@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+// exifJPEG returns a jpeg carrying an EXIF segment with
+// Make=Canon and a GPS position of 52.5N, 13.25W
+func exifJPEG(t *testing.T) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	tiff := make([]byte, 146)
+	copy(tiff, "II*\x00")
+	le.PutUint32(tiff[4:], 8)
+
+	entry := func(pos int, tag, kind uint16, count, value uint32) {
+		le.PutUint16(tiff[pos:], tag)
+		le.PutUint16(tiff[pos+2:], kind)
+		le.PutUint32(tiff[pos+4:], count)
+		le.PutUint32(tiff[pos+8:], value)
+	}
+	rationals := func(pos int, values ...uint32) {
+		for i, v := range values {
+			le.PutUint32(tiff[pos+i*8:], v)
+			le.PutUint32(tiff[pos+i*8+4:], 1)
+		}
+	}
+
+	// IFD0: Make and GPS pointer
+	le.PutUint16(tiff[8:], 2)
+	entry(10, 0x010F, 2, 6, 38)
+	entry(22, gpsIFDPointer, 4, 1, 44)
+	copy(tiff[38:], "Canon\x00")
+
+	// GPS IFD
+	le.PutUint16(tiff[44:], 4)
+	entry(46, 1, 2, 2, 'N')
+	entry(58, 2, 5, 3, 98)
+	entry(70, 3, 2, 2, 'W')
+	entry(82, 4, 5, 3, 122)
+	rationals(98, 52, 30, 0)
+	rationals(122, 13, 15, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	data := img.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestExtractImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Extract("image/png", "a.png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Width != 30 || e.Height != 20 {
+		t.Errorf("expected 30x20, got %dx%d", e.Width, e.Height)
+	}
+}
+
+func TestExtractExif(t *testing.T) {
+	e, err := Extract("image/jpeg", "a.jpg", exifJPEG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Width != 8 || e.Height != 4 {
+		t.Errorf("expected 8x4, got %dx%d", e.Width, e.Height)
+	}
+
+	if e.Exif["Make"] != "Canon" {
+		t.Errorf("expected Make Canon, got %q", e.Exif["Make"])
+	}
+
+	if !e.HasGPS || e.Exif["GPSLatitude"] != "52.500000" || e.Exif["GPSLongitude"] != "-13.250000" {
+		t.Errorf("unexpected gps position %v", e.Exif)
+	}
+}
+
+func TestExtractExifEmptyEntry(t *testing.T) {
+	// Make as a short(kind 3) with no value,
+	// the tiff header starts at byte 12
+	data := exifJPEG(t)
+	binary.LittleEndian.PutUint16(data[12+12:], 3)
+	binary.LittleEndian.PutUint32(data[12+14:], 0)
+
+	e, err := Extract("image/jpeg", "a.jpg", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := e.Exif["Make"]; ok || !e.HasGPS {
+		t.Errorf("expected the empty entry to be skipped, got %v", e.Exif)
+	}
+}
+
+func TestStripGPS(t *testing.T) {
+	data := exifJPEG(t)
+	stripped, ok := StripGPS(data)
+	if !ok {
+		t.Fatal("expected gps to be stripped")
+	}
+
+	if len(stripped) != len(data) {
+		t.Errorf("expected size to be kept, got %d want %d", len(stripped), len(data))
+	}
+
+	e, err := Extract("image/jpeg", "a.jpg", stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.HasGPS || e.Exif["GPSLatitude"] != "" {
+		t.Errorf("expected no gps position, got %v", e.Exif)
+	}
+
+	if e.Exif["Make"] != "Canon" {
+		t.Errorf("expected other tags to be kept, got %v", e.Exif)
+	}
+
+	if _, ok := StripGPS(stripped); ok {
+		t.Error("expected nothing to strip")
+	}
+}
+
+func TestExtractPDF(t *testing.T) {
+	pdf := "%PDF-1.4\n1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] /Count 2 >> endobj\n" +
+		"2 0 obj << /Type /Page /Parent 1 0 R >> endobj\n3 0 obj << /Type/Page /Parent 1 0 R >> endobj\n"
+	e, err := Extract("application/pdf", "a.pdf", []byte(pdf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.PageCount != 2 {
+		t.Errorf("expected 2 pages, got %d", e.PageCount)
+	}
+}
+
+func box(kind string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], kind)
+	return append(b, body...)
+}
+
+func TestExtractMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)  // timescale
+	binary.BigEndian.PutUint32(mvhd[16:], 12500) // duration
+	data := append(box("ftyp", []byte("isom")), box("moov", box("mvhd", mvhd))...)
+
+	e, err := Extract("video/mp4", "a.mp4", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Duration != 12.5 {
+		t.Errorf("expected 12.5s, got %v", e.Duration)
+	}
+}
+
+func TestExtractWebM(t *testing.T) {
+	// Duration of 3500 ticks, TimecodeScale of 1ms per tick
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(3500))
+	info := append([]byte{0x2A, 0xD7, 0xB1, 0x83, 0x0F, 0x42, 0x40}, append([]byte{0x44, 0x89, 0x88}, duration...)...)
+	segment := append([]byte{0x15, 0x49, 0xA9, 0x66, byte(0x80 | len(info))}, info...)
+
+	// empty EBML header followed by a segment of unknown size
+	data := []byte{0x1A, 0x45, 0xDF, 0xA3, 0x80}
+	data = append(data, 0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	data = append(data, segment...)
+
+	e, err := Extract("video/webm", "a.webm", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Duration != 3.5 {
+		t.Errorf("expected 3.5s, got %v", e.Duration)
+	}
+}
+
+func TestExtractWAV(t *testing.T) {
+	le := binary.LittleEndian
+	data := make([]byte, 44)
+	copy(data, "RIFF")
+	copy(data[8:], "WAVEfmt ")
+	le.PutUint32(data[16:], 16)
+	le.PutUint32(data[28:], 16000) // byte rate
+	copy(data[36:], "data")
+	le.PutUint32(data[40:], 40000)
+
+	e, err := Extract("audio/wave", "a.wav", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Duration != 2.5 {
+		t.Errorf("expected 2.5s, got %v", e.Duration)
+	}
+}
+
+func TestExtractMP3(t *testing.T) {
+	// MPEG-1 layer III, 128kbps, 44.1kHz, stereo, constant bitrate
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	data := bytes.Repeat(frame, 100)
+
+	e, err := Extract("application/octet-stream", "song.mp3", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := float64(len(data)) * 8 / 128000
+	if math.Abs(e.Duration-expected) > 0.001 {
+		t.Errorf("expected %vs, got %v", expected, e.Duration)
+	}
+
+	// Xing header with a frame count
+	xing := make([]byte, 417)
+	copy(xing, []byte{0xFF, 0xFB, 0x90, 0x00})
+	copy(xing[36:], "Xing")
+	binary.BigEndian.PutUint32(xing[40:], 1)
+	binary.BigEndian.PutUint32(xing[44:], 1000)
+
+	e, err = Extract("audio/mpeg", "song.mp3", xing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = 1000 * 1152 / 44100.0
+	if math.Abs(e.Duration-expected) > 0.001 {
+		t.Errorf("expected %vs, got %v", expected, e.Duration)
+	}
+}
+
+func TestExtractInvalid(t *testing.T) {
+	if _, err := Extract("video/mp4", "a.mp4", []byte("not a movie")); err == nil {
+		t.Error("expected an error for invalid mp4")
+	}
+
+	e, err := Extract("text/plain; charset=utf-8", "notes.txt", []byte(strings.Repeat("a", 10)))
+	if err != nil || e.Width != 0 || e.Duration != 0 {
+		t.Errorf("expected nothing to be extracted, got %+v, %v", e, err)
+	}
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxRedirects is the number of redirects followed by an import
+var maxRedirects = 5
+
+// blockedNetworks are private, loopback, link local and otherwise
+// internal ranges, urls resolving to them are not fetched
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// ErrBlockedAddress is returned for urls resolving to internal addresses
+var ErrBlockedAddress = errors.New("url resolves to a private or loopback address")
+
+// ErrFetchTooLarge is returned for remote files larger than the size limit of the Fetcher
+var ErrFetchTooLarge = errors.New("remote file is larger than the size limit")
+
+// FetchError is returned when the remote server does not respond with 2xx
+type FetchError struct {
+	StatusCode int
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("remote server responded with %d", e.StatusCode)
+}
+
+// Temporary reports whether fetching again may succeed
+func (e *FetchError) Temporary() bool {
+	return e.StatusCode == 408 || e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// RemoteFile is a file fetched from a remote url. Its body is
+// streamed from the remote server, it must be closed
+type RemoteFile struct {
+	URL         string
+	Filename    string
+	ContentType string
+	Size        int64     // -1 when the server did not send it, see Spool
+	Body        io.Reader // at most the size limit of the Fetcher
+
+	resp  io.Closer
+	spool *os.File // holds the body once spooled
+}
+
+// Spool writes a body of unknown size to a temporary file, so its
+// size is known before it is stored. Bodies of known size are left as they are
+func (f *RemoteFile) Spool() error {
+	if f.Size >= 0 {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile("", "blober-fetch")
+	if err != nil {
+		return err
+	}
+	f.spool = tmp
+
+	n, err := io.Copy(tmp, f.Body)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f.Size, f.Body = n, tmp
+	return nil
+}
+
+// Close closes the response and removes the spooled body
+func (f *RemoteFile) Close() error {
+	if f.spool != nil {
+		f.spool.Close()
+		if err := os.Remove(f.spool.Name()); err != nil {
+			log.Printf("failed to remove spooled body %s, %v", f.spool.Name(), err)
+		}
+	}
+
+	return f.resp.Close()
+}
+
+// Fetcher fetches remote files, refusing urls resolving to
+// internal addresses unless their host or network is allowlisted
+type Fetcher struct {
+	client       *http.Client
+	transport    *http.Transport // dials checked addresses only
+	allowedHosts map[string]bool
+	allowed      []*net.IPNet
+	maxSize      int64 // bytes of a fetched file
+}
+
+// FetcherOption holds the internal hosts a Fetcher may
+// fetch from and the bounds of fetched files
+type FetcherOption struct {
+	// Allowlist entries are hostnames, IP addresses or CIDR
+	// networks allowed even when they are internal
+	Allowlist []string
+	MaxSize   int64         // bytes of a fetched file
+	Timeout   time.Duration // time a single fetch may take, body included
+}
+
+// NewFetcher creates a Fetcher from passed FetcherOption
+func NewFetcher(opt *FetcherOption) (*Fetcher, error) {
+	f := &Fetcher{allowedHosts: make(map[string]bool), maxSize: opt.MaxSize}
+	for _, entry := range opt.Allowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowlist network %s", entry)
+			}
+			f.allowed = append(f.allowed, network)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			f.allowed = append(f.allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			f.allowedHosts[entry] = true
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	f.transport = &http.Transport{
+		// no proxy, addresses are checked when dialing
+		Proxy:                 nil,
+		DialContext:           f.dialContext(dialer),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	f.client = &http.Client{Transport: f.transport, Timeout: opt.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return checkScheme(req.URL)
+		}}
+
+	return f, nil
+}
+
+// dialContext resolves the host itself and dials a checked IP,
+// a host can not resolve to another address between check and dial
+func (f *Fetcher) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.allowedHosts[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if !f.allowedIP(addr.IP) {
+				continue
+			}
+
+			return dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		}
+
+		return nil, ErrBlockedAddress
+	}
+}
+
+// CheckURL reports whether rawurl is an http or https url
+// whose host resolves to an address that can be fetched from
+func (f *Fetcher) CheckURL(ctx context.Context, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	if err := checkScheme(u); err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if f.allowedHosts[strings.ToLower(host)] {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if f.allowedIP(addr.IP) {
+			return nil
+		}
+	}
+
+	return ErrBlockedAddress
+}
+
+// allowedIP reports whether ip can be fetched from
+func (f *Fetcher) allowedIP(ip net.IP) bool {
+	for _, network := range f.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Fetch requests rawurl, its body is read from the returned file.
+// progress, when not nil, is called as the body is read with the bytes
+// received so far and the expected size, -1 when the server did not send it
+func (f *Fetcher) Fetch(ctx context.Context, rawurl string, progress func(received, total int64)) (*RemoteFile, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkScheme(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "blober.io-import")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		// the dial error is wrapped by the client
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == ErrBlockedAddress {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &FetchError{StatusCode: resp.StatusCode}
+	}
+
+	if resp.ContentLength > f.maxSize {
+		resp.Body.Close()
+		return nil, ErrFetchTooLarge
+	}
+
+	var body io.Reader = &fetchLimit{r: resp.Body, remaining: f.maxSize}
+	if progress != nil {
+		body = &fetchProgress{r: body, total: resp.ContentLength, fn: progress}
+	}
+
+	return &RemoteFile{URL: resp.Request.URL.String(), Filename: remoteFilename(resp),
+		ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength, Body: body, resp: resp.Body}, nil
+}
+
+// remoteFilename returns the filename of a response, from its
+// Content-Disposition header or the last segment of its url
+func remoteFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.Replace(params["filename"], "\\", "/", -1)); params["filename"] != "" && name != "/" && name != "." {
+			return name
+		}
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if name == "/" || name == "." || name == "" {
+		return resp.Request.URL.Hostname()
+	}
+
+	return name
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q, expected http or https", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.New("url has no host")
+	}
+
+	return nil
+}
+
+// fetchLimit fails with ErrFetchTooLarge once more
+// than remaining bytes are read from r
+type fetchLimit struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *fetchLimit) Read(b []byte) (int, error) {
+	if l.remaining <= 0 {
+		// a single byte tells whether the body ended
+		n, err := l.r.Read(make([]byte, 1))
+		if n > 0 {
+			return 0, ErrFetchTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(b)) > l.remaining {
+		b = b[:l.remaining]
+	}
+
+	n, err := l.r.Read(b)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// fetchProgress reports bytes read at most every fetchProgressInterval
+type fetchProgress struct {
+	r        io.Reader
+	received int64
+	total    int64
+	last     time.Time
+	fn       func(received, total int64)
+}
+
+// fetchProgressInterval is the minimum time between two progress reports
+var fetchProgressInterval = time.Second
+
+func (p *fetchProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.received += int64(n)
+	if err == io.EOF || time.Since(p.last) >= fetchProgressInterval {
+		p.last = time.Now()
+		p.fn(p.received, p.total)
+	}
+
+	return n, err
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newOrigin() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/report.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "quarterly report")
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../../photo.jpg"`)
+		fmt.Fprint(w, "jpeg")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/files/report.txt", http.StatusFound)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 2048))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// flushed chunks are sent without Content-Length
+		for i := 0; i < 4; i++ {
+			fmt.Fprint(w, strings.Repeat("x", 512))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})
+	return httptest.NewServer(mux)
+}
+
+// fetcherOption returns the option of a Fetcher allowed to fetch from allowlist
+func fetcherOption(allowlist ...string) *FetcherOption {
+	return &FetcherOption{Allowlist: allowlist, MaxSize: 512 << 20, Timeout: time.Minute}
+}
+
+func TestFetchBlocksInternalAddresses(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	f, err := NewFetcher(fetcherOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest listens on loopback
+	_, err = f.Fetch(context.Background(), origin.URL+"/files/report.txt", nil)
+	if err != ErrBlockedAddress {
+		t.Errorf("expected loopback origin to be blocked, got %v", err)
+	}
+
+	for _, u := range []string{"http://169.254.169.254/latest/meta-data", "http://10.0.0.1/", "http://[::1]/"} {
+		if _, err := f.Fetch(context.Background(), u, nil); err != ErrBlockedAddress {
+			t.Errorf("expected %s to be blocked, got %v", u, err)
+		}
+	}
+
+	for _, u := range []string{"file:///etc/passwd", "gopher://example.com/", "http:///path"} {
+		if _, err := f.Fetch(context.Background(), u, nil); err == nil {
+			t.Errorf("expected %s to be refused", u)
+		}
+	}
+}
+
+func TestFetchAllowlisted(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	for _, allowlist := range [][]string{{"127.0.0.1"}, {"127.0.0.0/8"}} {
+		f, err := NewFetcher(fetcherOption(allowlist...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var received, total int64
+		file, err := f.Fetch(context.Background(), origin.URL+"/redirect", func(r, t int64) {
+			received, total = r, t
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(file.Body)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "quarterly report" || file.Filename != "report.txt" || file.Size != int64(len(data)) {
+			t.Errorf("unexpected file %s of %d bytes %q", file.Filename, file.Size, data)
+		}
+
+		if received != int64(len(data)) || total != int64(len(data)) {
+			t.Errorf("expected progress to report %d bytes, got %d of %d", len(data), received, total)
+		}
+
+		file, err = f.Fetch(context.Background(), origin.URL+"/download", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		if file.Filename != "photo.jpg" {
+			t.Errorf("expected Content-Disposition filename without directories, got %s", file.Filename)
+		}
+	}
+
+	if _, err := NewFetcher(fetcherOption("10.0.0.0/33")); err == nil {
+		t.Error("expected invalid allowlist network to be refused")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	f, _ := NewFetcher(fetcherOption("127.0.0.1"))
+	_, err := f.Fetch(context.Background(), origin.URL+"/missing", nil)
+	if fetchErr, ok := err.(*FetchError); !ok || fetchErr.StatusCode != 404 || fetchErr.Temporary() {
+		t.Errorf("expected permanent 404 error, got %v", err)
+	}
+
+	_, err = f.Fetch(context.Background(), origin.URL+"/unavailable", nil)
+	if fetchErr, ok := err.(*FetchError); !ok || !fetchErr.Temporary() {
+		t.Errorf("expected temporary 503 error, got %v", err)
+	}
+
+	opt := fetcherOption("127.0.0.1")
+	opt.MaxSize = 1024
+	f, _ = NewFetcher(opt)
+
+	if _, err := f.Fetch(context.Background(), origin.URL+"/large", nil); err != ErrFetchTooLarge {
+		t.Errorf("expected ErrFetchTooLarge, got %v", err)
+	}
+
+	// bodies without Content-Length are refused once they are too large
+	file, err := f.Fetch(context.Background(), origin.URL+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := file.Spool(); err != ErrFetchTooLarge {
+		t.Errorf("expected ErrFetchTooLarge, got %v", err)
+	}
+}
+
+func TestRemoteFile_Spool(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	f, _ := NewFetcher(fetcherOption("127.0.0.1"))
+	file, err := f.Fetch(context.Background(), origin.URL+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.Size != -1 {
+		t.Fatalf("expected an unknown size, found %d", file.Size)
+	}
+
+	if err := file.Spool(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(file.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.Size != 2048 || len(data) != 2048 {
+		t.Fatalf("expected 2048 spooled bytes, found %d of %d", len(data), file.Size)
+	}
+
+	// the spooled body is removed with the file
+	name := file.spool.Name()
+	file.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", name, err)
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"blober.io/models"
+	"github.com/minio/minio-go"
+	"strings"
+	"time"
+)
+
+// StoredObject is an object of an app's bucket
+type StoredObject struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+}
+
+// Buckets returns the names of every bucket
+func (service *StorageService) Buckets() ([]string, error) {
+	buckets, err := service.client.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(buckets))
+	for _, b := range buckets {
+		names = append(names, b.Name)
+	}
+
+	return names, nil
+}
+
+// BucketExists reports whether the bucket of app exists
+func (service *StorageService) BucketExists(app *models.App) (bool, error) {
+	return service.client.BucketExists(strings.ToLower(app.UniqueId()))
+}
+
+// EachObject calls fn with every object of app's bucket,
+// listing stops at the first error returned by fn
+func (service *StorageService) EachObject(app *models.App, fn func(obj *StoredObject) error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	for info := range service.client.ListObjectsV2(strings.ToLower(app.UniqueId()), "", true, done) {
+		if info.Err != nil {
+			return info.Err
+		}
+
+		if err := fn(&StoredObject{Name: info.Key, Size: info.Size, LastModified: info.LastModified}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EachCachedBlob calls fn with every blob of the blob
+// cache and its key, see CacheKey
+func (service *StorageService) EachCachedBlob(fn func(key string, blob models.Blob) error) error {
+	return service.store.Each(fn)
+}
+
+// UncacheKey removes the cache entry key
+func (service *StorageService) UncacheKey(key string) error {
+	return service.store.Delete(key)
+}
+
+// ObjectExists reports whether app's bucket holds the object name
+func (service *StorageService) ObjectExists(app *models.App, name string) (bool, error) {
+	_, err := service.client.StatObject(strings.ToLower(app.UniqueId()), name, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"blober.io/models"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultMaxAttempts is the number of times a job
+// is tried before it is moved to the dead letter list
+var defaultMaxAttempts = 5
+
+// baseBackoff and maxBackoff bound the delay
+// between attempts of a failed job
+var baseBackoff = 5 * time.Second
+var maxBackoff = 1 * time.Hour
+
+// ErrUnknownJob is recorded on jobs no handler is registered for
+var ErrUnknownJob = errors.New("no handler registered for job kind")
+
+// JobHandler processes a job. Returning an error schedules a retry
+type JobHandler func(job *models.Job) error
+
+// JobQueue is a durable, Postgres backed job queue.
+// Jobs are claimed with row locks, so many workers
+// (and many blober.io instances) can share a queue
+type JobQueue struct {
+	db       *gorm.DB
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	wake     chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup // workers and the recovery loop
+
+	pollInterval      time.Duration
+	claimTimeout      time.Duration
+	heartbeatInterval time.Duration
+}
+
+// JobQueueOption holds the time intervals of a JobQueue
+type JobQueueOption struct {
+	PollInterval time.Duration // time at which idle workers check for due jobs
+
+	// ClaimTimeout is how long a job stays claimed without heartbeat,
+	// running jobs not refreshed since were left by a stopped worker
+	ClaimTimeout      time.Duration
+	HeartbeatInterval time.Duration // time at which the claim of a running job is refreshed
+}
+
+// NewJobQueue creates a new JobQueue from passed JobQueueOption
+func NewJobQueue(db *gorm.DB, opt *JobQueueOption) *JobQueue {
+	return &JobQueue{db: db, handlers: make(map[string]JobHandler), wake: make(chan struct{}, 1),
+		done: make(chan struct{}), pollInterval: opt.PollInterval, claimTimeout: opt.ClaimTimeout,
+		heartbeatInterval: opt.HeartbeatInterval}
+}
+
+// Register sets the handler of jobs of kind
+func (q *JobQueue) Register(kind string, handler JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue persists job, it is run as soon as a worker is free
+func (q *JobQueue) Enqueue(job *models.Job) error {
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = defaultMaxAttempts
+	}
+
+	if err := q.db.Create(job).Error; err != nil {
+		log.Printf("failed to enqueue %s job, %v", job.Kind, err)
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Start starts n workers and recovers jobs left running by stopped
+// workers. Other replicas may be running jobs, only stale claims are
+// recovered, periodically
+func (q *JobQueue) Start(n int) {
+	q.wg.Add(n + 1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(q.claimTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			if err := q.recoverStale(); err != nil {
+				log.Printf("failed to recover running jobs, %v", err)
+			}
+
+			select {
+			case <-q.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		go q.work()
+	}
+}
+
+// Stop stops the workers, it returns once the jobs
+// they are running are done
+func (q *JobQueue) Stop() {
+	close(q.done)
+	q.wg.Wait()
+}
+
+// recoverStale moves jobs whose claim was not refreshed for
+// the claim timeout back to the queue, they will never complete
+func (q *JobQueue) recoverStale() error {
+	return q.db.Model(&models.Job{}).
+		Where("status = ? AND updated_at < ?", models.JobRunning, time.Now().Add(-q.claimTimeout)).
+		UpdateColumn("status", models.JobPending).Error
+}
+
+// Retry moves a dead job back to the queue
+func (q *JobQueue) Retry(job *models.Job) error {
+	if job.Status != models.JobDead {
+		return errors.New("only dead jobs can be retried")
+	}
+
+	err := q.db.Model(job).UpdateColumns(map[string]interface{}{
+		"status": models.JobPending, "attempts": 0, "run_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain due jobs before waiting
+		for !q.stopped() && q.runNext() {
+		}
+
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// stopped reports whether Stop was called
+func (q *JobQueue) stopped() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// heartbeat refreshes the claim of job until stop is closed,
+// so jobs running longer than the claim timeout are not recovered
+func (q *JobQueue) heartbeat(job *models.Job, stop chan struct{}) {
+	ticker := time.NewTicker(q.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := q.db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, models.JobRunning).
+				UpdateColumn("updated_at", time.Now()).Error
+			if err != nil {
+				log.Printf("failed to refresh job %d, %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// runNext claims and runs a single due job.
+// It returns false when no job was due
+func (q *JobQueue) runNext() bool {
+	job := &models.Job{}
+	err := q.db.Raw(`UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE status = ? AND run_at <= ? AND deleted_at IS NULL
+		ORDER BY run_at LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING *`,
+		models.JobRunning, time.Now(), models.JobPending, time.Now()).Scan(job).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			log.Printf("failed to claim job, %v", err)
+		}
+		return false
+	}
+
+	if job.ID == 0 {
+		return false
+	}
+
+	stop := make(chan struct{})
+	go q.heartbeat(job, stop)
+	err = q.run(job)
+	close(stop)
+
+	columns := map[string]interface{}{"status": models.JobDone, "last_error": ""}
+	if err != nil {
+		log.Printf("%s job %d failed, attempt %d of %d, %v", job.Kind, job.ID, job.Attempts, job.MaxAttempts, err)
+		columns["last_error"] = err.Error()
+		if job.Attempts >= job.MaxAttempts || err == ErrUnknownJob {
+			columns["status"] = models.JobDead
+		} else {
+			columns["status"] = models.JobPending
+			columns["run_at"] = time.Now().Add(Backoff(job.Attempts))
+		}
+	}
+
+	if err := q.db.Model(job).UpdateColumns(columns).Error; err != nil {
+		log.Printf("failed to update job %d, %v", job.ID, err)
+	}
+
+	return true
+}
+
+// run calls the handler of job, recovering from panics
+func (q *JobQueue) run(job *models.Job) (err error) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked, %v", r)
+		}
+	}()
+
+	return handler(job)
+}
+
+// Backoff returns the delay before the next attempt
+// of a job that failed attempts times
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"blober.io/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/joho/godotenv"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i, d := range expected {
+		if b := Backoff(i + 1); b != d {
+			t.Fatalf("attempt %d: expected %v, found %v", i+1, d, b)
+		}
+	}
+
+	if b := Backoff(100); b != maxBackoff {
+		t.Fatalf("expected backoff to be capped at %v, found %v", maxBackoff, b)
+	}
+}
+
+// newTestQueue returns a queue over a schema of its own, so jobs
+// of other tests and servers are never claimed. The schema is
+// dropped by the returned func
+func newTestQueue(t *testing.T) (*JobQueue, func()) {
+	godotenv.Load("../.env")
+	uri := os.Getenv("DATABASE_URL")
+	if uri == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open("postgres", uri)
+	if err != nil {
+		t.Skipf("database is not available, %v", err)
+	}
+
+	schema := fmt.Sprintf("queue_test_%d", time.Now().UnixNano())
+	if err := db.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	drop := func() {
+		if err := db.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+			t.Errorf("failed to drop schema %s, %v", schema, err)
+		}
+		db.Close()
+	}
+
+	// unknown connection parameters are sent as run-time parameters
+	if strings.Contains(uri, "://") {
+		if strings.Contains(uri, "?") {
+			uri += "&search_path=" + schema
+		} else {
+			uri += "?search_path=" + schema
+		}
+	} else {
+		uri += " search_path=" + schema
+	}
+
+	queueDB, err := gorm.Open("postgres", uri)
+	if err != nil {
+		drop()
+		t.Fatal(err)
+	}
+
+	if err := queueDB.AutoMigrate(&models.Job{}).Error; err != nil {
+		queueDB.Close()
+		drop()
+		t.Fatal(err)
+	}
+
+	opt := &JobQueueOption{PollInterval: 2 * time.Second, ClaimTimeout: time.Hour, HeartbeatInterval: 5 * time.Minute}
+	return NewJobQueue(queueDB, opt), func() {
+		queueDB.Close()
+		drop()
+	}
+}
+
+// loadJob reloads job from the database
+func loadJob(t *testing.T, q *JobQueue, job *models.Job) *models.Job {
+	found := &models.Job{}
+	if err := q.db.First(found, job.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
+
+func TestJobQueue_ClaimSkipsLockedJobs(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	runs := 0
+	q.Register("test", func(job *models.Job) error {
+		runs++
+		return nil
+	})
+
+	job := models.NewJob("test", 1, 1, "")
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	// a job locked by another worker is skipped, not waited for
+	tx := q.db.Begin()
+	if err := tx.Exec("SELECT id FROM jobs WHERE id = ? FOR UPDATE", job.ID).Error; err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+
+	if q.runNext() {
+		tx.Rollback()
+		t.Fatal("expected the locked job to be skipped")
+	}
+	tx.Rollback()
+
+	if !q.runNext() || runs != 1 {
+		t.Fatalf("expected the job to run once unlocked, ran %d times", runs)
+	}
+
+	if found := loadJob(t, q, job); found.Status != models.JobDone || found.Attempts != 1 {
+		t.Fatalf("expected a done job after 1 attempt, found %s after %d", found.Status, found.Attempts)
+	}
+}
+
+func TestJobQueue_ClaimOnce(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	var mu sync.Mutex
+	runs := make(map[uint]int)
+	q.Register("test", func(job *models.Job) error {
+		mu.Lock()
+		runs[job.ID]++
+		mu.Unlock()
+		return nil
+	})
+
+	for i := 0; i < 20; i++ {
+		if err := q.Enqueue(models.NewJob("test", 1, uint(i), "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// concurrent workers never claim the same job
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q.runNext() {
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(runs) != 20 {
+		t.Fatalf("expected 20 jobs to run, %d ran", len(runs))
+	}
+
+	for id, n := range runs {
+		if n != 1 {
+			t.Fatalf("job %d ran %d times", id, n)
+		}
+	}
+}
+
+func TestJobQueue_Retry(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	attempts := 0
+	q.Register("test", func(job *models.Job) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		return nil
+	})
+
+	job := models.NewJob("test", 1, 1, "")
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	if !q.runNext() {
+		t.Fatal("expected the job to run")
+	}
+
+	// a failed job is retried after a backoff
+	found := loadJob(t, q, job)
+	if found.Status != models.JobPending || found.LastError != "attempt 1 failed" || !found.RunAt.After(time.Now()) {
+		t.Fatalf("expected a pending job retried later, found %+v", found)
+	}
+
+	if q.runNext() {
+		t.Fatal("expected the job not to run before its backoff")
+	}
+
+	if err := q.db.Model(found).UpdateColumn("run_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if !q.runNext() {
+		t.Fatal("expected the job to be retried")
+	}
+
+	if found := loadJob(t, q, job); found.Status != models.JobDone || found.Attempts != 2 || found.LastError != "" {
+		t.Fatalf("expected a done job after 2 attempts, found %+v", found)
+	}
+}
+
+func TestJobQueue_DeadLetter(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	q.Register("test", func(job *models.Job) error {
+		return fmt.Errorf("always fails")
+	})
+
+	job := models.NewJob("test", 1, 1, "")
+	job.MaxAttempts = 2
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < job.MaxAttempts; i++ {
+		if err := q.db.Model(job).UpdateColumn("run_at", time.Now()).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if !q.runNext() {
+			t.Fatalf("expected attempt %d to run", i+1)
+		}
+	}
+
+	// jobs failing every attempt are kept dead
+	found := loadJob(t, q, job)
+	if found.Status != models.JobDead || found.Attempts != 2 {
+		t.Fatalf("expected a dead job after 2 attempts, found %s after %d", found.Status, found.Attempts)
+	}
+
+	if q.runNext() {
+		t.Fatal("expected dead jobs not to run")
+	}
+
+	if err := q.Retry(found); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := loadJob(t, q, job); found.Status != models.JobPending || found.Attempts != 0 {
+		t.Fatalf("expected a retried job to be pending, found %s after %d", found.Status, found.Attempts)
+	}
+
+	if err := q.Retry(loadJob(t, q, job)); err == nil {
+		t.Fatal("expected only dead jobs to be retried")
+	}
+
+	// jobs of unknown kinds are dead at once
+	unknown := models.NewJob("unknown", 1, 1, "")
+	if err := q.Enqueue(unknown); err != nil {
+		t.Fatal(err)
+	}
+
+	for q.runNext() {
+	}
+
+	if found := loadJob(t, q, unknown); found.Status != models.JobDead || found.LastError != ErrUnknownJob.Error() {
+		t.Fatalf("expected a dead job, found %+v", found)
+	}
+}
+
+func TestJobQueue_RecoverStale(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	stale := models.NewJob("test", 1, 1, "")
+	running := models.NewJob("test", 1, 2, "")
+	for _, job := range []*models.Job{stale, running} {
+		job.Status = models.JobRunning
+		if err := q.Enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only jobs claimed before the claim timeout were left by a stopped
+	// worker, the others may be running on another replica
+	err := q.db.Model(stale).UpdateColumn("updated_at", time.Now().Add(-2*q.claimTimeout)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.recoverStale(); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := loadJob(t, q, stale); found.Status != models.JobPending {
+		t.Fatalf("expected the stale job to be pending, found %s", found.Status)
+	}
+
+	if found := loadJob(t, q, running); found.Status != models.JobRunning {
+		t.Fatalf("expected the running job to be left running, found %s", found.Status)
+	}
+}
+
+func TestJobQueue_Heartbeat(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	q.heartbeatInterval = 20 * time.Millisecond
+
+	job := models.NewJob("test", 1, 1, "")
+	q.Register("test", func(running *models.Job) error {
+		// jobs running longer than the claim timeout are not recovered
+		claimed := loadJob(t, q, job).UpdatedAt
+		time.Sleep(100 * time.Millisecond)
+		if !loadJob(t, q, job).UpdatedAt.After(claimed) {
+			return fmt.Errorf("expected the claim to be refreshed")
+		}
+		return nil
+	})
+
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	if !q.runNext() {
+		t.Fatal("expected the job to run")
+	}
+
+	if found := loadJob(t, q, job); found.Status != models.JobDone {
+		t.Fatalf("expected a done job, found %s, %s", found.Status, found.LastError)
+	}
+}
+
+func TestJobQueue_Stop(t *testing.T) {
+	q, closeQueue := newTestQueue(t)
+	defer closeQueue()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	q.Register("test", func(job *models.Job) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	job := models.NewJob("test", 1, 1, "")
+	if err := q.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Start(2)
+	<-started
+
+	// Stop returns once running jobs are done
+	stopped := make(chan struct{})
+	go func() {
+		q.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("expected Stop to wait for the running job")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+
+	if found := loadJob(t, q, job); found.Status != models.JobDone {
+		t.Fatalf("expected a done job, found %s", found.Status)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// ScanResult is the outcome of scanning a file
+type ScanResult struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature"` // name of the detected malware
+}
+
+// Scanner scans uploaded files for malware
+type Scanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+// NoopScanner reports every file as clean
+type NoopScanner struct{}
+
+// Scan implements Scanner
+func (NoopScanner) Scan(r io.Reader) (*ScanResult, error) {
+	return &ScanResult{}, nil
+}
+
+// eicar is the EICAR anti-malware test file
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// EicarScanner only detects the EICAR test signature.
+// It is useful to test quarantine without a real scanner
+type EicarScanner struct{}
+
+// Scan implements Scanner
+func (EicarScanner) Scan(r io.Reader) (*ScanResult, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte(eicar)) {
+		return &ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+
+	return &ScanResult{}, nil
+}
+
+// clamdChunkSize is the size of chunks streamed to clamd
+var clamdChunkSize = 64 << 10
+
+// ClamdScanner scans files with a ClamAV daemon
+// using the INSTREAM command of the clamd protocol
+type ClamdScanner struct {
+	Network string // tcp or unix
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner creates a ClamdScanner. address is either
+// host:port or the path of clamd's unix socket
+func NewClamdScanner(address string) *ClamdScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+
+	return &ClamdScanner{Network: network, Address: address, Timeout: 2 * time.Minute}
+}
+
+// Scan implements Scanner
+func (s *ClamdScanner) Scan(r io.Reader) (*ScanResult, error) {
+	conn, err := net.DialTimeout(s.Network, s.Address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if s.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+
+	// each chunk is prefixed by its length as a 4 bytes
+	// big endian integer, a zero length chunk ends the stream
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses replies like
+// "stream: OK" and "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*ScanResult, error) {
+	reply = strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case reply == "OK":
+		return &ScanResult{}, nil
+	case strings.HasSuffix(reply, "FOUND"):
+		return &ScanResult{Infected: true, Signature: strings.TrimSpace(strings.TrimSuffix(reply, "FOUND"))}, nil
+	case strings.HasSuffix(reply, "ERROR"):
+		return nil, fmt.Errorf("clamd error, %s", strings.TrimSpace(strings.TrimSuffix(reply, "ERROR")))
+	default:
+		return nil, errors.New("unexpected clamd reply " + reply)
+	}
+}
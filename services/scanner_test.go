@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeClamd serves the clamd INSTREAM command on a local
+// listener and reports streams containing EICAR as infected
+func fakeClamd(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var data bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+						return
+					}
+				}
+
+				if bytes.Contains(data.Bytes(), []byte(eicar)) {
+					conn.Write([]byte("stream: Win.Test.EICAR_HDB-1 FOUND\x00"))
+					return
+				}
+				conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+
+	return l
+}
+
+func TestClamdScanner_Clean(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+
+	scanner := NewClamdScanner(l.Addr().String())
+	result, err := scanner.Scan(strings.NewReader(strings.Repeat("clean file ", 20000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Infected {
+		t.Fatal("expected file to be clean")
+	}
+}
+
+func TestClamdScanner_Infected(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+
+	scanner := NewClamdScanner(l.Addr().String())
+	result, err := scanner.Scan(strings.NewReader("prefix " + eicar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Infected || result.Signature != "Win.Test.EICAR_HDB-1" {
+		t.Fatalf("expected infected file, found %+v", result)
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if _, err := parseClamdReply("stream: INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Fatal("expected clamd error")
+	}
+}
+
+func TestEicarScanner(t *testing.T) {
+	result, err := EicarScanner{}.Scan(strings.NewReader(eicar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Infected {
+		t.Fatal("expected EICAR to be detected")
+	}
+
+	result, _ = EicarScanner{}.Scan(strings.NewReader("hello"))
+	if result.Infected {
+		t.Fatal("expected file to be clean")
+	}
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4ChunkAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+	amzDateFormat       = "20060102T150405Z"
+
+	UnsignedPayload  = "UNSIGNED-PAYLOAD"
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// emptySHA256 is the hex encoded sha256 of an empty payload
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// MaxSigV4Skew is how far the date of a signed request can be from now
+var MaxSigV4Skew = 15 * time.Minute
+
+// maxPresignExpiry is the longest validity of a presigned url
+var maxPresignExpiry = 7 * 24 * time.Hour
+
+// maxChunkSize bounds chunks of streaming uploads, they are buffered to be verified
+var maxChunkSize = 16 << 20
+
+var (
+	// ErrNotSigned is returned for requests without a SigV4 signature
+	ErrNotSigned = errors.New("request is not signed with AWS Signature Version 4")
+
+	// ErrSignatureMismatch is returned when the signature of a request is wrong
+	ErrSignatureMismatch = errors.New("the request signature does not match the signature computed with the secret key")
+
+	// ErrRequestExpired is returned for signed requests
+	// too far from now, or presigned urls past their expiry
+	ErrRequestExpired = errors.New("the request is expired or not valid yet")
+
+	// ErrBadDigest is returned when a payload does not match its signed hash
+	ErrBadDigest = errors.New("the payload does not match its signed sha256")
+)
+
+// SigV4 is the AWS Signature Version 4 authentication of a request,
+// sent in the Authorization header or in the query of presigned urls
+type SigV4 struct {
+	AccessKey     string
+	Date          time.Time
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+	PayloadHash   string
+
+	presigned bool
+	expires   time.Duration
+	scope     string
+	amzDate   string
+}
+
+// ParseSigV4 parses the signature of r, ErrNotSigned is
+// returned when r is not signed with Signature Version 4
+func ParseSigV4(r *http.Request) (*SigV4, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresigned(query)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+		return nil, ErrNotSigned
+	}
+
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+
+	s := &SigV4{Signature: fields["Signature"], PayloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+		amzDate: r.Header.Get("X-Amz-Date")}
+	if s.PayloadHash == "" {
+		return nil, errors.New("missing x-amz-content-sha256 header")
+	}
+
+	if err := s.parse(fields["Credential"], fields["SignedHeaders"]); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func parsePresigned(query url.Values) (*SigV4, error) {
+	if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", query.Get("X-Amz-Algorithm"))
+	}
+
+	s := &SigV4{Signature: query.Get("X-Amz-Signature"), PayloadHash: UnsignedPayload,
+		amzDate: query.Get("X-Amz-Date"), presigned: true}
+	if hash := query.Get("X-Amz-Content-Sha256"); hash != "" {
+		s.PayloadHash = hash
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 {
+		return nil, errors.New("invalid X-Amz-Expires")
+	}
+	s.expires = time.Duration(expires) * time.Second
+	if s.expires > maxPresignExpiry {
+		return nil, errors.New("presigned urls can not be valid for more than 7 days")
+	}
+
+	if err := s.parse(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// parse parses the credential scope, signed headers and date of s
+func (s *SigV4) parse(credential, signedHeaders string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return errors.New("invalid credential scope")
+	}
+
+	s.AccessKey, s.Region, s.Service = parts[0], parts[2], parts[3]
+	s.scope = strings.Join(parts[1:], "/")
+
+	if signedHeaders == "" || s.Signature == "" {
+		return errors.New("missing signed headers or signature")
+	}
+	s.SignedHeaders = strings.Split(signedHeaders, ";")
+
+	date, err := time.Parse(amzDateFormat, s.amzDate)
+	if err != nil {
+		return errors.New("invalid or missing x-amz-date")
+	}
+	s.Date = date
+
+	if parts[1] != date.Format("20060102") {
+		return errors.New("credential scope date does not match x-amz-date")
+	}
+
+	return nil
+}
+
+// Verify checks the signature of r against secret at time now. The
+// body of r is replaced by a reader checking the signed payload hash,
+// streaming uploads are decoded and every chunk is verified
+func (s *SigV4) Verify(r *http.Request, secret string, now time.Time) error {
+	if s.presigned {
+		if now.Before(s.Date.Add(-MaxSigV4Skew)) || now.After(s.Date.Add(s.expires)) {
+			return ErrRequestExpired
+		}
+	} else if now.Sub(s.Date) > MaxSigV4Skew || s.Date.Sub(now) > MaxSigV4Skew {
+		return ErrRequestExpired
+	}
+
+	key := s.signingKey(secret)
+	stringToSign := strings.Join([]string{sigV4Algorithm, s.amzDate, s.scope,
+		sha256Hex([]byte(s.canonicalRequest(r)))}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(s.Signature)) {
+		return ErrSignatureMismatch
+	}
+
+	switch s.PayloadHash {
+	case UnsignedPayload:
+	case StreamingPayload:
+		size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			return errors.New("invalid x-amz-decoded-content-length")
+		}
+
+		r.ContentLength = size
+		r.Body = &chunkedReader{body: r.Body, r: bufio.NewReader(r.Body), key: key,
+			amzDate: s.amzDate, scope: s.scope, previous: s.Signature}
+	default:
+		expected, err := hex.DecodeString(s.PayloadHash)
+		if err != nil || len(expected) != sha256.Size {
+			return fmt.Errorf("unsupported x-amz-content-sha256 %s", s.PayloadHash)
+		}
+
+		r.Body = &payloadReader{ReadCloser: r.Body, hash: sha256.New(), expected: expected}
+	}
+
+	return nil
+}
+
+// Presign returns rawurl presigned for method with the access key and secret,
+// valid for expires after now. Only the host header is signed and the payload
+// is unsigned, like presigned urls of S3 SDKs
+func Presign(method, rawurl, accessKey, secret, region string, now time.Time, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > maxPresignExpiry {
+		return "", errors.New("presigned urls must be valid between a second and 7 days")
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	now = now.UTC()
+	s := &SigV4{AccessKey: accessKey, Date: now, Region: region, Service: "s3", SignedHeaders: []string{"host"},
+		PayloadHash: UnsignedPayload, presigned: true, amzDate: now.Format(amzDateFormat),
+		scope: now.Format("20060102") + "/" + region + "/s3/aws4_request"}
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", accessKey+"/"+s.scope)
+	query.Set("X-Amz-Date", s.amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = query.Encode()
+
+	r := &http.Request{Method: method, URL: u, Host: u.Host, Header: make(http.Header)}
+	stringToSign := strings.Join([]string{sigV4Algorithm, s.amzDate, s.scope,
+		sha256Hex([]byte(s.canonicalRequest(r)))}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + hex.EncodeToString(hmacSHA256(s.signingKey(secret), stringToSign))
+
+	return u.String(), nil
+}
+
+// canonicalRequest builds the canonical form of r
+func (s *SigV4) canonicalRequest(r *http.Request) string {
+	uri := uriEncode(r.URL.Path, false)
+	if uri == "" {
+		uri = "/"
+	}
+
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if s.presigned && k == "X-Amz-Signature" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	headers := make([]string, len(s.SignedHeaders))
+	for i, name := range s.SignedHeaders {
+		headers[i] = name + ":" + canonicalHeaderValue(r, name)
+	}
+
+	return strings.Join([]string{r.Method, uri, strings.Join(pairs, "&"),
+		strings.Join(headers, "\n") + "\n", strings.Join(s.SignedHeaders, ";"), s.PayloadHash}, "\n")
+}
+
+func (s *SigV4) signingKey(secret string) []byte {
+	parts := strings.Split(s.scope, "/")
+	key := hmacSHA256([]byte("AWS4"+secret), parts[0])
+	key = hmacSHA256(key, parts[1])
+	key = hmacSHA256(key, parts[2])
+	return hmacSHA256(key, parts[3])
+}
+
+// canonicalHeaderValue returns the trimmed values of header name of r
+func canonicalHeaderValue(r *http.Request, name string) string {
+	switch name {
+	case "host":
+		return r.Host
+	case "content-length":
+		if r.Header.Get("Content-Length") == "" {
+			return strconv.FormatInt(r.ContentLength, 10)
+		}
+	}
+
+	values := r.Header[http.CanonicalHeaderKey(name)]
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.Join(strings.Fields(v), " ")
+	}
+
+	return strings.Join(trimmed, ",")
+}
+
+// uriEncode percent encodes every byte of s except
+// unreserved characters, and slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadReader fails reading at EOF when the
+// payload does not match its signed hash
+type payloadReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.hash.Write(b[:n])
+	if err == io.EOF && !hmac.Equal(p.hash.Sum(nil), p.expected) {
+		return n, ErrBadDigest
+	}
+
+	return n, err
+}
+
+// chunkedReader decodes aws-chunked bodies of streaming
+// uploads, verifying the signature of every chunk
+type chunkedReader struct {
+	body     io.Closer
+	r        *bufio.Reader
+	key      []byte
+	amzDate  string
+	scope    string
+	previous string // signature of the previous chunk
+	chunk    []byte
+	done     bool
+	err      error
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		if c.done {
+			return 0, io.EOF
+		}
+
+		c.err = c.next()
+	}
+
+	n := copy(b, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads and verifies the next chunk
+func (c *chunkedReader) next() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	header := strings.SplitN(strings.TrimRight(line, "\r\n"), ";chunk-signature=", 2)
+	if len(header) != 2 {
+		return errors.New("malformed chunk header")
+	}
+
+	size, err := strconv.ParseInt(header[0], 16, 64)
+	if err != nil || size < 0 || size > int64(maxChunkSize) {
+		return errors.New("invalid chunk size")
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	crlf := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, crlf); err != nil || !bytes.Equal(crlf, []byte("\r\n")) {
+		return errors.New("malformed chunk")
+	}
+
+	stringToSign := strings.Join([]string{sigV4ChunkAlgorithm, c.amzDate, c.scope, c.previous,
+		emptySHA256, sha256Hex(data)}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(header[1])) {
+		return ErrSignatureMismatch
+	}
+
+	c.previous = header[1]
+	c.chunk = data
+	c.done = size == 0
+	return nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
@@ -3,101 +3,297 @@ package services
 import (
 	"blober.io/models"
 	"blober.io/store"
+	"bufio"
 	"bytes"
 	"crypto/md5"
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/minio/minio-go"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
 )
 
 // StorageService encaps interaction with minio server
-// and a badgerDB backed blob structs store
+// and a store of blob structs
 type StorageService struct {
-	client *minio.Client
-	store  *store.BlobStore
+	client    *minio.Client
+	store     store.BlobStore
+	loader    BlobLoader // nil when misses are not loaded
+	region    string     // region buckets are created in
+	publicURL string     // download urls of blobs start with it
 }
 
+// BlobLoader loads a downloadable blob missing from the blob
+// store from the database, it returns nil when there is none
+type BlobLoader func(appName, hash string) (*models.Blob, error)
+
 // StorageServiceOption holds minio setup access keys
-// and a handle to BlobStore pointer
+// and the BlobStore caching blob structs
 type StorageServiceOption struct {
 	AccessKey string
 	SecretKey string
 	Host      string
-	Store     *store.BlobStore
+	UseTLS    bool
+	Region    string
+	PublicURL string
+	Store     store.BlobStore
 }
 
 // NewStorageService creates a new StorageService from passed
 // StorageServiceOption
 func NewStorageService(opt *StorageServiceOption) (*StorageService, error) {
-	client, err := minio.New(opt.Host, opt.AccessKey, opt.SecretKey, false)
+	client, err := minio.New(opt.Host, opt.AccessKey, opt.SecretKey, opt.UseTLS)
 	if err != nil {
 		return nil, err
 	}
 
-	return &StorageService{client: client, store: opt.Store}, nil
+	return &StorageService{client: client, store: opt.Store, region: opt.Region, publicURL: opt.PublicURL}, nil
+}
+
+// newBlob creates a blob downloaded under publicURL
+func (service *StorageService) newBlob(hash, contentType string, app *models.App, size int64) *models.Blob {
+	blob := models.NewBlob(hash, contentType, app, size)
+	blob.PopulateDownloadURL(service.publicURL)
+	return blob
 }
 
 // CreateBucketForApp creates a minio bucket for a created app
 func (service *StorageService) CreateBucketForApp(app *models.App) error {
-	location := "us-east-1"
 	bucketName := strings.ToLower(app.UniqueId())
-	return service.client.MakeBucket(bucketName, location)
+	return service.client.MakeBucket(bucketName, service.region)
+}
+
+// RemoveBucketForApp removes the minio bucket of a deleted app,
+// the bucket must be empty
+func (service *StorageService) RemoveBucketForApp(app *models.App) error {
+	return service.client.RemoveBucket(strings.ToLower(app.UniqueId()))
 }
 
-// UploadBlob sends a file to a minio server
-func (service *StorageService) UploadBlob(app *models.App, isPrivate bool, body io.Reader) (*models.Blob, error) {
+// UploadBlob streams size bytes of body to a minio server, they are
+// stored under hash. The returned blob is neither cached nor saved
+func (service *StorageService) UploadBlob(app *models.App, hash string, isPrivate bool, body io.Reader, size int64) (*models.Blob, error) {
 	bucketName := strings.ToLower(app.UniqueId())
-	fileName := randomMD5()
 
-	var buf bytes.Buffer
-	n, err := io.Copy(&buf, body)
+	r := bufio.NewReaderSize(body, sniffLen)
+	contentType := SniffContentType(r)
+
+	h := md5.New()
+	n, err := service.client.PutObject(bucketName, hash, io.TeeReader(r, h), size,
+		minio.PutObjectOptions{ContentType: contentType})
 	if err != nil {
-		log.Printf("failed to copy file %v", err)
 		return nil, err
 	}
 
-	contentType := http.DetectContentType(buf.Bytes())
-	size, err := service.client.PutObject(bucketName, fileName, body, n,
-		minio.PutObjectOptions{ContentType: contentType})
-	if err != nil {
+	if err := drain(r); err != nil {
+		if rerr := service.client.RemoveObject(bucketName, hash); rerr != nil {
+			log.Printf("failed to remove object %s, %v", hash, rerr)
+		}
 		return nil, err
 	}
 
-	blob := models.NewBlob(fileName, contentType, app, size)
+	blob := service.newBlob(hash, contentType, app, n)
 	blob.IsPrivate = isPrivate
-	key := fmt.Sprintf("%s%s", bucketName, fileName)
+	blob.ETag = fmt.Sprintf("%x", h.Sum(nil))
+	return blob, nil
+}
+
+// sniffLen is the number of bytes used to detect a content type
+const sniffLen = 512
 
-	// cache blob struct
-	err = service.store.Set(key, blob)
+// SniffContentType detects the content type of the
+// first bytes of r, they are not consumed
+func SniffContentType(r *bufio.Reader) string {
+	data, _ := r.Peek(sniffLen)
+	return http.DetectContentType(data)
+}
+
+// drain reads r to EOF once its expected size was read. Readers
+// verifying their content, e.g signed payloads, report a mismatch
+// at EOF only. A body larger than its size is refused
+func drain(r io.Reader) error {
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return errors.New("body is larger than its declared size")
+	}
+
+	return nil
+}
+
+// UploadBytes stores data under hash in app's bucket.
+// The returned blob is neither cached nor saved
+func (service *StorageService) UploadBytes(app *models.App, hash string, data []byte, contentType string) (*models.Blob, error) {
+	bucketName := strings.ToLower(app.UniqueId())
+	size, err := service.client.PutObject(bucketName, hash, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
 	if err != nil {
-		log.Printf("failed to cache blob, %v", err)
 		return nil, err
 	}
 
+	blob := service.newBlob(hash, contentType, app, size)
+	blob.ETag = fmt.Sprintf("%x", md5.Sum(data))
 	return blob, nil
 }
 
+// ReplaceObject overwrites the stored object of blob
+// with data and updates its ETag. The cached blob is left untouched
+func (service *StorageService) ReplaceObject(blob *models.Blob, data []byte) error {
+	bucketName := strings.ToLower(blob.AppName)
+	_, err := service.client.PutObject(bucketName, blob.Hash, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: blob.ContentType})
+	if err != nil {
+		return err
+	}
+
+	blob.ETag = fmt.Sprintf("%x", md5.Sum(data))
+	return nil
+}
+
+// CopyBlob copies the stored object of blob into app's bucket under
+// hash, the copy is done by minio server. The returned blob
+// is a copy of blob, it is neither cached nor saved
+func (service *StorageService) CopyBlob(blob *models.Blob, app *models.App, hash string) (*models.Blob, error) {
+	src := minio.NewSourceInfo(strings.ToLower(blob.AppName), blob.Hash, nil)
+	dst, err := minio.NewDestinationInfo(strings.ToLower(app.UniqueId()), hash, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := service.client.CopyObject(dst, src); err != nil {
+		return nil, err
+	}
+
+	copied := service.newBlob(hash, blob.ContentType, app, blob.Size)
+	copied.IsPrivate = blob.IsPrivate
+	copied.ETag = blob.ETag
+	copied.Filename = blob.Filename
+	copied.Key = blob.Key
+	copied.Metadata = blob.Metadata
+	copied.Tags = blob.Tags
+	return copied, nil
+}
+
+// PutObject streams size bytes of r to an object named name
+// in app's bucket, the md5 of the content is returned. It is
+// used for objects that are not blobs yet, e.g upload parts
+func (service *StorageService) PutObject(app *models.App, name string, r io.Reader, size int64) (string, error) {
+	h := md5.New()
+	_, err := service.client.PutObject(strings.ToLower(app.UniqueId()), name, io.TeeReader(r, h), size,
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return "", err
+	}
+
+	if err := drain(r); err != nil {
+		if rerr := service.RemoveObject(app, name); rerr != nil {
+			log.Printf("failed to remove object %s, %v", name, rerr)
+		}
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// RemoveObject removes the object named name from app's bucket
+func (service *StorageService) RemoveObject(app *models.App, name string) error {
+	return service.client.RemoveObject(strings.ToLower(app.UniqueId()), name)
+}
+
+// ComposeBlob concatenates objects of app's bucket into an object
+// stored under hash, the concatenation is done by minio server. Every object
+// but the last must be at least 5MiB. The returned blob is neither cached nor saved
+func (service *StorageService) ComposeBlob(app *models.App, hash string, names []string, size int64, contentType string) (*models.Blob, error) {
+	bucketName := strings.ToLower(app.UniqueId())
+	srcs := make([]minio.SourceInfo, len(names))
+	for i, name := range names {
+		srcs[i] = minio.NewSourceInfo(bucketName, name, nil)
+	}
+
+	dst, err := minio.NewDestinationInfo(bucketName, hash, nil, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := service.client.ComposeObject(dst, srcs); err != nil {
+		return nil, err
+	}
+
+	return service.newBlob(hash, contentType, app, size), nil
+}
+
+// UncacheBlob removes blob struct from blobStore,
+// the blob can no longer be downloaded
+func (service *StorageService) UncacheBlob(blob *models.Blob) error {
+	return service.store.Delete(CacheKey(blob.AppName, blob.Hash))
+}
+
+// RemoveBlob removes blob's stored object and cached struct
+func (service *StorageService) RemoveBlob(blob *models.Blob) error {
+	if err := service.UncacheBlob(blob); err != nil {
+		log.Printf("failed to remove cached blob, %v", err)
+	}
+
+	// delete markers have no stored object
+	if blob.IsDeleteMarker {
+		return nil
+	}
+
+	return service.client.RemoveObject(strings.ToLower(blob.AppName), blob.Hash)
+}
+
+// CacheBlob puts or replaces blob struct in blobStore
+func (service *StorageService) CacheBlob(blob *models.Blob) error {
+	return service.store.Set(CacheKey(blob.AppName, blob.Hash), blob)
+}
+
 // GetFile download a file from minio server
 func (service *StorageService) GetFile(appName, hash string) (io.Reader, error) {
 	bucketName := strings.ToLower(appName)
 	return service.client.GetObject(bucketName, hash, minio.GetObjectOptions{})
 }
 
-// GetBlob gets a blob struct from blobStore
+// GetBlob gets a blob struct from blobStore, blobs missing
+// from it are loaded with the blob loader and cached
 func (service *StorageService) GetBlob(appName, hash string) (models.Blob, error) {
-	key := fmt.Sprintf("%s%s", strings.ToLower(appName), hash)
-	return service.store.Get(key)
+	key := CacheKey(appName, hash)
+	if service.loader == nil {
+		return service.store.Get(key)
+	}
+
+	return service.store.GetOrLoad(key, func() (*models.Blob, error) {
+		return service.loader(appName, hash)
+	})
+}
+
+// CachedBlob gets a blob struct from blobStore only
+func (service *StorageService) CachedBlob(appName, hash string) (models.Blob, error) {
+	return service.store.Get(CacheKey(appName, hash))
+}
+
+// PartialCache reports whether blobStore may miss downloadable blobs,
+// they are loaded on download when it is read-through, evicted when it is bounded
+func (service *StorageService) PartialCache() bool {
+	return service.loader != nil || service.store.Stats().MaxEntries > 0
+}
+
+// SetBlobLoader sets the loader of blobs missing from blobStore
+func (service *StorageService) SetBlobLoader(loader BlobLoader) {
+	service.loader = loader
+}
+
+// CacheStats returns the counters of blobStore
+func (service *StorageService) CacheStats() store.CacheStats {
+	return service.store.Stats()
 }
 
-// randomMD5 generates a unique
-// md5 hashed UUID
-func randomMD5() string {
-	s := uuid.New().String()
-	m5 := md5.New()
-	m5.Write([]byte(s))
-	return fmt.Sprintf("%x", m5.Sum(nil))
+// CacheKey returns the blobStore key of a blob,
+// bucket name + hash
+func CacheKey(appName, hash string) string {
+	return fmt.Sprintf("%s%s", strings.ToLower(appName), hash)
 }
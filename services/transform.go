@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// MaxTransformDimension is the largest width or height
+// a transformed image can have
+var MaxTransformDimension = 4096
+
+// MaxSourcePixels is the largest image(width * height)
+// that would be decoded for transformation
+var MaxSourcePixels = 50 * 1000 * 1000
+
+// MaxSourceSize is the largest stored image
+// that would be read for transformation
+var MaxSourceSize int64 = 64 << 20
+
+// transformSlots bounds the number of images decoded and
+// transformed at the same time, one per cpu
+var transformSlots = make(chan struct{}, runtime.NumCPU())
+
+// defaultQuality is the jpeg quality used when none is requested
+var defaultQuality = 85
+
+const (
+	FitContain = "contain" // fit inside width x height, keeps aspect ratio
+	FitCover   = "cover"   // cover width x height then crop the overflow
+	FitFill    = "fill"    // stretch to exactly width x height
+	FitInside  = "inside"  // like contain, but never upscales
+)
+
+// ErrNotAnImage is returned when a transformation
+// is requested on a blob that is not a supported image
+var ErrNotAnImage = errors.New("transformations are only supported on jpeg, png, gif and webp images")
+
+// ErrSourceTooLarge is returned when the image to transform is larger
+// than MaxSourceSize bytes or MaxSourcePixels pixels
+var ErrSourceTooLarge = errors.New("image is too large to be transformed")
+
+// transformableTypes are content types that can be transformed
+var transformableTypes = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+}
+
+// IsTransformable reports whether blobs of contentType
+// can be transformed
+func IsTransformable(contentType string) bool {
+	return transformableTypes[strings.ToLower(contentType)]
+}
+
+// Transformation describes how an image is derived
+// from an original. Crop is applied first, then resize
+type Transformation struct {
+	Width   int
+	Height  int
+	Crop    *image.Rectangle
+	Fit     string
+	Format  string // jpeg, png or gif. Defaults to the source format
+	Quality int    // jpeg quality, 1 - 100
+}
+
+// ParseTransformation reads a transformation from query
+// parameters w, h, crop(x,y,width,height), fit, format and q.
+// nil is returned when no transformation is requested
+func ParseTransformation(query url.Values) (*Transformation, error) {
+	t := &Transformation{}
+	requested := false
+
+	var err error
+	if v := query.Get("w"); v != "" {
+		requested = true
+		if t.Width, err = parseDimension("w", v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("h"); v != "" {
+		requested = true
+		if t.Height, err = parseDimension("h", v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("crop"); v != "" {
+		requested = true
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return nil, errors.New("crop must be in the form x,y,width,height")
+		}
+
+		values := make([]int, 4)
+		for i, p := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || n < 0 {
+				return nil, errors.New("crop values must be positive integers")
+			}
+			values[i] = n
+		}
+
+		if values[2] == 0 || values[3] == 0 {
+			return nil, errors.New("crop width and height can not be zero")
+		}
+
+		rect := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+		t.Crop = &rect
+	}
+
+	if v := query.Get("fit"); v != "" {
+		requested = true
+		switch v {
+		case FitContain, FitCover, FitFill, FitInside:
+			t.Fit = v
+		default:
+			return nil, fmt.Errorf("unknown fit mode %s", v)
+		}
+	}
+
+	if v := query.Get("format"); v != "" {
+		requested = true
+		switch strings.ToLower(v) {
+		case "jpeg", "jpg":
+			t.Format = "jpeg"
+		case "png", "gif":
+			t.Format = strings.ToLower(v)
+		default:
+			return nil, fmt.Errorf("unsupported output format %s", v)
+		}
+	}
+
+	if v := query.Get("q"); v != "" {
+		requested = true
+		q, err := strconv.Atoi(v)
+		if err != nil || q < 1 || q > 100 {
+			return nil, errors.New("quality must be between 1 and 100")
+		}
+		t.Quality = q
+	}
+
+	if !requested {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+func parseDimension(name, v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+
+	if n > MaxTransformDimension {
+		return 0, fmt.Errorf("%s can not be larger than %d", name, MaxTransformDimension)
+	}
+
+	return n, nil
+}
+
+// String returns the normalized form of t. Two transformations
+// that produce the same image have the same string
+func (t *Transformation) String() string {
+	parts := make([]string, 0)
+	if t.Crop != nil {
+		parts = append(parts, fmt.Sprintf("crop=%d,%d,%d,%d", t.Crop.Min.X, t.Crop.Min.Y, t.Crop.Dx(), t.Crop.Dy()))
+	}
+
+	if t.Width > 0 {
+		parts = append(parts, fmt.Sprintf("w=%d", t.Width))
+	}
+
+	if t.Height > 0 {
+		parts = append(parts, fmt.Sprintf("h=%d", t.Height))
+	}
+
+	// fit mode only matters when both sides are set
+	if t.Width > 0 && t.Height > 0 {
+		fit := t.Fit
+		if fit == "" {
+			fit = FitContain
+		}
+		parts = append(parts, "fit="+fit)
+	}
+
+	if t.Format != "" {
+		parts = append(parts, "format="+t.Format)
+	}
+
+	if t.Quality > 0 && t.Quality != defaultQuality && (t.Format == "" || t.Format == "jpeg") {
+		parts = append(parts, fmt.Sprintf("q=%d", t.Quality))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// DerivativeHash returns the hash of the image derived
+// from the blob identified by hash using t
+func (t *Transformation) DerivativeHash(hash string) string {
+	return DerivativeHash(hash, t.String())
+}
+
+// DerivativeHash returns the hash of variant of
+// the blob identified by hash
+func DerivativeHash(hash, variant string) string {
+	return fmt.Sprintf("%s_%x", hash, md5.Sum([]byte(variant)))[:len(hash)+17]
+}
+
+// Apply transforms the image read from r, at most MaxSourceSize
+// bytes are read. The encoded image and its content type are returned
+func (t *Transformation) Apply(r io.Reader) ([]byte, string, error) {
+	r = io.LimitReader(r, MaxSourceSize)
+
+	// refuse decompression bombs before decoding, only the
+	// header read by DecodeConfig is kept
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, "", ErrNotAnImage
+	}
+
+	if config.Width*config.Height > MaxSourcePixels {
+		return nil, "", ErrSourceTooLarge
+	}
+
+	// decoded images take width * height * 4 bytes at
+	// least, wait for a slot before decoding
+	transformSlots <- struct{}{}
+	defer func() { <-transformSlots }()
+
+	src, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, "", ErrNotAnImage
+	}
+
+	if t.Crop != nil {
+		rect := t.Crop.Add(src.Bounds().Min).Intersect(src.Bounds())
+		if rect.Empty() {
+			return nil, "", errors.New("crop is outside of the image")
+		}
+		src = subImage(src, rect)
+	}
+
+	outFormat := t.Format
+	if outFormat == "" {
+		outFormat = format
+		// webp can not be encoded, keep transparency with png
+		if outFormat == "webp" {
+			outFormat = "png"
+		}
+	}
+
+	dst, err := t.resize(src, outFormat == "jpeg")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var out bytes.Buffer
+	switch outFormat {
+	case "jpeg":
+		quality := t.Quality
+		if quality == 0 {
+			quality = defaultQuality
+		}
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: quality})
+	case "gif":
+		err = gif.Encode(&out, dst, nil)
+	default:
+		outFormat = "png"
+		err = png.Encode(&out, dst)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return out.Bytes(), "image/" + outFormat, nil
+}
+
+// resize scales src according to t's dimensions and fit mode.
+// Transparent pixels are flattened on white when opaque is true
+func (t *Transformation) resize(src image.Image, opaque bool) (image.Image, error) {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	srcRect := src.Bounds()
+	dw, dh := t.Width, t.Height
+
+	switch {
+	case dw == 0 && dh == 0:
+		dw, dh = sw, sh
+	case dh == 0:
+		dh = maxInt(1, sh*dw/sw)
+	case dw == 0:
+		dw = maxInt(1, sw*dh/sh)
+	default:
+		switch t.Fit {
+		case FitFill:
+		case FitCover:
+			// crop the source to the target aspect ratio, centered
+			if sw*dh > sh*dw {
+				cw := sh * dw / dh
+				x := srcRect.Min.X + (sw-cw)/2
+				srcRect = image.Rect(x, srcRect.Min.Y, x+cw, srcRect.Max.Y)
+			} else {
+				ch := sw * dh / dw
+				y := srcRect.Min.Y + (sh-ch)/2
+				srcRect = image.Rect(srcRect.Min.X, y, srcRect.Max.X, y+ch)
+			}
+		default:
+			if t.Fit == FitInside && sw <= dw && sh <= dh {
+				dw, dh = sw, sh
+				break
+			}
+
+			if sw*dh > sh*dw {
+				dh = maxInt(1, sh*dw/sw)
+			} else {
+				dw = maxInt(1, sw*dh/sh)
+			}
+		}
+	}
+
+	if dw > MaxTransformDimension || dh > MaxTransformDimension {
+		return nil, fmt.Errorf("transformed image can not be larger than %dx%d",
+			MaxTransformDimension, MaxTransformDimension)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Over, nil)
+	return dst, nil
+}
+
+// subImage returns the portion of img inside rect
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeSize(t *testing.T, data []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config.Width, config.Height
+}
+
+func TestParseTransformation(t *testing.T) {
+	query, _ := url.ParseQuery("w=200&h=100&fit=cover&format=jpg&q=70&crop=0,0,50,60")
+	tr, err := ParseTransformation(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "crop=0,0,50,60;w=200;h=100;fit=cover;format=jpeg;q=70"
+	if tr.String() != expected {
+		t.Fatalf("expected %s, found %s", expected, tr.String())
+	}
+}
+
+func TestParseTransformationNone(t *testing.T) {
+	query, _ := url.ParseQuery("bloberId=key")
+	tr, err := ParseTransformation(query)
+	if err != nil || tr != nil {
+		t.Fatalf("expected no transformation, found %v %v", tr, err)
+	}
+}
+
+func TestParseTransformationLimits(t *testing.T) {
+	for _, q := range []string{"w=100000", "h=0", "q=101", "fit=stretch", "format=bmp", "crop=1,2,3"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := ParseTransformation(query); err == nil {
+			t.Fatalf("expected %s to be rejected", q)
+		}
+	}
+}
+
+func TestTransformation_StringNormalized(t *testing.T) {
+	a, _ := ParseTransformation(url.Values{"h": {"100"}, "w": {"200"}})
+	b, _ := ParseTransformation(url.Values{"w": {"200"}, "h": {"100"}, "fit": {"contain"}, "q": {"85"}})
+	if a.String() != b.String() {
+		t.Fatalf("expected equal transformations, found %s and %s", a.String(), b.String())
+	}
+
+	if a.DerivativeHash("abc") != b.DerivativeHash("abc") {
+		t.Fatal("expected equal derivative hashes")
+	}
+
+	if !strings.HasPrefix(a.DerivativeHash("abc"), "abc_") || len(a.DerivativeHash("abc")) != 20 {
+		t.Fatalf("unexpected derivative hash %s", a.DerivativeHash("abc"))
+	}
+}
+
+func TestTransformation_ApplyContain(t *testing.T) {
+	tr := &Transformation{Width: 100, Height: 100}
+	data, contentType, err := tr.Apply(bytes.NewReader(testImage(t, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "image/png" {
+		t.Fatalf("expected image/png, found %s", contentType)
+	}
+
+	if w, h := decodeSize(t, data); w != 100 || h != 50 {
+		t.Fatalf("expected 100x50, found %dx%d", w, h)
+	}
+}
+
+func TestTransformation_ApplyCover(t *testing.T) {
+	tr := &Transformation{Width: 50, Height: 50, Fit: FitCover, Format: "jpeg"}
+	data, contentType, err := tr.Apply(bytes.NewReader(testImage(t, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "image/jpeg" {
+		t.Fatalf("expected image/jpeg, found %s", contentType)
+	}
+
+	if w, h := decodeSize(t, data); w != 50 || h != 50 {
+		t.Fatalf("expected 50x50, found %dx%d", w, h)
+	}
+}
+
+func TestTransformation_ApplyCropAndInside(t *testing.T) {
+	crop := image.Rect(10, 10, 70, 40)
+	tr := &Transformation{Width: 500, Height: 500, Fit: FitInside, Crop: &crop}
+	data, _, err := tr.Apply(bytes.NewReader(testImage(t, 200, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, h := decodeSize(t, data); w != 60 || h != 30 {
+		t.Fatalf("expected 60x30, found %dx%d", w, h)
+	}
+}
+
+func TestTransformation_ApplyNotAnImage(t *testing.T) {
+	tr := &Transformation{Width: 10}
+	if _, _, err := tr.Apply(strings.NewReader("not an image")); err != ErrNotAnImage {
+		t.Fatalf("expected %v, found %v", ErrNotAnImage, err)
+	}
+}
+
+func TestTransformation_ApplySourceTooLarge(t *testing.T) {
+	max := MaxSourcePixels
+	MaxSourcePixels = 100
+	defer func() { MaxSourcePixels = max }()
+
+	tr := &Transformation{Width: 10}
+	if _, _, err := tr.Apply(bytes.NewReader(testImage(t, 200, 100))); err != ErrSourceTooLarge {
+		t.Fatalf("expected %v, found %v", ErrSourceTooLarge, err)
+	}
+}
+
+func TestTransformation_ApplyTooLarge(t *testing.T) {
+	max := MaxTransformDimension
+	MaxTransformDimension = 50
+	defer func() { MaxTransformDimension = max }()
+
+	tr := &Transformation{Format: "png"}
+	if _, _, err := tr.Apply(bytes.NewReader(testImage(t, 200, 100))); err == nil {
+		t.Fatal("expected output larger than the limit to be rejected")
+	}
+}
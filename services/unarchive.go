@@ -0,0 +1,297 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"blober.io/models"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// codes of archive entries that were not extracted
+const (
+	EntryInvalidPath = "invalid_path"
+	EntryTooLarge    = "entry_too_large"
+	EntryCompression = "compression_ratio"
+	EntryUnsupported = "unsupported_entry"
+)
+
+// UnarchiveLimits bound what is extracted from an archive
+type UnarchiveLimits struct {
+	MaxEntries   int   // files of the archive
+	MaxEntrySize int64 // uncompressed size of a single file
+	MaxTotalSize int64 // uncompressed size of the whole archive
+	MaxRatio     int64 // uncompressed/compressed size of a zip entry
+}
+
+// ErrUnknownArchive is returned for uploads that are not zip, tar or tar.gz archives
+var ErrUnknownArchive = errors.New("archive must be a zip, tar or tar.gz file")
+
+// ErrTooManyEntries is returned when an archive has more entries than allowed
+var ErrTooManyEntries = errors.New("archive has too many entries")
+
+// ErrUnarchiveTooLarge is returned when the uncompressed content
+// of an archive is larger than allowed
+var ErrUnarchiveTooLarge = errors.New("uncompressed archive is too large")
+
+// EntryFunc receives a file of an archive. path is the cleaned path of
+// the entry. Entries that were not extracted have no data and a
+// *models.PolicyViolation describing why
+type EntryFunc func(path string, data []byte, err error)
+
+// Unarchive calls fn for every file of the zip, tar or tar.gz archive
+// read from r. Directories are skipped. An error is returned when the
+// archive is corrupt or exceeds limits, fn may have been called already
+func Unarchive(r io.ReaderAt, size int64, limits *UnarchiveLimits, fn EntryFunc) error {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return extractZip(r, size, limits, fn)
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, limits, fn)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return extractTar(io.NewSectionReader(r, 0, size), limits, fn)
+	}
+
+	return ErrUnknownArchive
+}
+
+func extractZip(r io.ReaderAt, size int64, limits *UnarchiveLimits, fn EntryFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	files := 0
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files++
+		}
+	}
+
+	if files > limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		name, err := cleanEntryPath(f.Name)
+		if err != nil {
+			fn(f.Name, nil, err)
+			continue
+		}
+
+		if !f.Mode().IsRegular() {
+			fn(name, nil, unsupportedEntry(name))
+			continue
+		}
+
+		// declared sizes can lie, they are checked again while reading
+		if int64(f.UncompressedSize64) > limits.MaxEntrySize {
+			fn(name, nil, entryTooLarge(name, limits))
+			continue
+		}
+
+		if f.CompressedSize64 > 0 && int64(f.UncompressedSize64/f.CompressedSize64) > limits.MaxRatio {
+			fn(name, nil, compressionRatio(name, limits))
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			fn(name, nil, &models.PolicyViolation{Filename: name, Code: EntryUnsupported, Message: err.Error()})
+			continue
+		}
+
+		data, err := readEntry(rc, limits, total)
+		rc.Close()
+		if err == ErrUnarchiveTooLarge {
+			return err
+		}
+
+		if err == nil && f.CompressedSize64 > 0 && int64(len(data))/int64(f.CompressedSize64) > limits.MaxRatio {
+			err = compressionRatio(name, limits)
+		}
+
+		if err != nil {
+			if _, ok := err.(*models.PolicyViolation); !ok {
+				err = &models.PolicyViolation{Filename: name, Code: EntryUnsupported, Message: err.Error()}
+			}
+			fn(name, nil, err)
+			continue
+		}
+
+		total += int64(len(data))
+		fn(name, data, nil)
+	}
+
+	return nil
+}
+
+func extractTar(r io.Reader, limits *UnarchiveLimits, fn EntryFunc) error {
+	// bounds decompression, including entries that are skipped
+	counter := &countingReader{r: bufio.NewReader(r), limit: limits.MaxTotalSize + int64(limits.MaxEntries+2)*1024}
+	tr := tar.NewReader(counter)
+
+	files := 0
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if counter.exceeded {
+			return ErrUnarchiveTooLarge
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		files++
+		if files > limits.MaxEntries {
+			return ErrTooManyEntries
+		}
+
+		name, err := cleanEntryPath(header.Name)
+		if err != nil {
+			fn(header.Name, nil, err)
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			fn(name, nil, unsupportedEntry(name))
+			continue
+		}
+
+		if header.Size > limits.MaxEntrySize {
+			fn(name, nil, entryTooLarge(name, limits))
+			continue
+		}
+
+		data, err := readEntry(tr, limits, total)
+		if counter.exceeded || err == ErrUnarchiveTooLarge {
+			return ErrUnarchiveTooLarge
+		}
+
+		if err != nil {
+			return err
+		}
+
+		total += int64(len(data))
+		fn(name, data, nil)
+	}
+}
+
+// readEntry reads an entry, enforcing the size limits
+// of an entry and of the whole archive
+func readEntry(r io.Reader, limits *UnarchiveLimits, total int64) ([]byte, error) {
+	limit := limits.MaxEntrySize
+	if remaining := limits.MaxTotalSize - total; remaining < limit {
+		limit = remaining
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		if limit < limits.MaxEntrySize {
+			return nil, ErrUnarchiveTooLarge
+		}
+		return nil, entryTooLarge("", limits)
+	}
+
+	return data, nil
+}
+
+// cleanEntryPath validates the path of an archive entry. Absolute paths
+// and paths escaping the archive root are refused rather than rewritten
+func cleanEntryPath(name string) (string, error) {
+	p := strings.Replace(name, "\\", "/", -1)
+	invalid := &models.PolicyViolation{Filename: name, Code: EntryInvalidPath,
+		Message: "entry path must be relative and stay inside the archive"}
+
+	if p == "" || strings.HasPrefix(p, "/") || strings.ContainsRune(p, 0) ||
+		(len(p) > 1 && p[1] == ':') {
+		return "", invalid
+	}
+
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", invalid
+		}
+	}
+
+	cleaned := path.Clean(p)
+	if cleaned == "." {
+		return "", invalid
+	}
+
+	return cleaned, nil
+}
+
+func unsupportedEntry(name string) error {
+	return &models.PolicyViolation{Filename: name, Code: EntryUnsupported,
+		Message: "only regular files are extracted, links and devices are skipped"}
+}
+
+func entryTooLarge(name string, limits *UnarchiveLimits) error {
+	return &models.PolicyViolation{Filename: name, Code: EntryTooLarge,
+		Message: fmt.Sprintf("entry is larger than the %d bytes limit", limits.MaxEntrySize)}
+}
+
+func compressionRatio(name string, limits *UnarchiveLimits) error {
+	return &models.PolicyViolation{Filename: name, Code: EntryCompression,
+		Message: fmt.Sprintf("entry is compressed more than %d times, refusing a possible zip bomb", limits.MaxRatio)}
+}
+
+// countingReader stops reading after limit bytes
+type countingReader struct {
+	r        io.Reader
+	n, limit int64
+	exceeded bool
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	if c.n >= c.limit {
+		c.exceeded = true
+		return 0, ErrUnarchiveTooLarge
+	}
+
+	if int64(len(b)) > c.limit-c.n {
+		b = b[:c.limit-c.n]
+	}
+
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
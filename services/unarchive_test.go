@@ -0,0 +1,194 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"blober.io/models"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+// testLimits are the default limits of archives uploaded for extraction
+var testLimits = &UnarchiveLimits{MaxEntries: 1000, MaxEntrySize: 256 << 20, MaxTotalSize: 1 << 30, MaxRatio: 100}
+
+type unarchived struct {
+	paths  []string
+	data   map[string]string
+	errors map[string]string // path -> violation code
+}
+
+func unarchive(t *testing.T, archive []byte, limits *UnarchiveLimits) (*unarchived, error) {
+	result := &unarchived{data: make(map[string]string), errors: make(map[string]string)}
+	err := Unarchive(bytes.NewReader(archive), int64(len(archive)), limits, func(path string, data []byte, err error) {
+		result.paths = append(result.paths, path)
+		if err != nil {
+			violation, ok := err.(*models.PolicyViolation)
+			if !ok {
+				t.Fatalf("expected a policy violation for %s, got %v", path, err)
+			}
+			result.errors[path] = violation.Code
+			return
+		}
+		result.data[path] = string(data)
+	})
+
+	return result, err
+}
+
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func tarGzOf(t *testing.T, headers []*tar.Header, contents []string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for i, h := range headers {
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents[i]))
+	}
+	w.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestUnarchiveZip(t *testing.T) {
+	archive := zipOf(t, map[string]string{
+		"docs/":             "",
+		"docs/readme.txt":   "hello",
+		"docs/2019/a.txt":   "a",
+		`win\path\b.txt`:    "b",
+		"../escape.txt":     "x",
+		"/etc/passwd":       "x",
+		"docs/../../up.txt": "x",
+	})
+
+	result, err := unarchive(t, archive, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range map[string]string{"docs/readme.txt": "hello", "docs/2019/a.txt": "a", "win/path/b.txt": "b"} {
+		if result.data[path] != content {
+			t.Errorf("expected %s to hold %q, got %q", path, content, result.data[path])
+		}
+	}
+
+	for _, path := range []string{"../escape.txt", "/etc/passwd", "docs/../../up.txt"} {
+		if result.errors[path] != EntryInvalidPath {
+			t.Errorf("expected %s to be refused as invalid path, got %q", path, result.errors[path])
+		}
+	}
+
+	if len(result.paths) != 6 {
+		t.Errorf("expected 6 entries, directories are skipped, got %v", result.paths)
+	}
+}
+
+func TestUnarchiveZipBomb(t *testing.T) {
+	archive := zipOf(t, map[string]string{
+		"bomb.txt":  strings.Repeat("0", 1<<20),
+		"small.txt": "fine",
+	})
+
+	result, err := unarchive(t, archive, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.errors["bomb.txt"] != EntryCompression {
+		t.Errorf("expected highly compressed entry to be refused, got %q", result.errors["bomb.txt"])
+	}
+
+	if result.data["small.txt"] != "fine" {
+		t.Errorf("expected other entries to be extracted")
+	}
+}
+
+func TestUnarchiveLimits(t *testing.T) {
+	archive := zipOf(t, map[string]string{"a.txt": "0123456789", "b.txt": "01"})
+	limits := &UnarchiveLimits{MaxEntries: 10, MaxEntrySize: 5, MaxTotalSize: 100, MaxRatio: 100}
+	result, err := unarchive(t, archive, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.errors["a.txt"] != EntryTooLarge || result.data["b.txt"] != "01" {
+		t.Errorf("expected only a.txt to exceed the entry limit, got %v %v", result.errors, result.data)
+	}
+
+	limits = &UnarchiveLimits{MaxEntries: 1, MaxEntrySize: 100, MaxTotalSize: 100, MaxRatio: 100}
+	if _, err := unarchive(t, archive, limits); err != ErrTooManyEntries {
+		t.Errorf("expected ErrTooManyEntries, got %v", err)
+	}
+
+	limits = &UnarchiveLimits{MaxEntries: 10, MaxEntrySize: 100, MaxTotalSize: 11, MaxRatio: 100}
+	if _, err := unarchive(t, archive, limits); err != ErrUnarchiveTooLarge {
+		t.Errorf("expected ErrUnarchiveTooLarge, got %v", err)
+	}
+}
+
+func TestUnarchiveTarGz(t *testing.T) {
+	headers := []*tar.Header{
+		{Name: "photos/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "photos/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		{Name: "photos/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd", Mode: 0777},
+		{Name: "../../evil.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "big.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 200},
+	}
+	contents := []string{"", "abc", "", "evil", strings.Repeat("x", 200)}
+	archive := tarGzOf(t, headers, contents)
+
+	limits := &UnarchiveLimits{MaxEntries: 10, MaxEntrySize: 100, MaxTotalSize: 1000, MaxRatio: 100}
+	result, err := unarchive(t, archive, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.data["photos/a.txt"] != "abc" {
+		t.Errorf("expected photos/a.txt to be extracted, got %q", result.data["photos/a.txt"])
+	}
+
+	expected := map[string]string{"photos/link": EntryUnsupported, "../../evil.txt": EntryInvalidPath, "big.txt": EntryTooLarge}
+	for path, code := range expected {
+		if result.errors[path] != code {
+			t.Errorf("expected %s to be refused with %s, got %q", path, code, result.errors[path])
+		}
+	}
+}
+
+func TestUnarchiveTarBomb(t *testing.T) {
+	// a single entry decompressing far beyond the total limit
+	headers := []*tar.Header{{Name: "huge.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4 << 20}}
+	archive := tarGzOf(t, headers, []string{strings.Repeat("0", 4<<20)})
+
+	limits := &UnarchiveLimits{MaxEntries: 10, MaxEntrySize: 1 << 20, MaxTotalSize: 1 << 20, MaxRatio: 100}
+	if _, err := unarchive(t, archive, limits); err != ErrUnarchiveTooLarge {
+		t.Errorf("expected ErrUnarchiveTooLarge, got %v", err)
+	}
+}
+
+func TestUnarchiveUnknown(t *testing.T) {
+	data := []byte("just some text")
+	if _, err := unarchive(t, data, testLimits); err != ErrUnknownArchive {
+		t.Errorf("expected ErrUnknownArchive, got %v", err)
+	}
+}
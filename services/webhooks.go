@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the signature of webhook deliveries
+const SignatureHeader = "X-Blober-Signature"
+
+// maxResponseBody is how much of a receiver's response is read,
+// the rest is dropped along with the connection
+var maxResponseBody int64 = 4 << 10
+
+// WebhookTimeout bounds a single delivery attempt
+var WebhookTimeout = 10 * time.Second
+
+// WebhookResponse is the response of a webhook receiver. Its
+// body is not kept, it could reveal content of internal services
+type WebhookResponse struct {
+	StatusCode int
+}
+
+// WebhookSender sends signed webhook deliveries
+type WebhookSender struct {
+	client *http.Client
+}
+
+// NewWebhookSender creates a WebhookSender dialing through the checked
+// dialer of fetcher, urls resolving to internal addresses are refused
+// unless allowlisted. Redirects are not followed
+func NewWebhookSender(fetcher *Fetcher) *WebhookSender {
+	return &WebhookSender{client: &http.Client{Transport: fetcher.transport, Timeout: WebhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}}
+}
+
+// Send posts payload to url, signed with secret. Responses other
+// than 2xx, redirects included, are returned along with an error
+func (s *WebhookSender) Send(rawurl, secret, event, deliveryId string, payload []byte) (*WebhookResponse, error) {
+	req, err := http.NewRequest("POST", rawurl, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blober.io-webhooks")
+	req.Header.Set("X-Blober-Event", event)
+	req.Header.Set("X-Blober-Delivery", deliveryId)
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, SignPayload(secret, timestamp, payload)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// the dial error is wrapped by the client
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == ErrBlockedAddress {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// read a small response so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	response := &WebhookResponse{StatusCode: resp.StatusCode}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return response, fmt.Errorf("webhook receiver responded with %d", resp.StatusCode)
+	}
+
+	return response, nil
+}
+
+// SignPayload returns the hex encoded HMAC-SHA256 of
+// "timestamp.payload" keyed with secret
+func SignPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature header of a delivery
+// of payload. Signatures older than tolerance are refused
+func VerifySignature(header, secret string, payload []byte, tolerance time.Duration) bool {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			signature = kv[1]
+		}
+	}
+
+	if timestamp == 0 || signature == "" {
+		return false
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return false
+	}
+
+	expected := SignPayload(secret, timestamp, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records deliveries and verifies their signature
+type webhookReceiver struct {
+	secret   string
+	status   int
+	received []string
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !VerifySignature(r.Header.Get(SignatureHeader), rcv.secret, body, time.Minute) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	rcv.received = append(rcv.received, r.Header.Get("X-Blober-Event")+" "+string(body))
+	w.WriteHeader(rcv.status)
+	w.Write([]byte("ok"))
+}
+
+// newLocalSender returns a sender allowed to deliver to test servers
+func newLocalSender(t *testing.T) *WebhookSender {
+	f, err := NewFetcher(fetcherOption("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewWebhookSender(f)
+}
+
+func TestWebhookSender_Send(t *testing.T) {
+	rcv := &webhookReceiver{secret: "secret", status: http.StatusOK}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	resp, err := newLocalSender(t).Send(server.URL, "secret", "blob.created", "1", []byte(`{"id":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if len(rcv.received) != 1 || rcv.received[0] != `blob.created {"id":"1"}` {
+		t.Fatalf("unexpected deliveries %v", rcv.received)
+	}
+}
+
+func TestWebhookSender_SendFailure(t *testing.T) {
+	rcv := &webhookReceiver{secret: "secret", status: http.StatusInternalServerError}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	resp, err := newLocalSender(t).Send(server.URL, "secret", "blob.created", "1", []byte(`{}`))
+	if err == nil || resp == nil || resp.StatusCode != 500 {
+		t.Fatalf("expected failed delivery, got %+v, %v", resp, err)
+	}
+
+	// a receiver with another secret refuses the delivery
+	rcv.secret = "other"
+	resp, err = newLocalSender(t).Send(server.URL, "secret", "blob.created", "1", []byte(`{}`))
+	if err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected signature to be refused, got %+v, %v", resp, err)
+	}
+}
+
+func TestWebhookSender_Blocked(t *testing.T) {
+	rcv := &webhookReceiver{secret: "secret", status: http.StatusOK}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	f, err := NewFetcher(fetcherOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loopback receivers are refused unless allowlisted
+	if _, err := NewWebhookSender(f).Send(server.URL, "secret", "blob.created", "1", []byte(`{}`)); err != ErrBlockedAddress {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+
+	if err := f.CheckURL(context.Background(), server.URL); err != ErrBlockedAddress {
+		t.Fatalf("expected ErrBlockedAddress, got %v", err)
+	}
+
+	if len(rcv.received) != 0 {
+		t.Fatalf("expected nothing to be delivered, got %v", rcv.received)
+	}
+}
+
+func TestWebhookSender_Redirect(t *testing.T) {
+	rcv := &webhookReceiver{secret: "secret", status: http.StatusOK}
+	target := httptest.NewServer(rcv)
+	defer target.Close()
+
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	// redirects are not followed, they fail the delivery
+	resp, err := newLocalSender(t).Send(server.URL, "secret", "blob.created", "1", []byte(`{}`))
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("expected redirect to fail the delivery, got %+v, %v", resp, err)
+	}
+
+	if len(rcv.received) != 0 {
+		t.Fatalf("expected nothing to be delivered, got %v", rcv.received)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"type":"blob.created"}`)
+	now := time.Now().Unix()
+	header := "t=" + strconv.FormatInt(now, 10) + ",v1=" + SignPayload("secret", now, payload)
+
+	if !VerifySignature(header, "secret", payload, time.Minute) {
+		t.Fatal("expected signature to be valid")
+	}
+
+	if VerifySignature(header, "secret", []byte(`{}`), time.Minute) {
+		t.Fatal("expected signature of another payload to be invalid")
+	}
+
+	old := time.Now().Add(-time.Hour).Unix()
+	stale := "t=" + strconv.FormatInt(old, 10) + ",v1=" + SignPayload("secret", old, payload)
+	if VerifySignature(stale, "secret", payload, time.Minute) {
+		t.Fatal("expected stale signature to be refused")
+	}
+
+	if VerifySignature("v1=abc", "secret", payload, 0) {
+		t.Fatal("expected malformed header to be refused")
+	}
+}
@@ -2,40 +2,83 @@ package store
 
 import (
 	"blober.io/models"
+	"container/list"
 	"encoding/json"
 	"github.com/dgraph-io/badger"
 	"log"
-	"os"
+	"path/filepath"
+	"sync"
 )
 
-// BlobStore caches Blob/File struct data
+// BadgerBlobStore caches Blob/File struct data
 // for easy access when being download,
 // it should provide faster access and be more
 // resources efficient than say a remote SQL database.
-// It is back by a key/value store(BadgerDB)
-type BlobStore struct {
+// It is back by a key/value store(BadgerDB).
+// The cache can be bounded, least recently
+// used blobs are evicted first
+type BadgerBlobStore struct {
 	db *badger.DB
+	counters
+	loads loads
+
+	mu         sync.Mutex
+	recent     *list.List               // keys, most recently used first
+	entries    map[string]*list.Element // elements of recent by key
+	maxEntries int                      // 0 when unbounded
 }
 
-// NewBlobStore creates a new blobstore
-func NewBlobStore() (*BlobStore, error) {
+// NewBadgerBlobStore creates a new blobstore in dir/blobs
+func NewBadgerBlobStore(dir string) (*BadgerBlobStore, error) {
 	opt := badger.DefaultOptions
-	opt.Dir = os.Getenv("DB_DIR") + "/blobs"
-	opt.ValueDir = os.Getenv("DB_DIR") + "/blobs"
+	opt.Dir = filepath.Join(dir, "blobs")
+	opt.ValueDir = opt.Dir
 
 	db, err := badger.Open(opt)
 	if err != nil {
 		return nil, err
 	}
 
-	return &BlobStore{db: db}, nil
+	s := &BadgerBlobStore{db: db, recent: list.New(), entries: make(map[string]*list.Element)}
+
+	// index blobs cached before a restart, their
+	// use is unknown so they are evicted first
+	err = db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := string(it.Item().KeyCopy(nil))
+			s.entries[key] = s.recent.PushBack(key)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SetMaxEntries bounds the number of cached blobs,
+// 0 removes the bound. Extra blobs are evicted
+func (s *BadgerBlobStore) SetMaxEntries(n int) {
+	s.mu.Lock()
+	s.maxEntries = n
+	evicted := s.evictLocked()
+	s.mu.Unlock()
+
+	s.remove(evicted)
 }
 
 // Set puts/caches a blob
 // key is unique for each blob
 // key in most cases equals appname+hash
-func (s *BlobStore) Set(key string, blob *models.Blob) error {
-	return s.db.Update(func(txn *badger.Txn) error {
+func (s *BadgerBlobStore) Set(key string, blob *models.Blob) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
 		b, err := json.Marshal(blob)
 		if err != nil {
 			log.Printf("failed to marshall blob %v", err)
@@ -44,15 +87,52 @@ func (s *BlobStore) Set(key string, blob *models.Blob) error {
 
 		return txn.Set([]byte(key), b)
 	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.touchLocked(key)
+	evicted := s.evictLocked()
+	s.mu.Unlock()
+
+	s.remove(evicted)
+	return nil
+}
+
+// Get fetches a cached blob, ErrNotFound
+// is returned when it is not cached
+func (s *BadgerBlobStore) Get(key string) (models.Blob, error) {
+	blob, err := s.get(key)
+	switch err {
+	case nil:
+		s.count(1, 0, 0, 0, 0)
+		s.mu.Lock()
+		s.touchLocked(key)
+		s.mu.Unlock()
+	case ErrNotFound:
+		s.count(0, 1, 0, 0, 0)
+	}
+
+	return blob, err
 }
 
-// Get fetches a cached blob
-func (s *BlobStore) Get(key string) (models.Blob, error) {
+// GetOrLoad fetches a cached blob, on a miss the blob is loaded with
+// load and cached. load returns nil when there is no such blob, then
+// ErrNotFound is returned
+func (s *BadgerBlobStore) GetOrLoad(key string, load func() (*models.Blob, error)) (models.Blob, error) {
+	return getOrLoad(s, &s.counters, &s.loads, key, load)
+}
+
+// get reads a cached blob
+func (s *BadgerBlobStore) get(key string) (models.Blob, error) {
 	var blob models.Blob
 	err := s.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(key))
 		if err != nil {
-			log.Printf("failed to get data for key %s, error %v", key, err)
+			if err != ErrNotFound {
+				log.Printf("failed to get data for key %s, error %v", key, err)
+			}
 			return err
 		}
 
@@ -68,7 +148,107 @@ func (s *BlobStore) Get(key string) (models.Blob, error) {
 	return blob, err
 }
 
+// Delete removes a cached blob
+func (s *BadgerBlobStore) Delete(key string) error {
+	s.loads.invalidate(key)
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok {
+		s.recent.Remove(e)
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Each calls fn with every cached blob and its key,
+// iteration stops at the first error returned by fn
+func (s *BadgerBlobStore) Each(fn func(key string, blob models.Blob) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			value, err := item.Value()
+			if err != nil {
+				return err
+			}
+
+			var blob models.Blob
+			if err := json.Unmarshal(value, &blob); err != nil {
+				log.Printf("failed to unmarshal cached blob %s, %v", item.Key(), err)
+			}
+
+			if err := fn(string(item.Key()), blob); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Stats returns the counters of the cache
+func (s *BadgerBlobStore) Stats() CacheStats {
+	stats := s.snapshot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.Entries = s.recent.Len()
+	stats.MaxEntries = s.maxEntries
+	return stats
+}
+
+// touchLocked marks key as the most recently used
+func (s *BadgerBlobStore) touchLocked(key string) {
+	if e, ok := s.entries[key]; ok {
+		s.recent.MoveToFront(e)
+		return
+	}
+
+	s.entries[key] = s.recent.PushFront(key)
+}
+
+// evictLocked unindexes least recently used keys past
+// maxEntries, they are returned to be removed
+func (s *BadgerBlobStore) evictLocked() []string {
+	if s.maxEntries <= 0 {
+		return nil
+	}
+
+	evicted := make([]string, 0)
+	for s.recent.Len() > s.maxEntries {
+		e := s.recent.Back()
+		key := e.Value.(string)
+		s.recent.Remove(e)
+		delete(s.entries, key)
+		evicted = append(evicted, key)
+	}
+	s.count(0, 0, 0, 0, int64(len(evicted)))
+
+	return evicted
+}
+
+// remove deletes evicted keys from badgerDB
+func (s *BadgerBlobStore) remove(keys []string) {
+	for _, key := range keys {
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete([]byte(key))
+		})
+		if err != nil {
+			log.Printf("failed to evict cached blob %s, %v", key, err)
+		}
+	}
+}
+
 // Close underlying badgerDB
-func (s *BlobStore) Close() error {
+func (s *BadgerBlobStore) Close() error {
 	return s.db.Close()
 }
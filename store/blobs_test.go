@@ -2,12 +2,16 @@ package store
 
 import (
 	"blober.io/models"
+	"errors"
+	"github.com/dgraph-io/badger"
 	"github.com/joho/godotenv"
+	"io/ioutil"
 	"log"
+	"os"
 	"testing"
 )
 
-var blobStore *BlobStore
+var blobStore *BadgerBlobStore
 var accessKey = "app1"
 var blob = &models.Blob{
 	Hash: "9876HJKL", AppName: "appOne",
@@ -18,7 +22,7 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	blobStore, _ = NewBlobStore()
+	blobStore, _ = NewBadgerBlobStore(os.Getenv("DB_DIR"))
 }
 
 func TestBlobStore_Set(t *testing.T) {
@@ -38,3 +42,146 @@ func TestBlobStore_Get(t *testing.T) {
 		t.Fatalf("expected hash %s, found %s", blob.Hash, b.Hash)
 	}
 }
+
+// newTestBlobStore opens a blob store in a temporary directory
+func newTestBlobStore(t *testing.T) *BadgerBlobStore {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewBadgerBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestBlobStore_Eviction(t *testing.T) {
+	s := newTestBlobStore(t)
+	defer s.Close()
+
+	s.SetMaxEntries(2)
+	for _, key := range []string{"a", "b"} {
+		if err := s.Set(key, &models.Blob{Hash: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a is used, so b is the least recently used
+	if _, err := s.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Set("c", &models.Blob{Hash: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("b"); err != badger.ErrKeyNotFound {
+		t.Fatalf("expected b to be evicted, found %v", err)
+	}
+
+	stats := s.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBlobStore_GetOrLoad(t *testing.T) {
+	s := newTestBlobStore(t)
+	defer s.Close()
+
+	loads := 0
+	load := func() (*models.Blob, error) {
+		loads++
+		return &models.Blob{Hash: "a"}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		b, err := s.GetOrLoad("a", load)
+		if err != nil || b.Hash != "a" {
+			t.Fatalf("expected blob a, found %+v, %v", b, err)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("expected a single load, found %d", loads)
+	}
+
+	_, err := s.GetOrLoad("missing", func() (*models.Blob, error) { return nil, nil })
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("expected %v, found %v", badger.ErrKeyNotFound, err)
+	}
+
+	_, err = s.GetOrLoad("failing", func() (*models.Blob, error) { return nil, errors.New("db is down") })
+	if err == nil {
+		t.Fatal("expected load error")
+	}
+
+	stats := s.Stats()
+	if stats.Loads != 1 || stats.LoadErrors != 1 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBlobStore_GetOrLoadDeleted(t *testing.T) {
+	s := newTestBlobStore(t)
+	defer s.Close()
+
+	// a blob deleted while it is loaded is not cached,
+	// the load may have read it before it changed
+	b, err := s.GetOrLoad("a", func() (*models.Blob, error) {
+		if err := s.Delete("a"); err != nil {
+			t.Fatal(err)
+		}
+		return &models.Blob{Hash: "a"}, nil
+	})
+	if err != nil || b.Hash != "a" {
+		t.Fatalf("expected blob a, found %+v, %v", b, err)
+	}
+
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected the deleted blob not to be cached, found %v", err)
+	}
+
+	// later loads are cached
+	if _, err := s.GetOrLoad("a", func() (*models.Blob, error) { return &models.Blob{Hash: "a"}, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("a"); err != nil {
+		t.Fatalf("expected a cached blob, found %v", err)
+	}
+}
+
+func TestBlobStore_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewBadgerBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("a", &models.Blob{Hash: "a"})
+	s.Set("b", &models.Blob{Hash: "b"})
+	s.Close()
+
+	// cached blobs are indexed again, and bounded
+	s, err = NewBadgerBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if n := s.Stats().Entries; n != 2 {
+		t.Fatalf("expected 2 entries, found %d", n)
+	}
+
+	s.SetMaxEntries(1)
+	if stats := s.Stats(); stats.Entries != 1 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
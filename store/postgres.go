@@ -0,0 +1,266 @@
+package store
+
+import (
+	"blober.io/models"
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"log"
+	"sync"
+	"time"
+)
+
+// postgresTrimInterval is the number of blobs cached
+// between two evictions of a bounded PostgresBlobStore
+var postgresTrimInterval = 100
+
+// sessionRow is a session stored in Postgres
+type sessionRow struct {
+	Key       string    `gorm:"primary_key"`
+	Value     string    `gorm:"type:text"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// TableName is the table of sessions
+func (sessionRow) TableName() string {
+	return "sessions"
+}
+
+// PostgresSessionStore stores sessions in a Postgres table, shared
+// by replicas. Sessions expire with their credential
+type PostgresSessionStore struct {
+	db   *gorm.DB
+	done chan struct{}
+}
+
+// NewPostgresSessionStore creates the sessions table of db
+// if needed, db is not closed when the store is
+func NewPostgresSessionStore(db *gorm.DB) (*PostgresSessionStore, error) {
+	if err := db.AutoMigrate(&sessionRow{}).Error; err != nil {
+		return nil, err
+	}
+
+	s := &PostgresSessionStore{db: db, done: make(chan struct{})}
+	go s.cleanUp()
+	return s, nil
+}
+
+// Set creates and store a new session
+func (s *PostgresSessionStore) Set(key string, account *models.Account) error {
+	b, err := json.Marshal(account)
+	if err != nil {
+		log.Printf("failed to marshall session %v", err)
+		return err
+	}
+
+	return s.db.Exec(`INSERT INTO sessions (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, string(b), time.Now().Add(sessionTTL(account))).Error
+}
+
+// Get retrieve a stored session
+func (s *PostgresSessionStore) Get(key string) (models.Account, error) {
+	var account models.Account
+	row := &sessionRow{}
+	err := s.db.Where("key = ? AND expires_at > ?", key, time.Now()).First(row).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return account, ErrNotFound
+		}
+		return account, err
+	}
+
+	return account, json.Unmarshal([]byte(row.Value), &account)
+}
+
+// cleanUp deletes expired sessions every cleanUpInterval
+func (s *PostgresSessionStore) cleanUp() {
+	ticker := time.NewTicker(cleanUpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.db.Where("expires_at <= ?", time.Now()).Delete(&sessionRow{}).Error; err != nil {
+				log.Printf("failed to do cleanup %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close stops the cleanup of expired sessions
+func (s *PostgresSessionStore) Close() error {
+	close(s.done)
+	return nil
+}
+
+// cachedBlobRow is a blob cached in Postgres
+type cachedBlobRow struct {
+	Key      string    `gorm:"primary_key"`
+	Value    string    `gorm:"type:text"`
+	CachedAt time.Time `gorm:"index"`
+}
+
+// TableName is the table of cached blobs
+func (cachedBlobRow) TableName() string {
+	return "cached_blobs"
+}
+
+// PostgresBlobStore caches blob structs in a Postgres table, shared by
+// replicas. It spares loading attributes of blobs on every download.
+// When bounded, the blobs cached first are evicted first
+type PostgresBlobStore struct {
+	db *gorm.DB
+	counters
+	loads loads
+
+	mu         sync.Mutex
+	maxEntries int // 0 when unbounded
+	sets       int // blobs cached since the last eviction
+}
+
+// NewPostgresBlobStore creates the cached_blobs table of db
+// if needed, db is not closed when the store is
+func NewPostgresBlobStore(db *gorm.DB) (*PostgresBlobStore, error) {
+	if err := db.AutoMigrate(&cachedBlobRow{}).Error; err != nil {
+		return nil, err
+	}
+
+	return &PostgresBlobStore{db: db}, nil
+}
+
+// SetMaxEntries bounds the number of cached blobs,
+// 0 removes the bound. Extra blobs are evicted
+func (s *PostgresBlobStore) SetMaxEntries(n int) {
+	s.mu.Lock()
+	s.maxEntries = n
+	s.mu.Unlock()
+
+	s.evict()
+}
+
+// Set puts/caches a blob
+func (s *PostgresBlobStore) Set(key string, blob *models.Blob) error {
+	b, err := json.Marshal(blob)
+	if err != nil {
+		log.Printf("failed to marshall blob %v", err)
+		return err
+	}
+
+	err = s.db.Exec(`INSERT INTO cached_blobs (key, value, cached_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, cached_at = excluded.cached_at`,
+		key, string(b), time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	// evictions scan the table, they are batched
+	s.mu.Lock()
+	s.sets++
+	trim := s.sets >= postgresTrimInterval
+	if trim {
+		s.sets = 0
+	}
+	s.mu.Unlock()
+
+	if trim {
+		s.evict()
+	}
+	return nil
+}
+
+// Get fetches a cached blob, ErrNotFound
+// is returned when it is not cached
+func (s *PostgresBlobStore) Get(key string) (models.Blob, error) {
+	var blob models.Blob
+	row := &cachedBlobRow{}
+	if err := s.db.Where("key = ?", key).First(row).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			s.count(0, 1, 0, 0, 0)
+			return blob, ErrNotFound
+		}
+		return blob, err
+	}
+
+	s.count(1, 0, 0, 0, 0)
+	return blob, json.Unmarshal([]byte(row.Value), &blob)
+}
+
+// GetOrLoad fetches a cached blob, on a miss the blob is loaded with
+// load and cached. load returns nil when there is no such blob, then
+// ErrNotFound is returned
+func (s *PostgresBlobStore) GetOrLoad(key string, load func() (*models.Blob, error)) (models.Blob, error) {
+	return getOrLoad(s, &s.counters, &s.loads, key, load)
+}
+
+// Delete removes a cached blob
+func (s *PostgresBlobStore) Delete(key string) error {
+	s.loads.invalidate(key)
+	return s.db.Where("key = ?", key).Delete(&cachedBlobRow{}).Error
+}
+
+// Each calls fn with every cached blob and its key, in key
+// order. Iteration stops at the first error returned by fn
+func (s *PostgresBlobStore) Each(fn func(key string, blob models.Blob) error) error {
+	last := ""
+	for {
+		rows := make([]*cachedBlobRow, 0)
+		if err := s.db.Where("key > ?", last).Order("key").Limit(500).Find(&rows).Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			var blob models.Blob
+			if err := json.Unmarshal([]byte(row.Value), &blob); err != nil {
+				log.Printf("failed to unmarshal cached blob %s, %v", row.Key, err)
+			}
+
+			if err := fn(row.Key, blob); err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < 500 {
+			return nil
+		}
+		last = rows[len(rows)-1].Key
+	}
+}
+
+// Stats returns the counters of the cache
+func (s *PostgresBlobStore) Stats() CacheStats {
+	stats := s.snapshot()
+	if err := s.db.Model(&cachedBlobRow{}).Count(&stats.Entries).Error; err != nil {
+		log.Printf("failed to count cached blobs, %v", err)
+	}
+
+	s.mu.Lock()
+	stats.MaxEntries = s.maxEntries
+	s.mu.Unlock()
+	return stats
+}
+
+// evict removes the blobs cached first past maxEntries
+func (s *PostgresBlobStore) evict() {
+	s.mu.Lock()
+	max := s.maxEntries
+	s.mu.Unlock()
+	if max <= 0 {
+		return
+	}
+
+	result := s.db.Exec(`DELETE FROM cached_blobs WHERE key IN
+		(SELECT key FROM cached_blobs ORDER BY cached_at DESC OFFSET ?)`, max)
+	if result.Error != nil {
+		log.Printf("failed to evict cached blobs, %v", result.Error)
+		return
+	}
+
+	s.count(0, 0, 0, 0, result.RowsAffected)
+}
+
+// Close does nothing, db is owned by the caller
+func (s *PostgresBlobStore) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"blober.io/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"os"
+	"testing"
+)
+
+// newTestPostgres connects to DATABASE_URL, tests
+// are skipped when it is not set
+func newTestPostgres(t *testing.T) *gorm.DB {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open("postgres", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPostgresSessionStore_Shared(t *testing.T) {
+	db := newTestPostgres(t)
+	defer db.Close()
+
+	// two replicas sharing the database
+	a, err := NewPostgresSessionStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := NewPostgresSessionStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	account := models.NewAccount("Lekan", "Adigun", "lekan@blober.io", "password")
+	key := account.Cred.StripKey()
+	if err := a.Set(key, account); err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions can be set again
+	if err := a.Set(key, account); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := b.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.FirstName != account.FirstName {
+		t.Fatalf("expected %s, found %s", account.FirstName, found.FirstName)
+	}
+
+	if _, err := b.Get("unknown"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, found %v", err)
+	}
+}
+
+func TestPostgresBlobStore_Eviction(t *testing.T) {
+	db := newTestPostgres(t)
+	defer db.Close()
+
+	s, err := NewPostgresBlobStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	defer db.Delete(&cachedBlobRow{})
+
+	db.Delete(&cachedBlobRow{})
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Set(key, &models.Blob{Hash: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the blob cached first is evicted
+	s.SetMaxEntries(2)
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected a to be evicted, found %v", err)
+	}
+
+	found, err := s.Get("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Hash != "c" {
+		t.Fatalf("expected c, found %s", found.Hash)
+	}
+
+	if stats := s.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
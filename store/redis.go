@@ -0,0 +1,278 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds a command, connecting included
+var redisTimeout = 5 * time.Second
+
+// redisPoolSize is the number of idle connections kept open
+var redisPoolSize = 16
+
+// redisError is an error replied by Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisClient is a minimal client of the Redis protocol,
+// connections are pooled and safe for concurrent use
+type redisClient struct {
+	address  string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+// redisConn is a connection to Redis
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisClient connects to the Redis server of rawurl,
+// e.g redis://:password@localhost:6379/0
+func newRedisClient(rawurl string) (*redisClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, errors.New("redis url must start with redis://")
+	}
+
+	c := &redisClient{address: u.Host, pool: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		c.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %s", db)
+		}
+	}
+
+	if _, err := c.do("PING"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// do sends a command and returns its reply: a string,
+// an int64, a []interface{} of replies, or nil
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	rc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// the connection is in an unknown state
+		rc.conn.Close()
+		return nil, err
+	}
+
+	c.put(rc)
+	return reply, err
+}
+
+// with runs fn on a single connection, e.g to WATCH keys before
+// a transaction. The connection is closed when fn fails, it may
+// be left in a transaction
+func (c *redisClient) with(fn func(rc *redisConn) error) error {
+	rc, err := c.get()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(rc); err != nil {
+		rc.conn.Close()
+		return err
+	}
+
+	c.put(rc)
+	return nil
+}
+
+// multi runs cmds atomically in a MULTI/EXEC transaction
+// and returns their replies
+func (c *redisClient) multi(cmds ...[]string) ([]interface{}, error) {
+	var replies []interface{}
+	err := c.with(func(rc *redisConn) error {
+		reply, err := rc.exec(cmds...)
+		replies, _ = reply.([]interface{})
+		return err
+	})
+	if err == nil && len(replies) != len(cmds) {
+		return nil, errors.New("redis: transaction was aborted")
+	}
+
+	return replies, err
+}
+
+// get returns an idle connection or opens one
+func (c *redisClient) get() (*redisConn, error) {
+	select {
+	case rc := <-c.pool:
+		return rc, nil
+	default:
+		return c.dial()
+	}
+}
+
+// put returns rc to the idle connections
+func (c *redisClient) put(rc *redisConn) {
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+// dial opens an authenticated connection
+func (c *redisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		if _, err := rc.do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return rc, nil
+}
+
+// close closes idle connections
+func (c *redisClient) close() error {
+	for {
+		select {
+		case rc := <-c.pool:
+			rc.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends a command on the connection and reads its reply
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(rc.r)
+}
+
+// exec runs cmds in a MULTI/EXEC transaction. The reply of EXEC is
+// returned, nil when the transaction was aborted by a watched key
+func (rc *redisConn) exec(cmds ...[]string) (interface{}, error) {
+	if _, err := rc.do("MULTI"); err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range cmds {
+		if _, err := rc.do(cmd...); err != nil {
+			return nil, err
+		}
+	}
+
+	return rc.do("EXEC")
+}
+
+// readRedisReply reads a reply, error replies are returned as redisError
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisStrings converts an array reply of strings
+func redisStrings(reply interface{}) []string {
+	items, _ := reply.([]interface{})
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
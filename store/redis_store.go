@@ -0,0 +1,293 @@
+package store
+
+import (
+	"blober.io/models"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// keys of Redis stores
+const (
+	redisSessionPrefix = "blober:session:"
+	redisBlobPrefix    = "blober:blob:"
+	redisBlobIndex     = "blober:blobs" // cached blob keys scored by last use
+)
+
+// RedisSessionStore stores sessions in Redis, shared by replicas.
+// Sessions expire with their credential
+type RedisSessionStore struct {
+	client *redisClient
+}
+
+// NewRedisSessionStore connects to the Redis server of url,
+// e.g redis://:password@localhost:6379/0
+func NewRedisSessionStore(url string) (*RedisSessionStore, error) {
+	client, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisSessionStore{client: client}, nil
+}
+
+// Set creates and store a new session
+func (s *RedisSessionStore) Set(key string, account *models.Account) error {
+	b, err := json.Marshal(account)
+	if err != nil {
+		log.Printf("failed to marshall session %v", err)
+		return err
+	}
+
+	_, err = s.client.do("SET", redisSessionPrefix+key, string(b), "PX", formatMillis(sessionTTL(account)))
+	return err
+}
+
+// Get retrieve a stored session
+func (s *RedisSessionStore) Get(key string) (models.Account, error) {
+	var account models.Account
+	reply, err := s.client.do("GET", redisSessionPrefix+key)
+	if err != nil {
+		return account, err
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return account, ErrNotFound
+	}
+
+	return account, json.Unmarshal([]byte(value), &account)
+}
+
+// Close closes connections to Redis
+func (s *RedisSessionStore) Close() error {
+	return s.client.close()
+}
+
+// RedisBlobStore caches blob structs in Redis, shared by replicas. Keys
+// are indexed by last use, least recently used blobs are evicted first
+type RedisBlobStore struct {
+	client *redisClient
+	counters
+	loads loads
+
+	mu         sync.Mutex
+	maxEntries int // 0 when unbounded
+}
+
+// NewRedisBlobStore connects to the Redis server of url,
+// e.g redis://:password@localhost:6379/0
+func NewRedisBlobStore(url string) (*RedisBlobStore, error) {
+	client, err := newRedisClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisBlobStore{client: client}, nil
+}
+
+// SetMaxEntries bounds the number of cached blobs,
+// 0 removes the bound. Extra blobs are evicted
+func (s *RedisBlobStore) SetMaxEntries(n int) {
+	s.mu.Lock()
+	s.maxEntries = n
+	s.mu.Unlock()
+
+	s.evict()
+}
+
+// Set puts/caches a blob
+func (s *RedisBlobStore) Set(key string, blob *models.Blob) error {
+	b, err := json.Marshal(blob)
+	if err != nil {
+		log.Printf("failed to marshall blob %v", err)
+		return err
+	}
+
+	_, err = s.client.multi([]string{"SET", redisBlobPrefix + key, string(b)},
+		[]string{"ZADD", redisBlobIndex, redisScore(), key})
+	if err != nil {
+		return err
+	}
+
+	s.evict()
+	return nil
+}
+
+// Get fetches a cached blob, ErrNotFound
+// is returned when it is not cached. The blob is marked
+// as the most recently used, unless it was deleted meanwhile
+func (s *RedisBlobStore) Get(key string) (models.Blob, error) {
+	var blob models.Blob
+	replies, err := s.client.multi([]string{"GET", redisBlobPrefix + key},
+		[]string{"ZADD", redisBlobIndex, "XX", redisScore(), key})
+	if err != nil {
+		return blob, err
+	}
+
+	value, ok := replies[0].(string)
+	if !ok {
+		s.count(0, 1, 0, 0, 0)
+		return blob, ErrNotFound
+	}
+
+	s.count(1, 0, 0, 0, 0)
+	return blob, json.Unmarshal([]byte(value), &blob)
+}
+
+// GetOrLoad fetches a cached blob, on a miss the blob is loaded with
+// load and cached. load returns nil when there is no such blob, then
+// ErrNotFound is returned
+func (s *RedisBlobStore) GetOrLoad(key string, load func() (*models.Blob, error)) (models.Blob, error) {
+	return getOrLoad(s, &s.counters, &s.loads, key, load)
+}
+
+// Delete removes a cached blob
+func (s *RedisBlobStore) Delete(key string) error {
+	s.loads.invalidate(key)
+	_, err := s.client.multi([]string{"DEL", redisBlobPrefix + key}, []string{"ZREM", redisBlobIndex, key})
+	return err
+}
+
+// Each calls fn with every cached blob and its key,
+// iteration stops at the first error returned by fn
+func (s *RedisBlobStore) Each(fn func(key string, blob models.Blob) error) error {
+	reply, err := s.client.do("ZRANGE", redisBlobIndex, "0", "-1")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range redisStrings(reply) {
+		reply, err := s.client.do("GET", redisBlobPrefix+key)
+		if err != nil {
+			return err
+		}
+
+		value, ok := reply.(string)
+		if !ok {
+			continue
+		}
+
+		var blob models.Blob
+		if err := json.Unmarshal([]byte(value), &blob); err != nil {
+			log.Printf("failed to unmarshal cached blob %s, %v", key, err)
+		}
+
+		if err := fn(key, blob); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the counters of the cache
+func (s *RedisBlobStore) Stats() CacheStats {
+	stats := s.snapshot()
+	if reply, err := s.client.do("ZCARD", redisBlobIndex); err == nil {
+		n, _ := reply.(int64)
+		stats.Entries = int(n)
+	}
+
+	s.mu.Lock()
+	stats.MaxEntries = s.maxEntries
+	s.mu.Unlock()
+	return stats
+}
+
+// redisScore scores a key used now
+func redisScore() string {
+	return strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+}
+
+// redisEvictAttempts is the number of times an eviction is
+// tried, it is aborted when another replica uses the cache meanwhile
+var redisEvictAttempts = 3
+
+// evict removes least recently used blobs past maxEntries
+func (s *RedisBlobStore) evict() {
+	s.mu.Lock()
+	max := s.maxEntries
+	s.mu.Unlock()
+	if max <= 0 {
+		return
+	}
+
+	for i := 0; i < redisEvictAttempts; i++ {
+		evicted, err := s.tryEvict(max)
+		if err != nil {
+			log.Printf("failed to evict cached blobs, %v", err)
+			return
+		}
+
+		if evicted >= 0 {
+			s.count(0, 0, 0, 0, int64(evicted))
+			return
+		}
+	}
+}
+
+// tryEvict removes least recently used blobs past max in a
+// transaction watching the index, so a blob cached or used meanwhile
+// is kept. It returns the number of blobs evicted, -1 when aborted
+func (s *RedisBlobStore) tryEvict(max int) (int, error) {
+	evicted := 0
+	err := s.client.with(func(rc *redisConn) error {
+		if _, err := rc.do("WATCH", redisBlobIndex); err != nil {
+			return err
+		}
+
+		reply, err := rc.do("ZRANGE", redisBlobIndex, "0", strconv.Itoa(-max-1))
+		if err != nil {
+			return err
+		}
+
+		keys := redisStrings(reply)
+		if len(keys) == 0 {
+			_, err := rc.do("UNWATCH")
+			return err
+		}
+
+		del := []string{"DEL"}
+		for _, key := range keys {
+			del = append(del, redisBlobPrefix+key)
+		}
+
+		reply, err = rc.exec(del, append([]string{"ZREM", redisBlobIndex}, keys...))
+		if err != nil {
+			return err
+		}
+
+		evicted = len(keys)
+		if reply == nil {
+			evicted = -1
+		}
+		return nil
+	})
+
+	return evicted, err
+}
+
+// Close closes connections to Redis
+func (s *RedisBlobStore) Close() error {
+	return s.client.close()
+}
+
+// sessionTTL returns how long the session of account lives,
+// until its credential expires or sessionExpiration
+func sessionTTL(account *models.Account) time.Duration {
+	if account.Cred != nil {
+		if ttl := time.Until(account.Cred.ExpiresIn); ttl > 0 {
+			return ttl
+		}
+	}
+
+	return sessionExpiration
+}
+
+// formatMillis formats d in milliseconds
+func formatMillis(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"blober.io/models"
+	"blober.io/store/redistest"
+	"testing"
+	"time"
+)
+
+// newTestRedis starts an in-process Redis server
+func newTestRedis(t *testing.T) *redistest.Server {
+	server, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+func TestRedisSessionStore_Shared(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+
+	// two replicas connected to the same server
+	a, err := NewRedisSessionStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := NewRedisSessionStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	account := models.NewAccount("Lekan", "Adigun", "lekan@blober.io", "password")
+	if err := a.Set(account.Cred.StripKey(), account); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := b.Get(account.Cred.StripKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.FirstName != account.FirstName {
+		t.Fatalf("expected %s, found %s", account.FirstName, found.FirstName)
+	}
+
+	if _, err := b.Get("unknown"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, found %v", err)
+	}
+}
+
+func TestRedisSessionStore_Expiry(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+
+	s, err := NewRedisSessionStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// sessions expire with their credential
+	account := models.NewAccount("Lekan", "Adigun", "lekan@blober.io", "password")
+	account.Cred.ExpiresIn = time.Now().Add(50 * time.Millisecond)
+	if err := s.Set("expiring", account); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("expiring"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.Get("expiring"); err != ErrNotFound {
+		t.Fatalf("expected expired session, found %v", err)
+	}
+}
+
+func TestRedisSessionStore_Password(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+	server.RequirePassword("secret")
+
+	if _, err := NewRedisSessionStore(server.URL()); err == nil {
+		t.Fatal("expected connecting without a password to fail")
+	}
+
+	s, err := NewRedisSessionStore("redis://:secret@" + server.Addr() + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func TestRedisBlobStore_Shared(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+
+	a, err := NewRedisBlobStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := NewRedisBlobStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// a blob loaded by a replica is cached for the other
+	loads := 0
+	load := func() (*models.Blob, error) {
+		loads++
+		return &models.Blob{Hash: "h1", AppName: "app"}, nil
+	}
+
+	if _, err := a.GetOrLoad("app:h1", load); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := b.GetOrLoad("app:h1", load)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Hash != "h1" || loads != 1 {
+		t.Fatalf("expected h1 loaded once, found %s loaded %d times", found.Hash, loads)
+	}
+
+	// deletes invalidate every replica
+	if err := a.Delete("app:h1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Get("app:h1"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, found %v", err)
+	}
+
+	if stats := b.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRedisBlobStore_Eviction(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+
+	s, err := NewRedisBlobStore(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.SetMaxEntries(2)
+	for _, key := range []string{"a", "b"} {
+		if err := s.Set(key, &models.Blob{Hash: key}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// a is used after b, b is the least recently used
+	if _, err := s.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	if err := s.Set("c", &models.Blob{Hash: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("b"); err != ErrNotFound {
+		t.Fatalf("expected b to be evicted, found %v", err)
+	}
+
+	keys := make([]string, 0)
+	err = s.Each(func(key string, blob models.Blob) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 cached blobs, found %v", keys)
+	}
+
+	if stats := s.Stats(); stats.Evictions != 1 || stats.Entries != 2 || stats.MaxEntries != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRedisClient_Transactions(t *testing.T) {
+	server := newTestRedis(t)
+	defer server.Close()
+
+	c, err := newRedisClient(server.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	replies, err := c.multi([]string{"SET", "a", "1"}, []string{"ZADD", "index", "XX", "1", "a"}, []string{"GET", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// XX does not add members
+	if len(replies) != 3 || replies[1] != int64(0) || replies[2] != "1" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+
+	// a transaction is aborted when a watched key is written meanwhile
+	var reply interface{}
+	err = c.with(func(rc *redisConn) error {
+		if _, err := rc.do("WATCH", "index"); err != nil {
+			return err
+		}
+
+		if _, err := c.do("ZADD", "index", "1", "a"); err != nil {
+			return err
+		}
+
+		reply, err = rc.exec([]string{"ZREM", "index", "a"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != nil {
+		t.Fatalf("expected an aborted transaction, found %v", reply)
+	}
+
+	if reply, err := c.do("ZCARD", "index"); err != nil || reply != int64(1) {
+		t.Fatalf("expected the member to be kept, found %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,451 @@
+// Package redistest provides an in-process Redis server for tests.
+// It implements the subset of commands used by blober.io stores
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory Redis server listening on a local port
+type Server struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	strings  map[string]string
+	expires  map[string]time.Time
+	zsets    map[string]map[string]float64
+	versions map[string]int // writes of keys, for WATCH
+	password string
+	conns    map[net.Conn]bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener: listener,
+		strings:  make(map[string]string),
+		expires:  make(map[string]time.Time),
+		zsets:    make(map[string]map[string]float64),
+		versions: make(map[string]int),
+		conns:    make(map[net.Conn]bool),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// RequirePassword makes connections authenticate with password
+func (s *Server) RequirePassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns the redis:// url of the server
+func (s *Server) URL() string {
+	return "redis://" + s.Addr()
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle serves the commands of a connection
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	state := &connState{}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		reply := s.do(args, state)
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+// connState is the state of a connection
+type connState struct {
+	authenticated bool
+	queued        [][]string     // commands of a transaction, nil outside MULTI
+	watched       map[string]int // versions of watched keys
+}
+
+// do runs a command and returns its encoded reply
+func (s *Server) do(args []string, state *connState) string {
+	if len(args) == 0 {
+		return errorReply("empty command")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+	if s.password != "" && !state.authenticated && cmd != "AUTH" {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch cmd {
+	case "AUTH":
+		if len(args) != 2 || args[1] != s.password {
+			return errorReply("invalid password")
+		}
+		state.authenticated = true
+		return "+OK\r\n"
+	case "MULTI":
+		if state.queued != nil {
+			return errorReply("MULTI calls can not be nested")
+		}
+		state.queued = make([][]string, 0)
+		return "+OK\r\n"
+	case "EXEC":
+		if state.queued == nil {
+			return errorReply("EXEC without MULTI")
+		}
+
+		queued, watched := state.queued, state.watched
+		state.queued, state.watched = nil, nil
+		for key, version := range watched {
+			if s.versions[key] != version {
+				return "*-1\r\n"
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString("*" + strconv.Itoa(len(queued)) + "\r\n")
+		for _, args := range queued {
+			b.WriteString(s.run(args))
+		}
+		return b.String()
+	case "DISCARD":
+		if state.queued == nil {
+			return errorReply("DISCARD without MULTI")
+		}
+		state.queued, state.watched = nil, nil
+		return "+OK\r\n"
+	case "WATCH":
+		if state.queued != nil {
+			return errorReply("WATCH inside MULTI is not allowed")
+		}
+
+		if state.watched == nil {
+			state.watched = make(map[string]int)
+		}
+		for _, key := range args[1:] {
+			state.watched[key] = s.versions[key]
+		}
+		return "+OK\r\n"
+	case "UNWATCH":
+		state.watched = nil
+		return "+OK\r\n"
+	}
+
+	if state.queued != nil {
+		state.queued = append(state.queued, args)
+		return "+QUEUED\r\n"
+	}
+
+	return s.run(args)
+}
+
+// run runs a command outside transactions, s.mu is held
+func (s *Server) run(args []string) string {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 2 {
+			return arityError(cmd)
+		}
+
+		value, ok := s.get(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulkReply(value)
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return arityError(cmd)
+		}
+
+		s.strings[args[1]] = args[2]
+		s.versions[args[1]]++
+		delete(s.expires, args[1])
+		if len(args) == 5 {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if strings.ToUpper(args[3]) != "PX" || err != nil || ms <= 0 {
+				return errorReply("syntax error")
+			}
+			s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			s.versions[key]++
+			if _, ok := s.get(key); ok {
+				deleted++
+			}
+			delete(s.strings, key)
+			delete(s.expires, key)
+			if _, ok := s.zsets[key]; ok {
+				delete(s.zsets, key)
+				deleted++
+			}
+		}
+		return intReply(deleted)
+	case "ZADD":
+		// XX only updates existing members
+		xx := len(args) > 2 && strings.ToUpper(args[2]) == "XX"
+		pairs := args[2:]
+		if xx {
+			pairs = args[3:]
+		}
+		if len(pairs) < 2 || len(pairs)%2 != 0 {
+			return arityError(cmd)
+		}
+
+		zset := s.zsets[args[1]]
+		if zset == nil {
+			zset = make(map[string]float64)
+		}
+
+		added := 0
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := strconv.ParseFloat(pairs[i], 64)
+			if err != nil {
+				return errorReply("value is not a valid float")
+			}
+
+			_, ok := zset[pairs[i+1]]
+			if xx && !ok {
+				continue
+			}
+			if !ok {
+				added++
+			}
+			zset[pairs[i+1]] = score
+			s.versions[args[1]]++
+		}
+
+		if len(zset) > 0 {
+			s.zsets[args[1]] = zset
+		}
+		return intReply(added)
+	case "ZREM":
+		if len(args) < 3 {
+			return arityError(cmd)
+		}
+
+		removed := 0
+		zset := s.zsets[args[1]]
+		for _, member := range args[2:] {
+			if _, ok := zset[member]; ok {
+				delete(zset, member)
+				s.versions[args[1]]++
+				removed++
+			}
+		}
+		return intReply(removed)
+	case "ZCARD":
+		if len(args) != 2 {
+			return arityError(cmd)
+		}
+		return intReply(len(s.zsets[args[1]]))
+	case "ZRANGE":
+		if len(args) != 4 {
+			return arityError(cmd)
+		}
+
+		start, err1 := strconv.Atoi(args[2])
+		stop, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			return errorReply("value is not an integer or out of range")
+		}
+		return arrayReply(s.zrange(args[1], start, stop))
+	}
+
+	return errorReply(fmt.Sprintf("unknown command '%s'", args[0]))
+}
+
+// get returns the unexpired string value of key
+func (s *Server) get(key string) (string, bool) {
+	if at, ok := s.expires[key]; ok && !time.Now().Before(at) {
+		delete(s.strings, key)
+		delete(s.expires, key)
+	}
+
+	value, ok := s.strings[key]
+	return value, ok
+}
+
+// zrange returns members of a sorted set by rank,
+// negative indexes count from the highest rank
+func (s *Server) zrange(key string, start, stop int) []string {
+	zset := s.zsets[key]
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]]
+		}
+		return members[i] < members[j]
+	})
+
+	n := len(members)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil
+	}
+
+	return members[start : stop+1]
+}
+
+func errorReply(msg string) string {
+	return "-ERR " + msg + "\r\n"
+}
+
+func arityError(cmd string) string {
+	return errorReply(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func intReply(n int) string {
+	return ":" + strconv.Itoa(n) + "\r\n"
+}
+
+func bulkReply(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func arrayReply(values []string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(values)) + "\r\n")
+	for _, value := range values {
+		b.WriteString(bulkReply(value))
+	}
+
+	return b.String()
+}
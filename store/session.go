@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"github.com/dgraph-io/badger"
 	"log"
-	"os"
 	"time"
 )
 
@@ -17,35 +16,35 @@ var sessionExpiration = 2 * 24 * time.Hour // two days
 // sessionstore would be clean up
 var cleanUpInterval = 20 * time.Minute
 
-// SessionStore caches accounts struct data
+// BadgerSessionStore caches accounts struct data
 // for easy access during authentication,
 // because we are using either private or public
 // key for authentication, JWT might not be useful.
 // it should provide faster access and be more
 // resources efficient than say a remote SQL database.
 // It is back by a key/value store(BadgerDB)
-type SessionStore struct {
+type BadgerSessionStore struct {
 	db *badger.DB
 }
 
-// NewSessionStore creates a new session store
-func NewSessionStore() (*SessionStore, error) {
+// NewBadgerSessionStore creates a new session store in dir
+func NewBadgerSessionStore(dir string) (*BadgerSessionStore, error) {
 	opt := badger.DefaultOptions
-	opt.Dir = os.Getenv("DB_DIR")
-	opt.ValueDir = os.Getenv("DB_DIR")
+	opt.Dir = dir
+	opt.ValueDir = dir
 
 	db, err := badger.Open(opt)
 	if err != nil {
 		return nil, err
 	}
 
-	sess := &SessionStore{db: db}
+	sess := &BadgerSessionStore{db: db}
 	go sess.cleanUp()
 	return sess, nil
 }
 
 // Set creates and store a new session
-func (s *SessionStore) Set(key string, account *models.Account) error {
+func (s *BadgerSessionStore) Set(key string, account *models.Account) error {
 	return s.db.Update(func(txn *badger.Txn) error {
 		b, err := json.Marshal(account)
 		if err != nil {
@@ -58,7 +57,7 @@ func (s *SessionStore) Set(key string, account *models.Account) error {
 }
 
 // Get retrieve a stored session
-func (s *SessionStore) Get(key string) (models.Account, error) {
+func (s *BadgerSessionStore) Get(key string) (models.Account, error) {
 	var account models.Account
 	err := s.db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(key))
@@ -82,7 +81,7 @@ func (s *SessionStore) Get(key string) (models.Account, error) {
 // cleanUp do a sane, session cleanup.
 // Which means expired session would be removed
 // and users would have to reauthenticate
-func (s *SessionStore) cleanUp() {
+func (s *BadgerSessionStore) cleanUp() {
 	ticker := time.NewTicker(cleanUpInterval)
 	for {
 		select {
@@ -96,7 +95,7 @@ func (s *SessionStore) cleanUp() {
 }
 
 // doCleanUp deletes all expired sessions
-func (s *SessionStore) doCleanUp() error {
+func (s *BadgerSessionStore) doCleanUp() error {
 	return s.db.Update(func(txn *badger.Txn) error {
 		opt := badger.DefaultIteratorOptions
 		opt.PrefetchSize = 20
@@ -136,6 +135,6 @@ func (s *SessionStore) doCleanUp() error {
 }
 
 // Close closes underling badgerDB
-func (s *SessionStore) Close() error {
+func (s *BadgerSessionStore) Close() error {
 	return s.db.Close()
 }
@@ -4,10 +4,11 @@ import (
 	"blober.io/models"
 	"github.com/joho/godotenv"
 	"log"
+	"os"
 	"testing"
 )
 
-var store *SessionStore
+var store *BadgerSessionStore
 var key = "lekan"
 
 func init() {
@@ -15,7 +16,7 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	store, _ = NewSessionStore()
+	store, _ = NewBadgerSessionStore(os.Getenv("DB_DIR"))
 }
 
 var (
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"blober.io/models"
+	"github.com/dgraph-io/badger"
+	"log"
+	"sync"
+)
+
+// ErrNotFound is returned by stores when a key is not stored
+var ErrNotFound = badger.ErrKeyNotFound
+
+// SessionStore stores accounts of authenticated sessions, keyed by
+// the stripped key of their credential. BadgerDB stores are local to
+// a server, replicas share sessions stored in Postgres or Redis
+type SessionStore interface {
+	Set(key string, account *models.Account) error
+	Get(key string) (models.Account, error)
+	Close() error
+}
+
+// BlobStore caches blob structs, keyed by app name and hash.
+// The database is the source of truth, blobs missing from the
+// store are loaded with GetOrLoad. BadgerDB stores are local to
+// a server, replicas share caches stored in Postgres or Redis
+type BlobStore interface {
+	Set(key string, blob *models.Blob) error
+	Get(key string) (models.Blob, error)
+	GetOrLoad(key string, load func() (*models.Blob, error)) (models.Blob, error)
+	Delete(key string) error
+	Each(fn func(key string, blob models.Blob) error) error
+
+	// SetMaxEntries bounds the number of cached
+	// blobs, 0 removes the bound
+	SetMaxEntries(n int)
+	Stats() CacheStats
+	Close() error
+}
+
+// CacheStats are counters of a BlobStore. Entries and evictions
+// of shared stores count every replica, other counters don't
+type CacheStats struct {
+	Entries    int   `json:"entries"`
+	MaxEntries int   `json:"max_entries"` // 0 when unbounded
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Loads      int64 `json:"loads"`     // misses loaded from the database
+	Evictions  int64 `json:"evictions"` // blobs evicted to bound the cache
+	LoadErrors int64 `json:"load_errors"`
+}
+
+// counters counts accesses of a BlobStore
+type counters struct {
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+// count adds to the counters
+func (c *counters) count(hits, misses, loads, loadErrors, evictions int64) {
+	c.mu.Lock()
+	c.stats.Hits += hits
+	c.stats.Misses += misses
+	c.stats.Loads += loads
+	c.stats.LoadErrors += loadErrors
+	c.stats.Evictions += evictions
+	c.mu.Unlock()
+}
+
+// snapshot returns the counters
+func (c *counters) snapshot() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// loads tracks the keys being loaded by getOrLoad. A key deleted
+// while it is loaded is not cached, the loaded blob may predate
+// the change the delete follows. Only deletes of the same store are seen,
+// not those of other replicas sharing it
+type loads struct {
+	mu      sync.Mutex
+	pending map[string]*pendingLoad
+}
+
+// pendingLoad are the running loads of a key
+type pendingLoad struct {
+	mu    sync.Mutex // held while a loaded blob is cached
+	n     int
+	stale bool // the key was deleted since the loads started
+}
+
+// begin registers a load of key
+func (l *loads) begin(key string) *pendingLoad {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending == nil {
+		l.pending = make(map[string]*pendingLoad)
+	}
+
+	p := l.pending[key]
+	if p == nil {
+		p = &pendingLoad{}
+		l.pending[key] = p
+	}
+	p.n++
+	return p
+}
+
+// end unregisters a load of key
+func (l *loads) end(key string, p *pendingLoad) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p.n--
+	if p.n == 0 {
+		delete(l.pending, key)
+	}
+}
+
+// invalidate marks running loads of key stale. It must be called
+// before key is deleted, a blob being cached is then deleted too
+func (l *loads) invalidate(key string) {
+	l.mu.Lock()
+	p := l.pending[key]
+	l.mu.Unlock()
+
+	if p != nil {
+		p.mu.Lock()
+		p.stale = true
+		p.mu.Unlock()
+	}
+}
+
+// getOrLoad fetches key from s, on a miss the blob is loaded
+// with load and cached unless key was deleted meanwhile. Gets of s
+// must count hits and misses, deletes must invalidate l
+func getOrLoad(s BlobStore, c *counters, l *loads, key string, load func() (*models.Blob, error)) (models.Blob, error) {
+	blob, err := s.Get(key)
+	if err != ErrNotFound {
+		return blob, err
+	}
+
+	p := l.begin(key)
+	defer l.end(key, p)
+
+	loaded, err := load()
+	if err != nil {
+		c.count(0, 0, 0, 1, 0)
+		return models.Blob{}, err
+	}
+
+	if loaded == nil {
+		return models.Blob{}, ErrNotFound
+	}
+
+	c.count(0, 0, 1, 0, 0)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stale {
+		return *loaded, nil
+	}
+
+	if err := s.Set(key, loaded); err != nil {
+		log.Printf("failed to cache loaded blob %s, %v", key, err)
+	}
+
+	return *loaded, nil
+}